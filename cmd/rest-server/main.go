@@ -1 +1,395 @@
 package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/authstrategy"
+	"github.com/ankur-anand/prod-todo/pkg/notifier"
+	"github.com/ankur-anand/prod-todo/pkg/observability"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler"
+	"github.com/ankur-anand/prod-todo/pkg/storage"
+	"github.com/ankur-anand/prod-todo/pkg/webhook"
+)
+
+const appName = "prod-todo"
+
+// config is read from the environment, see configFromEnv.
+type config struct {
+	addr           string
+	dbURL          string
+	privKeyPath    string
+	pubKeyPath     string
+	issuer         string
+	audience       string
+	tokenTTL       time.Duration
+	trashRetention time.Duration
+	purgeInterval  time.Duration
+	// the reminders are emailed through the smtpAddr relay, and posted
+	// to the webhookURL if not empty.
+	smtpAddr         string
+	smtpFrom         string
+	webhookURL       string
+	reminderInterval time.Duration
+	// the recurring todos finished without their next occurrence are advanced every advanceInterval.
+	advanceInterval time.Duration
+	// the events of the outbox are relayed every relayInterval. The webhooks of
+	// the staffOrgID are told about the signups, none are if it's nil. The events
+	// and their deliveries are kept deliveryRetention.
+	relayInterval     time.Duration
+	staffOrgID        uuid.UUID
+	webhookInterval   time.Duration
+	deliveryRetention time.Duration
+	// the expvar metrics are served on the internal adminAddr, none if it's empty.
+	adminAddr string
+	// the todo event streams are served on the streamAddr, and ended after the
+	// streamLifetime, the clients reconnect and resume after the last event they got.
+	streamAddr     string
+	streamLifetime time.Duration
+	// trustedProxies are the proxies in front of the server, the client
+	// address is read from the X-Forwarded-For they set.
+	trustedProxies []*net.IPNet
+	// the browser frontends of the corsOrigins can call the api, none if empty.
+	corsOrigins     []string
+	corsCredentials bool
+	corsMaxAge      time.Duration
+}
+
+func configFromEnv() (config, error) {
+	c := config{
+		addr:        ":" + envOr("PORT", "8080"),
+		streamAddr:  ":" + envOr("STREAM_PORT", "8081"),
+		adminAddr:   envOr("ADMIN_ADDR", "localhost:9090"),
+		dbURL:       os.Getenv("DATABASE_URL"),
+		privKeyPath: os.Getenv("JWT_PRIVATE_KEY"),
+		pubKeyPath:  os.Getenv("JWT_PUBLIC_KEY"),
+		issuer:      envOr("JWT_ISSUER", appName),
+		audience:    envOr("JWT_AUDIENCE", appName),
+		smtpAddr:    envOr("SMTP_ADDR", "localhost:1025"),
+		smtpFrom:    envOr("SMTP_FROM", "reminders@localhost"),
+		webhookURL:  os.Getenv("REMINDER_WEBHOOK_URL"),
+	}
+	var err error
+	if c.tokenTTL, err = time.ParseDuration(envOr("JWT_TTL", "1h")); err != nil {
+		return c, err
+	}
+	if c.trashRetention, err = time.ParseDuration(envOr("TRASH_RETENTION", "720h")); err != nil {
+		return c, err
+	}
+	if c.purgeInterval, err = time.ParseDuration(envOr("PURGE_INTERVAL", "1h")); err != nil {
+		return c, err
+	}
+	if c.reminderInterval, err = time.ParseDuration(envOr("REMINDER_INTERVAL", "30s")); err != nil {
+		return c, err
+	}
+	if c.advanceInterval, err = time.ParseDuration(envOr("SERIES_ADVANCE_INTERVAL", "1m")); err != nil {
+		return c, err
+	}
+	if c.relayInterval, err = time.ParseDuration(envOr("EVENT_RELAY_INTERVAL", "5s")); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("WEBHOOK_STAFF_ORG_ID"); v != "" {
+		if c.staffOrgID, err = uuid.Parse(v); err != nil {
+			return c, err
+		}
+	}
+	if c.webhookInterval, err = time.ParseDuration(envOr("WEBHOOK_INTERVAL", "10s")); err != nil {
+		return c, err
+	}
+	if c.deliveryRetention, err = time.ParseDuration(envOr("WEBHOOK_RETENTION", "168h")); err != nil {
+		return c, err
+	}
+	if c.streamLifetime, err = time.ParseDuration(envOr("TODO_STREAM_LIFETIME", "5m")); err != nil {
+		return c, err
+	}
+	if c.trustedProxies, err = parseNetworks(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return c, err
+	}
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			c.corsOrigins = append(c.corsOrigins, origin)
+		}
+	}
+	if c.corsCredentials, err = strconv.ParseBool(envOr("CORS_ALLOW_CREDENTIALS", "false")); err != nil {
+		return c, err
+	}
+	if c.corsMaxAge, err = time.ParseDuration(envOr("CORS_MAX_AGE", "10m")); err != nil {
+		return c, err
+	}
+	if len(c.corsOrigins) > 0 {
+		if err = c.cors().Validate(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func (c config) cors() resthandler.CORSConfig {
+	return resthandler.CORSConfig{
+		AllowedOrigins:   c.corsOrigins,
+		AllowCredentials: c.corsCredentials,
+		MaxAge:           c.corsMaxAge,
+	}
+}
+
+// parseNetworks parses a comma separated list of CIDR networks or IP addresses.
+func parseNetworks(v string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func newJWT(c config) (authstrategy.JWT, error) {
+	privPEM, err := ioutil.ReadFile(c.privKeyPath)
+	if err != nil {
+		return authstrategy.JWT{}, err
+	}
+	pubPEM, err := ioutil.ReadFile(c.pubKeyPath)
+	if err != nil {
+		return authstrategy.JWT{}, err
+	}
+	privKey, err := jwt.ParseRSAPrivateKeyFromPEM(privPEM)
+	if err != nil {
+		return authstrategy.JWT{}, err
+	}
+	pubKey, err := jwt.ParseRSAPublicKeyFromPEM(pubPEM)
+	if err != nil {
+		return authstrategy.JWT{}, err
+	}
+	return authstrategy.NewJWT(privKey, pubKey, c.issuer, c.audience, c.tokenTTL)
+}
+
+func main() {
+	hostname, _ := os.Hostname()
+	salt := os.Getenv("LOG_HASH_SALT")
+	observability.NewProduction(appName, hostname, salt)
+	logger := zap.L()
+	defer func() { _ = logger.Sync() }()
+	if salt == "" {
+		logger.Fatal("invalid config", zap.Error(fmt.Errorf("LOG_HASH_SALT is required to hash the logged user data")))
+	}
+
+	c, err := configFromEnv()
+	if err != nil {
+		logger.Fatal("invalid config", zap.Error(err))
+	}
+	repo, err := storage.NewPostgreSQL(c.dbURL)
+	if err != nil {
+		logger.Fatal("err connecting to the database", zap.Error(err))
+	}
+	defer repo.Close()
+	tokenizer, err := newJWT(c)
+	if err != nil {
+		logger.Fatal("err loading the jwt keys", zap.Error(err))
+	}
+
+	// the members of an organization have a role on its projects, along with the grants.
+	auth := pkg.NewOrgAuthorizer(repo.GrantStorageSQL(), repo.OrgStorageSQL())
+	shareSvc := pkg.NewShareService(repo.TodoStorageSQL(), repo.ProjectStorageSQL(), repo.UserStorageSQL(), repo.GrantStorageSQL(), auth)
+	todoSvc := pkg.NewRecurringTodoService(repo.TodoStorageSQL(), repo.ProjectStorageSQL(), auth, repo.SeriesStorageSQL())
+	// every replica reads the whole log, to stream the events whichever replica changed the todos.
+	stream := pkg.NewTodoStream(repo.EventStorageSQL(), auth)
+	// the options common to the api and the event streams.
+	common := []resthandler.Option{
+		resthandler.WithAuth(pkg.NewRegAndAuthService(repo.UserStorageSQL()), tokenizer),
+		resthandler.WithTrustedProxies(c.trustedProxies),
+	}
+	if len(c.corsOrigins) > 0 {
+		common = append(common, resthandler.WithCORS(c.cors()))
+	}
+	mh := resthandler.NewMuxHandler(logger, append(common,
+		resthandler.WithTodoService(todoSvc),
+		resthandler.WithItemService(pkg.NewItemService(todoSvc, repo.ItemStorageSQL())),
+		resthandler.WithSeriesService(pkg.NewSeriesService(todoSvc, repo.SeriesStorageSQL())),
+		resthandler.WithReminderService(pkg.NewReminderService(todoSvc, repo.ReminderStorageSQL())),
+		resthandler.WithProjectService(pkg.NewSharedProjectService(repo.ProjectStorageSQL(), auth)),
+		resthandler.WithShareService(shareSvc),
+		resthandler.WithOrgService(pkg.NewOrgService(repo.OrgStorageSQL(), repo.UserStorageSQL())),
+		resthandler.WithWebhookService(pkg.NewWebhookService(repo.WebhookStorageSQL(), auth, c.staffOrgID)),
+		// the live editing reads the same stream, with the default rate limit of the connections.
+		resthandler.WithLive(stream, resthandler.Rate{}),
+		// the offline clients sync the todos changed since from the same log.
+		resthandler.WithSyncService(pkg.NewSyncService(todoSvc, repo.TodoStorageSQL(), repo.EventStorageSQL())),
+		resthandler.WithSearchService(pkg.NewSearchService(repo.TodoStorageSQL())),
+		resthandler.WithIdempotencyStore(repo.IdempotencyStorageSQL(), 24*time.Hour),
+	)...)
+
+	// the event streams write for longer than the write timeout of the api, they're served on their own.
+	streams := resthandler.NewMuxHandler(logger, append(common, resthandler.WithTodoStream(stream, c.streamLifetime))...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	purger := pkg.NewTrashPurger(repo.TodoStorageSQL(), c.trashRetention, c.purgeInterval)
+	go purger.Run(ctx, func(n int64, err error) {
+		if err != nil {
+			logger.Error("err purging the trash", zap.Error(err))
+		} else {
+			logger.Info("trash purged", zap.Int64("todos", n))
+		}
+		// the expired idempotency keys are purged along with the trash.
+		if _, err = repo.IdempotencyStorageSQL().PurgeExpired(ctx); err != nil {
+			logger.Error("err purging the idempotency keys", zap.Error(err))
+		}
+		// and so are the old webhook deliveries, and then their events.
+		before := time.Now().Add(-c.deliveryRetention)
+		if _, err = repo.WebhookStorageSQL().PurgeDeliveries(ctx, before); err != nil {
+			logger.Error("err purging the webhook deliveries", zap.Error(err))
+		}
+		if _, err = repo.EventStorageSQL().PurgeEvents(ctx, before); err != nil {
+			logger.Error("err purging the outbox events", zap.Error(err))
+		}
+	})
+
+	advancer := pkg.NewSeriesAdvancer(todoSvc, c.advanceInterval)
+	go advancer.Run(ctx, func(n int, err error) {
+		if err != nil {
+			logger.Error("err advancing the recurring todos", zap.Error(err))
+		} else if n > 0 {
+			logger.Info("recurring todos advanced", zap.Int("todos", n))
+		}
+	})
+
+	notifiers := map[string]pkg.Notifier{
+		pkg.ChannelEmail: notifier.NewSMTP(c.smtpAddr, c.smtpFrom, 10*time.Second),
+		pkg.ChannelLog:   notifier.NewLog(logger),
+	}
+	if c.webhookURL != "" {
+		notifiers[pkg.ChannelWebhook] = notifier.NewWebhook(c.webhookURL, 10*time.Second)
+	}
+	scheduler := pkg.NewReminderScheduler(repo.ReminderStorageSQL(), notifiers, c.reminderInterval)
+	go scheduler.Run(ctx, func(sent, failed int, err error) {
+		if err != nil {
+			logger.Error("err delivering the reminders", zap.Error(err))
+		} else if sent+failed > 0 {
+			logger.Info("reminders delivered", zap.Int("sent", sent), zap.Int("failed", failed))
+		}
+	})
+
+	dispatcher := pkg.NewWebhookDispatcher(repo.WebhookStorageSQL(), webhook.NewHTTPSender(10*time.Second),
+		c.staffOrgID, c.webhookInterval)
+	go dispatcher.Run(ctx, func(delivered, failed int, err error) {
+		if err != nil {
+			logger.Error("err delivering the webhooks", zap.Error(err))
+		} else if delivered+failed > 0 {
+			logger.Info("webhooks delivered", zap.Int("delivered", delivered), zap.Int("failed", failed))
+		}
+	}, func(webhookID uuid.UUID) {
+		logger.Warn("webhook disabled after repeated failures", zap.String("webhook_id", webhookID.String()))
+	})
+
+	bus := pkg.NewEventBus()
+	bus.Subscribe("audit", pkg.EventHandlerFunc(func(ctx context.Context, e pkg.Event) error {
+		logger.Info("event", zap.Int64("event_id", e.ID), zap.String("event_type", e.Type),
+			zap.String("user_id", e.UserID.String()), zap.String("subject_id", e.SubjectID.String()))
+		return nil
+	}))
+	bus.Subscribe("webhooks", dispatcher)
+	relay := pkg.NewOutboxRelay(repo.EventStorageSQL(), bus, c.relayInterval)
+	go relay.Run(ctx, func(relayed, failed int, err error) {
+		if err != nil {
+			logger.Error("err relaying the events", zap.Error(err))
+		} else if failed > 0 {
+			logger.Warn("events not relayed", zap.Int("relayed", relayed), zap.Int("failed", failed))
+		}
+	})
+
+	go stream.Run(ctx, 5*time.Second, func(err error) {
+		logger.Error("err reading the todo events", zap.Error(err))
+	})
+
+	srv := &http.Server{
+		Addr:              c.addr,
+		Handler:           mh,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	streamSrv := &http.Server{
+		Addr:              c.streamAddr,
+		Handler:           streams,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		// the event streams write until their lifetime is over
+		WriteTimeout: c.streamLifetime + 15*time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	servers := []*http.Server{srv, streamSrv}
+	if c.adminAddr != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/debug/vars", expvar.Handler())
+		servers = append(servers, &http.Server{
+			Addr:              c.adminAddr,
+			Handler:           admin,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+		})
+	}
+	for _, s := range servers {
+		go func(s *http.Server) {
+			logger.Info("listening", zap.String("addr", s.Addr))
+			if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("err serving", zap.Error(err))
+			}
+		}(s)
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancelShutdown()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			logger.Error("err shutting down", zap.Error(err), zap.String("addr", s.Addr))
+		}
+	}
+}
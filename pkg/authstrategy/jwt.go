@@ -21,6 +21,8 @@ const (
 // Claims defines custom claims that will be encoded to a JWT.
 type Claims struct {
 	UserID string `json:"user"` // uuid that represents user
+	// OrgID is the uuid of the active organization, empty for the personal workspace
+	OrgID string `json:"org,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -34,7 +36,7 @@ type JWT struct {
 	validDuration time.Duration
 }
 
-// NewJWT return an initialized JWT
+// NewJWT return an initialized JWT, its tokens expire after the validDuration
 func NewJWT(privKey *rsa.PrivateKey, pubKey *rsa.PublicKey, iss, aud string, validDuration time.Duration) (JWT, error) {
 	var j JWT
 	if privKey != nil && pubKey != nil {
@@ -50,8 +52,14 @@ func NewJWT(privKey *rsa.PrivateKey, pubKey *rsa.PublicKey, iss, aud string, val
 	return j, fmt.Errorf("rsa private key and public key should not be nil")
 }
 
-// Validate validates the provided token
+// Validate validates the provided token and returns its user id
 func (j JWT) Validate(token string) (string, error) {
+	c, err := j.ValidateClaims(token)
+	return c.UserID, err
+}
+
+// ValidateClaims validates the provided token and returns its claims
+func (j JWT) ValidateClaims(token string) (Claims, error) {
 	c := &Claims{}
 
 	parsedT, err := jwt.ParseWithClaims(token, c, func(token *jwt.Token) (interface{}, error) {
@@ -64,25 +72,29 @@ func (j JWT) Validate(token string) (string, error) {
 	}, jwt.WithAudience(j.aud[0]))
 
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
 
 	// check if the token is valid for "exp, iat, nbf"
 	if err := parsedT.Claims.Valid(j.validator); err != nil {
-		return "", err
+		return Claims{}, err
 	}
 
-	return c.UserID, nil
+	return *c, nil
 }
 
-// Generate a new token
+// Generate a new token of the user
 func (j JWT) Generate(id string) (string, error) {
+	return j.GenerateClaims(Claims{UserID: id})
+}
+
+// GenerateClaims generates a new token of the user and active organization of the claims,
+// its registered claims are set by the JWT.
+func (j JWT) GenerateClaims(c Claims) (string, error) {
 	// Declare the expiration time of the token
-	// here, we have kept it as 5 minutes
-	expirationTime := time.Now().Add(j.validDuration * time.Minute)
+	expirationTime := time.Now().Add(j.validDuration)
 	// Create the JWT claims, which includes the username and expiry time
-	claim := &Claims{}
-	claim.UserID = id
+	claim := &Claims{UserID: c.UserID, OrgID: c.OrgID}
 	claim.Audience = j.aud
 	claim.Issuer = j.issuer
 	claim.ExpiresAt = jwt.At(expirationTime)
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go/v4"
 )
@@ -48,7 +49,7 @@ func TestMain(m *testing.M) {
 
 func TestJWT_Generate(t *testing.T) {
 	t.Parallel()
-	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5)
+	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5*time.Minute)
 	if err != nil {
 		t.Error(err)
 	}
@@ -61,7 +62,7 @@ func TestJWT_Generate(t *testing.T) {
 func TestJWT_Validate(t *testing.T) {
 	t.Parallel()
 	userID := "randomUUUID"
-	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5)
+	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5*time.Minute)
 	if err != nil {
 		t.Error(err)
 	}
@@ -78,9 +79,49 @@ func TestJWT_Validate(t *testing.T) {
 	}
 }
 
+func TestJWT_ValidateClaims(t *testing.T) {
+	t.Parallel()
+	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5*time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+	for _, want := range []Claims{{UserID: "randomUUUID"}, {UserID: "randomUUUID", OrgID: "randomOrgID"}} {
+		token, err := nJwt.GenerateClaims(want)
+		if err != nil {
+			t.Error(err)
+		}
+		c, err := nJwt.ValidateClaims(token)
+		if err != nil {
+			t.Error(err)
+		}
+		if c.UserID != want.UserID || c.OrgID != want.OrgID {
+			t.Errorf("expected jwt to return the user %s and org %q, got %s and %q", want.UserID, want.OrgID, c.UserID, c.OrgID)
+		}
+	}
+}
+
+func TestJWT_Expiry(t *testing.T) {
+	t.Parallel()
+	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", time.Hour)
+	if err != nil {
+		t.Error(err)
+	}
+	token, err := nJwt.Generate("randomUUUID")
+	if err != nil {
+		t.Error(err)
+	}
+	c, err := nJwt.ValidateClaims(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Until(c.ExpiresAt.Time); left <= 59*time.Minute || left > time.Hour {
+		t.Errorf("expected the token to expire in an hour, got %v", left)
+	}
+}
+
 func BenchmarkJWT_Validate(b *testing.B) {
 	userID := "randomUUUID"
-	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5)
+	nJwt, err := NewJWT(rsaPrK, rsaPuK, "test", "ankur", 5*time.Minute)
 	if err != nil {
 		b.Error(err)
 	}
@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The types of the domain events. The storage writes them to its outbox in
+// the same transaction as the change they're about, whichever write makes it.
+const (
+	// EventUserSignedUp is a user registered, its data is only the id of the user.
+	// The events are kept along their deliveries, so they hold no personal data.
+	EventUserSignedUp = "user.signed_up"
+	// EventTodoCreated is a todo stored, the occurrences of the recurring ones included.
+	EventTodoCreated = "todo.created"
+	// EventTodoUpdated is a todo whose title, content, auto finish, due time or project changed.
+	EventTodoUpdated = "todo.updated"
+	// EventTodoCompleted is a todo finished, either directly or by its checklist items.
+	EventTodoCompleted = "todo.completed"
+	// EventTodoDeleted is a todo moved to the trash, or deleted along with its project.
+	EventTodoDeleted = "todo.deleted"
+	// EventTodoRestored is a todo restored from the trash.
+	EventTodoRestored = "todo.restored"
+	// EventAccessRevoked is a grant or a membership removed, its data is the user_id who lost it
+	// and the todo_id, project_id or org_id it was on. Only the sync reads it, a webhook can't
+	// subscribe to it.
+	EventAccessRevoked = "access.revoked"
+)
+
+// eventTypes are the types of the domain events a webhook can subscribe to.
+var eventTypes = map[string]bool{
+	EventUserSignedUp:  true,
+	EventTodoCreated:   true,
+	EventTodoUpdated:   true,
+	EventTodoCompleted: true,
+	EventTodoDeleted:   true,
+	EventTodoRestored:  true,
+}
+
+// Defaults of the outbox relay.
+const (
+	relayBatch       = 100
+	relayLease       = time.Minute
+	relayMaxAttempts = 10
+)
+
+// Event is a domain event of the outbox. The data of the todo events is the json of the
+// todo once changed, its id, user_id, project_id, title, content, finished, auto_finish, version,
+// due_at, deleted_at and series_id, along with the moved_from project if it moved.
+type Event struct {
+	// ID orders the events, it's the same for every attempt to relay the event.
+	ID   int64
+	Type string
+	// UserID is the user the event is about, the owner of the todo, the user signed up
+	// or the one who lost access.
+	UserID uuid.UUID
+	// OrgID is the organization of the project of the todo, nil for a personal one.
+	OrgID uuid.UUID
+	// SubjectID is the id of the todo or user.
+	SubjectID  uuid.UUID
+	Data       json.RawMessage
+	OccurredAt time.Time
+	// Attempt counts the attempts to relay the event, from 1.
+	Attempt int
+}
+
+// EventStorage define a contract for storage, to relay the events of its outbox.
+type EventStorage interface {
+	// ClaimEvents returns at most n events due at the now time, in order, and leases
+	// them until now+lease so no other claim returns them before.
+	ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, n int) ([]Event, error)
+	// MarkRelayed marks the event relayed to all the subscribers.
+	MarkRelayed(ctx context.Context, e Event) error
+	// MarkEventFailed records the failed attempt to relay the event, retried at
+	// the retryAt time unless it's dead.
+	MarkEventFailed(ctx context.Context, e Event, reason string, retryAt time.Time, dead bool) error
+	// PurgeEvents deletes the events relayed or dead before the time, and returns
+	// how many were deleted.
+	PurgeEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// EventHandler handles the events published on an EventBus. An event can be handled more
+// than once, a handler tells the duplicates apart by the ID of the event.
+type EventHandler interface {
+	Handle(ctx context.Context, e Event) error
+}
+
+// EventHandlerFunc is an EventHandler function.
+type EventHandlerFunc func(ctx context.Context, e Event) error
+
+// Handle calls f(ctx, e).
+func (f EventHandlerFunc) Handle(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+type subscription struct {
+	name    string
+	types   map[string]bool
+	handler EventHandler
+}
+
+// EventBus is an in-process publish/subscribe of the domain events, safe
+// for concurrent use. The events are handled in the goroutine publishing them.
+type EventBus struct {
+	mu   sync.RWMutex
+	subs []subscription
+}
+
+// NewEventBus returns an EventBus without subscriber.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe subscribes the handler, known by the name, to the events of the types,
+// to all of them if none.
+func (b *EventBus) Subscribe(name string, handler EventHandler, types ...string) {
+	sub := subscription{name: name, handler: handler}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, typ := range types {
+			sub.types[typ] = true
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, sub)
+}
+
+// Publish hands the event to every subscriber of its type, in the order they subscribed.
+// All of them are handed the event even if one fails, the error tells which failed.
+func (b *EventBus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	var failed []string
+	var first error
+	for _, sub := range subs {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+		if err := sub.handler.Handle(ctx, e); err != nil {
+			failed = append(failed, sub.name)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("event %d not handled by %v: %w", e.ID, failed, first)
+	}
+	return nil
+}
+
+// OutboxRelay publishes the events of the outbox on the bus, at least once. An event
+// is relayed once all the subscribers handled it, if any failed the event is published
+// again with an exponential backoff, to all of them, and is dead once it failed
+// relayMaxAttempts times. Like the reminders, the events are claimed from the storage
+// so many relays can share it.
+type OutboxRelay struct {
+	repo     EventStorage
+	bus      *EventBus
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewOutboxRelay returns an OutboxRelay that publishes the events of the repo on the bus every interval.
+func NewOutboxRelay(repo EventStorage, bus *EventBus, interval time.Duration) OutboxRelay {
+	return OutboxRelay{
+		repo:     repo,
+		bus:      bus,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Relay publishes the events due now, a batch after the other until none is left, and
+// returns how many were relayed and how many failed.
+func (or OutboxRelay) Relay(ctx context.Context) (relayed, failed int, err error) {
+	for {
+		events, err := or.repo.ClaimEvents(ctx, or.now(), relayLease, relayBatch)
+		if err != nil {
+			return relayed, failed, err
+		}
+		for _, e := range events {
+			err := or.bus.Publish(ctx, e)
+			if err == nil {
+				relayed++
+				err = or.repo.MarkRelayed(ctx, e)
+			} else {
+				failed++
+				err = or.repo.MarkEventFailed(ctx, e, err.Error(), or.now().Add(backoff(e.Attempt)),
+					e.Attempt >= relayMaxAttempts)
+			}
+			// the lease expires if the mark failed, so it's relayed again.
+			if err != nil {
+				return relayed, failed, err
+			}
+		}
+		if len(events) < relayBatch || ctx.Err() != nil {
+			return relayed, failed, nil
+		}
+	}
+}
+
+// Run relays right away and then every interval, until the ctx is done.
+// The outcome of every relay is reported to the onRelay, if not nil.
+func (or OutboxRelay) Run(ctx context.Context, onRelay func(relayed, failed int, err error)) {
+	ticker := time.NewTicker(or.interval)
+	defer ticker.Stop()
+	for {
+		relayed, failed, err := or.Relay(ctx)
+		if onRelay != nil && ctx.Err() == nil {
+			onRelay(relayed, failed, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
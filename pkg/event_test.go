@@ -0,0 +1,122 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// dummyEventRepo claims the due events once, and records their marks.
+type dummyEventRepo struct {
+	due     []Event
+	relayed []int64
+	failed  map[int64]failedEvent
+}
+
+type failedEvent struct {
+	reason  string
+	retryAt time.Time
+	dead    bool
+}
+
+func (d *dummyEventRepo) ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, n int) ([]Event, error) {
+	if n > len(d.due) {
+		n = len(d.due)
+	}
+	due := d.due[:n]
+	d.due = d.due[n:]
+	return due, nil
+}
+
+func (d *dummyEventRepo) MarkRelayed(ctx context.Context, e Event) error {
+	d.relayed = append(d.relayed, e.ID)
+	return nil
+}
+
+func (d *dummyEventRepo) MarkEventFailed(ctx context.Context, e Event, reason string, retryAt time.Time, dead bool) error {
+	d.failed[e.ID] = failedEvent{reason: reason, retryAt: retryAt, dead: dead}
+	return nil
+}
+
+func (d *dummyEventRepo) PurgeEvents(ctx context.Context, before time.Time) (int64, error) {
+	panic("implement me")
+}
+
+func TestEventBus_Publish(t *testing.T) {
+	t.Parallel()
+	bus := NewEventBus()
+	var audit, completed []int64
+	bus.Subscribe("audit", EventHandlerFunc(func(ctx context.Context, e Event) error {
+		audit = append(audit, e.ID)
+		return nil
+	}))
+	bus.Subscribe("broken", EventHandlerFunc(func(ctx context.Context, e Event) error {
+		return errors.New("connection refused")
+	}), EventTodoDeleted)
+	bus.Subscribe("completed", EventHandlerFunc(func(ctx context.Context, e Event) error {
+		completed = append(completed, e.ID)
+		return nil
+	}), EventTodoCompleted, EventTodoDeleted)
+
+	for id, typ := range []string{EventTodoCreated, EventTodoCompleted} {
+		if err := bus.Publish(context.Background(), Event{ID: int64(id + 1), Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(audit) != 2 || len(completed) != 1 || completed[0] != 2 {
+		t.Errorf("expected the events handled by the subscribers of their type got %v %v", audit, completed)
+	}
+
+	// the failing subscriber doesn't keep the event from the others
+	err := bus.Publish(context.Background(), Event{ID: 3, Type: EventTodoDeleted})
+	if err == nil || err.Error() != "event 3 not handled by [broken]: connection refused" {
+		t.Errorf("expected the event not handled by the broken subscriber got %v", err)
+	}
+	if len(audit) != 3 || len(completed) != 2 {
+		t.Errorf("expected the event handled by the other subscribers got %v %v", audit, completed)
+	}
+}
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	repo := &dummyEventRepo{failed: make(map[int64]failedEvent)}
+	for i := 1; i <= relayBatch+2; i++ {
+		repo.due = append(repo.due, Event{ID: int64(i), Type: EventTodoCreated, Attempt: 1})
+	}
+	retried := Event{ID: int64(relayBatch + 3), Type: EventTodoDeleted, Attempt: 3}
+	last := Event{ID: int64(relayBatch + 4), Type: EventTodoDeleted, Attempt: relayMaxAttempts}
+	repo.due = append(repo.due, retried, last)
+
+	bus := NewEventBus()
+	bus.Subscribe("webhooks", EventHandlerFunc(func(ctx context.Context, e Event) error {
+		if e.Type == EventTodoDeleted {
+			return errors.New("connection refused")
+		}
+		return nil
+	}))
+	or := NewOutboxRelay(repo, bus, time.Minute)
+	or.now = func() time.Time { return now }
+
+	relayed, failed, err := or.Relay(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relayed != relayBatch+2 || failed != 2 || len(repo.relayed) != relayBatch+2 || len(repo.due) != 0 {
+		t.Errorf("expected %d relayed and 2 failed got %d %d", relayBatch+2, relayed, failed)
+	}
+	for i, id := range repo.relayed {
+		if id != int64(i+1) {
+			t.Fatalf("expected the events relayed in order got %d at %d", id, i)
+		}
+	}
+	if mark := repo.failed[retried.ID]; mark.dead || mark.reason == "" || !mark.retryAt.Equal(now.Add(4*time.Minute)) {
+		t.Errorf("expected the third attempt retried in 4m got %+v", mark)
+	}
+	if mark := repo.failed[last.ID]; !mark.dead {
+		t.Errorf("expected the last attempt dead got %+v", mark)
+	}
+}
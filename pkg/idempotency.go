@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord is the first response to a request carrying an idempotency key,
+// replayed on the retries of the same request until it expires.
+type IdempotencyRecord struct {
+	// Scope isolates the keys of different clients, like the id of the user.
+	Scope string
+	Key   string
+	// Fingerprint identifies the request the key has first been used with,
+	// a retry must carry the same one.
+	Fingerprint string
+	// StatusCode is zero while the first request is still in flight.
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	ExpiresAt  time.Time
+}
+
+// Completed reports if the response of the record has been saved.
+func (ir IdempotencyRecord) Completed() bool {
+	return ir.StatusCode != 0
+}
+
+// IdempotencyStorage define a contract for storage, to interact
+// with the IdempotencyRecord.
+type IdempotencyStorage interface {
+	// Reserve stores the record without a response, unless an unexpired record exists
+	// for the same scope and key, in which case the existing one is returned with
+	// reserved false. The check and the store are atomic.
+	Reserve(ctx context.Context, record IdempotencyRecord) (existing IdempotencyRecord, reserved bool, err error)
+	// Complete saves the response of the reserved record.
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	// Release deletes the reserved record, so the request can be retried from scratch.
+	Release(ctx context.Context, scope, key string) error
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// NilItemModel is empty ItemModel, all zeros
+	NilItemModel ItemModel
+)
+
+var (
+	// ErrInvalidItem indicates the checklist item fails the validation
+	ErrInvalidItem = errors.New("invalid item")
+)
+
+// ItemModel is a step of a todo checklist
+type ItemModel struct {
+	ID     uuid.UUID
+	TodoID uuid.UUID
+	Text   string
+	// Position orders the items of the todo, lowest first.
+	Position int
+	Done     bool
+}
+
+// Progress tells how many checklist items of a todo are done.
+type Progress struct {
+	Done  int
+	Total int
+}
+
+// ItemStorage define a contract for storage, to interact
+// with the checklist ItemModel.
+//
+// Every write only applies to a todo of the owner userID not in the trash, serror.ErrTodoNotFound
+// is returned otherwise. Along with the item it increments the version of the todo, and
+// marks it as finished if it's an AutoFinish one whose items are now all done. The updated
+// todo is returned.
+type ItemStorage interface {
+	// FindItemsOfTodo returns the items of the todo, ordered by position.
+	FindItemsOfTodo(ctx context.Context, todoID uuid.UUID) ([]ItemModel, error)
+	// ProgressOfTodos returns the progress of the todos that have items.
+	ProgressOfTodos(ctx context.Context, todoIDs []uuid.UUID) (map[uuid.UUID]Progress, error)
+	// InsertOne stores the item and returns it, a zero Position puts it last.
+	InsertOne(ctx context.Context, userID uuid.UUID, item ItemModel) (ItemModel, TodoModel, error)
+	// UpdateOne updates the item and returns it, a zero Position keeps the current one.
+	UpdateOne(ctx context.Context, userID uuid.UUID, item ItemModel) (ItemModel, TodoModel, error)
+	DeleteOne(ctx context.Context, userID, todoID, id uuid.UUID) (TodoModel, error)
+}
+
+// ItemService provides the use cases implementation to work
+// with the checklist items of the todos of a user.
+type ItemService struct {
+	todos TodoService
+	repo  ItemStorage
+}
+
+// NewItemService returns a new ItemService initialized with
+// a concrete repo implementation, the items are authorized as their todo by the todos.
+func NewItemService(todos TodoService, repo ItemStorage) ItemService {
+	return ItemService{
+		todos: todos,
+		repo:  repo,
+	}
+}
+
+// IsValidItem validate if the item is valid or not
+func (is ItemService) IsValidItem(item ItemModel) bool {
+	text := strings.TrimSpace(item.Text)
+	return text != "" && len(text) <= 255 && item.Position >= 0
+}
+
+// List returns the items of the todo the user can read.
+func (is ItemService) List(ctx context.Context, userID, todoID uuid.UUID) ([]ItemModel, error) {
+	if _, err := is.todos.Find(ctx, userID, todoID); err != nil {
+		return nil, err
+	}
+	return is.repo.FindItemsOfTodo(ctx, todoID)
+}
+
+// Progress returns the progress of the todos, the ones without items have none.
+func (is ItemService) Progress(ctx context.Context, todoIDs []uuid.UUID) (map[uuid.UUID]Progress, error) {
+	if len(todoIDs) == 0 {
+		return map[uuid.UUID]Progress{}, nil
+	}
+	return is.repo.ProgressOfTodos(ctx, todoIDs)
+}
+
+// Create adds a new item to the todo the user can edit and returns it, along with the updated todo.
+func (is ItemService) Create(ctx context.Context, userID uuid.UUID, item ItemModel) (ItemModel, TodoModel, error) {
+	if !is.IsValidItem(item) {
+		return NilItemModel, NilTodoModel, ErrInvalidItem
+	}
+	todo, err := is.todos.authorized(ctx, userID, item.TodoID, RoleEditor)
+	if err != nil {
+		return NilItemModel, NilTodoModel, err
+	}
+	item.ID = uuid.New()
+	item.Text = strings.TrimSpace(item.Text)
+	created, updated, err := is.repo.InsertOne(ctx, todo.UserID, item)
+	if err != nil {
+		return NilItemModel, NilTodoModel, err
+	}
+	is.autoFinished(ctx, todo, updated)
+	return created, updated, nil
+}
+
+// Update updates the item of the todo the user can edit and returns it, along with the updated todo.
+func (is ItemService) Update(ctx context.Context, userID uuid.UUID, item ItemModel) (ItemModel, TodoModel, error) {
+	if !is.IsValidItem(item) {
+		return NilItemModel, NilTodoModel, ErrInvalidItem
+	}
+	todo, err := is.todos.authorized(ctx, userID, item.TodoID, RoleEditor)
+	if err != nil {
+		return NilItemModel, NilTodoModel, err
+	}
+	item.Text = strings.TrimSpace(item.Text)
+	saved, updated, err := is.repo.UpdateOne(ctx, todo.UserID, item)
+	if err != nil {
+		return NilItemModel, NilTodoModel, err
+	}
+	is.autoFinished(ctx, todo, updated)
+	return saved, updated, nil
+}
+
+// Delete deletes the item of the todo the user can edit and returns the updated todo.
+func (is ItemService) Delete(ctx context.Context, userID, todoID, id uuid.UUID) (TodoModel, error) {
+	todo, err := is.todos.authorized(ctx, userID, todoID, RoleEditor)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	updated, err := is.repo.DeleteOne(ctx, todo.UserID, todoID, id)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	is.autoFinished(ctx, todo, updated)
+	return updated, nil
+}
+
+// autoFinished stores the next occurrence of the recurring todo its items finished.
+// The item is saved already, a failed advance is caught up by the SeriesAdvancer.
+func (is ItemService) autoFinished(ctx context.Context, before, after TodoModel) {
+	if !before.Finished && after.Finished {
+		_ = is.todos.advance(ctx, after)
+	}
+}
@@ -0,0 +1,94 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyItemRepo records the inserted items, an item updated done finishes its todo of the todos.
+type dummyItemRepo struct {
+	inserted []ItemModel
+	todos    *dummyTodoRepo
+}
+
+func (d *dummyItemRepo) FindItemsOfTodo(ctx context.Context, todoID uuid.UUID) ([]ItemModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyItemRepo) ProgressOfTodos(ctx context.Context, todoIDs []uuid.UUID) (map[uuid.UUID]Progress, error) {
+	panic("implement me")
+}
+
+func (d *dummyItemRepo) InsertOne(ctx context.Context, userID uuid.UUID, item ItemModel) (ItemModel, TodoModel, error) {
+	d.inserted = append(d.inserted, item)
+	return item, NilTodoModel, nil
+}
+
+func (d *dummyItemRepo) UpdateOne(ctx context.Context, userID uuid.UUID, item ItemModel) (ItemModel, TodoModel, error) {
+	todo := d.todos.todos[item.TodoID]
+	todo.Finished = todo.Finished || (todo.AutoFinish && item.Done)
+	todo.Version++
+	d.todos.todos[todo.ID] = todo
+	return item, todo, nil
+}
+
+func (d *dummyItemRepo) DeleteOne(ctx context.Context, userID, todoID, id uuid.UUID) (TodoModel, error) {
+	panic("implement me")
+}
+
+func TestItemService_Create(t *testing.T) {
+	t.Parallel()
+	repo := &dummyItemRepo{}
+	todos := newDummyTodoRepo()
+	is := NewItemService(NewTodoService(todos), repo)
+	userID := uuid.New()
+	todoID := uuid.New()
+	todos.todos[todoID] = TodoModel{ID: todoID, UserID: userID, Title: "with steps", Version: 1}
+
+	for _, item := range []ItemModel{{TodoID: todoID, Text: "  "}, {TodoID: todoID, Text: "step", Position: -1}} {
+		if _, _, err := is.Create(context.Background(), userID, item); !errors.Is(err, ErrInvalidItem) {
+			t.Errorf("expected invalid item error for %+v got %v", item, err)
+		}
+	}
+	if _, _, err := is.Create(context.Background(), uuid.New(), ItemModel{TodoID: todoID, Text: "step"}); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for another user got %v", err)
+	}
+
+	item, _, err := is.Create(context.Background(), userID, ItemModel{TodoID: todoID, Text: " step "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID == uuid.Nil || item.Text != "step" || len(repo.inserted) != 1 {
+		t.Errorf("unexpected created item %+v", item)
+	}
+}
+
+func TestItemService_AutoFinishRecurring(t *testing.T) {
+	t.Parallel()
+	todos := newDummyTodoRepo()
+	series := newDummySeriesRepo(todos)
+	is := NewItemService(NewRecurringTodoService(todos, nil, NewAuthorizer(nil), series), &dummyItemRepo{todos: todos})
+	userID := uuid.New()
+	seriesID := uuid.New()
+	dueAt := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	series.series[seriesID] = SeriesModel{ID: seriesID, UserID: userID, RRule: "FREQ=DAILY", TimeZone: "UTC",
+		Start: dueAt, Title: "daily"}
+	todo := TodoModel{ID: uuid.New(), UserID: userID, Title: "daily", AutoFinish: true, Version: 1, DueAt: dueAt, SeriesID: seriesID}
+	todos.todos[todo.ID] = todo
+
+	_, updated, err := is.Update(context.Background(), userID, ItemModel{ID: uuid.New(), TodoID: todo.ID, Text: "step", Done: true})
+	if err != nil || !updated.Finished {
+		t.Fatalf("expected the todo finished by its item got %+v %v", updated, err)
+	}
+	if _, ok := todos.todos[occurrenceID(seriesID, dueAt.AddDate(0, 0, 1))]; !ok {
+		t.Errorf("expected the next occurrence of the auto finished todo stored")
+	}
+}
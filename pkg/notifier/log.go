@@ -0,0 +1,35 @@
+package notifier
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// Compile-time check for ensuring Log implements pkg.Notifier.
+var _ pkg.Notifier = (*Log)(nil)
+
+// Log writes the notifications to a logger, it never fails.
+type Log struct {
+	logger *zap.Logger
+}
+
+// NewLog returns a Log that writes to the logger.
+func NewLog(logger *zap.Logger) Log {
+	return Log{logger: logger}
+}
+
+// Notify logs the notification.
+func (l Log) Notify(ctx context.Context, n pkg.Notification) error {
+	l.logger.Info("reminder",
+		zap.String("reminder_id", n.ReminderID.String()),
+		zap.String("todo_id", n.TodoID.String()),
+		zap.String("user_id", n.UserID.String()),
+		zap.String("title", n.Title),
+		zap.Time("due_at", n.DueAt),
+		zap.Int("attempt", n.Attempt),
+	)
+	return nil
+}
@@ -0,0 +1,154 @@
+// +build unit_tests all_tests
+
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func notification() pkg.Notification {
+	return pkg.Notification{
+		ReminderID: uuid.New(),
+		TodoID:     uuid.New(),
+		UserID:     uuid.New(),
+		Email:      "ankur@example.com",
+		Channel:    pkg.ChannelEmail,
+		Title:      "pay rent",
+		DueAt:      time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC),
+		Attempt:    1,
+	}
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	t.Parallel()
+	n := notification()
+	var got webhookPayload
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a json post got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(srv.URL, time.Second)
+	if err := wh.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if got.ReminderID != n.ReminderID || got.Title != n.Title || !got.DueAt.Equal(n.DueAt) {
+		t.Errorf("expected the payload of the notification got %+v", got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := wh.Notify(context.Background(), n); err == nil {
+		t.Errorf("expected a failed delivery on a %d", status)
+	}
+}
+
+// fakeSMTP serves a single SMTP session on a local port, and
+// sends the data of the mail it received.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		reply := func(s string) {
+			_, _ = rw.WriteString(s + "\r\n")
+			_ = rw.Flush()
+		}
+		reply("220 localhost ESMTP")
+		var mail strings.Builder
+		inData := false
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				data <- mail.String()
+				reply("250 queued")
+			case inData:
+				mail.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), data
+}
+
+func TestSMTP_Notify(t *testing.T) {
+	t.Parallel()
+	addr, data := fakeSMTP(t)
+	n := notification()
+	n.Title = "pay\r\nBcc: intruder@example.com"
+	if err := NewSMTP(addr, "reminders@example.com", time.Second).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mail := <-data:
+		if !strings.Contains(mail, "To: ankur@example.com\r\n") || !strings.Contains(mail, "Subject: Reminder: pay") {
+			t.Errorf("expected the mail to the user got %q", mail)
+		}
+		if strings.Contains(mail, "\r\nBcc:") {
+			t.Errorf("expected the title kept inside its header got %q", mail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a mail sent")
+	}
+
+	n.Email = ""
+	if err := NewSMTP(addr, "reminders@example.com", time.Second).Notify(context.Background(), n); err == nil {
+		t.Errorf("expected a failed delivery without email")
+	}
+}
+
+func TestLog_Notify(t *testing.T) {
+	t.Parallel()
+	core, logs := observer.New(zap.InfoLevel)
+	n := notification()
+	if err := NewLog(zap.New(core)).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.FilterField(zap.String("todo_id", n.TodoID.String())).All()
+	if len(entries) != 1 {
+		t.Errorf("expected the notification logged got %+v", logs.All())
+	}
+}
@@ -0,0 +1,99 @@
+// Package notifier delivers the reminders of the todos, each type being
+// the pkg.Notifier of a channel.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// Compile-time check for ensuring SMTP implements pkg.Notifier.
+var _ pkg.Notifier = (*SMTP)(nil)
+
+// headerReplacer keeps the values from breaking out of their mail header.
+var headerReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// SMTP emails the notifications to the user, through a plain SMTP relay like a local
+// MailHog or Postfix. It doesn't authenticate nor encrypt, the relay is trusted.
+type SMTP struct {
+	addr    string
+	from    string
+	timeout time.Duration
+}
+
+// NewSMTP returns a SMTP that sends from the from address through the relay at the addr.
+// Every delivery gives up after the timeout.
+func NewSMTP(addr, from string, timeout time.Duration) SMTP {
+	return SMTP{
+		addr:    addr,
+		from:    from,
+		timeout: timeout,
+	}
+}
+
+// Notify emails the notification to the email of the user.
+func (s SMTP) Notify(ctx context.Context, n pkg.Notification) error {
+	if n.Email == "" {
+		return fmt.Errorf("no email of the user %s", n.UserID)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	host, _, _ := net.SplitHostPort(s.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err = c.Mail(s.from); err != nil {
+		return err
+	}
+	if err = c.Rcpt(n.Email); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = wc.Write(s.message(n)); err != nil {
+		return err
+	}
+	if err = wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message returns the plain text email of the notification.
+func (s SMTP) message(n pkg.Notification) []byte {
+	var b bytes.Buffer
+	subject := mime.QEncoding.Encode("utf-8", headerReplacer.Replace("Reminder: "+n.Title))
+	fmt.Fprintf(&b, "From: %s\r\n", headerReplacer.Replace(s.from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerReplacer.Replace(n.Email))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "%q is due at %s.\r\n", n.Title, n.DueAt.UTC().Format(time.RFC1123))
+	return b.Bytes()
+}
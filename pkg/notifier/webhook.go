@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// Compile-time check for ensuring Webhook implements pkg.Notifier.
+var _ pkg.Notifier = (*Webhook)(nil)
+
+// webhookPayload is the json body posted for a notification, without the email of the user.
+type webhookPayload struct {
+	ReminderID uuid.UUID `json:"reminder_id"`
+	TodoID     uuid.UUID `json:"todo_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Title      string    `json:"title"`
+	DueAt      time.Time `json:"due_at"`
+	Attempt    int       `json:"attempt"`
+}
+
+// Webhook posts the notifications as json to an url, any response
+// but a 2xx one is a failed delivery.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a Webhook that posts to the url, every post
+// giving up after the timeout.
+func NewWebhook(url string, timeout time.Duration) Webhook {
+	return Webhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts the notification to the url.
+func (wh Webhook) Notify(ctx context.Context, n pkg.Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		ReminderID: n.ReminderID,
+		TodoID:     n.TodoID,
+		UserID:     n.UserID,
+		Title:      n.Title,
+		DueAt:      n.DueAt,
+		Attempt:    n.Attempt,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drained for the connection to be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
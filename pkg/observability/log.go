@@ -13,11 +13,14 @@ import (
 // NewProduction creates a new zap logger and
 // put this into the global logger. It also return a http
 // handler that can be used to change the log level dynamically.
-func NewProduction(appname, hostname string) http.Handler {
+// The salt is mixed into the digest of the hashed log values.
+func NewProduction(appname, hostname, salt string) http.Handler {
 	sampled := zap.NewProductionEncoderConfig()
 	sampled.EncodeTime = zapcore.ISO8601TimeEncoder
 	atom := zap.NewAtomicLevelAt(zapcore.InfoLevel)
 	core := zapcore.NewCore(zapcore.NewJSONEncoder(sampled), os.Stdout, atom)
+	// redact before sampling, so the sampled entry is written through the redaction.
+	core = NewRedactCore(core, saltedRedactConfig(salt))
 	core = zapcore.NewSamplerWithOptions(core, time.Second, 100, 50)
 	log := zap.New(core, zap.AddCaller())
 	log = log.With(zap.String("application", appname), zap.String("hostname", hostname))
@@ -26,12 +29,21 @@ func NewProduction(appname, hostname string) http.Handler {
 }
 
 // NewDevelopment returns a new Dev Logger
-func NewDevelopment(appname, hostname string) {
-	dev, _ := zap.NewDevelopment()
+func NewDevelopment(appname, hostname, salt string) {
+	dev, _ := zap.NewDevelopment(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactCore(core, saltedRedactConfig(salt))
+	}))
 	log := dev.With(zap.String("application", appname), zap.String("hostname", hostname))
 	zap.ReplaceGlobals(log)
 }
 
+// saltedRedactConfig returns the default redaction rules hashing with the salt.
+func saltedRedactConfig(salt string) RedactConfig {
+	cfg := DefaultRedactConfig()
+	cfg.HashSalt = salt
+	return cfg
+}
+
 // A WriteSyncer is an io.Writer that can also flush any buffered data.
 // Used only for testing config
 type WriteSyncer interface {
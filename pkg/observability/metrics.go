@@ -0,0 +1,10 @@
+package observability
+
+import "expvar"
+
+var (
+	// HTTPPanics counts the panics recovered while serving an http request.
+	// It's exported through the expvar handler as http_panics_total, that the
+	// server mounts on /debug/vars of its internal admin listener.
+	HTTPPanics = expvar.NewInt("http_panics_total")
+)
@@ -0,0 +1,231 @@
+package observability
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// RedactMode tells how a sensitive value is rewritten before it is encoded.
+type RedactMode int8
+
+const (
+	// RedactMask replaces the value with a fixed mask
+	RedactMask RedactMode = iota
+	// RedactHash replaces the value with a short salted SHA-256 digest, so the
+	// same value can still be correlated across log lines without being readable
+	RedactHash
+)
+
+const redactedMask = "[REDACTED]"
+
+// RedactPattern rewrites every match of Regexp found inside a string value.
+// If Regexp has capture groups only the first group is rewritten, which allows
+// to keep the surrounding context, example `password=[REDACTED]`.
+type RedactPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+	Mode   RedactMode
+}
+
+// RedactConfig configures what a redacting core rewrites.
+type RedactConfig struct {
+	// Fields maps a field key to how its whole value is redacted,
+	// whatever the type of the value is.
+	Fields map[string]RedactMode
+	// Patterns are applied on string, error and stringer values of
+	// all the other fields.
+	Patterns []RedactPattern
+	// HashSalt is mixed into the digest of RedactHash values,
+	// without it the digest of a guessable value, like an email, can be reversed.
+	HashSalt string
+}
+
+// DefaultRedactConfig returns the PII rules the application logs with.
+func DefaultRedactConfig() RedactConfig {
+	return RedactConfig{
+		Fields: map[string]RedactMode{
+			"password":      RedactMask,
+			"password_hash": RedactMask,
+			"token":         RedactMask,
+			"authorization": RedactMask,
+			"email":         RedactHash,
+			"email_id":      RedactHash,
+		},
+		Patterns: []RedactPattern{
+			{
+				Name:   "jwt",
+				Regexp: regexp.MustCompile(`eyJ[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]*`),
+				Mode:   RedactMask,
+			},
+			{
+				Name:   "bearer",
+				Regexp: regexp.MustCompile(`(?i)bearer\s+([a-zA-Z0-9\-._~+/]+=*)`),
+				Mode:   RedactMask,
+			},
+			{
+				Name:   "secret_param",
+				Regexp: regexp.MustCompile(`(?i)(?:password|passwd|pwd|token|secret|api_key)=([^&\s"]+)`),
+				Mode:   RedactMask,
+			},
+			{
+				Name:   "email",
+				Regexp: regexp.MustCompile(`[a-zA-Z0-9._%+\-]+(?:@|%40)[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`),
+				Mode:   RedactHash,
+			},
+		},
+	}
+}
+
+// redactCore is a zapcore.Core that rewrites the sensitive fields
+// before handing them over to the wrapped core for encoding.
+type redactCore struct {
+	zapcore.Core
+	cfg RedactConfig
+}
+
+// NewRedactCore wraps the core, so every field logged through it is redacted
+// according to the cfg before being encoded.
+func NewRedactCore(core zapcore.Core, cfg RedactConfig) zapcore.Core {
+	return redactCore{Core: core, cfg: cfg}
+}
+
+// With adds structured context to the Core.
+func (rc redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{Core: rc.Core.With(rc.redact(fields)), cfg: rc.cfg}
+}
+
+// Check adds rc to the checked entry, so the Write goes through the redaction.
+func (rc redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if rc.Enabled(ent.Level) {
+		return ce.AddCore(ent, rc)
+	}
+	return ce
+}
+
+// Write redacts the fields and writes the entry with the wrapped core.
+func (rc redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return rc.Core.Write(ent, rc.redact(fields))
+}
+
+func (rc redactCore) redact(fields []zapcore.Field) []zapcore.Field {
+	// copy on write, the caller owns the fields slice.
+	var out []zapcore.Field
+	for i, f := range fields {
+		nf, changed := rc.redactField(f)
+		if !changed {
+			if out != nil {
+				out = append(out, f)
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, i, len(fields))
+			copy(out, fields[:i])
+		}
+		out = append(out, nf)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+func (rc redactCore) redactField(f zapcore.Field) (zapcore.Field, bool) {
+	if mode, ok := rc.cfg.Fields[strings.ToLower(f.Key)]; ok {
+		return stringField(f.Key, rc.apply(mode, fieldValue(f))), true
+	}
+
+	var value string
+	switch f.Type {
+	case zapcore.StringType:
+		value = f.String
+	case zapcore.ErrorType:
+		err, ok := f.Interface.(error)
+		if !ok || err == nil {
+			return f, false
+		}
+		value = err.Error()
+	case zapcore.StringerType:
+		s, ok := f.Interface.(fmt.Stringer)
+		if !ok || s == nil {
+			return f, false
+		}
+		value = s.String()
+	default:
+		// nested objects and arrays are only redacted by key
+		return f, false
+	}
+
+	redacted := rc.redactString(value)
+	if redacted == value {
+		return f, false
+	}
+	return stringField(f.Key, redacted), true
+}
+
+func (rc redactCore) redactString(s string) string {
+	for _, p := range rc.cfg.Patterns {
+		if p.Regexp == nil {
+			continue
+		}
+		s = rc.replace(p, s)
+	}
+	return s
+}
+
+func (rc redactCore) replace(p RedactPattern, s string) string {
+	matches := p.Regexp.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		// rewrite only the first group when the pattern has one
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(rc.apply(p.Mode, s[start:end]))
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func (rc redactCore) apply(mode RedactMode, value string) string {
+	switch mode {
+	case RedactHash:
+		sum := sha256.Sum256([]byte(rc.cfg.HashSalt + value))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	default:
+		return redactedMask
+	}
+}
+
+func stringField(key, value string) zapcore.Field {
+	return zapcore.Field{Key: key, Type: zapcore.StringType, String: value}
+}
+
+// fieldValue returns the string representation of the field value
+// that is used as a hash input.
+func fieldValue(f zapcore.Field) string {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String
+	case zapcore.ByteStringType, zapcore.BinaryType:
+		b, _ := f.Interface.([]byte)
+		return string(b)
+	default:
+		if f.Interface != nil {
+			return fmt.Sprint(f.Interface)
+		}
+		return fmt.Sprint(f.Integer)
+	}
+}
@@ -0,0 +1,344 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	// NilOrgModel is empty OrgModel, all zeros
+	NilOrgModel OrgModel
+)
+
+var (
+	// ErrInvalidOrg indicates the organization fails the validation
+	ErrInvalidOrg = errors.New("invalid organization")
+	// ErrInvalidMembership indicates the membership fails the validation
+	ErrInvalidMembership = errors.New("invalid membership")
+	// ErrLastOrgOwner indicates the write would leave the organization without an owner
+	ErrLastOrgOwner = errors.New("organization would be left without an owner")
+)
+
+// OrgModel is a workspace shared by its members
+type OrgModel struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// OrgRole is the role of a member in an organization, each role
+// has the permissions of the lower ones, see the DefaultPolicy.
+type OrgRole int8
+
+const (
+	// OrgRoleNone isn't a member, the organization isn't even found
+	OrgRoleNone OrgRole = iota
+	// OrgRoleGuest can read the projects and todos
+	OrgRoleGuest
+	// OrgRoleMember can also create projects and write the todos
+	OrgRoleMember
+	// OrgRoleAdmin can also manage all the projects and the members
+	OrgRoleAdmin
+	// OrgRoleOwner can also manage the owners
+	OrgRoleOwner
+)
+
+var orgRoleNames = map[OrgRole]string{
+	OrgRoleGuest:  "guest",
+	OrgRoleMember: "member",
+	OrgRoleAdmin:  "admin",
+	OrgRoleOwner:  "owner",
+}
+
+// String returns the name of the role, as the api shows it
+func (r OrgRole) String() string {
+	if name, ok := orgRoleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("OrgRole(%d)", r)
+}
+
+// ParseOrgRole returns the role of the name, ok is false if it's none of the member roles.
+func ParseOrgRole(name string) (role OrgRole, ok bool) {
+	for role, n := range orgRoleNames {
+		if n == name {
+			return role, true
+		}
+	}
+	return OrgRoleNone, false
+}
+
+// MembershipModel makes a user member of an organization
+type MembershipModel struct {
+	OrgID  uuid.UUID
+	UserID uuid.UUID
+	Role   OrgRole
+}
+
+// Permission is a named action inside an organization
+type Permission string
+
+const (
+	// PermOrgRead allows to read the organization and switch to it
+	PermOrgRead Permission = "org.read"
+	// PermMembersRead allows to list the members
+	PermMembersRead Permission = "org.members.read"
+	// PermMembersManage allows to add, update and remove the members
+	PermMembersManage Permission = "org.members.manage"
+	// PermOwnersManage allows to add, update and remove the owners
+	PermOwnersManage Permission = "org.owners.manage"
+	// PermProjectsRead allows to read the projects of the organization
+	PermProjectsRead Permission = "projects.read"
+	// PermProjectsCreate allows to create projects in the organization
+	PermProjectsCreate Permission = "projects.create"
+	// PermProjectsManage allows to update and delete any project of the organization
+	PermProjectsManage Permission = "projects.manage"
+	// PermTodosRead allows to read the todos of the projects of the organization
+	PermTodosRead Permission = "todos.read"
+	// PermTodosWrite allows to write the todos of the projects of the organization
+	PermTodosWrite Permission = "todos.write"
+	// PermWebhooksManage allows to register, update and delete the webhooks of the organization
+	PermWebhooksManage Permission = "webhooks.manage"
+)
+
+// Policy grants the permissions of each organization role
+type Policy map[OrgRole][]Permission
+
+// DefaultPolicy is the policy of every organization
+var DefaultPolicy = Policy{
+	OrgRoleGuest: {PermOrgRead, PermProjectsRead, PermTodosRead},
+	OrgRoleMember: {PermOrgRead, PermMembersRead, PermProjectsRead, PermProjectsCreate,
+		PermTodosRead, PermTodosWrite},
+	OrgRoleAdmin: {PermOrgRead, PermMembersRead, PermMembersManage, PermProjectsRead, PermProjectsCreate,
+		PermProjectsManage, PermTodosRead, PermTodosWrite, PermWebhooksManage},
+	OrgRoleOwner: {PermOrgRead, PermMembersRead, PermMembersManage, PermOwnersManage, PermProjectsRead,
+		PermProjectsCreate, PermProjectsManage, PermTodosRead, PermTodosWrite, PermWebhooksManage},
+}
+
+// Can reports if the role has the permission.
+func (p Policy) Can(role OrgRole, perm Permission) bool {
+	for _, granted := range p[role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// projectRole returns the role the org role has on the projects
+// of the organization, and on their todos.
+func (p Policy) projectRole(role OrgRole) Role {
+	switch {
+	case p.Can(role, PermProjectsManage):
+		return RoleOwner
+	case p.Can(role, PermTodosWrite):
+		return RoleEditor
+	case p.Can(role, PermTodosRead):
+		return RoleViewer
+	default:
+		return RoleNone
+	}
+}
+
+// OrgStorage define a contract for storage, to interact
+// with the OrgModel and its MembershipModel.
+type OrgStorage interface {
+	FindOneOrg(ctx context.Context, id uuid.UUID) (OrgModel, error)
+	// FindOrgsOfUser returns the organizations the user is a member of.
+	FindOrgsOfUser(ctx context.Context, userID uuid.UUID) ([]OrgModel, error)
+	// InsertOne stores the organization along with its first owner.
+	InsertOne(ctx context.Context, org OrgModel, ownerID uuid.UUID) error
+	// RoleOf returns the role of the user in the organization, OrgRoleNone if not a member.
+	RoleOf(ctx context.Context, orgID, userID uuid.UUID) (OrgRole, error)
+	// RoleOnProject returns the organization of the project, nil for a personal project,
+	// and the role of the user in it, OrgRoleNone if not a member.
+	RoleOnProject(ctx context.Context, userID, projectID uuid.UUID) (orgID uuid.UUID, role OrgRole, err error)
+	// FindMembers returns the members of the organization.
+	FindMembers(ctx context.Context, orgID uuid.UUID) ([]MembershipModel, error)
+	// UpsertMember stores the membership, replacing the role the user had.
+	UpsertMember(ctx context.Context, member MembershipModel) error
+	// DeleteMember removes the user from the organization,
+	// serror.ErrMemberNotFound is returned if not a member.
+	DeleteMember(ctx context.Context, orgID, userID uuid.UUID) error
+}
+
+// OrgService provides the use cases implementation to work
+// with the organizations of a user and their members.
+type OrgService struct {
+	auth  Authorizer
+	repo  OrgStorage
+	users UserStorage
+}
+
+// NewOrgService returns a new OrgService initialized with
+// the concrete repo implementations and the DefaultPolicy.
+func NewOrgService(repo OrgStorage, users UserStorage) OrgService {
+	return OrgService{
+		auth:  NewOrgAuthorizer(nil, repo),
+		repo:  repo,
+		users: users,
+	}
+}
+
+// IsValidOrg validate if the organization is valid or not
+func (os OrgService) IsValidOrg(org OrgModel) bool {
+	name := strings.TrimSpace(org.Name)
+	return name != "" && len(name) <= 100
+}
+
+// Authorize returns nil if the role of the user in the organization has the permission.
+func (os OrgService) Authorize(ctx context.Context, userID, orgID uuid.UUID, perm Permission) error {
+	return os.auth.AuthorizeOrg(ctx, userID, orgID, perm)
+}
+
+// Create stores a new organization whose owner is the user and returns it.
+func (os OrgService) Create(ctx context.Context, userID uuid.UUID, org OrgModel) (OrgModel, error) {
+	if !os.IsValidOrg(org) {
+		return NilOrgModel, ErrInvalidOrg
+	}
+	org.ID = uuid.New()
+	org.Name = strings.TrimSpace(org.Name)
+	if err := os.repo.InsertOne(ctx, org, userID); err != nil {
+		return NilOrgModel, err
+	}
+	return org, nil
+}
+
+// Find returns the organization with the id, if the user is a member.
+func (os OrgService) Find(ctx context.Context, userID, id uuid.UUID) (OrgModel, error) {
+	if err := os.Authorize(ctx, userID, id, PermOrgRead); err != nil {
+		return NilOrgModel, err
+	}
+	return os.repo.FindOneOrg(ctx, id)
+}
+
+// List returns the organizations the user is a member of.
+func (os OrgService) List(ctx context.Context, userID uuid.UUID) ([]OrgModel, error) {
+	return os.repo.FindOrgsOfUser(ctx, userID)
+}
+
+// Members returns the members of the organization.
+func (os OrgService) Members(ctx context.Context, userID, orgID uuid.UUID) ([]MembershipModel, error) {
+	if err := os.Authorize(ctx, userID, orgID, PermMembersRead); err != nil {
+		return nil, err
+	}
+	return os.repo.FindMembers(ctx, orgID)
+}
+
+// AddMember makes the user registered with the email a member of the organization,
+// replacing the role it may already have.
+func (os OrgService) AddMember(ctx context.Context, userID, orgID uuid.UUID, email string, role OrgRole) (MembershipModel, error) {
+	if _, ok := orgRoleNames[role]; !ok {
+		return MembershipModel{}, ErrInvalidMembership
+	}
+	actor, err := os.manager(ctx, userID, orgID)
+	if err != nil {
+		return MembershipModel{}, err
+	}
+	invitee, err := os.users.FindByEmail(ctx, normalize(email))
+	if errors.Is(err, serror.ErrUserNotFound) {
+		return MembershipModel{}, ErrInviteeNotFound
+	}
+	if err != nil {
+		return MembershipModel{}, err
+	}
+	current, err := os.repo.RoleOf(ctx, orgID, invitee.ID)
+	if err != nil {
+		return MembershipModel{}, err
+	}
+	return os.setRole(ctx, actor, current, MembershipModel{OrgID: orgID, UserID: invitee.ID, Role: role})
+}
+
+// UpdateMember changes the role of the member of the organization.
+func (os OrgService) UpdateMember(ctx context.Context, userID uuid.UUID, member MembershipModel) (MembershipModel, error) {
+	if _, ok := orgRoleNames[member.Role]; !ok {
+		return MembershipModel{}, ErrInvalidMembership
+	}
+	actor, current, err := os.managedMember(ctx, userID, member.OrgID, member.UserID)
+	if err != nil {
+		return MembershipModel{}, err
+	}
+	return os.setRole(ctx, actor, current, member)
+}
+
+// RemoveMember removes the member from the organization.
+func (os OrgService) RemoveMember(ctx context.Context, userID, orgID, memberID uuid.UUID) error {
+	actor, current, err := os.managedMember(ctx, userID, orgID, memberID)
+	if err != nil {
+		return err
+	}
+	if err = os.canChangeOwner(ctx, orgID, actor, current, OrgRoleNone); err != nil {
+		return err
+	}
+	return os.repo.DeleteMember(ctx, orgID, memberID)
+}
+
+// manager returns the role of the user in the organization, if the user can manage its members.
+func (os OrgService) manager(ctx context.Context, userID, orgID uuid.UUID) (OrgRole, error) {
+	actor, err := os.auth.RoleInOrg(ctx, userID, orgID)
+	if err != nil {
+		return OrgRoleNone, err
+	}
+	return actor, os.auth.permit(actor, PermMembersManage)
+}
+
+// managedMember returns the roles of the user and of the member in the
+// organization, if the user can manage its members.
+func (os OrgService) managedMember(ctx context.Context, userID, orgID, memberID uuid.UUID) (actor, member OrgRole, err error) {
+	if actor, err = os.manager(ctx, userID, orgID); err != nil {
+		return actor, member, err
+	}
+	if member, err = os.repo.RoleOf(ctx, orgID, memberID); err != nil {
+		return actor, member, err
+	}
+	if member == OrgRoleNone {
+		return actor, member, serror.ErrMemberNotFound
+	}
+	return actor, member, nil
+}
+
+// setRole stores the member with its new role, replacing the current one.
+func (os OrgService) setRole(ctx context.Context, actor, current OrgRole, member MembershipModel) (MembershipModel, error) {
+	if err := os.canChangeOwner(ctx, member.OrgID, actor, current, member.Role); err != nil {
+		return MembershipModel{}, err
+	}
+	if err := os.repo.UpsertMember(ctx, member); err != nil {
+		return MembershipModel{}, err
+	}
+	return member, nil
+}
+
+// canChangeOwner returns nil unless the member moves from or to the owner role, which only the
+// roles with PermOwnersManage can do, and never for the last owner of the organization.
+// A removed member moves to OrgRoleNone.
+func (os OrgService) canChangeOwner(ctx context.Context, orgID uuid.UUID, actor, from, to OrgRole) error {
+	if from != OrgRoleOwner && to != OrgRoleOwner {
+		return nil
+	}
+	if !os.auth.policy.Can(actor, PermOwnersManage) {
+		return ErrForbidden
+	}
+	if from != OrgRoleOwner || to == OrgRoleOwner {
+		return nil
+	}
+
+	members, err := os.repo.FindMembers(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	owners := 0
+	for _, member := range members {
+		if member.Role == OrgRoleOwner {
+			owners++
+		}
+	}
+	if owners <= 1 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyOrgRepo keeps the members of the organizations, and the organization of the projects.
+type dummyOrgRepo struct {
+	members     map[uuid.UUID]map[uuid.UUID]OrgRole
+	projectOrgs map[uuid.UUID]uuid.UUID
+}
+
+func newDummyOrgRepo() *dummyOrgRepo {
+	return &dummyOrgRepo{members: make(map[uuid.UUID]map[uuid.UUID]OrgRole), projectOrgs: make(map[uuid.UUID]uuid.UUID)}
+}
+
+func (d *dummyOrgRepo) FindOneOrg(ctx context.Context, id uuid.UUID) (OrgModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyOrgRepo) FindOrgsOfUser(ctx context.Context, userID uuid.UUID) ([]OrgModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyOrgRepo) InsertOne(ctx context.Context, org OrgModel, ownerID uuid.UUID) error {
+	d.members[org.ID] = map[uuid.UUID]OrgRole{ownerID: OrgRoleOwner}
+	return nil
+}
+
+func (d *dummyOrgRepo) RoleOf(ctx context.Context, orgID, userID uuid.UUID) (OrgRole, error) {
+	return d.members[orgID][userID], nil
+}
+
+func (d *dummyOrgRepo) RoleOnProject(ctx context.Context, userID, projectID uuid.UUID) (uuid.UUID, OrgRole, error) {
+	orgID := d.projectOrgs[projectID]
+	return orgID, d.members[orgID][userID], nil
+}
+
+func (d *dummyOrgRepo) FindMembers(ctx context.Context, orgID uuid.UUID) ([]MembershipModel, error) {
+	var members []MembershipModel
+	for userID, role := range d.members[orgID] {
+		members = append(members, MembershipModel{OrgID: orgID, UserID: userID, Role: role})
+	}
+	return members, nil
+}
+
+func (d *dummyOrgRepo) UpsertMember(ctx context.Context, member MembershipModel) error {
+	d.members[member.OrgID][member.UserID] = member.Role
+	return nil
+}
+
+func (d *dummyOrgRepo) DeleteMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	if _, ok := d.members[orgID][userID]; !ok {
+		return serror.ErrMemberNotFound
+	}
+	delete(d.members[orgID], userID)
+	return nil
+}
+
+func TestPolicy_Can(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		role OrgRole
+		perm Permission
+		want bool
+	}{
+		{role: OrgRoleGuest, perm: PermTodosRead, want: true},
+		{role: OrgRoleGuest, perm: PermTodosWrite, want: false},
+		{role: OrgRoleGuest, perm: PermMembersRead, want: false},
+		{role: OrgRoleMember, perm: PermProjectsCreate, want: true},
+		{role: OrgRoleMember, perm: PermMembersManage, want: false},
+		{role: OrgRoleAdmin, perm: PermMembersManage, want: true},
+		{role: OrgRoleAdmin, perm: PermOwnersManage, want: false},
+		{role: OrgRoleOwner, perm: PermOwnersManage, want: true},
+		{role: OrgRoleMember, perm: PermWebhooksManage, want: false},
+		{role: OrgRoleAdmin, perm: PermWebhooksManage, want: true},
+		{role: OrgRoleNone, perm: PermOrgRead, want: false},
+	}
+	for _, tc := range tcs {
+		if got := DefaultPolicy.Can(tc.role, tc.perm); got != tc.want {
+			t.Errorf("expected %v for %s %s got %v", tc.want, tc.role, tc.perm, got)
+		}
+	}
+}
+
+func TestAuthorizer_Org(t *testing.T) {
+	t.Parallel()
+	orgID, ownerID, guestID, memberID, adminID := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	orgs := newDummyOrgRepo()
+	orgs.members[orgID] = map[uuid.UUID]OrgRole{guestID: OrgRoleGuest, memberID: OrgRoleMember, adminID: OrgRoleAdmin}
+	project := ProjectModel{ID: uuid.New(), UserID: ownerID, OrgID: orgID}
+	orgs.projectOrgs[project.ID] = orgID
+	todo := TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: project.ID}
+	a := NewOrgAuthorizer(nil, orgs)
+
+	tcs := []struct {
+		name   string
+		userID uuid.UUID
+		need   Role
+		want   error
+	}{
+		{name: "guest reads", userID: guestID, need: RoleViewer},
+		{name: "guest writes", userID: guestID, need: RoleEditor, want: ErrForbidden},
+		{name: "member writes", userID: memberID, need: RoleEditor},
+		{name: "member shares", userID: memberID, need: RoleOwner, want: ErrForbidden},
+		{name: "admin manages", userID: adminID, need: RoleOwner},
+		{name: "outsider", userID: uuid.New(), need: RoleViewer, want: serror.ErrTodoNotFound},
+		{name: "creator out of the org", userID: ownerID, need: RoleViewer, want: serror.ErrTodoNotFound},
+	}
+	for _, tc := range tcs {
+		err := a.AuthorizeTodo(context.Background(), tc.userID, todo, tc.need)
+		if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, err)
+		}
+	}
+
+	// the member who created a project and its todos only has the role of its membership
+	created := ProjectModel{ID: project.ID, UserID: memberID, OrgID: orgID}
+	createdTodo := TodoModel{ID: uuid.New(), UserID: memberID, ProjectID: project.ID}
+	if err := a.AuthorizeProject(context.Background(), memberID, created, RoleOwner); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the creator deleting the project got %v", err)
+	}
+	orgs.members[orgID][memberID] = OrgRoleGuest
+	if err := a.AuthorizeTodo(context.Background(), memberID, createdTodo, RoleEditor); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the creator demoted to guest got %v", err)
+	}
+	delete(orgs.members[orgID], memberID)
+	if err := a.AuthorizeTodo(context.Background(), memberID, createdTodo, RoleViewer); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for the creator removed got %v", err)
+	}
+	if err := a.AuthorizeProject(context.Background(), memberID, created, RoleViewer); !errors.Is(err, serror.ErrProjectNotFound) {
+		t.Errorf("expected project not found for the creator removed got %v", err)
+	}
+
+	if err := a.AuthorizeOrg(context.Background(), guestID, orgID, PermProjectsCreate); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the guest got %v", err)
+	}
+	if err := a.AuthorizeOrg(context.Background(), uuid.New(), orgID, PermOrgRead); !errors.Is(err, serror.ErrOrgNotFound) {
+		t.Errorf("expected org not found for an outsider got %v", err)
+	}
+}
+
+func TestOrgService_Members(t *testing.T) {
+	t.Parallel()
+	ownerID := uuid.New()
+	invitee := UserModel{ID: uuid.New(), Email: "invitee@example.com"}
+	orgs := newDummyOrgRepo()
+	os := NewOrgService(orgs, dummyInviteeRepo{invitee: invitee})
+	ctx := context.Background()
+
+	if _, err := os.Create(ctx, ownerID, OrgModel{Name: " "}); !errors.Is(err, ErrInvalidOrg) {
+		t.Errorf("expected invalid org got %v", err)
+	}
+	org, err := os.Create(ctx, ownerID, OrgModel{Name: " acme "})
+	if err != nil || org.Name != "acme" {
+		t.Fatalf("unexpected org %+v %v", org, err)
+	}
+
+	if _, err = os.AddMember(ctx, ownerID, org.ID, invitee.Email, OrgRoleNone); !errors.Is(err, ErrInvalidMembership) {
+		t.Errorf("expected invalid membership got %v", err)
+	}
+	if _, err = os.AddMember(ctx, ownerID, org.ID, "nobody@example.com", OrgRoleMember); !errors.Is(err, ErrInviteeNotFound) {
+		t.Errorf("expected invitee not found got %v", err)
+	}
+	if _, err = os.AddMember(ctx, invitee.ID, org.ID, invitee.Email, OrgRoleMember); !errors.Is(err, serror.ErrOrgNotFound) {
+		t.Errorf("expected org not found for an outsider got %v", err)
+	}
+	member, err := os.AddMember(ctx, ownerID, org.ID, " Invitee@Example.com ", OrgRoleAdmin)
+	if err != nil || member.UserID != invitee.ID || member.Role != OrgRoleAdmin {
+		t.Fatalf("unexpected member %+v %v", member, err)
+	}
+
+	// an admin manages the members, but not the owners
+	if _, err = os.UpdateMember(ctx, invitee.ID, MembershipModel{OrgID: org.ID, UserID: invitee.ID, Role: OrgRoleOwner}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the admin promoting an owner got %v", err)
+	}
+	if err = os.RemoveMember(ctx, invitee.ID, org.ID, ownerID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the admin removing the owner got %v", err)
+	}
+	if _, err = os.UpdateMember(ctx, invitee.ID, MembershipModel{OrgID: org.ID, UserID: uuid.New(), Role: OrgRoleGuest}); !errors.Is(err, serror.ErrMemberNotFound) {
+		t.Errorf("expected member not found got %v", err)
+	}
+
+	// the last owner stays
+	if _, err = os.UpdateMember(ctx, ownerID, MembershipModel{OrgID: org.ID, UserID: ownerID, Role: OrgRoleAdmin}); !errors.Is(err, ErrLastOrgOwner) {
+		t.Errorf("expected last owner on demote got %v", err)
+	}
+	if err = os.RemoveMember(ctx, ownerID, org.ID, ownerID); !errors.Is(err, ErrLastOrgOwner) {
+		t.Errorf("expected last owner on remove got %v", err)
+	}
+	if _, err = os.UpdateMember(ctx, ownerID, MembershipModel{OrgID: org.ID, UserID: invitee.ID, Role: OrgRoleOwner}); err != nil {
+		t.Fatalf("expected the owner to promote an owner got %v", err)
+	}
+	if err = os.RemoveMember(ctx, invitee.ID, org.ID, ownerID); err != nil {
+		t.Errorf("expected another owner to remove the first one got %v", err)
+	}
+}
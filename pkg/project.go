@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// InboxProjectName is the name of the project every user gets at signup,
+// holding the todos not filed under another project.
+const InboxProjectName = "Inbox"
+
+var (
+	// NilProjectModel is empty ProjectModel, all zeros
+	NilProjectModel ProjectModel
+)
+
+var (
+	// ErrInvalidProject indicates the project fails the validation
+	ErrInvalidProject = errors.New("invalid project")
+	// ErrInboxProject indicates the write would delete or archive the inbox of the user
+	ErrInboxProject = errors.New("inbox project can't be deleted or archived")
+	// ErrOrgProjectInbox indicates the todos of a project of an organization
+	// would be moved to the inbox of their creator, out of the organization
+	ErrOrgProjectInbox = errors.New("todos of an org project can't be moved to an inbox")
+)
+
+var (
+	rxProjectColor = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+)
+
+// ProjectModel is a list grouping the todos of a user
+type ProjectModel struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// OrgID is the organization the project and its todos belong to, nil for a personal project.
+	OrgID uuid.UUID
+	Name  string
+	// Color is an hex rgb color like #ff0000, or empty.
+	Color    string
+	Archived bool
+	// Inbox is true for the project every user gets at signup.
+	Inbox bool
+}
+
+// ProjectDeleteMode tells what happens to the todos of a deleted project
+type ProjectDeleteMode int8
+
+const (
+	// MoveTodosToInbox moves the todos of the project to the inbox of the user,
+	// only the todos of a personal project can be moved
+	MoveTodosToInbox ProjectDeleteMode = iota
+	// CascadeTodos deletes the todos of the project for good, the ones in the trash too
+	CascadeTodos
+)
+
+// ProjectStorage define a contract for storage, to interact
+// with the ProjectModel.
+type ProjectStorage interface {
+	FindOneProject(ctx context.Context, id uuid.UUID) (ProjectModel, error)
+	// FindAllProjectOfUser returns the personal projects of the user, the inbox first.
+	FindAllProjectOfUser(ctx context.Context, userID uuid.UUID) ([]ProjectModel, error)
+	// FindAllProjectOfOrg returns the projects of the organization.
+	FindAllProjectOfOrg(ctx context.Context, orgID uuid.UUID) ([]ProjectModel, error)
+	InsertOne(ctx context.Context, project ProjectModel) (uuid.UUID, error)
+	UpdateOne(ctx context.Context, project ProjectModel) error
+	// DeleteOne deletes the project, which can't be an inbox, along with its todos
+	// or after moving them to the inbox, depending on the mode.
+	DeleteOne(ctx context.Context, id uuid.UUID, mode ProjectDeleteMode) error
+}
+
+// ProjectService provides the use cases implementation to work
+// with the projects of a user, and the ones shared with the user.
+type ProjectService struct {
+	repo ProjectStorage
+	auth Authorizer
+}
+
+// NewProjectService returns a new ProjectService initialized with
+// a concrete repo implementation, the projects are only the ones of their owner.
+func NewProjectService(repo ProjectStorage) ProjectService {
+	return ProjectService{
+		repo: repo,
+	}
+}
+
+// NewSharedProjectService returns a new ProjectService whose projects are shared as the auth decides.
+func NewSharedProjectService(repo ProjectStorage, auth Authorizer) ProjectService {
+	return ProjectService{
+		repo: repo,
+		auth: auth,
+	}
+}
+
+// IsValidProject validate if the project is valid or not
+func (ps ProjectService) IsValidProject(project ProjectModel) bool {
+	name := strings.TrimSpace(project.Name)
+	return name != "" && len(name) <= 100 && (project.Color == "" || rxProjectColor.MatchString(project.Color))
+}
+
+// Find returns the project with the id, if the user can read it. A project
+// the user has no role on is reported as not found, so its existence isn't leaked.
+func (ps ProjectService) Find(ctx context.Context, userID, id uuid.UUID) (ProjectModel, error) {
+	return ps.authorized(ctx, userID, id, RoleViewer)
+}
+
+// authorized returns the project with the id, if the user has at least the needed role on it.
+func (ps ProjectService) authorized(ctx context.Context, userID, id uuid.UUID, need Role) (ProjectModel, error) {
+	project, err := ps.repo.FindOneProject(ctx, id)
+	if err != nil {
+		return NilProjectModel, err
+	}
+	if err = ps.auth.AuthorizeProject(ctx, userID, project, need); err != nil {
+		return NilProjectModel, err
+	}
+	return project, nil
+}
+
+// List returns the personal projects of the user.
+func (ps ProjectService) List(ctx context.Context, userID uuid.UUID) ([]ProjectModel, error) {
+	return ps.repo.FindAllProjectOfUser(ctx, userID)
+}
+
+// ListOrg returns the projects of the organization, if the user can read them.
+func (ps ProjectService) ListOrg(ctx context.Context, userID, orgID uuid.UUID) ([]ProjectModel, error) {
+	if err := ps.auth.AuthorizeOrg(ctx, userID, orgID, PermProjectsRead); err != nil {
+		return nil, err
+	}
+	return ps.repo.FindAllProjectOfOrg(ctx, orgID)
+}
+
+// Create stores a new project of the user and returns it, the project
+// belongs to its organization if the user can create projects there,
+// the user then only has the role of its membership on it.
+func (ps ProjectService) Create(ctx context.Context, userID uuid.UUID, project ProjectModel) (ProjectModel, error) {
+	if !ps.IsValidProject(project) {
+		return NilProjectModel, ErrInvalidProject
+	}
+	if project.OrgID != uuid.Nil {
+		if err := ps.auth.AuthorizeOrg(ctx, userID, project.OrgID, PermProjectsCreate); err != nil {
+			return NilProjectModel, err
+		}
+	}
+	project.ID = uuid.New()
+	project.UserID = userID
+	project.Name = strings.TrimSpace(project.Name)
+	project.Color = strings.ToLower(project.Color)
+	project.Inbox = false
+	id, err := ps.repo.InsertOne(ctx, project)
+	if err != nil {
+		return NilProjectModel, err
+	}
+	project.ID = id
+	return project, nil
+}
+
+// Update updates the name, color and archived of the project the user can edit and returns it.
+func (ps ProjectService) Update(ctx context.Context, userID uuid.UUID, project ProjectModel) (ProjectModel, error) {
+	if !ps.IsValidProject(project) {
+		return NilProjectModel, ErrInvalidProject
+	}
+	stored, err := ps.authorized(ctx, userID, project.ID, RoleEditor)
+	if err != nil {
+		return NilProjectModel, err
+	}
+	if stored.Inbox && project.Archived {
+		return NilProjectModel, ErrInboxProject
+	}
+	stored.Name = strings.TrimSpace(project.Name)
+	stored.Color = strings.ToLower(project.Color)
+	stored.Archived = project.Archived
+	if err = ps.repo.UpdateOne(ctx, stored); err != nil {
+		return NilProjectModel, err
+	}
+	return stored, nil
+}
+
+// Delete deletes the project the user owns, and either moves its todos to
+// the inbox or deletes them too. The inbox itself can't be deleted, and the
+// todos of a project of an organization don't leave it for an inbox.
+func (ps ProjectService) Delete(ctx context.Context, userID, id uuid.UUID, mode ProjectDeleteMode) error {
+	project, err := ps.authorized(ctx, userID, id, RoleOwner)
+	if err != nil {
+		return err
+	}
+	if project.Inbox {
+		return ErrInboxProject
+	}
+	if project.OrgID != uuid.Nil && mode == MoveTodosToInbox {
+		return ErrOrgProjectInbox
+	}
+	return ps.repo.DeleteOne(ctx, id, mode)
+}
@@ -0,0 +1,117 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyProjectRepo is an in-memory ProjectStorage.
+type dummyProjectRepo struct {
+	projects map[uuid.UUID]ProjectModel
+}
+
+func (d *dummyProjectRepo) FindOneProject(ctx context.Context, id uuid.UUID) (ProjectModel, error) {
+	project, ok := d.projects[id]
+	if !ok {
+		return NilProjectModel, serror.ErrProjectNotFound
+	}
+	return project, nil
+}
+
+func (d *dummyProjectRepo) FindAllProjectOfUser(ctx context.Context, userID uuid.UUID) ([]ProjectModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyProjectRepo) FindAllProjectOfOrg(ctx context.Context, orgID uuid.UUID) ([]ProjectModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyProjectRepo) InsertOne(ctx context.Context, project ProjectModel) (uuid.UUID, error) {
+	d.projects[project.ID] = project
+	return project.ID, nil
+}
+
+func (d *dummyProjectRepo) UpdateOne(ctx context.Context, project ProjectModel) error {
+	d.projects[project.ID] = project
+	return nil
+}
+
+func (d *dummyProjectRepo) DeleteOne(ctx context.Context, id uuid.UUID, mode ProjectDeleteMode) error {
+	delete(d.projects, id)
+	return nil
+}
+
+func TestProjectService_IsValidProject(t *testing.T) {
+	t.Parallel()
+	ps := NewProjectService(&dummyProjectRepo{})
+	tcs := []struct {
+		project ProjectModel
+		want    bool
+	}{
+		{project: ProjectModel{Name: "work"}, want: true},
+		{project: ProjectModel{Name: "work", Color: "#a0B1c2"}, want: true},
+		{project: ProjectModel{Name: "  "}, want: false},
+		{project: ProjectModel{Name: "work", Color: "red"}, want: false},
+		{project: ProjectModel{Name: "work", Color: "#fff"}, want: false},
+	}
+	for _, tc := range tcs {
+		if got := ps.IsValidProject(tc.project); got != tc.want {
+			t.Errorf("expected %v for %+v got %v", tc.want, tc.project, got)
+		}
+	}
+}
+
+func TestProjectService_Inbox(t *testing.T) {
+	t.Parallel()
+	userID := uuid.New()
+	inbox := ProjectModel{ID: uuid.New(), UserID: userID, Name: InboxProjectName, Inbox: true}
+	repo := &dummyProjectRepo{projects: map[uuid.UUID]ProjectModel{inbox.ID: inbox}}
+	ps := NewProjectService(repo)
+
+	if err := ps.Delete(context.Background(), userID, inbox.ID, CascadeTodos); !errors.Is(err, ErrInboxProject) {
+		t.Errorf("expected inbox project error on delete got %v", err)
+	}
+	archived := inbox
+	archived.Archived = true
+	if _, err := ps.Update(context.Background(), userID, archived); !errors.Is(err, ErrInboxProject) {
+		t.Errorf("expected inbox project error on archive got %v", err)
+	}
+	renamed := inbox
+	renamed.Name = "Later"
+	got, err := ps.Update(context.Background(), userID, renamed)
+	if err != nil || got.Name != "Later" || !got.Inbox {
+		t.Errorf("expected the inbox renamed got %+v %v", got, err)
+	}
+	if _, err := ps.Find(context.Background(), uuid.New(), inbox.ID); !errors.Is(err, serror.ErrProjectNotFound) {
+		t.Errorf("expected project not found for another user got %v", err)
+	}
+}
+
+func TestProjectService_DeleteOrg(t *testing.T) {
+	t.Parallel()
+	orgID, adminID := uuid.New(), uuid.New()
+	orgs := newDummyOrgRepo()
+	orgs.members[orgID] = map[uuid.UUID]OrgRole{adminID: OrgRoleAdmin}
+	project := ProjectModel{ID: uuid.New(), UserID: uuid.New(), OrgID: orgID, Name: "roadmap"}
+	orgs.projectOrgs[project.ID] = orgID
+	repo := &dummyProjectRepo{projects: map[uuid.UUID]ProjectModel{project.ID: project}}
+	ps := NewSharedProjectService(repo, NewOrgAuthorizer(nil, orgs))
+
+	// the todos stay in the organization, not in the inbox of their creator
+	if err := ps.Delete(context.Background(), adminID, project.ID, MoveTodosToInbox); !errors.Is(err, ErrOrgProjectInbox) {
+		t.Errorf("expected org project inbox error on delete got %v", err)
+	}
+	if _, ok := repo.projects[project.ID]; !ok {
+		t.Fatalf("expected the project kept")
+	}
+	if err := ps.Delete(context.Background(), adminID, project.ID, CascadeTodos); err != nil {
+		t.Errorf("expected the project deleted along its todos got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"time"
+)
+
+// TrashPurger deletes for good the todos that have been in the trash for
+// longer than the retention period.
+type TrashPurger struct {
+	repo      TodoStorage
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+}
+
+// NewTrashPurger returns a TrashPurger that purges the repo every interval.
+func NewTrashPurger(repo TodoStorage, retention, interval time.Duration) TrashPurger {
+	return TrashPurger{
+		repo:      repo,
+		retention: retention,
+		interval:  interval,
+		now:       time.Now,
+	}
+}
+
+// Purge deletes the todos deleted before the retention period
+// and returns how many were deleted.
+func (tp TrashPurger) Purge(ctx context.Context) (int64, error) {
+	return tp.repo.PurgeDeleted(ctx, tp.now().Add(-tp.retention))
+}
+
+// Run purges right away and then every interval, until the ctx is done.
+// The outcome of every purge is reported to the onPurge, if not nil.
+func (tp TrashPurger) Run(ctx context.Context, onPurge func(n int64, err error)) {
+	ticker := time.NewTicker(tp.interval)
+	defer ticker.Stop()
+	for {
+		n, err := tp.Purge(ctx)
+		if onPurge != nil && ctx.Err() == nil {
+			onPurge(n, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
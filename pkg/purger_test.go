@@ -0,0 +1,61 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTrashPurger_Purge(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	repo := newDummyTodoRepo()
+	old := TodoModel{ID: uuid.New(), DeletedAt: now.Add(-31 * 24 * time.Hour)}
+	recent := TodoModel{ID: uuid.New(), DeletedAt: now.Add(-time.Hour)}
+	live := TodoModel{ID: uuid.New()}
+	for _, todo := range []TodoModel{old, recent, live} {
+		repo.todos[todo.ID] = todo
+	}
+
+	tp := NewTrashPurger(repo, 30*24*time.Hour, time.Hour)
+	tp.now = func() time.Time { return now }
+	n, err := tp.Purge(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 todo to be purged got %d", n)
+	}
+	if _, ok := repo.todos[old.ID]; ok {
+		t.Errorf("expected todo past the retention to be purged")
+	}
+	if len(repo.todos) != 2 {
+		t.Errorf("expected recently deleted and live todos to be kept got %d todos", len(repo.todos))
+	}
+}
+
+func TestTrashPurger_Run(t *testing.T) {
+	t.Parallel()
+	tp := NewTrashPurger(newDummyTodoRepo(), time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tp.Run(ctx, func(n int64, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			cancel()
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to purge right away and return once the ctx is done")
+	}
+}
@@ -0,0 +1,256 @@
+package recurrence
+
+import (
+	"time"
+)
+
+// maxEmptyPeriods bounds the periods in a row without any occurrence, so the
+// rules that never match, like the 30th of February, come to an end.
+const maxEmptyPeriods = 1000
+
+// After returns at most n occurrences following the after time, of the series
+// whose first occurrence is the start.
+//
+// The occurrences keep the wall clock time of the start in its location, across
+// the DST transitions. A wall clock time skipped by a transition is read with the
+// offset before it, so it moves forward by the length of the gap, and a wall clock
+// time repeated by a transition is the first of them.
+func (r Rule) After(start, after time.Time, n int) []time.Time {
+	if n <= 0 {
+		return nil
+	}
+	var list []time.Time
+	r.each(start, func(t time.Time) bool {
+		if t.After(after) {
+			list = append(list, t)
+		}
+		return len(list) < n
+	})
+	return list
+}
+
+// Next returns the first occurrence following the after time, of the series
+// whose first occurrence is the start. ok is false once the series is over.
+func (r Rule) Next(start, after time.Time) (next time.Time, ok bool) {
+	list := r.After(start, after, 1)
+	if len(list) == 0 {
+		return time.Time{}, false
+	}
+	return list[0], true
+}
+
+// each calls fn with the occurrences of the series in order, until fn returns
+// false or the series is over. The start is the first occurrence, whether or
+// not it matches the rule, as in RFC 5545.
+func (r Rule) each(start time.Time, fn func(t time.Time) bool) {
+	count := 0
+	emit := func(t time.Time) bool {
+		if r.ended(t) {
+			return false
+		}
+		count++
+		return fn(t) && (r.Count == 0 || count < r.Count)
+	}
+	if !emit(start) {
+		return
+	}
+
+	day := date(start)
+	for period, empty := 0, 0; empty < maxEmptyPeriods; period++ {
+		dates := r.expand(day, period*r.Interval)
+		if len(dates) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, d := range dates {
+			t := wallClock(d, start)
+			if !t.After(start) {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// ended tells the occurrence is past the Until of the rule.
+func (r Rule) ended(t time.Time) bool {
+	switch {
+	case r.Until.IsZero():
+		return false
+	case r.untilDate:
+		return date(t).After(r.Until)
+	default:
+		return t.After(r.Until)
+	}
+}
+
+// expand returns the sorted dates of the period of the rule that is step
+// periods after the one of the day.
+func (r Rule) expand(day time.Time, step int) []time.Time {
+	switch r.Freq {
+	case Daily:
+		d := day.AddDate(0, 0, step)
+		if !r.inMonths(d) || !r.onMonthDays(d) || !r.onWeekdays(d) {
+			return nil
+		}
+		return []time.Time{d}
+	case Weekly:
+		// the weeks start on monday
+		monday := day.AddDate(0, 0, 7*step-(int(day.Weekday())+6)%7)
+		var dates []time.Time
+		for i := 0; i < 7; i++ {
+			d := monday.AddDate(0, 0, i)
+			if len(r.ByDay) == 0 && d.Weekday() != day.Weekday() {
+				continue
+			}
+			if r.onWeekdays(d) && r.inMonths(d) {
+				dates = append(dates, d)
+			}
+		}
+		return dates
+	case Monthly:
+		first := time.Date(day.Year(), day.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		if !r.inMonths(first) {
+			return nil
+		}
+		return r.monthDates(first, day)
+	case Yearly:
+		year := day.Year() + step
+		if len(r.ByMonth) == 0 && len(r.ByDay) > 0 {
+			// the ordinals are within the year
+			first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+			return r.weekdayDates(first, first.AddDate(1, 0, 0))
+		}
+		months := r.ByMonth
+		switch {
+		case len(months) > 0:
+		case len(r.ByMonthDay) > 0:
+			months = []time.Month{time.January, time.February, time.March, time.April, time.May, time.June,
+				time.July, time.August, time.September, time.October, time.November, time.December}
+		default:
+			months = []time.Month{day.Month()}
+		}
+		var dates []time.Time
+		for _, m := range months {
+			dates = append(dates, r.monthDates(time.Date(year, m, 1, 0, 0, 0, 0, time.UTC), day)...)
+		}
+		return sortDates(dates)
+	default:
+		return nil
+	}
+}
+
+// monthDates returns the sorted dates of the month starting on the first.
+// Without BYDAY and BYMONTHDAY, it's the day of the month of the day, if the month has it.
+func (r Rule) monthDates(first, day time.Time) []time.Time {
+	next := first.AddDate(0, 1, 0)
+	switch {
+	case len(r.ByDay) > 0:
+		return r.weekdayDates(first, next)
+	case len(r.ByMonthDay) > 0:
+		var dates []time.Time
+		for d := first; d.Before(next); d = d.AddDate(0, 0, 1) {
+			if r.onMonthDays(d) {
+				dates = append(dates, d)
+			}
+		}
+		return dates
+	default:
+		d := first.AddDate(0, 0, day.Day()-1)
+		if d.Month() != first.Month() {
+			return nil
+		}
+		return []time.Time{d}
+	}
+}
+
+// weekdayDates returns the sorted dates of [from, to) matching the BYDAY,
+// the ordinals counting the weekdays within the range, and the BYMONTHDAY.
+func (r Rule) weekdayDates(from, to time.Time) []time.Time {
+	var dates []time.Time
+	for _, w := range r.ByDay {
+		var matching []time.Time
+		offset := (int(w.Day) - int(from.Weekday()) + 7) % 7
+		for d := from.AddDate(0, 0, offset); d.Before(to); d = d.AddDate(0, 0, 7) {
+			matching = append(matching, d)
+		}
+		switch {
+		case w.N == 0:
+			dates = append(dates, matching...)
+		case w.N > 0 && w.N <= len(matching):
+			dates = append(dates, matching[w.N-1])
+		case w.N < 0 && -w.N <= len(matching):
+			dates = append(dates, matching[len(matching)+w.N])
+		}
+	}
+	filtered := dates[:0]
+	for _, d := range dates {
+		if r.onMonthDays(d) {
+			filtered = append(filtered, d)
+		}
+	}
+	return sortDates(filtered)
+}
+
+// inMonths tells the date is in one of the BYMONTH, if any.
+func (r Rule) inMonths(d time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if d.Month() == m {
+			return true
+		}
+	}
+	return false
+}
+
+// onMonthDays tells the date is one of the BYMONTHDAY, if any.
+func (r Rule) onMonthDays(d time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, md := range r.ByMonthDay {
+		if md == d.Day() || last+md+1 == d.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// onWeekdays tells the date is one of the BYDAY weekdays, if any, ignoring their ordinals.
+func (r Rule) onWeekdays(d time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, w := range r.ByDay {
+		if d.Weekday() == w.Day {
+			return true
+		}
+	}
+	return false
+}
+
+// date returns the date of the wall clock of t, as midnight UTC
+// so the calendar math doesn't cross any DST transition.
+func date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// wallClock returns the time on the date d with the wall clock time of the start, in its location.
+func wallClock(d, start time.Time) time.Time {
+	loc := start.Location()
+	t := time.Date(d.Year(), d.Month(), d.Day(), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), loc)
+	if t.Day() == d.Day() && t.Hour() == start.Hour() && t.Minute() == start.Minute() {
+		return t
+	}
+	// skipped by a DST transition, the time.Date normalization isn't the one of
+	// RFC 5545, the wall clock time is read with the offset of the day before.
+	_, offset := time.Date(d.Year(), d.Month(), d.Day()-1, start.Hour(), start.Minute(), 0, 0, loc).Zone()
+	naive := time.Date(d.Year(), d.Month(), d.Day(), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), time.UTC)
+	return naive.Add(-time.Duration(offset) * time.Second).In(loc)
+}
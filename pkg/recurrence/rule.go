@@ -0,0 +1,291 @@
+// Package recurrence parses the RFC 5545 recurrence rules of the recurring
+// todos, and expands them into their occurrences.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidRule indicates the rule isn't a valid, or supported, RRULE
+	ErrInvalidRule = errors.New("invalid recurrence rule")
+)
+
+// Frequency is the FREQ of a rule, the period the rule repeats over.
+type Frequency int8
+
+const (
+	// Daily repeats every day
+	Daily Frequency = iota + 1
+	// Weekly repeats every week, starting on monday
+	Weekly
+	// Monthly repeats every month
+	Monthly
+	// Yearly repeats every year
+	Yearly
+)
+
+var frequencies = map[string]Frequency{
+	"DAILY":   Daily,
+	"WEEKLY":  Weekly,
+	"MONTHLY": Monthly,
+	"YEARLY":  Yearly,
+}
+
+func (f Frequency) String() string {
+	for name, freq := range frequencies {
+		if freq == f {
+			return name
+		}
+	}
+	return "Frequency(" + strconv.Itoa(int(f)) + ")"
+}
+
+// weekdays are the BYDAY names of the time.Weekday
+var weekdays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// WeekdayNum is a BYDAY value. It's the Nth weekday of the month, or year, of a
+// monthly or yearly rule, counting from the end if N is negative. A zero N is
+// every of those weekdays.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return weekdays[w.Day]
+	}
+	return strconv.Itoa(w.N) + weekdays[w.Day]
+}
+
+// Rule is a recurrence rule, made of the FREQ, INTERVAL, COUNT, UNTIL,
+// BYDAY, BYMONTHDAY and BYMONTH parts of an RFC 5545 RRULE.
+type Rule struct {
+	Freq Frequency
+	// Interval is how many periods of the Freq there are between the occurrences.
+	Interval int
+	// Count is the number of occurrences, zero is unbounded.
+	Count int
+	// Until is the last time an occurrence can be at, zero is unbounded.
+	Until time.Time
+	// untilDate tells the Until is a date, the end of which is read in the
+	// location of the series.
+	untilDate  bool
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+}
+
+// Parse parses the value of an RRULE, with or without its "RRULE:" name.
+// The parts other than the ones of the Rule are rejected, as is a WKST other
+// than MO, the weeks always start on monday.
+func Parse(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 6 && strings.EqualFold(s[:6], "RRULE:") {
+		s = s[6:]
+	}
+	r := Rule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return Rule{}, invalid("malformed part %q", part)
+		}
+		name, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		if seen[name] {
+			return Rule{}, invalid("duplicate %s", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			var ok bool
+			if r.Freq, ok = frequencies[value]; !ok {
+				err = fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = parseInt(value, 1, 1000)
+		case "COUNT":
+			r.Count, err = parseInt(value, 1, 1000)
+		case "UNTIL":
+			r.Until, r.untilDate, err = parseUntil(value)
+		case "BYDAY":
+			r.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(value, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseInts(value, 1, 12)
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "WKST":
+			if value != "MO" {
+				err = errors.New("only MO is supported")
+			}
+		default:
+			return Rule{}, invalid("unsupported part %s", name)
+		}
+		if err != nil {
+			return Rule{}, invalid("%s: %v", name, err)
+		}
+	}
+	return r, r.validate()
+}
+
+// validate checks the parts of the rule go together.
+func (r Rule) validate() error {
+	if r.Freq == 0 {
+		return invalid("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return invalid("COUNT and UNTIL are exclusive")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return invalid("BYMONTHDAY is not allowed in a weekly rule")
+	}
+	// the ordinals are within a month, unless of a yearly rule without BYMONTH
+	max := 5
+	if r.Freq == Yearly && len(r.ByMonth) == 0 {
+		max = 53
+	}
+	for _, w := range r.ByDay {
+		if w.N == 0 {
+			continue
+		}
+		if r.Freq != Monthly && r.Freq != Yearly {
+			return invalid("BYDAY %s: ordinals are only allowed in a monthly or yearly rule", w)
+		}
+		if w.N > max || w.N < -max {
+			return invalid("BYDAY %s: ordinal out of range", w)
+		}
+	}
+	return nil
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRule, fmt.Sprintf(format, a...))
+}
+
+// parseInt parses the value as an integer of [min, max].
+func parseInt(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", value)
+	}
+	if n < min || n > max || n == 0 {
+		return 0, fmt.Errorf("%d out of range", n)
+	}
+	return n, nil
+}
+
+// parseInts parses the comma separated list of integers of [min, max], zero excluded.
+func parseInts(value string, min, max int) ([]int, error) {
+	var list []int
+	for _, v := range strings.Split(value, ",") {
+		n, err := parseInt(v, min, max)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// parseUntil parses either a date, or a date and time in UTC.
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse("20060102T150405Z", value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither a date nor a UTC date and time", value)
+	}
+	return t, false, nil
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	var list []WeekdayNum
+	for _, v := range strings.Split(value, ",") {
+		if len(v) < 2 {
+			return nil, fmt.Errorf("malformed weekday %q", v)
+		}
+		w := WeekdayNum{Day: -1}
+		for day, name := range weekdays {
+			if strings.HasSuffix(v, name) {
+				w.Day = time.Weekday(day)
+			}
+		}
+		if w.Day < 0 {
+			return nil, fmt.Errorf("unknown weekday %q", v)
+		}
+		if ordinal := v[:len(v)-2]; ordinal != "" {
+			n, err := strconv.Atoi(ordinal)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("malformed weekday %q", v)
+			}
+			w.N = n
+		}
+		list = append(list, w)
+	}
+	return list, nil
+}
+
+// String returns the rule as the value of an RRULE, its parts in a canonical order.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq.String()}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.untilDate {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]string, 0, len(r.ByMonth))
+		for _, m := range r.ByMonth {
+			months = append(months, strconv.Itoa(int(m)))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, 0, len(r.ByMonthDay))
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, 0, len(r.ByDay))
+		for _, w := range r.ByDay {
+			days = append(days, w.String())
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// sortDates sorts the dates and removes the duplicates.
+func sortDates(dates []time.Time) []time.Time {
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	uniq := dates[:0]
+	for _, d := range dates {
+		if len(uniq) == 0 || !d.Equal(uniq[len(uniq)-1]) {
+			uniq = append(uniq, d)
+		}
+	}
+	return uniq
+}
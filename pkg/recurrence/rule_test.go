@@ -0,0 +1,176 @@
+// +build unit_tests all_tests
+
+package recurrence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	valid := []struct {
+		rrule string
+		want  string
+	}{
+		{rrule: "FREQ=DAILY", want: "FREQ=DAILY"},
+		{rrule: "RRULE:FREQ=WEEKLY;BYDAY=MO", want: "FREQ=WEEKLY;BYDAY=MO"},
+		{rrule: "freq=weekly;interval=2;byday=mo,we;wkst=mo", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE"},
+		{rrule: "FREQ=MONTHLY;BYDAY=-1FR;COUNT=10", want: "FREQ=MONTHLY;COUNT=10;BYDAY=-1FR"},
+		{rrule: "FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=20211231", want: "FREQ=MONTHLY;UNTIL=20211231;BYMONTHDAY=1,-1"},
+		{rrule: "FREQ=YEARLY;BYMONTH=11;BYDAY=+4TH;UNTIL=20301231T235959Z", want: "FREQ=YEARLY;UNTIL=20301231T235959Z;BYMONTH=11;BYDAY=4TH"},
+	}
+	for _, tc := range valid {
+		r, err := Parse(tc.rrule)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.rrule, err)
+			continue
+		}
+		if got := r.String(); got != tc.want {
+			t.Errorf("%s: expected %s got %s", tc.rrule, tc.want, got)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20210101",
+		"FREQ=DAILY;UNTIL=2021-01-01",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=WEEKLY;WKST=SU",
+		"FREQ=DAILY;;",
+	}
+	for _, rrule := range invalid {
+		if _, err := Parse(rrule); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("%q: expected invalid rule got %v", rrule, err)
+		}
+	}
+}
+
+func TestRule_After(t *testing.T) {
+	t.Parallel()
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tcs := []struct {
+		name  string
+		rrule string
+		start string
+		// after is the start if empty, which is then included
+		after string
+		n     int
+		want  []string
+	}{
+		{name: "count", rrule: "FREQ=DAILY;COUNT=3", start: "2021-01-30 09:00", n: 5,
+			want: []string{"2021-01-30 09:00", "2021-01-31 09:00", "2021-02-01 09:00"}},
+		{name: "after", rrule: "FREQ=DAILY", start: "2021-01-01 09:00", after: "2021-06-01 09:00", n: 2,
+			want: []string{"2021-06-02 09:00", "2021-06-03 09:00"}},
+		{name: "weekdays", rrule: "FREQ=WEEKLY;BYDAY=MO,WE", start: "2021-03-01 09:00", n: 4,
+			want: []string{"2021-03-01 09:00", "2021-03-03 09:00", "2021-03-08 09:00", "2021-03-10 09:00"}},
+		{name: "every other week", rrule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", start: "2021-03-01 09:00", n: 3,
+			want: []string{"2021-03-01 09:00", "2021-03-15 09:00", "2021-03-29 09:00"}},
+		{name: "start off the rule", rrule: "FREQ=WEEKLY;BYDAY=MO", start: "2021-03-02 09:00", n: 3,
+			want: []string{"2021-03-02 09:00", "2021-03-08 09:00", "2021-03-15 09:00"}},
+		{name: "months without the day", rrule: "FREQ=MONTHLY;BYMONTHDAY=31", start: "2021-01-31 09:00", n: 3,
+			want: []string{"2021-01-31 09:00", "2021-03-31 09:00", "2021-05-31 09:00"}},
+		{name: "last day of the month", rrule: "FREQ=MONTHLY;BYMONTHDAY=-1", start: "2021-01-31 09:00", n: 3,
+			want: []string{"2021-01-31 09:00", "2021-02-28 09:00", "2021-03-31 09:00"}},
+		{name: "last friday", rrule: "FREQ=MONTHLY;BYDAY=-1FR", start: "2021-01-29 09:00", n: 3,
+			want: []string{"2021-01-29 09:00", "2021-02-26 09:00", "2021-03-26 09:00"}},
+		{name: "second tuesday", rrule: "FREQ=MONTHLY;BYDAY=2TU", start: "2021-01-12 09:00", n: 3,
+			want: []string{"2021-01-12 09:00", "2021-02-09 09:00", "2021-03-09 09:00"}},
+		{name: "leap day", rrule: "FREQ=YEARLY", start: "2020-02-29 09:00", n: 3,
+			want: []string{"2020-02-29 09:00", "2024-02-29 09:00", "2028-02-29 09:00"}},
+		{name: "thanksgiving", rrule: "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", start: "2021-11-25 09:00", n: 3,
+			want: []string{"2021-11-25 09:00", "2022-11-24 09:00", "2023-11-23 09:00"}},
+		{name: "first monday of the year", rrule: "FREQ=YEARLY;BYDAY=1MO", start: "2021-01-04 09:00", n: 2,
+			want: []string{"2021-01-04 09:00", "2022-01-03 09:00"}},
+		{name: "until date", rrule: "FREQ=DAILY;UNTIL=20210103", start: "2021-01-01 23:00", n: 5,
+			want: []string{"2021-01-01 23:00", "2021-01-02 23:00", "2021-01-03 23:00"}},
+		{name: "never matches", rrule: "FREQ=MONTHLY;BYMONTH=2;BYMONTHDAY=30", start: "2021-01-30 09:00", n: 3,
+			want: []string{"2021-01-30 09:00"}},
+		{name: "none", rrule: "FREQ=DAILY", start: "2021-01-01 09:00", n: 0},
+	}
+	for _, tc := range tcs {
+		r, err := Parse(tc.rrule)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		start := day(tc.start)
+		after := start.Add(-time.Second)
+		if tc.after != "" {
+			after = day(tc.after)
+		}
+		got := r.After(start, after, tc.n)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(day(tc.want[i])) {
+				t.Errorf("%s: expected %v got %v", tc.name, tc.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestRule_AfterDST(t *testing.T) {
+	t.Parallel()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	tcs := []struct {
+		name  string
+		rrule string
+		start time.Time
+		// want are in UTC
+		want []string
+	}{
+		{name: "spring forward", rrule: "FREQ=WEEKLY;BYDAY=MO", start: time.Date(2021, 3, 8, 9, 0, 0, 0, loc),
+			want: []string{"2021-03-08T14:00:00Z", "2021-03-15T13:00:00Z"}},
+		{name: "skipped time", rrule: "FREQ=DAILY", start: time.Date(2021, 3, 13, 2, 30, 0, 0, loc),
+			want: []string{"2021-03-13T07:30:00Z", "2021-03-14T07:30:00Z", "2021-03-15T06:30:00Z"}},
+		{name: "repeated time", rrule: "FREQ=DAILY", start: time.Date(2021, 11, 6, 1, 30, 0, 0, loc),
+			want: []string{"2021-11-06T05:30:00Z", "2021-11-07T05:30:00Z", "2021-11-08T06:30:00Z"}},
+	}
+	for _, tc := range tcs {
+		r, err := Parse(tc.rrule)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := r.After(tc.start, tc.start.Add(-time.Second), len(tc.want))
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: expected %v got %v", tc.name, tc.want, got)
+		}
+		for i := range got {
+			if s := got[i].UTC().Format(time.RFC3339); s != tc.want[i] {
+				t.Errorf("%s: expected %v got %s at %d", tc.name, tc.want, s, i)
+			}
+			if got[i].Location() != loc {
+				t.Errorf("%s: expected the occurrence in %s got %s", tc.name, loc, got[i].Location())
+			}
+		}
+	}
+
+	r, _ := Parse("FREQ=DAILY;COUNT=2")
+	start := time.Date(2021, 1, 1, 9, 0, 0, 0, loc)
+	if _, ok := r.Next(start, start.AddDate(0, 0, 1)); ok {
+		t.Errorf("expected the series over after its count")
+	}
+}
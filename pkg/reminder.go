@@ -0,0 +1,254 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// NilReminderModel is empty ReminderModel, all zeros
+	NilReminderModel ReminderModel
+)
+
+var (
+	// ErrInvalidReminder indicates the reminder fails the validation
+	ErrInvalidReminder = errors.New("invalid reminder")
+)
+
+// maxReminderOffset is how long before the due time a reminder can be delivered at most.
+const maxReminderOffset = 30 * 24 * time.Hour
+
+// The channels a reminder is delivered through.
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelLog     = "log"
+)
+
+// ReminderStatus tells where the delivery of a reminder stands,
+// for the current due time of its todo.
+type ReminderStatus string
+
+const (
+	// ReminderPending is a reminder still to be delivered, or to be retried.
+	ReminderPending ReminderStatus = "pending"
+	// ReminderSent is a reminder delivered.
+	ReminderSent ReminderStatus = "sent"
+	// ReminderDead is a reminder given up on after too many failed deliveries.
+	ReminderDead ReminderStatus = "dead"
+)
+
+// ReminderModel is a reminder of a todo, delivered to its user Offset before
+// the todo is due. Changing the due time of the todo schedules it again.
+type ReminderModel struct {
+	ID     uuid.UUID
+	TodoID uuid.UUID
+	// UserID is the user reminded, the one who set the reminder.
+	UserID  uuid.UUID
+	Offset  time.Duration
+	Channel string
+	// Status, Attempts and LastError are about the delivery, set by the ReminderScheduler.
+	Status    ReminderStatus
+	Attempts  int
+	LastError string
+}
+
+// Notification is a reminder due for delivery.
+type Notification struct {
+	ReminderID uuid.UUID
+	TodoID     uuid.UUID
+	UserID     uuid.UUID
+	// Email is the one of the user reminded.
+	Email   string
+	Channel string
+	Title   string
+	DueAt   time.Time
+	// Attempt counts the deliveries of the reminder for the DueAt, from 1.
+	Attempt int
+}
+
+// Notifier delivers the notifications of a channel.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// ReminderStorage define a contract for storage, to interact
+// with the ReminderModel of the todos, and to schedule their delivery.
+type ReminderStorage interface {
+	// FindRemindersOfTodo returns the reminders of the todo, soonest first.
+	FindRemindersOfTodo(ctx context.Context, todoID uuid.UUID) ([]ReminderModel, error)
+	// InsertOne stores the reminder, serror.ErrTodoNotFound is returned
+	// if the todo doesn't exist.
+	InsertOne(ctx context.Context, reminder ReminderModel) error
+	DeleteOne(ctx context.Context, todoID, id uuid.UUID) error
+	// ClaimDue returns at most n notifications due at the now time, of the todos not finished
+	// nor in the trash, and leases them until now+lease so no other claim returns them before.
+	// A reminder is due once its todo is due within the Offset, if it isn't delivered
+	// yet for the due time of the todo, or it's pending and its retry is due.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, n int) ([]Notification, error)
+	// MarkSent marks the notification delivered.
+	MarkSent(ctx context.Context, n Notification) error
+	// MarkFailed records the failed delivery of the notification, retried at the retryAt
+	// time unless it's dead. The Mark are ignored if the todo is due at another time since.
+	MarkFailed(ctx context.Context, n Notification, reason string, retryAt time.Time, dead bool) error
+}
+
+// ReminderService provides the use cases implementation to work
+// with the reminders of the todos of a user.
+type ReminderService struct {
+	todos TodoService
+	repo  ReminderStorage
+}
+
+// NewReminderService returns a new ReminderService initialized with
+// a concrete repo implementation, the reminders are authorized as their todo by the todos.
+func NewReminderService(todos TodoService, repo ReminderStorage) ReminderService {
+	return ReminderService{
+		todos: todos,
+		repo:  repo,
+	}
+}
+
+// IsValidReminder validate if the reminder is valid or not
+func (rs ReminderService) IsValidReminder(reminder ReminderModel) bool {
+	switch reminder.Channel {
+	case ChannelEmail, ChannelWebhook, ChannelLog:
+	default:
+		return false
+	}
+	return reminder.Offset >= 0 && reminder.Offset <= maxReminderOffset
+}
+
+// List returns the reminders of the todo the user can read.
+func (rs ReminderService) List(ctx context.Context, userID, todoID uuid.UUID) ([]ReminderModel, error) {
+	if _, err := rs.todos.Find(ctx, userID, todoID); err != nil {
+		return nil, err
+	}
+	return rs.repo.FindRemindersOfTodo(ctx, todoID)
+}
+
+// Create adds a new reminder of the user to the todo the user can edit and returns it.
+func (rs ReminderService) Create(ctx context.Context, userID uuid.UUID, reminder ReminderModel) (ReminderModel, error) {
+	if !rs.IsValidReminder(reminder) {
+		return NilReminderModel, ErrInvalidReminder
+	}
+	if _, err := rs.todos.authorized(ctx, userID, reminder.TodoID, RoleEditor); err != nil {
+		return NilReminderModel, err
+	}
+	reminder.ID = uuid.New()
+	reminder.UserID = userID
+	reminder.Status = ReminderPending
+	reminder.Attempts = 0
+	reminder.LastError = ""
+	if err := rs.repo.InsertOne(ctx, reminder); err != nil {
+		return NilReminderModel, err
+	}
+	return reminder, nil
+}
+
+// Delete deletes the reminder of the todo the user can edit.
+func (rs ReminderService) Delete(ctx context.Context, userID, todoID, id uuid.UUID) error {
+	if _, err := rs.todos.authorized(ctx, userID, todoID, RoleEditor); err != nil {
+		return err
+	}
+	return rs.repo.DeleteOne(ctx, todoID, id)
+}
+
+// Defaults of the ReminderScheduler.
+const (
+	reminderBatch       = 100
+	reminderLease       = time.Minute
+	reminderMaxAttempts = 5
+	reminderBackoff     = time.Minute
+	reminderMaxBackoff  = time.Hour
+)
+
+// ReminderScheduler delivers the due reminders through the notifier of their channel.
+// The schedule is the one of the storage, so it survives restarts and many
+// schedulers can share it, each claiming its own reminders.
+//
+// A failed delivery is retried with an exponential backoff, and the
+// reminder is dead once it failed reminderMaxAttempts times.
+type ReminderScheduler struct {
+	repo      ReminderStorage
+	notifiers map[string]Notifier
+	interval  time.Duration
+	now       func() time.Time
+}
+
+// NewReminderScheduler returns a ReminderScheduler that delivers the due reminders
+// of the repo every interval, through the notifiers by channel.
+func NewReminderScheduler(repo ReminderStorage, notifiers map[string]Notifier, interval time.Duration) ReminderScheduler {
+	return ReminderScheduler{
+		repo:      repo,
+		notifiers: notifiers,
+		interval:  interval,
+		now:       time.Now,
+	}
+}
+
+// backoff returns how long to wait before the retry of the failed attempt, from 1.
+func backoff(attempt int) time.Duration {
+	d := reminderBackoff
+	for i := 1; i < attempt && d < reminderMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > reminderMaxBackoff {
+		d = reminderMaxBackoff
+	}
+	return d
+}
+
+// Deliver delivers the reminders due now, and returns how many were
+// sent and how many failed.
+func (rs ReminderScheduler) Deliver(ctx context.Context) (sent, failed int, err error) {
+	list, err := rs.repo.ClaimDue(ctx, rs.now(), reminderLease, reminderBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, n := range list {
+		if err = rs.notify(ctx, n); err == nil {
+			sent++
+			err = rs.repo.MarkSent(ctx, n)
+		} else {
+			failed++
+			dead := n.Attempt >= reminderMaxAttempts
+			err = rs.repo.MarkFailed(ctx, n, err.Error(), rs.now().Add(backoff(n.Attempt)), dead)
+		}
+		// the lease expires if the mark failed, so it's delivered again.
+		if err != nil {
+			return sent, failed, err
+		}
+	}
+	return sent, failed, nil
+}
+
+func (rs ReminderScheduler) notify(ctx context.Context, n Notification) error {
+	notifier, ok := rs.notifiers[n.Channel]
+	if !ok {
+		return fmt.Errorf("no notifier of the %s channel", n.Channel)
+	}
+	return notifier.Notify(ctx, n)
+}
+
+// Run delivers the due reminders right away and then every interval, until the ctx
+// is done. The outcome of every delivery is reported to the onDeliver, if not nil.
+func (rs ReminderScheduler) Run(ctx context.Context, onDeliver func(sent, failed int, err error)) {
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		sent, failed, err := rs.Deliver(ctx)
+		if onDeliver != nil && ctx.Err() == nil {
+			onDeliver(sent, failed, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
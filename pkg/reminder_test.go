@@ -0,0 +1,145 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyReminderRepo claims the due notifications once, and records their marks.
+type dummyReminderRepo struct {
+	inserted []ReminderModel
+	due      []Notification
+	sent     []Notification
+	failed   map[uuid.UUID]failedMark
+}
+
+type failedMark struct {
+	retryAt time.Time
+	dead    bool
+}
+
+func (d *dummyReminderRepo) FindRemindersOfTodo(ctx context.Context, todoID uuid.UUID) ([]ReminderModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyReminderRepo) InsertOne(ctx context.Context, reminder ReminderModel) error {
+	d.inserted = append(d.inserted, reminder)
+	return nil
+}
+
+func (d *dummyReminderRepo) DeleteOne(ctx context.Context, todoID, id uuid.UUID) error {
+	panic("implement me")
+}
+
+func (d *dummyReminderRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, n int) ([]Notification, error) {
+	due := d.due
+	d.due = nil
+	return due, nil
+}
+
+func (d *dummyReminderRepo) MarkSent(ctx context.Context, n Notification) error {
+	d.sent = append(d.sent, n)
+	return nil
+}
+
+func (d *dummyReminderRepo) MarkFailed(ctx context.Context, n Notification, reason string, retryAt time.Time, dead bool) error {
+	d.failed[n.ReminderID] = failedMark{retryAt: retryAt, dead: dead}
+	return nil
+}
+
+// notifierFunc is a Notifier function.
+type notifierFunc func(ctx context.Context, n Notification) error
+
+func (f notifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+func TestReminderService_Create(t *testing.T) {
+	t.Parallel()
+	repo := &dummyReminderRepo{}
+	todos := newDummyTodoRepo()
+	rs := NewReminderService(NewTodoService(todos), repo)
+	userID := uuid.New()
+	todoID := uuid.New()
+	todos.todos[todoID] = TodoModel{ID: todoID, UserID: userID, Title: "pay rent", Version: 1}
+
+	invalid := []ReminderModel{
+		{TodoID: todoID, Channel: "pigeon"},
+		{TodoID: todoID, Channel: ChannelEmail, Offset: -time.Minute},
+		{TodoID: todoID, Channel: ChannelEmail, Offset: 31 * 24 * time.Hour},
+	}
+	for _, reminder := range invalid {
+		if _, err := rs.Create(context.Background(), userID, reminder); !errors.Is(err, ErrInvalidReminder) {
+			t.Errorf("expected invalid reminder error for %+v got %v", reminder, err)
+		}
+	}
+	if _, err := rs.Create(context.Background(), uuid.New(), ReminderModel{TodoID: todoID, Channel: ChannelLog}); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for another user got %v", err)
+	}
+
+	reminder, err := rs.Create(context.Background(), userID, ReminderModel{TodoID: todoID, Channel: ChannelEmail,
+		Offset: time.Hour, Status: ReminderDead, Attempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reminder.ID == uuid.Nil || reminder.UserID != userID || reminder.Status != ReminderPending || reminder.Attempts != 0 ||
+		len(repo.inserted) != 1 {
+		t.Errorf("unexpected created reminder %+v", reminder)
+	}
+}
+
+func TestReminderScheduler_Deliver(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	ok := Notification{ReminderID: uuid.New(), Channel: ChannelLog, Attempt: 1}
+	retried := Notification{ReminderID: uuid.New(), Channel: ChannelWebhook, Attempt: 3}
+	last := Notification{ReminderID: uuid.New(), Channel: ChannelWebhook, Attempt: reminderMaxAttempts}
+	unknown := Notification{ReminderID: uuid.New(), Channel: "pigeon", Attempt: 1}
+	repo := &dummyReminderRepo{due: []Notification{ok, retried, last, unknown}, failed: make(map[uuid.UUID]failedMark)}
+
+	var delivered []Notification
+	rs := NewReminderScheduler(repo, map[string]Notifier{
+		ChannelLog: notifierFunc(func(ctx context.Context, n Notification) error {
+			delivered = append(delivered, n)
+			return nil
+		}),
+		ChannelWebhook: notifierFunc(func(ctx context.Context, n Notification) error {
+			return errors.New("webhook responded 503 Service Unavailable")
+		}),
+	}, time.Minute)
+	rs.now = func() time.Time { return now }
+
+	sent, failed, err := rs.Deliver(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 || failed != 3 || len(delivered) != 1 || len(repo.sent) != 1 || repo.sent[0].ReminderID != ok.ReminderID {
+		t.Errorf("expected 1 sent and 3 failed got %d %d", sent, failed)
+	}
+	if mark := repo.failed[retried.ReminderID]; mark.dead || !mark.retryAt.Equal(now.Add(4*time.Minute)) {
+		t.Errorf("expected the third attempt retried in 4m got %+v", mark)
+	}
+	if mark := repo.failed[last.ReminderID]; !mark.dead {
+		t.Errorf("expected the last attempt dead got %+v", mark)
+	}
+	if mark, found := repo.failed[unknown.ReminderID]; !found || mark.dead {
+		t.Errorf("expected a channel without notifier retried got %+v", mark)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	for attempt, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 6: 32 * time.Minute, 7: time.Hour, 50: time.Hour} {
+		if got := backoff(attempt); got != want {
+			t.Errorf("expected a backoff of %v for the attempt %d got %v", want, attempt, got)
+		}
+	}
+}
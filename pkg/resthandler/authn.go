@@ -0,0 +1,60 @@
+package resthandler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+var (
+	contextKeyUserID = contextKey("user_id")
+	contextKeyOrgID  = contextKey("org_id")
+
+	errUnauthorized = envelope.NewError(envelope.CodeUnauthorized, "Missing or invalid bearer token.")
+)
+
+// requireAuth returns a middleware that only lets through the requests carrying
+// a valid bearer token. The id of the authenticated user and of its active
+// organization, if any, are put in the context.
+func requireAuth(logger *zap.Logger, tokenizer Tokenizer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id, orgID uuid.UUID
+			token := bearerToken(r)
+			claims, err := tokenizer.ValidateClaims(token)
+			if err == nil {
+				id, err = uuid.Parse(claims.UserID)
+			}
+			if err == nil && claims.OrgID != "" {
+				orgID, err = uuid.Parse(claims.OrgID)
+			}
+			if token == "" || err != nil {
+				code := http.StatusUnauthorized
+				w.Header().Set("WWW-Authenticate", `Bearer realm="todo"`)
+				writeError(w, r, code, errUnauthorized, logger)
+				logger.Error("unauthenticated request", httpReqField(code, r, err)...)
+				return
+			}
+			ctx := context.WithValue(r.Context(), contextKeyUserID, id)
+			r = r.WithContext(context.WithValue(ctx, contextKeyOrgID, orgID))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// userIDFromReqCtx returns the id of the user authenticated by requireAuth.
+func userIDFromReqCtx(r *http.Request) uuid.UUID {
+	id, _ := r.Context().Value(contextKeyUserID).(uuid.UUID)
+	return id
+}
+
+// orgIDFromReqCtx returns the id of the active organization of the user
+// authenticated by requireAuth, uuid.Nil in the personal workspace.
+func orgIDFromReqCtx(r *http.Request) uuid.UUID {
+	id, _ := r.Context().Value(contextKeyOrgID).(uuid.UUID)
+	return id
+}
@@ -0,0 +1,173 @@
+package resthandler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+// maxBatchOperations is the maximum number of operations of a single batch request
+const maxBatchOperations = 100
+
+// batch operations
+const (
+	batchCreate   = "create"
+	batchUpdate   = "update"
+	batchComplete = "complete"
+	batchDelete   = "delete"
+)
+
+var (
+	errInvalidBatch = envelope.NewError(envelope.CodeValidationFailed, "Invalid batch.")
+)
+
+// batchForm type Decode the submitted json body of a batch.
+// If Atomic, either all the operations are applied or none.
+type batchForm struct {
+	Atomic     bool                 `json:"atomic"`
+	Operations []batchOperationForm `json:"operations"`
+}
+
+// batchOperationForm is a single operation of a batch, the Version is the version
+// the update, complete or delete expects to replace, like an If-Match.
+type batchOperationForm struct {
+	Op      string    `json:"op"`
+	ID      string    `json:"id"`
+	Version int64     `json:"version"`
+	Todo    *todoForm `json:"todo"`
+}
+
+// batchResult is the outcome of a single operation, with the status code
+// it would have had as a request on its own.
+type batchResult struct {
+	Status int             `json:"status"`
+	Data   *todoResponse   `json:"data,omitempty"`
+	Error  *envelope.Error `json:"error,omitempty"`
+}
+
+// batchResponse carries the results in the same order as the operations.
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// toTodoWrites validates the operations of the form and returns them as todo writes.
+// The field errors of all the invalid operations are returned, pointing at them.
+func (bf batchForm) toTodoWrites() ([]pkg.TodoWrite, []envelope.FieldError) {
+	if len(bf.Operations) == 0 || len(bf.Operations) > maxBatchOperations {
+		return nil, []envelope.FieldError{{
+			Field:   "/operations",
+			Code:    envelope.CodeInvalidLength,
+			Message: fmt.Sprintf("Should have 1 to %d operations.", maxBatchOperations),
+		}}
+	}
+
+	var details []envelope.FieldError
+	writes := make([]pkg.TodoWrite, len(bf.Operations))
+	for i, op := range bf.Operations {
+		at := fmt.Sprintf("/operations/%d", i)
+		w := pkg.TodoWrite{Todo: pkg.TodoModel{Version: op.Version}}
+		switch op.Op {
+		case batchCreate:
+			w.Op = pkg.TodoInsert
+		case batchUpdate:
+			w.Op = pkg.TodoUpdate
+		case batchComplete:
+			w.Op = pkg.TodoComplete
+		case batchDelete:
+			w.Op = pkg.TodoDelete
+		default:
+			details = append(details, envelope.FieldError{Field: at + "/op", Code: envelope.CodeInvalidValue,
+				Message: "Should be create, update, complete or delete."})
+			continue
+		}
+
+		if w.Op != pkg.TodoInsert {
+			id, err := uuid.Parse(op.ID)
+			if err != nil {
+				details = append(details, envelope.FieldError{Field: at + "/id", Code: envelope.CodeInvalidValue,
+					Message: "Should be the id of a todo."})
+				continue
+			}
+			w.Todo.ID = id
+		}
+		if w.Op == pkg.TodoInsert || w.Op == pkg.TodoUpdate {
+			if op.Todo == nil {
+				details = append(details, envelope.FieldError{Field: at + "/todo", Code: envelope.CodeRequired,
+					Message: "Todo is required."})
+				continue
+			}
+			w.Todo.Title = op.Todo.Title
+			w.Todo.Content = op.Todo.Content
+			w.Todo.Finished = op.Todo.Finished
+			w.Todo.AutoFinish = op.Todo.AutoFinish
+			w.Todo.ProjectID = op.Todo.ProjectID
+			w.Todo.DueAt = op.Todo.dueAt()
+		}
+		writes[i] = w
+	}
+	return writes, details
+}
+
+// newBatchResult returns the result of the write, the status codes are
+// the ones of the equivalent single todo request.
+func newBatchResult(op pkg.TodoWriteOp, wr pkg.TodoWriteResult) batchResult {
+	if wr.Err != nil {
+		status, apiErr := domainErrorFor(wr.Err)
+		return batchResult{Status: status, Error: &apiErr}
+	}
+	switch op {
+	case pkg.TodoInsert:
+		resp := newTodoResponse(wr.Todo)
+		return batchResult{Status: http.StatusCreated, Data: &resp}
+	case pkg.TodoDelete:
+		return batchResult{Status: http.StatusNoContent}
+	default:
+		resp := newTodoResponse(wr.Todo)
+		return batchResult{Status: http.StatusOK, Data: &resp}
+	}
+}
+
+// batch applies a list of operations on the todos of the user in a single request.
+// The request succeeds as a whole, even if some operations fail, each carrying its own
+// status code and error. An atomic batch that failed only has the failing operation
+// with its error, the other ones report they have been aborted.
+func (th todos) batch(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form batchForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	writes, details := form.toTodoWrites()
+	if len(details) != 0 {
+		code = http.StatusUnprocessableEntity
+		writeError(w, r, code, envelope.NewError(errInvalidBatch.Code, errInvalidBatch.Message, details...), th.logger)
+		th.logger.Error("invalid batch", httpReqField(code, r, nil)...)
+		return
+	}
+
+	results, err := th.svc.Batch(r.Context(), userIDFromReqCtx(r), writes, form.Atomic)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Batch", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchResult, len(results))}
+	for i, wr := range results {
+		resp.Results[i] = newBatchResult(writes[i].Op, wr)
+		if resp.Results[i].Status == http.StatusInternalServerError {
+			th.logger.Error("err batch operation", httpReqField(http.StatusInternalServerError, r, wr.Err)...)
+		}
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("todo batch applied", httpReqField(code, r, nil)...)
+}
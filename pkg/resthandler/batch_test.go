@@ -0,0 +1,135 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func TestTodos_Batch(t *testing.T) {
+	t.Parallel()
+	mh, repo := newTodoTestHandler(t)
+	userID := uuid.New()
+	mine := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "mine", Version: 1}
+	other := pkg.TodoModel{ID: uuid.New(), UserID: uuid.New(), Title: "not mine", Version: 1}
+	repo.todos[mine.ID] = mine
+	repo.todos[other.ID] = other
+
+	operations := fmt.Sprintf(`[
+		{"op":"create","todo":{"title":"new"}},
+		{"op":"complete","id":"%s","version":1},
+		{"op":"update","id":"%s","version":1,"todo":{"title":"stale"}},
+		{"op":"delete","id":"%s"}
+	]`, mine.ID, mine.ID, other.ID)
+
+	tcs := []struct {
+		name       string
+		atomic     bool
+		wantStatus []int
+		wantTodos  int
+	}{
+		{
+			name:       "atomic",
+			atomic:     true,
+			wantStatus: []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusPreconditionFailed, http.StatusFailedDependency},
+			wantTodos:  2,
+		},
+		{
+			name:       "independent",
+			wantStatus: []int{http.StatusCreated, http.StatusOK, http.StatusPreconditionFailed, http.StatusNotFound},
+			wantTodos:  3,
+		},
+	}
+
+	for _, tc := range tcs {
+		body := fmt.Sprintf(`{"atomic":%t,"operations":%s}`, tc.atomic, operations)
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeTodosBatch, userID, body))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d got %d %s", tc.name, http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var resp struct {
+			Data batchResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Data.Results) != len(tc.wantStatus) {
+			t.Fatalf("%s: expected %d results got %d", tc.name, len(tc.wantStatus), len(resp.Data.Results))
+		}
+		for i, want := range tc.wantStatus {
+			if got := resp.Data.Results[i].Status; got != want {
+				t.Errorf("%s: expected status %d of operation %d got %d", tc.name, want, i, got)
+			}
+		}
+		if len(repo.todos) != tc.wantTodos {
+			t.Errorf("%s: expected %d todos got %d", tc.name, tc.wantTodos, len(repo.todos))
+		}
+	}
+
+	if got := repo.todos[mine.ID]; !got.Finished || got.Version != 2 {
+		t.Errorf("expected todo to be completed got %+v", got)
+	}
+	if _, ok := repo.todos[other.ID]; !ok {
+		t.Errorf("expected todo of another user to be left untouched")
+	}
+}
+
+func TestTodos_BatchInvalid(t *testing.T) {
+	t.Parallel()
+	mh, _ := newTodoTestHandler(t)
+
+	tcs := []struct {
+		name      string
+		body      string
+		wantField []string
+	}{
+		{name: "empty", body: `{"operations":[]}`, wantField: []string{"/operations"}},
+		{
+			name:      "too many",
+			body:      `{"operations":[` + strings.Repeat(`{"op":"create","todo":{"title":"t"}},`, maxBatchOperations) + `{"op":"create","todo":{"title":"t"}}]}`,
+			wantField: []string{"/operations"},
+		},
+		{
+			name:      "invalid operations",
+			body:      `{"operations":[{"op":"archive"},{"op":"delete","id":"1"},{"op":"create"}]}`,
+			wantField: []string{"/operations/0/op", "/operations/1/id", "/operations/2/todo"},
+		},
+	}
+
+	for _, tc := range tcs {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeTodosBatch, uuid.New(), tc.body))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status code %d got %d", tc.name, http.StatusUnprocessableEntity, rr.Code)
+			continue
+		}
+		var resp struct {
+			Errors []struct {
+				Details []struct {
+					Field string `json:"field"`
+				} `json:"details"`
+			} `json:"errors"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range resp.Errors[0].Details {
+			got = append(got, d.Field)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.wantField, ",") {
+			t.Errorf("%s: expected errors at %v got %v", tc.name, tc.wantField, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package resthandler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrCORSCredentialsWildcard indicates a CORSConfig allowing the credentials of any origin.
+	ErrCORSCredentialsWildcard = errors.New("cors: credentials can't be allowed for the \"*\" origin")
+	// ErrCORSNoOrigin indicates a CORSConfig allowing no origin.
+	ErrCORSNoOrigin = errors.New("cors: no allowed origin")
+)
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", idempotencyKeyHeader}
+	// response headers that aren't CORS safelisted, the frontend needs to read them
+	defaultCORSExposedHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+		"ETag", "Location", idempotentReplayedHeader}
+)
+
+// CORSConfig configures the Cross-Origin Resource Sharing of the api,
+// so browser frontends running on another origin can call it.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to call the api. An origin can be
+	// "*" for any origin, or contain a wildcard subdomain "https://*.example.com"
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, POST, PUT, PATCH and DELETE
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed, "*" allows any
+	// header. It defaults to Accept, Authorization, Content-Type, the
+	// conditional request headers and Idempotency-Key.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the frontend can read
+	ExposedHeaders []string
+	// AllowCredentials allows the cookies and the Authorization header to be
+	// sent. It can't be along with an "*" origin, any site could then call the
+	// api on behalf of its visitors.
+	AllowCredentials bool
+	// MaxAge is how long the preflight response can be cached
+	MaxAge time.Duration
+}
+
+// Validate returns an error if the config allows no origin, or the credentials of any origin.
+func (c CORSConfig) Validate() error {
+	if len(c.AllowedOrigins) == 0 {
+		return ErrCORSNoOrigin
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" && c.AllowCredentials {
+			return ErrCORSCredentialsWildcard
+		}
+	}
+	return nil
+}
+
+// WithCORS enables the CORS support with the cfg. It panics if the cfg isn't valid,
+// it should be checked with its Validate first.
+func WithCORS(cfg CORSConfig) Option {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	return func(mh *MuxHandler) {
+		if len(cfg.AllowedMethods) == 0 {
+			cfg.AllowedMethods = defaultCORSMethods
+		}
+		if len(cfg.AllowedHeaders) == 0 {
+			cfg.AllowedHeaders = defaultCORSHeaders
+		}
+		if len(cfg.ExposedHeaders) == 0 {
+			cfg.ExposedHeaders = defaultCORSExposedHeaders
+		}
+		mh.cors = &cfg
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin,
+// or an empty string if the origin is not allowed.
+func (c CORSConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if matchOrigin(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// matchOrigin reports if the origin matches the pattern,
+// which can hold a single "*" wildcard subdomain.
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+func (c CORSConfig) allowMethod(method string) bool {
+	for _, m := range c.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) allowHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		ok := false
+		for _, allowed := range c.AllowedHeaders {
+			if allowed == "*" || strings.EqualFold(allowed, h) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// cors returns a middleware that handles the preflight requests and sets the
+// CORS headers of the actual requests. Preflight requests are answered here,
+// they never reach the router.
+func cors(cfg CORSConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				// without the allow headers the browser fails the preflight
+				allowOrigin := cfg.allowOrigin(origin)
+				method := r.Header.Get("Access-Control-Request-Method")
+				reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+				if origin != "" && allowOrigin != "" && cfg.allowMethod(method) && cfg.allowHeaders(reqHeaders) {
+					h.Set("Access-Control-Allow-Origin", allowOrigin)
+					h.Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+					if reqHeaders != "" {
+						h.Set("Access-Control-Allow-Headers", reqHeaders)
+					}
+					if cfg.AllowCredentials {
+						h.Set("Access-Control-Allow-Credentials", "true")
+					}
+					if cfg.MaxAge > 0 {
+						h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+					}
+				}
+				h.Del("Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if origin != "" {
+				if allowOrigin := cfg.allowOrigin(origin); allowOrigin != "" {
+					h.Set("Access-Control-Allow-Origin", allowOrigin)
+					if cfg.AllowCredentials {
+						h.Set("Access-Control-Allow-Credentials", "true")
+					}
+					h.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{pattern: "https://app.example.com", origin: "https://app.example.com", want: true},
+		{pattern: "https://app.example.com", origin: "http://app.example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://app.example.com", want: true},
+		{pattern: "https://*.example.com", origin: "https://eu.app.example.com", want: true},
+		{pattern: "https://*.example.com", origin: "https://example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://evil.com/.example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://attacker-example.com", want: false},
+	}
+	for _, tc := range tcs {
+		if got := matchOrigin(tc.pattern, tc.origin); got != tc.want {
+			t.Errorf("expected match of %s against %s to be %v", tc.origin, tc.pattern, tc.want)
+		}
+	}
+}
+
+func TestCORSConfig_Validate(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		name string
+		cfg  CORSConfig
+		want error
+	}{
+		{name: "any origin", cfg: CORSConfig{AllowedOrigins: []string{"*"}}},
+		{name: "credentials of an origin", cfg: CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}},
+		{name: "credentials of any origin", cfg: CORSConfig{AllowedOrigins: []string{"https://app.example.com", "*"},
+			AllowCredentials: true}, want: ErrCORSCredentialsWildcard},
+		{name: "no origin", cfg: CORSConfig{}, want: ErrCORSNoOrigin},
+	}
+	for _, tc := range tcs {
+		if err := tc.cfg.Validate(); err != tc.want {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected WithCORS to reject the credentials of any origin")
+		}
+	}()
+	WithCORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+	mux := NewMuxHandler(zap.NewNop(), WithCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+
+	t.Run("preflight", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, routeLogin, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type, authorization")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d got %d", http.StatusNoContent, rr.Code)
+		}
+		want := map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Headers":     "content-type, authorization",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "600",
+		}
+		for k, v := range want {
+			if got := rr.Header().Get(k); got != v {
+				t.Errorf("expected header %s to be %q got %q", k, v, got)
+			}
+		}
+	})
+
+	t.Run("preflight disallowed header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, routeLogin, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "x-secret")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("expected no allow origin for a disallowed header")
+		}
+	})
+
+	t.Run("actual request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d got %d", http.StatusOK, rr.Code)
+		}
+		if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Errorf("expected allow origin header got %v", rr.Header())
+		}
+		if rr.Header().Get("Access-Control-Expose-Headers") == "" {
+			t.Errorf("expected expose headers")
+		}
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+		req.Header.Set("Origin", "https://evil.com")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("expected no allow origin for disallowed origin")
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package resthandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+const (
+	// defaultMaxBodySize is the maximum request body size of a route
+	// if not configured otherwise.
+	defaultMaxBodySize int64 = 1 << 20
+)
+
+var (
+	contextKeyMaxBodySize = contextKey("max_body_size")
+
+	errRequestTooLarge = envelope.NewError(envelope.CodeRequestTooLarge, "Request body too large.")
+	errEmptyBody       = envelope.NewError(envelope.CodeInvalidRequest, "Request body must not be empty.")
+)
+
+// decodeError is a failure to decode or apply the request body, reported back
+// to the client with the status code.
+type decodeError struct {
+	status int
+	apiErr envelope.Error
+	err    error
+}
+
+func (de *decodeError) Error() string {
+	return de.err.Error()
+}
+
+func (de *decodeError) Unwrap() error {
+	return de.err
+}
+
+// limitBody returns a middleware that limits the size of the request body to n bytes.
+// Request announcing a larger body is rejected upfront with 413 Request Entity Too Large,
+// others are rejected by the decodeBody while reading.
+func limitBody(logger *zap.Logger, n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				code := http.StatusRequestEntityTooLarge
+				writeError(w, r, code, errRequestTooLarge, logger)
+				logger.Error("request body too large", httpReqField(code, r, nil)...)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyMaxBodySize, n))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func maxBodySizeFromReqCtx(r *http.Request) int64 {
+	n, ok := r.Context().Value(contextKeyMaxBodySize).(int64)
+	if !ok {
+		return defaultMaxBodySize
+	}
+	return n
+}
+
+// decodeBody decodes the request body into the dst according to its Content-Type,
+// either a json document or an url encoded form. Decoding is strict, unknown
+// fields and trailing data after the json document are rejected.
+// Any returned error is a *decodeError.
+func decodeBody(r *http.Request, dst interface{}) error {
+	limit := maxBodySizeFromReqCtx(r)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return &decodeError{status: http.StatusBadRequest, apiErr: errInvalidJSON, err: err}
+	}
+	if int64(len(body)) > limit {
+		return &decodeError{
+			status: http.StatusRequestEntityTooLarge,
+			apiErr: errRequestTooLarge,
+			err:    fmt.Errorf("request body larger than %d bytes", limit),
+		}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &decodeError{status: http.StatusBadRequest, apiErr: errEmptyBody, err: io.EOF}
+	}
+
+	if mediaType(r) == formContentType {
+		return decodeForm(body, dst)
+	}
+	return decodeJSON(body, dst)
+}
+
+// writeDecodeError writes the err returned by decodeBody and returns the status code written.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error, l *zap.Logger) int {
+	var de *decodeError
+	if !errors.As(err, &de) {
+		writeInternalServerError(w, r, l)
+		return http.StatusInternalServerError
+	}
+	writeError(w, r, de.status, de.apiErr, l)
+	return de.status
+}
+
+func decodeJSON(body []byte, dst interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		// only a single json document is allowed.
+		offset := dec.InputOffset()
+		if _, err := dec.Token(); err != io.EOF {
+			// point at the first byte of the trailing data
+			trailing := bytes.TrimLeft(body[offset:], " \t\r\n")
+			offset = int64(len(body)-len(trailing)) + 1
+			return invalidJSON(body, offset, envelope.FieldError{
+				Code:    envelope.CodeTrailingData,
+				Message: "Unexpected data after the json document.",
+			}, errors.New("json: trailing data after document"))
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return invalidJSON(body, syntaxErr.Offset, envelope.FieldError{
+			Code:    envelope.CodeSyntaxError,
+			Message: syntaxErr.Error(),
+		}, err)
+	case errors.As(err, &typeErr):
+		return invalidJSON(body, typeErr.Offset, envelope.FieldError{
+			Field:   typeErr.Field,
+			Code:    envelope.CodeInvalidType,
+			Message: fmt.Sprintf("Expected %s got %s.", jsonTypeName(typeErr.Type), typeErr.Value),
+		}, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return invalidJSON(body, int64(len(body)), envelope.FieldError{
+			Code:    envelope.CodeSyntaxError,
+			Message: "Unexpected end of the json document.",
+		}, err)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json doesn't export a type for the unknown field error.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &decodeError{status: http.StatusBadRequest, err: err, apiErr: envelope.NewError(
+			envelope.CodeInvalidRequest, errInvalidJSON.Message, envelope.FieldError{
+				Field:   field,
+				Code:    envelope.CodeUnknownField,
+				Message: "Unknown field.",
+			})}
+	default:
+		return &decodeError{status: http.StatusBadRequest, apiErr: errInvalidJSON, err: err}
+	}
+}
+
+// invalidJSON returns a decodeError pointing at the line and column of the offset.
+func invalidJSON(body []byte, offset int64, fe envelope.FieldError, err error) error {
+	fe.Line, fe.Column = position(body, offset)
+	return &decodeError{
+		status: http.StatusBadRequest,
+		apiErr: envelope.NewError(envelope.CodeInvalidRequest, errInvalidJSON.Message, fe),
+		err:    err,
+	}
+}
+
+// position returns the 1 based line and column of the last byte read
+// when the decoder stopped after reading offset bytes of the body.
+func position(body []byte, offset int64) (line, column int) {
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := body[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// decodeForm decodes the url encoded form into the string fields of the struct
+// pointed to by dst. Values are matched with the field by their json tag name,
+// so the same form type serves both the json and url encoded request.
+func decodeForm(body []byte, dst interface{}) error {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return &decodeError{status: http.StatusBadRequest, apiErr: errInvalidJSON, err: err}
+	}
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode form: dst should be a pointer to struct got %T", dst)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	known := make(map[string]bool, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || field.Type.Kind() != reflect.String {
+			continue
+		}
+		known[name] = true
+		if v, ok := values[name]; ok && len(v) > 0 {
+			rv.Field(i).SetString(v[0])
+		}
+	}
+
+	for name := range values {
+		if !known[name] {
+			return &decodeError{
+				status: http.StatusBadRequest,
+				err:    fmt.Errorf("form: unknown field %q", name),
+				apiErr: envelope.NewError(envelope.CodeInvalidRequest, errInvalidJSON.Message,
+					envelope.FieldError{Field: name, Code: envelope.CodeUnknownField, Message: "Unknown field."}),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+func TestDecodeBody(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int64
+		wantStatus  int
+		wantDetail  envelope.FieldError
+	}{
+		{
+			name:       "valid json",
+			body:       `{"email_id": "ankur@example.com", "password": "secret"}`,
+			wantStatus: 0,
+		},
+		{
+			name:       "syntax error position",
+			body:       "{\n  \"email_id\": \"ankur@example.com\",\n  \"password\" \"secret\"\n}",
+			wantStatus: http.StatusBadRequest,
+			wantDetail: envelope.FieldError{Code: envelope.CodeSyntaxError, Line: 3, Column: 14},
+		},
+		{
+			name:       "type error position",
+			body:       `{"email_id": 42}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: envelope.FieldError{Field: "email_id", Code: envelope.CodeInvalidType, Line: 1, Column: 15},
+		},
+		{
+			name:       "unknown field",
+			body:       `{"email_id": "ankur@example.com", "admin": true}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: envelope.FieldError{Field: "admin", Code: envelope.CodeUnknownField},
+		},
+		{
+			name:       "trailing data",
+			body:       `{"email_id": "ankur@example.com"} {"password": "secret"}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: envelope.FieldError{Code: envelope.CodeTrailingData, Line: 1, Column: 35},
+		},
+		{
+			name:       "empty body",
+			body:       " ",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too large",
+			body:       `{"email_id": "ankur@example.com"}`,
+			limit:      10,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "form",
+			contentType: formContentType,
+			body:        "email_id=ankur%40example.com&password=secret",
+			wantStatus:  0,
+		},
+		{
+			name:        "form unknown field",
+			contentType: formContentType,
+			body:        "email_id=ankur%40example.com&admin=true",
+			wantStatus:  http.StatusBadRequest,
+			wantDetail:  envelope.FieldError{Field: "admin", Code: envelope.CodeUnknownField},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, routeLogin, strings.NewReader(tc.body))
+			if tc.contentType == "" {
+				tc.contentType = jsonContentType
+			}
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.limit == 0 {
+				tc.limit = defaultMaxBodySize
+			}
+
+			var form loginForm
+			var status int
+			rr := httptest.NewRecorder()
+			// unknown content length, so the limit is enforced while reading
+			req.ContentLength = -1
+			limitBody(zap.NewNop(), tc.limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := decodeBody(r, &form); err != nil {
+					status = writeDecodeError(w, r, err, zap.NewNop())
+				}
+			})).ServeHTTP(rr, req)
+
+			if status != tc.wantStatus {
+				t.Fatalf("expected status %d got %d %s", tc.wantStatus, status, rr.Body.String())
+			}
+			if status == 0 {
+				if form.EmailID != "ankur@example.com" {
+					t.Errorf("expected email to be decoded got %q", form.EmailID)
+				}
+				return
+			}
+			if tc.wantDetail.Code == "" {
+				return
+			}
+
+			var resp struct {
+				Errors []envelope.Error `json:"errors"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Errors) != 1 || len(resp.Errors[0].Details) != 1 {
+				t.Fatalf("expected a single field error got %s", rr.Body.String())
+			}
+			got := resp.Errors[0].Details[0]
+			got.Message = ""
+			if got != tc.wantDetail {
+				t.Errorf("expected field error %+v got %+v", tc.wantDetail, got)
+			}
+		})
+	}
+}
+
+func TestLimitBody_ContentLength(t *testing.T) {
+	t.Parallel()
+	l := zap.NewNop()
+	mux := NewMuxHandler(l, WithMaxBodySize(routeLogin, 8))
+
+	req := httptest.NewRequest(http.MethodPost, routeLogin, strings.NewReader(`{"email_id": "ankur@example.com"}`))
+	req.Header.Set("Content-Type", jsonContentType)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status code %d got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
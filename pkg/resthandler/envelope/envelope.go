@@ -0,0 +1,169 @@
+// Package envelope defines the JSON document every rest api response is
+// wrapped into, loosely following https://jsonapi.org/format/
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrEncode indicates the response could not be encoded
+// and nothing has been written to the client.
+var ErrEncode = errors.New("response encoding failed")
+
+// Code is a machine readable error code, clients can branch on it
+// instead of parsing the human readable message.
+type Code string
+
+const (
+	// CodeInvalidRequest indicates the request body could not be decoded
+	CodeInvalidRequest Code = "invalid_request"
+	// CodeValidationFailed indicates one or more fields failed the validation
+	CodeValidationFailed Code = "validation_failed"
+	// CodeInvalidCredentials indicates the email and password doesn't match
+	CodeInvalidCredentials Code = "invalid_credentials"
+	// CodeDuplicateRegistration indicates the email is already registered
+	CodeDuplicateRegistration Code = "duplicate_registration"
+	// CodeUnauthorized indicates the request is not authenticated
+	CodeUnauthorized Code = "unauthorized"
+	// CodePreconditionFailed indicates the resource has been modified since the client read it
+	CodePreconditionFailed Code = "precondition_failed"
+	// CodeMethodNotAllowed indicates the route doesn't support the request method
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	// CodeNotFound indicates the requested resource doesn't exist
+	CodeNotFound Code = "not_found"
+	// CodeTodoNotFound indicates the requested todo doesn't exist
+	CodeTodoNotFound Code = "todo_not_found"
+	// CodeItemNotFound indicates the requested checklist item doesn't exist
+	CodeItemNotFound Code = "item_not_found"
+	// CodeProjectNotFound indicates the requested project doesn't exist
+	CodeProjectNotFound Code = "project_not_found"
+	// CodeShareNotFound indicates the user has no share on the todo or project
+	CodeShareNotFound Code = "share_not_found"
+	// CodeSeriesNotFound indicates the todo doesn't recur
+	CodeSeriesNotFound Code = "series_not_found"
+	// CodeReminderNotFound indicates the todo has no such reminder
+	CodeReminderNotFound Code = "reminder_not_found"
+	// CodeOrgNotFound indicates the requested organization doesn't exist
+	CodeOrgNotFound Code = "org_not_found"
+	// CodeMemberNotFound indicates the user isn't a member of the organization
+	CodeMemberNotFound Code = "member_not_found"
+	// CodeLastOrgOwner indicates the organization would be left without an owner
+	CodeLastOrgOwner Code = "last_org_owner"
+	// CodeWebhookNotFound indicates the requested webhook doesn't exist, or the user can't manage it
+	CodeWebhookNotFound Code = "webhook_not_found"
+	// CodeForbidden indicates the role of the user on the todo or project doesn't allow the request
+	CodeForbidden Code = "forbidden"
+	// CodeInboxProject indicates the inbox project can't be deleted or archived
+	CodeInboxProject Code = "inbox_project"
+	// CodeUnsupportedMediaType indicates the request body is in a format the route doesn't accept
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	// CodeRequestTooLarge indicates the request body exceeds the size limit of the route
+	CodeRequestTooLarge Code = "request_too_large"
+	// CodeTooManyRequests indicates the client exceeded the rate limit of the route
+	CodeTooManyRequests Code = "too_many_requests"
+	// CodeInvalidPatch indicates the patch document is malformed or can't be applied to the resource
+	CodeInvalidPatch Code = "invalid_patch"
+	// CodePatchTestFailed indicates a test operation of the json patch doesn't hold
+	CodePatchTestFailed Code = "patch_test_failed"
+	// CodeIdempotencyKeyReused indicates the idempotency key has been used with a different request
+	CodeIdempotencyKeyReused Code = "idempotency_key_reused"
+	// CodeIdempotencyKeyInFlight indicates the request of the idempotency key is still being processed
+	CodeIdempotencyKeyInFlight Code = "idempotency_key_in_flight"
+	// CodeBatchAborted indicates the operation was not applied because another one of the atomic batch failed
+	CodeBatchAborted Code = "batch_aborted"
+	// CodeNotAcceptable indicates none of the formats the client accepts can be produced
+	CodeNotAcceptable Code = "not_acceptable"
+	// CodeInternal indicates an unexpected server side failure
+	CodeInternal Code = "internal_error"
+)
+
+// Field level validation codes.
+const (
+	// CodeInvalidEmail indicates the field is not a valid email address
+	CodeInvalidEmail Code = "invalid_email"
+	// CodeInvalidLength indicates the field length is out of the allowed range
+	CodeInvalidLength Code = "invalid_length"
+	// CodeInvalidValue indicates the field value is not one of the allowed values
+	CodeInvalidValue Code = "invalid_value"
+	// CodeSyntaxError indicates the request body is not a well formed json document
+	CodeSyntaxError Code = "syntax_error"
+	// CodeInvalidType indicates the field value is of the wrong json type
+	CodeInvalidType Code = "invalid_type"
+	// CodeUnknownField indicates the field is not part of the request
+	CodeUnknownField Code = "unknown_field"
+	// CodeTrailingData indicates unexpected data after the json document
+	CodeTrailingData Code = "trailing_data"
+	// CodeRequired indicates the field is missing
+	CodeRequired Code = "required"
+	// CodeInvalidOperation indicates the json patch operation is not supported
+	CodeInvalidOperation Code = "invalid_operation"
+	// CodePathNotFound indicates the json pointer doesn't reference an existing value
+	CodePathNotFound Code = "path_not_found"
+)
+
+// FieldError details the validation failure of a single request field.
+// Line and Column points into the request body when the failure
+// can be located in it.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+// Error is a single api error.
+type Error struct {
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// NewError returns an Error with the optional field level details.
+func NewError(code Code, message string, details ...FieldError) Error {
+	return Error{Code: code, Message: message, Details: details}
+}
+
+// Response is the envelope of every api response.
+// Exactly one of the Data or Errors is set.
+type Response struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Errors  []Error     `json:"errors,omitempty"`
+}
+
+// messageData is the Data of a response that only carries a message.
+type messageData struct {
+	Message string `json:"message"`
+}
+
+// Data returns a successful Response carrying v as data.
+func Data(v interface{}) Response {
+	return Response{Success: true, Data: v}
+}
+
+// Message returns a successful Response carrying only a message.
+func Message(m string) Response {
+	return Data(messageData{Message: m})
+}
+
+// Fail returns a failed Response carrying the errs.
+func Fail(errs ...Error) Response {
+	return Response{Success: false, Errors: errs}
+}
+
+// Write encodes the resp and writes it with the status code to w.
+// The resp is encoded before anything is written, so the caller
+// can still respond with an error if the encoding fails.
+func Write(w http.ResponseWriter, code int, resp Response) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEncode, err)
+	}
+	w.WriteHeader(code)
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,61 @@
+//go:build unit_tests || all_tests
+// +build unit_tests all_tests
+
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		name string
+		resp Response
+		want string
+	}{
+		{
+			name: "message",
+			resp: Message(`hello "world"`),
+			want: `{"success":true,"data":{"message":"hello \"world\""}}`,
+		},
+		{
+			name: "error with field details",
+			resp: Fail(NewError(CodeValidationFailed, "Invalid email address.",
+				FieldError{Field: "email_id", Code: CodeInvalidEmail, Message: "must be a valid email address"})),
+			want: `{"success":false,"errors":[{"code":"validation_failed","message":"Invalid email address.",` +
+				`"details":[{"field":"email_id","code":"invalid_email","message":"must be a valid email address"}]}]}`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			if err := Write(rr, http.StatusOK, tc.resp); err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(rr.Body.Bytes()) {
+				t.Fatalf("invalid json written %s", rr.Body.String())
+			}
+			if rr.Body.String() != tc.want {
+				t.Errorf("expected body %s got %s", tc.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWrite_EncodeErr(t *testing.T) {
+	t.Parallel()
+	rr := httptest.NewRecorder()
+	err := Write(rr, http.StatusOK, Data(make(chan int)))
+	if !errors.Is(err, ErrEncode) {
+		t.Fatalf("expected an encoding error got %v", err)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected nothing to be written on encoding error")
+	}
+}
@@ -0,0 +1,119 @@
+package envelope
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ProblemContentType is the media type of an RFC 7807 problem details document.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details document.
+// https://tools.ietf.org/html/rfc7807
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions are the additional members of the problem document,
+	// they must not collide with the standard members.
+	Extensions map[string]interface{}
+}
+
+// problemType is the stable type URI and title of a problem.
+type problemType struct {
+	uri   string
+	title string
+}
+
+// problemTypes maps the error codes to the problem type they are rendered as.
+// The URIs are relative references, resolved against the api base url, and
+// must never change once published as clients are allowed to rely on them.
+var problemTypes = map[Code]problemType{
+	CodeInvalidRequest:         {uri: "/problems/invalid-request", title: "Invalid request"},
+	CodeValidationFailed:       {uri: "/problems/validation-failed", title: "Validation failed"},
+	CodeInvalidCredentials:     {uri: "/problems/invalid-credentials", title: "Invalid credentials"},
+	CodeDuplicateRegistration:  {uri: "/problems/duplicate-registration", title: "Email already registered"},
+	CodeUnauthorized:           {uri: "/problems/unauthorized", title: "Unauthorized"},
+	CodePreconditionFailed:     {uri: "/problems/precondition-failed", title: "Precondition failed"},
+	CodeMethodNotAllowed:       {uri: "/problems/method-not-allowed", title: "Method not allowed"},
+	CodeNotFound:               {uri: "/problems/not-found", title: "Resource not found"},
+	CodeTodoNotFound:           {uri: "/problems/todo-not-found", title: "Todo not found"},
+	CodeItemNotFound:           {uri: "/problems/item-not-found", title: "Item not found"},
+	CodeProjectNotFound:        {uri: "/problems/project-not-found", title: "Project not found"},
+	CodeInboxProject:           {uri: "/problems/inbox-project", title: "Inbox project"},
+	CodeShareNotFound:          {uri: "/problems/share-not-found", title: "Share not found"},
+	CodeSeriesNotFound:         {uri: "/problems/series-not-found", title: "Series not found"},
+	CodeReminderNotFound:       {uri: "/problems/reminder-not-found", title: "Reminder not found"},
+	CodeOrgNotFound:            {uri: "/problems/org-not-found", title: "Organization not found"},
+	CodeMemberNotFound:         {uri: "/problems/member-not-found", title: "Member not found"},
+	CodeLastOrgOwner:           {uri: "/problems/last-org-owner", title: "Last organization owner"},
+	CodeWebhookNotFound:        {uri: "/problems/webhook-not-found", title: "Webhook not found"},
+	CodeForbidden:              {uri: "/problems/forbidden", title: "Forbidden"},
+	CodeUnsupportedMediaType:   {uri: "/problems/unsupported-media-type", title: "Unsupported media type"},
+	CodeInvalidPatch:           {uri: "/problems/invalid-patch", title: "Invalid patch"},
+	CodePatchTestFailed:        {uri: "/problems/patch-test-failed", title: "Patch test failed"},
+	CodeIdempotencyKeyReused:   {uri: "/problems/idempotency-key-reused", title: "Idempotency key reused"},
+	CodeIdempotencyKeyInFlight: {uri: "/problems/idempotency-key-in-flight", title: "Idempotency key in flight"},
+	CodeBatchAborted:           {uri: "/problems/batch-aborted", title: "Batch aborted"},
+	CodeNotAcceptable:          {uri: "/problems/not-acceptable", title: "Not acceptable"},
+	CodeRequestTooLarge:        {uri: "/problems/request-too-large", title: "Request body too large"},
+	CodeTooManyRequests:        {uri: "/problems/too-many-requests", title: "Too many requests"},
+}
+
+// ProblemFor returns the Problem representation of the api error e
+// that occurred while serving the instance with the status code.
+func ProblemFor(status int, e Error, instance string) Problem {
+	p := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   e.Message,
+		Instance: instance,
+		Extensions: map[string]interface{}{
+			"code": e.Code,
+		},
+	}
+	if pt, ok := problemTypes[e.Code]; ok {
+		p.Type = pt.uri
+		p.Title = pt.title
+	}
+	if len(e.Details) > 0 {
+		p.Extensions["invalid_params"] = e.Details
+	}
+	return p
+}
+
+// MarshalJSON encodes the problem with its extension members
+// inlined next to the standard members.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// WriteProblem encodes the p and writes it as application/problem+json to w.
+// Like Write nothing is written if the encoding fails.
+func WriteProblem(w http.ResponseWriter, p Problem) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEncode, err)
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	_, err = w.Write(b)
+	return err
+}
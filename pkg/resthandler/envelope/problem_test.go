@@ -0,0 +1,62 @@
+//go:build unit_tests || all_tests
+// +build unit_tests all_tests
+
+package envelope
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	t.Parallel()
+	apiErr := NewError(CodeValidationFailed, "Validation failed.",
+		FieldError{Field: "password", Code: CodeInvalidLength, Message: "too short"})
+	p := ProblemFor(http.StatusPreconditionFailed, apiErr, "/v1/users/signup")
+
+	rr := httptest.NewRecorder()
+	if err := WriteProblem(rr, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status code %d got %d", http.StatusPreconditionFailed, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("expected content type %s got %s", ProblemContentType, ct)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":     "/problems/validation-failed",
+		"title":    "Validation failed",
+		"status":   float64(http.StatusPreconditionFailed),
+		"detail":   "Validation failed.",
+		"instance": "/v1/users/signup",
+		"code":     "validation_failed",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("expected member %q to be %v got %v", k, v, got[k])
+		}
+	}
+	if params, _ := got["invalid_params"].([]interface{}); len(params) != 1 {
+		t.Errorf("expected invalid_params extension member got %v", got["invalid_params"])
+	}
+}
+
+func TestProblemFor_UnknownCode(t *testing.T) {
+	t.Parallel()
+	p := ProblemFor(http.StatusInternalServerError, NewError(CodeInternal, "Something went wrong."), "")
+	if p.Type != "about:blank" {
+		t.Errorf("expected about:blank type for an unmapped code got %s", p.Type)
+	}
+	if p.Title != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf("expected status text as title got %s", p.Title)
+	}
+}
@@ -0,0 +1,106 @@
+package resthandler
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errSomethingWentWrong = envelope.NewError(envelope.CodeInternal, "Something went wrong.")
+	errTodoNotFound       = envelope.NewError(envelope.CodeTodoNotFound, "Todo not found.")
+	errItemNotFound       = envelope.NewError(envelope.CodeItemNotFound, "Item not found.")
+	errProjectNotFound    = envelope.NewError(envelope.CodeProjectNotFound, "Project not found.")
+	errInboxProject       = envelope.NewError(envelope.CodeInboxProject, "The inbox can't be deleted or archived.")
+	errShareNotFound      = envelope.NewError(envelope.CodeShareNotFound, "Share not found.")
+	errForbidden          = envelope.NewError(envelope.CodeForbidden, "Your role doesn't allow it.")
+	errBatchAborted       = envelope.NewError(envelope.CodeBatchAborted,
+		"Not applied, another operation of the atomic batch failed.")
+)
+
+// domainError maps a known domain error to the api error it's rendered as.
+type domainError struct {
+	err    error
+	status int
+	apiErr envelope.Error
+}
+
+// domainErrors are the domain errors clients are told about,
+// any other error is an internal server error.
+var domainErrors = []domainError{
+	{err: pkg.ErrDuplicateRegistration, status: http.StatusConflict, apiErr: errDuplicateReg},
+	{err: pkg.ErrInvalidCredentials, status: http.StatusUnprocessableEntity, apiErr: errInvalidCredential},
+	{err: serror.ErrTodoNotFound, status: http.StatusNotFound, apiErr: errTodoNotFound},
+	{err: serror.ErrVersionConflict, status: http.StatusPreconditionFailed, apiErr: errPreconditionFailed},
+	{err: pkg.ErrInvalidTodo, status: http.StatusUnprocessableEntity, apiErr: errInvalidTodo},
+	{err: serror.ErrItemNotFound, status: http.StatusNotFound, apiErr: errItemNotFound},
+	{err: pkg.ErrInvalidItem, status: http.StatusUnprocessableEntity, apiErr: errInvalidItem},
+	{err: serror.ErrSeriesNotFound, status: http.StatusNotFound, apiErr: errSeriesNotFound},
+	{err: pkg.ErrInvalidSeries, status: http.StatusUnprocessableEntity, apiErr: errInvalidSeries},
+	{err: serror.ErrReminderNotFound, status: http.StatusNotFound, apiErr: errReminderNotFound},
+	{err: pkg.ErrInvalidReminder, status: http.StatusUnprocessableEntity, apiErr: errInvalidReminder},
+	{err: serror.ErrProjectNotFound, status: http.StatusNotFound, apiErr: errProjectNotFound},
+	{err: pkg.ErrInvalidProject, status: http.StatusUnprocessableEntity, apiErr: errInvalidProject},
+	{err: pkg.ErrInboxProject, status: http.StatusConflict, apiErr: errInboxProject},
+	{err: pkg.ErrOrgProjectInbox, status: http.StatusUnprocessableEntity, apiErr: errOrgProjectInbox},
+	{err: serror.ErrGrantNotFound, status: http.StatusNotFound, apiErr: errShareNotFound},
+	{err: pkg.ErrInvalidGrant, status: http.StatusUnprocessableEntity, apiErr: errInvalidGrant},
+	{err: pkg.ErrInviteeNotFound, status: http.StatusUnprocessableEntity, apiErr: errInviteeNotFound},
+	{err: serror.ErrOrgNotFound, status: http.StatusNotFound, apiErr: errOrgNotFound},
+	{err: serror.ErrMemberNotFound, status: http.StatusNotFound, apiErr: errMemberNotFound},
+	{err: pkg.ErrInvalidOrg, status: http.StatusUnprocessableEntity, apiErr: errInvalidOrg},
+	{err: pkg.ErrInvalidMembership, status: http.StatusUnprocessableEntity, apiErr: errInvalidMembership},
+	{err: pkg.ErrLastOrgOwner, status: http.StatusConflict, apiErr: errLastOrgOwner},
+	{err: serror.ErrWebhookNotFound, status: http.StatusNotFound, apiErr: errWebhookNotFound},
+	{err: pkg.ErrInvalidWebhook, status: http.StatusUnprocessableEntity, apiErr: errInvalidWebhook},
+	{err: pkg.ErrForbidden, status: http.StatusForbidden, apiErr: errForbidden},
+	{err: pkg.ErrInvalidSearch, status: http.StatusUnprocessableEntity, apiErr: errInvalidSearch},
+	{err: pkg.ErrInvalidSyncToken, status: http.StatusUnprocessableEntity, apiErr: errInvalidSyncToken},
+	{err: pkg.ErrBatchAborted, status: http.StatusFailedDependency, apiErr: errBatchAborted},
+}
+
+// writeDomainError writes the api error err is mapped to and returns
+// the status code written.
+func writeDomainError(w http.ResponseWriter, r *http.Request, err error, l *zap.Logger) int {
+	status, apiErr := domainErrorFor(err)
+	writeError(w, r, status, apiErr, l)
+	return status
+}
+
+// domainErrorFor returns the status code and api error the err is mapped to.
+func domainErrorFor(err error) (int, envelope.Error) {
+	for _, de := range domainErrors {
+		if errors.Is(err, de.err) {
+			return de.status, de.apiErr
+		}
+	}
+	return http.StatusInternalServerError, errSomethingWentWrong
+}
+
+// writeError writes the apiErr with the status code either inside a failed
+// response envelope or as a problem+json document, whichever the client
+// prefers through the Accept header.
+func writeError(w http.ResponseWriter, r *http.Request, code int, apiErr envelope.Error, l *zap.Logger) {
+	format := negotiate(r.Header.Get("Accept"), jsonContentType, envelope.ProblemContentType)
+	if format != envelope.ProblemContentType {
+		writeResponse(w, code, envelope.Fail(apiErr), l)
+		return
+	}
+
+	err := envelope.WriteProblem(w, envelope.ProblemFor(code, apiErr, r.URL.Path))
+	if errors.Is(err, envelope.ErrEncode) {
+		l.Error("problem encoding failed", zap.Error(err))
+		writeResponse(w, http.StatusInternalServerError, envelope.Fail(errSomethingWentWrong), l)
+		return
+	}
+	checkResponseWriteErr(err, l)
+}
+
+func writeInternalServerError(w http.ResponseWriter, r *http.Request, l *zap.Logger) {
+	writeError(w, r, http.StatusInternalServerError, errSomethingWentWrong, l)
+}
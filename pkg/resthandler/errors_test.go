@@ -0,0 +1,101 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+func TestWriteDomainError(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		name     string
+		err      error
+		accept   string
+		wantCode int
+		wantCT   string
+		wantType string
+	}{
+		{
+			name:     "duplicate registration envelope",
+			err:      pkg.ErrDuplicateRegistration,
+			wantCode: http.StatusConflict,
+			wantCT:   "",
+		},
+		{
+			name:     "duplicate registration problem",
+			err:      pkg.ErrDuplicateRegistration,
+			accept:   envelope.ProblemContentType,
+			wantCode: http.StatusConflict,
+			wantCT:   envelope.ProblemContentType,
+			wantType: "/problems/duplicate-registration",
+		},
+		{
+			name:     "invalid credentials problem",
+			err:      pkg.ErrInvalidCredentials,
+			accept:   envelope.ProblemContentType,
+			wantCode: http.StatusUnprocessableEntity,
+			wantCT:   envelope.ProblemContentType,
+			wantType: "/problems/invalid-credentials",
+		},
+		{
+			name:     "wrapped todo not found problem",
+			err:      serror.NewQueryError("find", serror.ErrTodoNotFound, ""),
+			accept:   envelope.ProblemContentType,
+			wantCode: http.StatusNotFound,
+			wantCT:   envelope.ProblemContentType,
+			wantType: "/problems/todo-not-found",
+		},
+		{
+			name:     "unknown error",
+			err:      fmt.Errorf("connection refused"),
+			accept:   envelope.ProblemContentType,
+			wantCode: http.StatusInternalServerError,
+			wantCT:   envelope.ProblemContentType,
+			wantType: "about:blank",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/users/signup", nil)
+			req.Header.Set("Accept", tc.accept)
+			rr := httptest.NewRecorder()
+
+			code := writeDomainError(rr, req, tc.err, zap.NewNop())
+			if code != tc.wantCode || rr.Code != tc.wantCode {
+				t.Errorf("expected status code %d got %d, written %d", tc.wantCode, code, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != tc.wantCT {
+				t.Errorf("expected content type %q got %q", tc.wantCT, ct)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantType == "" {
+				if body["success"] != false {
+					t.Errorf("expected a failed response envelope got %s", rr.Body.String())
+				}
+				return
+			}
+			if body["type"] != tc.wantType {
+				t.Errorf("expected problem type %s got %v", tc.wantType, body["type"])
+			}
+			if body["instance"] != "/v1/users/signup" {
+				t.Errorf("expected problem instance to be the request path got %v", body["instance"])
+			}
+		})
+	}
+}
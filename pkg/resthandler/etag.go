@@ -0,0 +1,63 @@
+package resthandler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the strong entity tag of a resource version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETags parses the list of entity tags of an If-Match or If-None-Match header.
+// Weak tags keep their W/ prefix, see etagMatch.
+func parseETags(h string) []string {
+	var tags []string
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// etagMatch reports if any of the tags matches the current etag. weak comparison
+// ignores the W/ prefix, as needed by If-None-Match, If-Match uses the strong one.
+func etagMatch(tags []string, current string, weak bool) bool {
+	for _, t := range tags {
+		if t == "*" {
+			return true
+		}
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == current {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports if the If-None-Match of the r matches the current etag,
+// in which case the client cached representation is still fresh.
+func notModified(r *http.Request, current string) bool {
+	h := r.Header.Get("If-None-Match")
+	if h == "" {
+		return false
+	}
+	return etagMatch(parseETags(h), current, true)
+}
+
+// preconditionFailed reports if the r carries an If-Match that doesn't
+// match the current etag, the write must not happen then.
+func preconditionFailed(r *http.Request, current string) bool {
+	h := r.Header.Get("If-Match")
+	if h == "" {
+		return false
+	}
+	return !etagMatch(parseETags(h), current, false)
+}
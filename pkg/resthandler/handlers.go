@@ -2,59 +2,335 @@ package resthandler
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
-	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/memory"
 	"github.com/gorilla/mux"
 )
 
 type contextKey string
 
+const (
+	jsonContentType = "application/json"
+	formContentType = "application/x-www-form-urlencoded"
+)
+
+// routes
+const (
+	routeSignUp = "/v1/users/signup"
+	routeLogin  = "/v1/users/login"
+	routeTodos  = "/v1/todos"
+	routeTodo   = "/v1/todos/{id}"
+	// custom method, as in https://google.aip.dev/136
+	routeTodosBatch = "/v1/todos:batch"
+	routeTodosTrash = "/v1/todos/trash"
+	// the Server-Sent Events of the todos
+	routeTodosStream = "/v1/todos/stream"
+	// the full text search of the todos
+	routeTodosSearch = "/v1/todos/search"
+	routeTodoRestore = "/v1/todos/{id}:restore"
+	routeTodoItems   = "/v1/todos/{id}/items"
+	routeTodoItem    = "/v1/todos/{id}/items/{item_id}"
+	// the series of a recurring todo, and its upcoming occurrences
+	routeTodoSeries      = "/v1/todos/{id}/series"
+	routeTodoOccurrences = "/v1/todos/{id}/series/occurrences"
+	routeTodoReminders   = "/v1/todos/{id}/reminders"
+	routeTodoReminder    = "/v1/todos/{id}/reminders/{reminder_id}"
+	routeProjects        = "/v1/projects"
+	routeProject         = "/v1/projects/{id}"
+	// shares of a todo or project, by user
+	routeTodoShares    = "/v1/todos/{id}/shares"
+	routeTodoShare     = "/v1/todos/{id}/shares/{user_id}"
+	routeProjectShares = "/v1/projects/{id}/shares"
+	routeProjectShare  = "/v1/projects/{id}/shares/{user_id}"
+	routeSharedWithMe  = "/v1/shared"
+	routeOrgs          = "/v1/orgs"
+	routeOrg           = "/v1/orgs/{id}"
+	// a token whose active organization is the one of the route
+	routeOrgToken   = "/v1/orgs/{id}/token"
+	routeOrgMembers = "/v1/orgs/{id}/members"
+	routeOrgMember  = "/v1/orgs/{id}/members/{user_id}"
+	routeWebhooks   = "/v1/webhooks"
+	routeWebhook    = "/v1/webhooks/{id}"
+	// the delivery log of a webhook
+	routeWebhookDeliveries = "/v1/webhooks/{id}/deliveries"
+	// the WebSocket of the live editing of the projects
+	routeLive = "/v1/live"
+	// the delta sync of the offline first clients
+	routeSync = "/v1/sync"
+)
+
 var (
 	contextKeyDuration = contextKey("duration")
 
-	someThingWentWrong = []byte(`{"status": "ERROR", 
-	"error": {"message": "Something went wrong.", "kind": "ServerException"}`)
+	errNotFound         = envelope.NewError(envelope.CodeNotFound, "Resource not found.")
+	errMethodNotAllowed = envelope.NewError(envelope.CodeMethodNotAllowed, "Method not allowed.")
 )
 
 // MuxHandler is a Handler that responds to an HTTP request.
 type MuxHandler struct {
 	log           *zap.Logger
 	regAndAuth    auth
+	tokenizer     Tokenizer
 	staticHandler staticHandler
-	router        *mux.Router
+	// todos is nil if no todo service is configured
+	todos *todos
+	// stream is nil if no todo stream is configured
+	stream *todoStream
+	// items is nil if no item service is configured
+	items *pkg.ItemService
+	// series is nil if no series service is configured
+	series *pkg.SeriesService
+	// reminders is nil if no reminder service is configured
+	reminders *pkg.ReminderService
+	// search is nil if no search service is configured
+	search *pkg.SearchService
+	// projects is nil if no project service is configured
+	projects *projects
+	// shares is nil if no share service is configured
+	shares *shares
+	// orgs is nil if no organization service is configured
+	orgs *orgs
+	// webhooks is nil if no webhook service is configured
+	webhooks *webhooks
+	// live is nil if the live api isn't enabled
+	live *live
+	// sync is nil if no sync service is configured
+	sync   *todoSync
+	router *mux.Router
+	// handler is the router wrapped with all the global middleware
+	handler http.Handler
+	// maxBodySize is the request body size limit by route path
+	maxBodySize map[string]int64
+	// rateLimits is the rate limit policy by route path
+	rateLimits     map[string]RateLimitPolicy
+	rateLimitStore RateLimitStore
+	// trustedProxies are the proxies whose X-Forwarded-For is honoured by the default rate limits
+	trustedProxies []*net.IPNet
+	// idempotency keys of the POST routes
+	idempotencyStore pkg.IdempotencyStorage
+	idempotencyTTL   time.Duration
+	// cors is nil if the CORS support is disabled
+	cors *CORSConfig
+}
+
+// Option configures the MuxHandler.
+type Option func(mh *MuxHandler)
+
+// WithMaxBodySize limits the request body size of the route to n bytes.
+func WithMaxBodySize(route string, n int64) Option {
+	return func(mh *MuxHandler) {
+		mh.maxBodySize[route] = n
+	}
+}
+
+// WithAuth sets the service and tokenizer used for the registration, login
+// and the authentication of the requests.
+func WithAuth(svc pkg.RegAndAuthService, tokenizer Tokenizer) Option {
+	return func(mh *MuxHandler) {
+		mh.regAndAuth.svc = svc
+		mh.regAndAuth.tokenizer = tokenizer
+		mh.tokenizer = tokenizer
+	}
+}
+
+// WithTodoService enables the todo api over the svc.
+// The todo api needs the WithAuth to authenticate the requests.
+func WithTodoService(svc pkg.TodoService) Option {
+	return func(mh *MuxHandler) {
+		mh.todos = &todos{svc: svc, logger: mh.log}
+	}
+}
+
+// WithTodoStream enables the Server-Sent Events of the todos over the stream,
+// which must Run on its own. Every response streams for the lifetime, and has to
+// end before the write timeout of the server, the streams are better served on
+// their own by a server with a write timeout longer than the lifetime.
+// The stream needs the WithAuth to authenticate the requests.
+func WithTodoStream(stream *pkg.TodoStream, lifetime time.Duration) Option {
+	return func(mh *MuxHandler) {
+		mh.stream = &todoStream{stream: stream, logger: mh.log, heartbeat: defaultStreamHeartbeat, lifetime: lifetime}
+	}
+}
+
+// WithItemService enables the checklist items of the todos over the svc.
+// The items need the WithTodoService.
+func WithItemService(svc pkg.ItemService) Option {
+	return func(mh *MuxHandler) {
+		mh.items = &svc
+	}
+}
+
+// WithSeriesService enables the recurring todos over the svc, the todo
+// service should be a recurring one to complete their occurrences.
+// The recurring todos need the WithTodoService.
+func WithSeriesService(svc pkg.SeriesService) Option {
+	return func(mh *MuxHandler) {
+		mh.series = &svc
+	}
+}
+
+// WithReminderService enables the reminders of the todos over the svc, they're
+// delivered by a pkg.ReminderScheduler over the same storage.
+// The reminders need the WithTodoService.
+func WithReminderService(svc pkg.ReminderService) Option {
+	return func(mh *MuxHandler) {
+		mh.reminders = &svc
+	}
+}
+
+// WithSearchService enables the full text search of the todos over the svc.
+// The search needs the WithTodoService.
+func WithSearchService(svc pkg.SearchService) Option {
+	return func(mh *MuxHandler) {
+		mh.search = &svc
+	}
+}
+
+// WithProjectService enables the project api over the svc.
+// The project api needs the WithAuth to authenticate the requests.
+func WithProjectService(svc pkg.ProjectService) Option {
+	return func(mh *MuxHandler) {
+		mh.projects = &projects{svc: svc, logger: mh.log}
+	}
+}
+
+// WithShareService enables the share api over the svc, the services of the
+// todos and projects should authorize with its Authorizer.
+// The share api needs the WithAuth to authenticate the requests.
+func WithShareService(svc pkg.ShareService) Option {
+	return func(mh *MuxHandler) {
+		mh.shares = &shares{svc: svc, logger: mh.log}
+	}
+}
+
+// WithOrgService enables the organization api over the svc, the services of
+// the todos and projects should authorize with an org Authorizer of the same storage.
+// The organization api needs the WithAuth to authenticate the requests.
+func WithOrgService(svc pkg.OrgService) Option {
+	return func(mh *MuxHandler) {
+		mh.orgs = &orgs{svc: svc, logger: mh.log}
+	}
+}
+
+// WithWebhookService enables the webhook api over the svc, the events are
+// delivered by a pkg.WebhookDispatcher over the same storage.
+// The webhook api needs the WithAuth to authenticate the requests.
+func WithWebhookService(svc pkg.WebhookService) Option {
+	return func(mh *MuxHandler) {
+		mh.webhooks = &webhooks{svc: svc, logger: mh.log}
+	}
+}
+
+// WithLive enables the WebSocket api of the live editing of the projects, the
+// todo changes are read from the stream, which must Run on its own. The messages
+// of every connection are limited to the rate, 30 every 10 seconds if it's zero.
+// The live api needs the WithTodoService and the WithProjectService.
+func WithLive(stream *pkg.TodoStream, rate Rate) Option {
+	return func(mh *MuxHandler) {
+		if rate == (Rate{}) {
+			rate = defaultLiveRate
+		}
+		mh.live = newLive(mh.log, stream, rate)
+	}
+}
+
+// WithSyncService enables the delta sync of the todos over the svc.
+// The sync needs the WithAuth to authenticate the requests.
+func WithSyncService(svc pkg.SyncService) Option {
+	return func(mh *MuxHandler) {
+		mh.sync = &todoSync{svc: svc, logger: mh.log}
+	}
+}
+
+// WithRateLimit limits the requests of the route with the policy.
+func WithRateLimit(route string, policy RateLimitPolicy) Option {
+	return func(mh *MuxHandler) {
+		mh.rateLimits[route] = policy
+	}
+}
+
+// WithTrustedProxies sets the proxies the requests come through, the default rate limits
+// of the signup and login key the requests by the client address they forward.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(mh *MuxHandler) {
+		mh.trustedProxies = proxies
+	}
+}
+
+// WithRateLimitStore sets the store of the rate limiter token buckets,
+// by default they are kept in memory.
+func WithRateLimitStore(store RateLimitStore) Option {
+	return func(mh *MuxHandler) {
+		mh.rateLimitStore = store
+	}
+}
+
+// WithIdempotencyStore sets the store of the idempotency keys and how long
+// a response is replayed, by default they are kept in memory for 24 hours.
+// The store must be shared by all the replicas for the keys to hold across them.
+func WithIdempotencyStore(store pkg.IdempotencyStorage, ttl time.Duration) Option {
+	return func(mh *MuxHandler) {
+		mh.idempotencyStore = store
+		mh.idempotencyTTL = ttl
+	}
 }
 
 // NewMuxHandler returns an initialized http.Handler
-func NewMuxHandler(logger *zap.Logger) *MuxHandler {
+func NewMuxHandler(logger *zap.Logger, opts ...Option) *MuxHandler {
 	mh := MuxHandler{
 		staticHandler: newStaticHandler(logger),
+		regAndAuth:    auth{logger: logger},
 		log:           logger,
 		router:        mux.NewRouter(),
+		maxBodySize: map[string]int64{
+			routeSignUp: 4 << 10,
+			routeLogin:  4 << 10,
+		},
+		rateLimits:       make(map[string]RateLimitPolicy),
+		rateLimitStore:   NewMemoryRateLimitStore(),
+		idempotencyStore: memory.NewIdempotencyStore(),
+		idempotencyTTL:   defaultIdempotencyKeyTTL,
+	}
+	for _, opt := range opts {
+		opt(&mh)
+	}
+	// slow down the credential stuffing and mass registration, unless limited otherwise
+	for _, route := range []string{routeSignUp, routeLogin} {
+		if _, ok := mh.rateLimits[route]; !ok {
+			mh.rateLimits[route] = RateLimitPolicy{Rate: Rate{Limit: 10, Period: time.Minute},
+				KeyFuncs: []RateLimitKeyFunc{KeyByIP(mh.trustedProxies)}}
+		}
 	}
 	mh.initializeRoutes()
+
+	// global middleware, outermost first
+	mh.handler = requireAcceptable(logger, routeTodosStream)(mh.router)
+	if mh.cors != nil {
+		mh.handler = cors(*mh.cors)(mh.handler)
+	}
+	mh.handler = recoverPanic(logger)(mh.handler)
 	return &mh
 }
 
 // ServeHTTP responds to an HTTP request
 func (mh *MuxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// if the content-type is not application json reject the request upfront
-	h := r.Header.Get("Content-Type")
-	if !strings.Contains(h, "application/json") {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
 	// put start time in the context
 	r = r.WithContext(context.WithValue(r.Context(), contextKeyDuration, time.Now()))
 	// all are json response
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	// prevent mime sniff
 	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Vary", "Accept")
 
-	mh.router.ServeHTTP(w, r)
+	mh.handler.ServeHTTP(w, r)
 }
 
 func (mh *MuxHandler) initializeRoutes() {
@@ -69,9 +345,203 @@ func (mh *MuxHandler) initializeRoutes() {
 
 	})
 
-	// login and registration
-	mh.router.HandleFunc("/v1/users/signup", mh.regAndAuth.signUp)
-	mh.router.HandleFunc("/v1/users/login", mh.regAndAuth.login)
+	// login and registration, also submitted by plain html forms
+	jsonOrForm := requireContentType(mh.log, jsonContentType, formContentType)
+	// signup retries replay the first account created instead of a conflict, login has
+	// no side effect to be retried safely, and its token isn't worth storing.
+	mh.handle(routeSignUp, http.HandlerFunc(mh.regAndAuth.signUp), jsonOrForm, mh.idempotent())
+	mh.handle(routeLogin, http.HandlerFunc(mh.regAndAuth.login), jsonOrForm)
+
+	// before the todo routes, their {id} would match it too.
+	if mh.stream != nil && mh.tokenizer != nil {
+		mh.handle(routeTodosStream, http.HandlerFunc(mh.stream.serve), requireAuth(mh.log, mh.tokenizer)).Methods(http.MethodGet)
+	}
+	if mh.todos != nil && mh.tokenizer != nil {
+		mh.initializeTodoRoutes()
+	}
+	if mh.projects != nil && mh.tokenizer != nil {
+		mh.initializeProjectRoutes()
+	}
+	if mh.shares != nil && mh.tokenizer != nil {
+		mh.initializeShareRoutes()
+	}
+	if mh.orgs != nil && mh.tokenizer != nil {
+		mh.initializeOrgRoutes()
+	}
+	if mh.webhooks != nil && mh.tokenizer != nil {
+		mh.initializeWebhookRoutes()
+	}
+	if mh.live != nil && mh.todos != nil && mh.projects != nil && mh.tokenizer != nil {
+		mh.initializeLiveRoutes()
+	}
+	if mh.sync != nil && mh.tokenizer != nil {
+		mh.initializeSyncRoutes()
+	}
+
+	mh.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, errNotFound, mh.log)
+		mh.log.Info("route not found", httpReqField(http.StatusNotFound, r, nil)...)
+	})
+	mh.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, errMethodNotAllowed, mh.log)
+		mh.log.Info("method not allowed", httpReqField(http.StatusMethodNotAllowed, r, nil)...)
+	})
+}
+
+func (mh *MuxHandler) initializeTodoRoutes() {
+	th := mh.todos
+	// before the handlers are bound, they copy the th.
+	th.items = mh.items
+	th.series = mh.series
+	th.reminders = mh.reminders
+	th.search = mh.search
+	authn := requireAuth(mh.log, mh.tokenizer)
+	jsonOnly := requireContentType(mh.log, jsonContentType)
+	patchOnly := requireContentType(mh.log, mergePatchContentType, jsonPatchContentType)
+
+	mh.handle(routeTodos, http.HandlerFunc(th.list), authn).Methods(http.MethodGet)
+	mh.handle(routeTodos, http.HandlerFunc(th.create), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+	mh.handle(routeTodosBatch, http.HandlerFunc(th.batch), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+	// before the routeTodo, its {id} would match them too.
+	mh.handle(routeTodosTrash, http.HandlerFunc(th.trash), authn).Methods(http.MethodGet)
+	if mh.search != nil {
+		mh.handle(routeTodosSearch, http.HandlerFunc(th.searchTodos), authn).Methods(http.MethodGet)
+	}
+	mh.handle(routeTodoRestore, http.HandlerFunc(th.restore), authn).Methods(http.MethodPost)
+	mh.handle(routeTodo, http.HandlerFunc(th.get), authn).Methods(http.MethodGet)
+	mh.handle(routeTodo, http.HandlerFunc(th.update), authn, jsonOnly).Methods(http.MethodPut)
+	mh.handle(routeTodo, http.HandlerFunc(th.patch), authn, patchOnly).Methods(http.MethodPatch)
+	mh.handle(routeTodo, http.HandlerFunc(th.delete), authn).Methods(http.MethodDelete)
+
+	if mh.series != nil {
+		mh.handle(routeTodoSeries, http.HandlerFunc(th.getSeries), authn).Methods(http.MethodGet)
+		mh.handle(routeTodoSeries, http.HandlerFunc(th.saveSeries), authn, jsonOnly).Methods(http.MethodPut)
+		mh.handle(routeTodoSeries, http.HandlerFunc(th.deleteSeries), authn).Methods(http.MethodDelete)
+		mh.handle(routeTodoOccurrences, http.HandlerFunc(th.occurrences), authn).Methods(http.MethodGet)
+	}
+	if mh.reminders != nil {
+		mh.handle(routeTodoReminders, http.HandlerFunc(th.listReminders), authn).Methods(http.MethodGet)
+		mh.handle(routeTodoReminders, http.HandlerFunc(th.createReminder), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+		mh.handle(routeTodoReminder, http.HandlerFunc(th.deleteReminder), authn).Methods(http.MethodDelete)
+	}
+	if mh.items == nil {
+		return
+	}
+	mh.handle(routeTodoItems, http.HandlerFunc(th.listItems), authn).Methods(http.MethodGet)
+	mh.handle(routeTodoItems, http.HandlerFunc(th.createItem), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+	mh.handle(routeTodoItem, http.HandlerFunc(th.updateItem), authn, jsonOnly).Methods(http.MethodPut)
+	mh.handle(routeTodoItem, http.HandlerFunc(th.deleteItem), authn).Methods(http.MethodDelete)
+}
+
+func (mh *MuxHandler) initializeProjectRoutes() {
+	ph := mh.projects
+	authn := requireAuth(mh.log, mh.tokenizer)
+	jsonOnly := requireContentType(mh.log, jsonContentType)
+
+	mh.handle(routeProjects, http.HandlerFunc(ph.list), authn).Methods(http.MethodGet)
+	mh.handle(routeProjects, http.HandlerFunc(ph.create), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+	mh.handle(routeProject, http.HandlerFunc(ph.get), authn).Methods(http.MethodGet)
+	mh.handle(routeProject, http.HandlerFunc(ph.update), authn, jsonOnly).Methods(http.MethodPut)
+	mh.handle(routeProject, http.HandlerFunc(ph.delete), authn).Methods(http.MethodDelete)
+}
+
+func (mh *MuxHandler) initializeShareRoutes() {
+	sh := mh.shares
+	authn := requireAuth(mh.log, mh.tokenizer)
+	jsonOnly := requireContentType(mh.log, jsonContentType)
+
+	mh.handle(routeSharedWithMe, http.HandlerFunc(sh.sharedWithMe), authn).Methods(http.MethodGet)
+	for _, routes := range []struct {
+		typ         pkg.ResourceType
+		list, share string
+	}{
+		{typ: pkg.ResourceTodo, list: routeTodoShares, share: routeTodoShare},
+		{typ: pkg.ResourceProject, list: routeProjectShares, share: routeProjectShare},
+	} {
+		mh.handle(routes.list, sh.list(routes.typ), authn).Methods(http.MethodGet)
+		mh.handle(routes.list, sh.invite(routes.typ), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+		mh.handle(routes.share, sh.revoke(routes.typ), authn).Methods(http.MethodDelete)
+	}
+}
+
+func (mh *MuxHandler) initializeOrgRoutes() {
+	oh := mh.orgs
+	// before the handlers are bound, they copy the oh.
+	oh.tokenizer = mh.tokenizer
+	authn := requireAuth(mh.log, mh.tokenizer)
+	jsonOnly := requireContentType(mh.log, jsonContentType)
+	can := func(perm pkg.Permission) func(http.Handler) http.Handler {
+		return requirePermission(mh.log, oh.svc, perm)
+	}
+
+	mh.handle(routeOrgs, http.HandlerFunc(oh.list), authn).Methods(http.MethodGet)
+	mh.handle(routeOrgs, http.HandlerFunc(oh.create), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+	mh.handle(routeOrg, http.HandlerFunc(oh.get), authn, can(pkg.PermOrgRead)).Methods(http.MethodGet)
+	mh.handle(routeOrgToken, http.HandlerFunc(oh.token), authn, can(pkg.PermOrgRead)).Methods(http.MethodPost)
+	mh.handle(routeOrgMembers, http.HandlerFunc(oh.listMembers), authn, can(pkg.PermMembersRead)).Methods(http.MethodGet)
+	mh.handle(routeOrgMembers, http.HandlerFunc(oh.addMember), authn, jsonOnly, can(pkg.PermMembersManage), mh.idempotent()).Methods(http.MethodPost)
+	mh.handle(routeOrgMember, http.HandlerFunc(oh.updateMember), authn, jsonOnly, can(pkg.PermMembersManage)).Methods(http.MethodPut)
+	mh.handle(routeOrgMember, http.HandlerFunc(oh.removeMember), authn, can(pkg.PermMembersManage)).Methods(http.MethodDelete)
+}
+
+func (mh *MuxHandler) initializeWebhookRoutes() {
+	wh := mh.webhooks
+	authn := requireAuth(mh.log, mh.tokenizer)
+	jsonOnly := requireContentType(mh.log, jsonContentType)
+
+	mh.handle(routeWebhooks, http.HandlerFunc(wh.list), authn).Methods(http.MethodGet)
+	mh.handle(routeWebhooks, http.HandlerFunc(wh.create), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+	mh.handle(routeWebhook, http.HandlerFunc(wh.get), authn).Methods(http.MethodGet)
+	mh.handle(routeWebhook, http.HandlerFunc(wh.update), authn, jsonOnly).Methods(http.MethodPut)
+	mh.handle(routeWebhook, http.HandlerFunc(wh.delete), authn).Methods(http.MethodDelete)
+	mh.handle(routeWebhookDeliveries, http.HandlerFunc(wh.deliveries), authn).Methods(http.MethodGet)
+}
+
+func (mh *MuxHandler) initializeSyncRoutes() {
+	sh := mh.sync
+	authn := requireAuth(mh.log, mh.tokenizer)
+	jsonOnly := requireContentType(mh.log, jsonContentType)
+
+	mh.handle(routeSync, http.HandlerFunc(sh.sync), authn, jsonOnly, mh.idempotent()).Methods(http.MethodPost)
+}
+
+func (mh *MuxHandler) initializeLiveRoutes() {
+	lh := mh.live
+	lh.todos = mh.todos.svc
+	lh.projects = mh.projects.svc
+	lh.upgrader.CheckOrigin = checkOrigin(mh.cors)
+	authn := requireAuth(mh.log, mh.tokenizer)
+
+	// authenticated during the upgrade, with the Authorization header or the bearer subprotocol
+	mh.handle(routeLive, http.HandlerFunc(lh.serve), bearerFromSubprotocol, authn).Methods(http.MethodGet)
+}
+
+// handle registers the h for the route wrapped with the route level middleware,
+// in order the rate limit, the body size limit and then the mws.
+func (mh *MuxHandler) handle(route string, h http.Handler, mws ...func(http.Handler) http.Handler) *mux.Route {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	h = mh.bodyLimit(route)(h)
+	if policy, ok := mh.rateLimits[route]; ok {
+		h = rateLimit(mh.log, mh.rateLimitStore, route, policy)(h)
+	}
+	return mh.router.Handle(route, h)
+}
+
+// idempotent returns the middleware replaying the responses of the POST routes
+// to the retries carrying the same Idempotency-Key.
+func (mh *MuxHandler) idempotent() func(http.Handler) http.Handler {
+	return idempotent(mh.log, mh.idempotencyStore, mh.idempotencyTTL)
+}
+
+// bodyLimit returns the middleware enforcing the body size limit of the route.
+func (mh *MuxHandler) bodyLimit(route string) func(http.Handler) http.Handler {
+	n, ok := mh.maxBodySize[route]
+	if !ok {
+		n = defaultMaxBodySize
+	}
+	return limitBody(mh.log, n)
 }
 
 // httpReqField is an helper method to build logger filed from an HTTPRequest
@@ -94,13 +564,23 @@ func durationFromReqCtx(r *http.Request) time.Duration {
 	return time.Since(startTime)
 }
 
-func writeInternalServerError(w http.ResponseWriter, l *zap.Logger) {
-	code := http.StatusInternalServerError
-	w.WriteHeader(code)
-	_, err := w.Write(someThingWentWrong)
-	if err != nil {
-		l.Error("writing to the response writer failed", zap.Error(err))
+// writeResponse writes the resp envelope with the status code.
+func writeResponse(w http.ResponseWriter, code int, resp envelope.Response, l *zap.Logger) {
+	err := envelope.Write(w, code, resp)
+	if err == nil {
+		return
 	}
+	// nothing has been written if the response itself failed to encode
+	if errors.Is(err, envelope.ErrEncode) {
+		l.Error("response encoding failed", zap.Error(err))
+		err = envelope.Write(w, http.StatusInternalServerError, envelope.Fail(errSomethingWentWrong))
+	}
+	checkResponseWriteErr(err, l)
+}
+
+// writeData writes the data inside a successful response envelope.
+func writeData(w http.ResponseWriter, code int, data interface{}, l *zap.Logger) {
+	writeResponse(w, code, envelope.Data(data), l)
 }
 
 func checkResponseWriteErr(err error, l *zap.Logger) {
@@ -5,6 +5,7 @@ package resthandler
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"go.uber.org/zap"
@@ -16,29 +17,93 @@ func TestMuxHandler_ServeHTTP(t *testing.T) {
 	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
 	mux := NewMuxHandler(l)
 
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	// bodiless probes from kubelet or curl don't send any content type
+	req := httptest.NewRequest(http.MethodGet, "/health/live", nil)
 	rr := httptest.NewRecorder()
 
 	mux.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected error code %d for missing content type got %d", http.StatusBadRequest, rr.Code)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d for GET without content type got %d", http.StatusOK, rr.Code)
 	}
 
-	req.Header.Add("Content-type", "application/json")
-
-	rr2 := httptest.NewRecorder()
-	mux.ServeHTTP(rr2, req)
+	if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("mime sniff prevention header in response missing")
+	}
 
-	if rr2.Code != http.StatusOK {
-		t.Errorf("expected error code %d for missing content type got %d", http.StatusOK, rr2.Code)
+	if rr.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("content-type header in response is missing")
 	}
+}
 
-	if rr2.Header().Get("X-Content-Type-Options") != "nosniff" {
-		t.Errorf("mime sniff prevention header in response missing")
+func TestMuxHandler_ContentNegotiation(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	mux := NewMuxHandler(l)
+
+	tcs := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		accept      string
+		body        string
+		want        int
+	}{
+		{
+			name:   "accept any",
+			method: http.MethodGet,
+			path:   "/",
+			accept: "*/*",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "not acceptable",
+			method: http.MethodGet,
+			path:   "/",
+			accept: "text/html",
+			want:   http.StatusNotAcceptable,
+		},
+		{
+			name:        "unsupported media type",
+			method:      http.MethodPost,
+			path:        "/v1/users/login",
+			contentType: "text/plain",
+			body:        "email_id=ankur@example.com",
+			want:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "json with charset is supported",
+			method:      http.MethodPost,
+			path:        "/v1/users/login",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"email_id": "invalid", "password": "short"}`,
+			want:        http.StatusPreconditionFailed,
+		},
+		{
+			name:        "form is supported",
+			method:      http.MethodPost,
+			path:        "/v1/users/signup",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "email_id=invalid&password=short",
+			want:        http.StatusPreconditionFailed,
+		},
 	}
 
-	if rr2.Header().Get("Content-Type") != "application/json; charset=utf-8" {
-		t.Errorf("content-type header in response is missing")
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("Accept", tc.accept)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tc.want {
+				t.Errorf("expected status code %d got %d", tc.want, rr.Code)
+			}
+			if rr.Body.Len() == 0 {
+				t.Errorf("expected a response body")
+			}
+		})
 	}
 }
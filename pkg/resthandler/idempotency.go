@@ -0,0 +1,211 @@
+package resthandler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+)
+
+var (
+	errInvalidIdempotencyKey = envelope.NewError(envelope.CodeInvalidRequest, "Invalid Idempotency-Key header.",
+		envelope.FieldError{Field: idempotencyKeyHeader, Code: envelope.CodeInvalidLength, Message: "Should be < 256."})
+	errIdempotencyKeyReused = envelope.NewError(envelope.CodeIdempotencyKeyReused,
+		"Idempotency-Key has already been used with a different request.")
+	errIdempotencyKeyInFlight = envelope.NewError(envelope.CodeIdempotencyKeyInFlight,
+		"A request with the same Idempotency-Key is still being processed, retry later.")
+)
+
+// replayedHeaders are the response headers saved along with the body, the others
+// like the RateLimit ones are about the current request and not the first one.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotent returns a middleware that makes the requests carrying an Idempotency-Key
+// header safe to retry. The first response to a key is saved in the store and replayed
+// on the retries until the ttl expires, instead of running the request again.
+//
+// Keys are scoped by the authenticated user, so it must run after the requireAuth.
+// The keys of the anonymous requests, like the signups, are scoped by the request
+// itself: a response is only replayed to a retry with the same body, the one
+// client that knows it, never to another body sent with the same key.
+// A key reused with a different request is rejected with 422 Unprocessable Entity,
+// and a retry while the first request is still in flight with 409 Conflict.
+// Server errors are not saved, the request can be retried then.
+//
+// Unlike the rate limit it fails closed, a client sending a key relies on it
+// to not create duplicates.
+func idempotent(logger *zap.Logger, store pkg.IdempotencyStorage, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var code int
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				code = http.StatusBadRequest
+				writeError(w, r, code, errInvalidIdempotencyKey, logger)
+				logger.Error("invalid idempotency key", httpReqField(code, r, nil)...)
+				return
+			}
+
+			limit := maxBodySizeFromReqCtx(r)
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil {
+				code = http.StatusBadRequest
+				writeError(w, r, code, errInvalidJSON, logger)
+				logger.Error("err reading body", httpReqField(code, r, err)...)
+				return
+			}
+			r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			// too large to be processed anyway, the decodeBody rejects it.
+			if int64(len(body)) > limit {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			fingerprint := requestFingerprint(r, body)
+			record := pkg.IdempotencyRecord{
+				Scope:       idempotencyScope(r, fingerprint),
+				Key:         key,
+				Fingerprint: fingerprint,
+				ExpiresAt:   time.Now().Add(ttl),
+			}
+			existing, reserved, err := store.Reserve(r.Context(), record)
+			if err != nil {
+				code = http.StatusInternalServerError
+				writeInternalServerError(w, r, logger)
+				logger.Error("err reserving idempotency key", httpReqField(code, r, err)...)
+				return
+			}
+			if !reserved {
+				replay(w, r, existing, record.Fingerprint, logger)
+				return
+			}
+
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				// the request may have been canceled, the key still has to be released.
+				if err := store.Release(context.Background(), record.Scope, record.Key); err != nil {
+					logger.Error("err releasing idempotency key", httpReqField(code, r, err)...)
+				}
+			}()
+
+			cw := &captureWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			code = cw.status()
+			if code >= http.StatusInternalServerError {
+				return
+			}
+
+			record.StatusCode = code
+			record.Header = make(http.Header, len(replayedHeaders))
+			for _, h := range replayedHeaders {
+				if v := w.Header().Get(h); v != "" {
+					record.Header.Set(h, v)
+				}
+			}
+			record.Body = cw.body.Bytes()
+			if err = store.Complete(context.Background(), record); err != nil {
+				logger.Error("err saving idempotent response", httpReqField(code, r, err)...)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// replay writes the saved response of the existing record, if it's one of the same request.
+func replay(w http.ResponseWriter, r *http.Request, existing pkg.IdempotencyRecord, fingerprint string, l *zap.Logger) {
+	var code int
+	switch {
+	case existing.Fingerprint != fingerprint:
+		code = http.StatusUnprocessableEntity
+		writeError(w, r, code, errIdempotencyKeyReused, l)
+		l.Error("idempotency key reused", httpReqField(code, r, nil)...)
+	case !existing.Completed():
+		code = http.StatusConflict
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, code, errIdempotencyKeyInFlight, l)
+		l.Error("idempotency key in flight", httpReqField(code, r, nil)...)
+	default:
+		code = existing.StatusCode
+		for h, v := range existing.Header {
+			w.Header()[h] = v
+		}
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(code)
+		_, err := w.Write(existing.Body)
+		checkResponseWriteErr(err, l)
+		l.Info("idempotent response replayed", httpReqField(code, r, nil)...)
+	}
+}
+
+// idempotencyScope returns the scope of the request keys, which is the
+// authenticated user or, for an anonymous request, its fingerprint.
+func idempotencyScope(r *http.Request, fingerprint string) string {
+	if id := userIDFromReqCtx(r); id != uuid.Nil {
+		return id.String()
+	}
+	return fingerprint
+}
+
+// requestFingerprint identifies the request by its route, body and body format.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+" "+r.URL.Path+"\n"+mediaType(r)+"\n")
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// captureWriter keeps a copy of the response written through it.
+type captureWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// status returns the status code written, a handler that has
+// written nothing responds with 200 OK.
+func (cw *captureWriter) status() int {
+	if cw.code == 0 {
+		return http.StatusOK
+	}
+	return cw.code
+}
@@ -0,0 +1,134 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/memory"
+)
+
+func TestIdempotent_TodoCreate(t *testing.T) {
+	t.Parallel()
+	mh, repo := newTodoTestHandler(t)
+	userID := uuid.New()
+
+	post := func(user uuid.UUID, key, body string) *httptest.ResponseRecorder {
+		req := todoRequest(http.MethodPost, routeTodos, user, body)
+		req.Header.Set(idempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post(userID, "key-1", `{"title":"only once"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d", http.StatusCreated, first.Code)
+	}
+	retry := post(userID, "key-1", `{"title":"only once"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the first response to be replayed got %d %s", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("expected replayed header")
+	}
+	if retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("expected location to be replayed got %s", retry.Header().Get("Location"))
+	}
+	if len(repo.todos) != 1 {
+		t.Errorf("expected a single todo to be created got %d", len(repo.todos))
+	}
+
+	if rr := post(userID, "key-1", `{"title":"something else"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d for reused key got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if rr := post(uuid.New(), "key-1", `{"title":"only once"}`); rr.Code != http.StatusCreated ||
+		rr.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("expected the key of another user to be independent got %d", rr.Code)
+	}
+	if rr := post(userID, "", `{"title":"only once"}`); rr.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("expected request without key to be processed")
+	}
+	if len(repo.todos) != 3 {
+		t.Errorf("expected 3 todos got %d", len(repo.todos))
+	}
+}
+
+func TestIdempotent_InFlightAndServerError(t *testing.T) {
+	t.Parallel()
+	store := memory.NewIdempotencyStore()
+	calls := 0
+	var inner http.Handler
+	handler := idempotent(zap.NewNop(), store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// a retry arriving while the first request is processed
+			rr := httptest.NewRecorder()
+			inner.ServeHTTP(rr, newIdempotentRequest())
+			if rr.Code != http.StatusConflict {
+				t.Errorf("expected status code %d for in flight key got %d", http.StatusConflict, rr.Code)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	inner = handler
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest())
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %d got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	// the server error is not saved, the retry runs again
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest())
+	if rr.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("expected the retry to be processed got %d after %d calls", rr.Code, calls)
+	}
+}
+
+func TestIdempotent_Anonymous(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	handler := idempotent(zap.NewNop(), memory.NewIdempotencyStore(), time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, routeSignUp, strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, "key")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	post(`{"email":"a@example.com"}`)
+	if rr := post(`{"email":"a@example.com"}`); rr.Code != http.StatusCreated || rr.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("expected the retry of the same request replayed got %d %v", rr.Code, rr.Header())
+	}
+	// another client sending the same key
+	if rr := post(`{"email":"b@example.com"}`); rr.Code != http.StatusCreated || rr.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("expected the request of another body processed got %d %v", rr.Code, rr.Header())
+	}
+	if calls != 2 {
+		t.Errorf("expected a response replayed only to the same body got %d calls", calls)
+	}
+}
+
+var idempotentUserID = uuid.New()
+
+func newIdempotentRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, routeTodos, nil)
+	req.Header.Set(idempotencyKeyHeader, "key")
+	return req.WithContext(context.WithValue(req.Context(), contextKeyUserID, idempotentUserID))
+}
@@ -0,0 +1,209 @@
+package resthandler
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errInvalidItem = envelope.NewError(envelope.CodeValidationFailed, "Invalid item.",
+		envelope.FieldError{Field: "text", Code: envelope.CodeInvalidLength, Message: "Text is required and should be < 255."},
+		envelope.FieldError{Field: "position", Code: envelope.CodeInvalidValue, Message: "Should be >= 0."})
+)
+
+// itemForm type Decode the submitted json body of a checklist item.
+// A zero Position puts a new item last, and keeps the one of an updated item.
+type itemForm struct {
+	Text     string `json:"text"`
+	Position int    `json:"position"`
+	Done     bool   `json:"done"`
+}
+
+// itemResponse is the json representation of a checklist item.
+type itemResponse struct {
+	ID       uuid.UUID `json:"id"`
+	Text     string    `json:"text"`
+	Position int       `json:"position"`
+	Done     bool      `json:"done"`
+}
+
+func newItemResponse(item pkg.ItemModel) itemResponse {
+	return itemResponse{
+		ID:       item.ID,
+		Text:     item.Text,
+		Position: item.Position,
+		Done:     item.Done,
+	}
+}
+
+// progressResponse tells how many checklist items of a todo are done.
+type progressResponse struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// responses returns the json representation of the todos,
+// with their progress if the checklist items are enabled.
+func (th todos) responses(r *http.Request, list ...pkg.TodoModel) ([]todoResponse, error) {
+	resp := make([]todoResponse, 0, len(list))
+	ids := make([]uuid.UUID, 0, len(list))
+	for _, todo := range list {
+		resp = append(resp, newTodoResponse(todo))
+		ids = append(ids, todo.ID)
+	}
+	if th.items == nil {
+		return resp, nil
+	}
+
+	progress, err := th.items.Progress(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp {
+		p := progress[resp[i].ID]
+		resp[i].Progress = &progressResponse{Done: p.Done, Total: p.Total}
+	}
+	return resp, nil
+}
+
+// itemResponses returns the json representation of the items of the todo.
+func (th todos) itemResponses(r *http.Request, todoID uuid.UUID) ([]itemResponse, error) {
+	items, err := th.items.List(r.Context(), userIDFromReqCtx(r), todoID)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]itemResponse, 0, len(items))
+	for _, item := range items {
+		resp = append(resp, newItemResponse(item))
+	}
+	return resp, nil
+}
+
+// itemIDsFromReq returns the todo and item ids of the route,
+// ok is false if either is not an uuid.
+func itemIDsFromReq(r *http.Request) (todoID, id uuid.UUID, ok bool) {
+	todoID, ok = todoIDFromReq(r)
+	if !ok {
+		return todoID, id, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["item_id"])
+	return todoID, id, err == nil
+}
+
+func (th todos) listItems(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	resp, err := th.itemResponses(r, id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err List", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("items listed", httpReqField(code, r, nil)...)
+}
+
+// createItem adds an item to the checklist of the todo. Like every item write, it
+// changes the version of the todo, and finishes it if it auto finishes.
+func (th todos) createItem(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form itemForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	todoID, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	item, _, err := th.items.Create(r.Context(), userIDFromReqCtx(r), pkg.ItemModel{
+		TodoID:   todoID,
+		Text:     form.Text,
+		Position: form.Position,
+		Done:     form.Done,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Create", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	w.Header().Set("Location", routeTodos+"/"+todoID.String()+"/items/"+item.ID.String())
+	writeData(w, code, newItemResponse(item), th.logger)
+	th.logger.Info("item created", httpReqField(code, r, nil)...)
+}
+
+func (th todos) updateItem(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form itemForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	todoID, id, ok := itemIDsFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrItemNotFound, th.logger)
+		th.logger.Error("invalid item id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	item, _, err := th.items.Update(r.Context(), userIDFromReqCtx(r), pkg.ItemModel{
+		ID:       id,
+		TodoID:   todoID,
+		Text:     form.Text,
+		Position: form.Position,
+		Done:     form.Done,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Update", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusOK
+	writeData(w, code, newItemResponse(item), th.logger)
+	th.logger.Info("item updated", httpReqField(code, r, nil)...)
+}
+
+func (th todos) deleteItem(w http.ResponseWriter, r *http.Request) {
+	var code int
+	todoID, id, ok := itemIDsFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrItemNotFound, th.logger)
+		th.logger.Error("invalid item id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	_, err := th.items.Delete(r.Context(), userIDFromReqCtx(r), todoID, id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Delete", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	th.logger.Info("item deleted", httpReqField(code, r, nil)...)
+}
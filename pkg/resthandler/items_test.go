@@ -0,0 +1,97 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func newItemTestHandler(t *testing.T) (*MuxHandler, *_mockTodoRepoStorage, *_mockItemRepoStorage) {
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	items := newMockItemRepoStorage(repo)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoService(pkg.NewTodoService(repo)), WithItemService(pkg.NewItemService(pkg.NewTodoService(repo), items)))
+	return mh, repo, items
+}
+
+func TestTodos_Items(t *testing.T) {
+	t.Parallel()
+	mh, repo, _ := newItemTestHandler(t)
+	userID := uuid.New()
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "with steps", AutoFinish: true, Version: 1}
+	repo.todos[todo.ID] = todo
+	target := routeTodos + "/" + todo.ID.String()
+
+	var ids []uuid.UUID
+	for _, body := range []string{`{"text":"first"}`, `{"text":"second"}`} {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodPost, target+"/items", userID, body))
+		var resp struct {
+			Data itemResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusCreated || resp.Data.Position != len(ids)+1 {
+			t.Fatalf("expected item created last got %d %s", rr.Code, rr.Body.String())
+		}
+		ids = append(ids, resp.Data.ID)
+	}
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		userID   uuid.UUID
+		body     string
+		wantCode int
+	}{
+		{name: "other user", method: http.MethodPost, target: target + "/items", userID: uuid.New(), body: `{"text":"intruder"}`, wantCode: http.StatusNotFound},
+		{name: "blank text", method: http.MethodPost, target: target + "/items", userID: userID, body: `{"text":" "}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "unknown item", method: http.MethodPut, target: target + "/items/" + uuid.New().String(), userID: userID, body: `{"text":"ghost"}`, wantCode: http.StatusNotFound},
+		{name: "first done", method: http.MethodPut, target: target + "/items/" + ids[0].String(), userID: userID, body: `{"text":"first","done":true}`, wantCode: http.StatusOK},
+		{name: "second done", method: http.MethodPut, target: target + "/items/" + ids[1].String(), userID: userID, body: `{"text":"second","done":true}`, wantCode: http.StatusOK},
+		{name: "first deleted", method: http.MethodDelete, target: target + "/items/" + ids[0].String(), userID: userID, wantCode: http.StatusNoContent},
+		{name: "unknown include", method: http.MethodGet, target: target + "?include=owner", userID: userID, wantCode: http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, step.userID, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+		if step.name == "first done" && repo.todos[todo.ID].Finished {
+			t.Errorf("expected todo to be unfinished until all its items are done")
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, target+"?include=items", userID, ""))
+	var resp struct {
+		Data todoResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	got := resp.Data
+	if !got.Finished || got.Progress == nil || *got.Progress != (progressResponse{Done: 1, Total: 1}) {
+		t.Errorf("expected auto finished todo with 1/1 items done got %s", rr.Body.String())
+	}
+	if len(got.Items) != 1 || got.Items[0].ID != ids[1] {
+		t.Errorf("expected the second item included got %s", rr.Body.String())
+	}
+	// two inserts, two updates and a delete
+	if tag := rr.Header().Get("ETag"); tag != etag(6) {
+		t.Errorf("expected every item write to change the todo ETag got %s", tag)
+	}
+}
@@ -0,0 +1,564 @@
+package resthandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+const (
+	// liveSubprotocol is the protocol of the messages of the live api.
+	liveSubprotocol = "todo.v1"
+	// the browsers can't set the Authorization header of a WebSocket, their token
+	// is offered as the subprotocol "bearer.<token>" along with the liveSubprotocol.
+	liveBearerPrefix = "bearer."
+	// liveSendBuffer is how many messages are queued to a connection,
+	// a slow consumer whose queue is full is disconnected.
+	liveSendBuffer = 64
+	liveMaxMessage = 16 << 10
+	liveWriteWait  = 10 * time.Second
+	livePongWait   = 60 * time.Second
+	livePingPeriod = livePongWait * 9 / 10
+)
+
+// the types of the messages of the live api.
+const (
+	// sent by the client
+	liveSubscribe   = "subscribe"
+	liveUnsubscribe = "unsubscribe"
+	liveTyping      = "typing"
+	liveCreate      = "create"
+	liveUpdate      = "update"
+	liveDelete      = "delete"
+	// sent by the server, along with the typing
+	liveSubscribed = "subscribed"
+	livePresence   = "presence"
+	liveEvent      = "event"
+	liveAck        = "ack"
+	liveError      = "error"
+)
+
+// defaultLiveRate limits the messages of a single connection.
+var defaultLiveRate = Rate{Limit: 30, Period: 10 * time.Second}
+
+var (
+	errInvalidHandshake = envelope.NewError(envelope.CodeInvalidRequest, "Invalid WebSocket handshake.")
+	errInvalidMessage   = envelope.NewError(envelope.CodeInvalidRequest, "Invalid message.",
+		envelope.FieldError{Field: "type", Code: envelope.CodeInvalidValue,
+			Message: "Should be subscribe, unsubscribe, typing, create, update or delete."})
+	errNotSubscribed = envelope.NewError(envelope.CodeInvalidRequest, "Not subscribed to the project.")
+	errNoVersion     = envelope.NewError(envelope.CodeInvalidRequest, "Invalid message.",
+		envelope.FieldError{Field: "version", Code: envelope.CodeRequired,
+			Message: "Should be the version of the todo updated or deleted."})
+)
+
+// liveRequest type Decode a message of the client, the ref is told back in the reply.
+type liveRequest struct {
+	Type      string    `json:"type"`
+	Ref       string    `json:"ref"`
+	ProjectID uuid.UUID `json:"project_id"`
+	TodoID    uuid.UUID `json:"todo_id"`
+	// Version is the version of the todo updated or deleted, the change of
+	// another client in between isn't overwritten.
+	Version int64 `json:"version"`
+	// Todo is the todo created or updated, a new todo is filed under the
+	// project_id of the message without its own.
+	Todo todoForm `json:"todo"`
+}
+
+// livePresenceMessage tells the users viewing the project, the subscribed one
+// replies to the subscribe of the ref.
+type livePresenceMessage struct {
+	Type      string      `json:"type"`
+	Ref       string      `json:"ref,omitempty"`
+	ProjectID uuid.UUID   `json:"project_id"`
+	Viewers   []uuid.UUID `json:"viewers"`
+}
+
+// liveTypingMessage tells the user is typing in the project, or in a todo of it.
+type liveTypingMessage struct {
+	Type      string     `json:"type"`
+	ProjectID uuid.UUID  `json:"project_id"`
+	TodoID    *uuid.UUID `json:"todo_id,omitempty"`
+	UserID    uuid.UUID  `json:"user_id"`
+}
+
+// liveEventMessage is an event of a todo of the project, the data of a
+// stream.reset tells the client it missed events.
+type liveEventMessage struct {
+	Type      string          `json:"type"`
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	ProjectID *uuid.UUID      `json:"project_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// liveReply is the outcome of a message of the client.
+type liveReply struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+	// Todo is the todo created or updated
+	Todo  *todoResponse   `json:"todo,omitempty"`
+	Error *envelope.Error `json:"error,omitempty"`
+}
+
+// live serves the WebSocket api of the projects: the todo changes of the subscribed
+// projects, the presence of their viewers and who's typing, along with the mutations
+// of the todos. The todo changes are read from the stream, so every replica tells
+// them, while the presence and typing are only told within a replica.
+type live struct {
+	todos    pkg.TodoService
+	projects pkg.ProjectService
+	stream   *pkg.TodoStream
+	// rate limits the messages of every connection
+	rate     Rate
+	hub      *liveHub
+	upgrader websocket.Upgrader
+	logger   *zap.Logger
+}
+
+func newLive(logger *zap.Logger, stream *pkg.TodoStream, rate Rate) *live {
+	lh := &live{stream: stream, rate: rate, hub: newLiveHub(), logger: logger}
+	lh.upgrader = websocket.Upgrader{
+		Subprotocols: []string{liveSubprotocol},
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			writeError(w, r, status, errInvalidHandshake, logger)
+		},
+	}
+	return lh
+}
+
+// checkOrigin allows the requests without Origin, which aren't sent by a browser,
+// the ones of the same origin and the ones allowed by the cors, if not nil.
+func checkOrigin(cors *CORSConfig) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if cors != nil && cors.allowOrigin(origin) != "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// bearerFromSubprotocol returns a middleware that sets the Authorization header
+// from the bearer subprotocol of the WebSocket handshake, if it has none.
+func bearerFromSubprotocol(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			for _, protocol := range websocket.Subprotocols(r) {
+				if strings.HasPrefix(protocol, liveBearerPrefix) {
+					r.Header.Set("Authorization", "Bearer "+protocol[len(liveBearerPrefix):])
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serve upgrades the request of the authenticated user to a WebSocket, and
+// serves it until either side closes it.
+func (lh *live) serve(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromReqCtx(r)
+	sub, err := lh.stream.Subscribe(r.Context(), userID, 0)
+	if err != nil {
+		code := http.StatusInternalServerError
+		writeInternalServerError(w, r, lh.logger)
+		lh.logger.Error("err subscribing to the todo stream", httpReqField(code, r, err)...)
+		return
+	}
+	ws, err := lh.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied already
+		lh.logger.Error("err upgrading to websocket", httpReqField(http.StatusBadRequest, r, err)...)
+		return
+	}
+
+	c := newLiveConn(ws, userID)
+	// the request context isn't canceled once the connection is hijacked.
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.writeLoop()
+	go lh.relay(ctx, c, sub)
+	lh.readLoop(ctx, c)
+	cancel()
+	c.close(websocket.CloseNormalClosure, "")
+	lh.hub.leaveAll(c)
+	lh.logger.Info("live connection closed", httpReqField(http.StatusSwitchingProtocols, r, nil)...)
+}
+
+// readLoop handles the messages of the client, until the connection fails.
+func (lh *live) readLoop(ctx context.Context, c *liveConn) {
+	c.ws.SetReadLimit(liveMaxMessage)
+	_ = c.ws.SetReadDeadline(time.Now().Add(livePongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+	limiter := NewMemoryRateLimitStore()
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req liveRequest
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&req)
+		if res, err := limiter.Take(ctx, "conn", lh.rate); err != nil || !res.Allowed {
+			lh.fail(c, req.Ref, errTooManyRequests)
+			continue
+		}
+		if err != nil {
+			lh.fail(c, req.Ref, errInvalidMessage)
+			continue
+		}
+		lh.handle(ctx, c, req)
+	}
+}
+
+// handle handles the message of the client.
+func (lh *live) handle(ctx context.Context, c *liveConn, req liveRequest) {
+	switch req.Type {
+	case liveSubscribe:
+		if _, err := lh.projects.Find(ctx, c.userID, req.ProjectID); err != nil {
+			lh.failErr(c, req.Ref, err)
+			return
+		}
+		c.subscribe(req.ProjectID, true)
+		viewers := lh.hub.join(req.ProjectID, c)
+		lh.send(c, livePresenceMessage{Type: liveSubscribed, Ref: req.Ref, ProjectID: req.ProjectID, Viewers: viewers})
+	case liveUnsubscribe:
+		c.subscribe(req.ProjectID, false)
+		lh.hub.leave(req.ProjectID, c)
+		lh.ack(c, req.Ref, nil)
+	case liveTyping:
+		if !c.subscribed(req.ProjectID) {
+			lh.fail(c, req.Ref, errNotSubscribed)
+			return
+		}
+		msg := liveTypingMessage{Type: liveTyping, ProjectID: req.ProjectID, UserID: c.userID}
+		if req.TodoID != uuid.Nil {
+			msg.TodoID = &req.TodoID
+		}
+		lh.hub.broadcast(req.ProjectID, msg, c, true)
+	case liveCreate, liveUpdate, liveDelete:
+		if req.Type != liveCreate && req.Version <= 0 {
+			lh.fail(c, req.Ref, errNoVersion)
+			return
+		}
+		todo, err := lh.mutate(ctx, c.userID, req)
+		if err != nil {
+			lh.failErr(c, req.Ref, err)
+			return
+		}
+		var resp *todoResponse
+		if req.Type != liveDelete {
+			r := newTodoResponse(todo)
+			resp = &r
+		}
+		lh.ack(c, req.Ref, resp)
+	default:
+		lh.fail(c, req.Ref, errInvalidMessage)
+	}
+}
+
+// mutate applies the create, update or delete of the todo through the todo service.
+func (lh *live) mutate(ctx context.Context, userID uuid.UUID, req liveRequest) (pkg.TodoModel, error) {
+	form := req.Todo
+	switch req.Type {
+	case liveCreate:
+		if form.ProjectID == uuid.Nil {
+			form.ProjectID = req.ProjectID
+		}
+		return lh.todos.Create(ctx, userID, pkg.TodoModel{
+			Title:      form.Title,
+			Content:    form.Content,
+			Finished:   form.Finished,
+			AutoFinish: form.AutoFinish,
+			ProjectID:  form.ProjectID,
+			DueAt:      form.dueAt(),
+		})
+	case liveUpdate:
+		todo, err := lh.todos.Find(ctx, userID, req.TodoID)
+		if err != nil {
+			return todo, err
+		}
+		todo.Title = form.Title
+		todo.Content = form.Content
+		todo.Finished = form.Finished
+		todo.AutoFinish = form.AutoFinish
+		todo.DueAt = form.dueAt()
+		if form.ProjectID != uuid.Nil {
+			todo.ProjectID = form.ProjectID
+		}
+		todo.Version = req.Version
+		return lh.todos.Update(ctx, userID, todo)
+	default:
+		return pkg.TodoModel{ID: req.TodoID}, lh.todos.Delete(ctx, userID, req.TodoID, req.Version)
+	}
+}
+
+// relay sends the events of the todos of the projects the client subscribed to,
+// until the ctx is done.
+func (lh *live) relay(ctx context.Context, c *liveConn, sub *pkg.TodoSubscription) {
+	for {
+		events, err := sub.Next(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, pkg.ErrEventGone) {
+			// the events missed can't be told, only that they were.
+			if sub, err = lh.stream.Subscribe(ctx, c.userID, 0); err == nil {
+				lh.send(c, liveEventMessage{Type: liveEvent, ID: sub.LastEventID(), Event: eventStreamReset,
+					Data: json.RawMessage("{}")})
+				continue
+			}
+		}
+		if err != nil {
+			lh.logger.Error("err reading the todo stream", zap.Error(err))
+			c.close(websocket.CloseInternalServerErr, "todo stream failed")
+			return
+		}
+		for _, e := range events {
+			var todo struct {
+				ProjectID uuid.UUID `json:"project_id"`
+			}
+			if err = json.Unmarshal(e.Data, &todo); err != nil || !c.subscribed(todo.ProjectID) {
+				continue
+			}
+			lh.send(c, liveEventMessage{Type: liveEvent, ID: e.ID, Event: e.Type, ProjectID: &todo.ProjectID,
+				Data: e.Data})
+		}
+	}
+}
+
+// send queues the msg to the client, and disconnects it if it's too slow to take it.
+func (lh *live) send(c *liveConn, msg interface{}) {
+	if !c.enqueue(msg) {
+		c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// ack replies to the message of the ref that it's done, with the todo if not nil.
+func (lh *live) ack(c *liveConn, ref string, todo *todoResponse) {
+	lh.send(c, liveReply{Type: liveAck, Ref: ref, Todo: todo})
+}
+
+// fail replies to the message of the ref with the apiErr.
+func (lh *live) fail(c *liveConn, ref string, apiErr envelope.Error) {
+	lh.send(c, liveReply{Type: liveError, Ref: ref, Error: &apiErr})
+}
+
+// failErr replies to the message of the ref with the api error the err is mapped to.
+func (lh *live) failErr(c *liveConn, ref string, err error) {
+	status, apiErr := domainErrorFor(err)
+	if status == http.StatusInternalServerError {
+		lh.logger.Error("err handling live message", zap.String("ref", ref), zap.Error(err))
+	}
+	lh.fail(c, ref, apiErr)
+}
+
+// liveConn is a WebSocket connection of the live api. Only its writeLoop
+// writes the messages, the others queue them.
+type liveConn struct {
+	ws     *websocket.Conn
+	userID uuid.UUID
+	send   chan interface{}
+	// done is closed once the connection is closed.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// projects are the projects the client subscribed to.
+	projects map[uuid.UUID]bool
+}
+
+func newLiveConn(ws *websocket.Conn, userID uuid.UUID) *liveConn {
+	return &liveConn{
+		ws:       ws,
+		userID:   userID,
+		send:     make(chan interface{}, liveSendBuffer),
+		done:     make(chan struct{}),
+		projects: make(map[uuid.UUID]bool),
+	}
+}
+
+// enqueue queues the msg, it returns false if the queue is full.
+func (c *liveConn) enqueue(msg interface{}) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the connection with the code, once.
+func (c *liveConn) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text),
+			time.Now().Add(time.Second))
+		_ = c.ws.Close()
+	})
+}
+
+func (c *liveConn) subscribe(projectID uuid.UUID, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if on {
+		c.projects[projectID] = true
+	} else {
+		delete(c.projects, projectID)
+	}
+}
+
+func (c *liveConn) subscribed(projectID uuid.UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.projects[projectID]
+}
+
+// writeLoop writes the messages queued and pings the client, until the connection is closed.
+func (c *liveConn) writeLoop() {
+	ticker := time.NewTicker(livePingPeriod)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			err = c.ws.WriteJSON(msg)
+		case <-ticker.C:
+			err = c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait))
+		}
+		if err != nil {
+			c.close(websocket.CloseAbnormalClosure, "")
+			return
+		}
+	}
+}
+
+// liveHub tells the connections of a project who's viewing it and typing.
+type liveHub struct {
+	mu    sync.Mutex
+	rooms map[uuid.UUID]map[*liveConn]bool
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{rooms: make(map[uuid.UUID]map[*liveConn]bool)}
+}
+
+// join adds the connection to the room of the project, tells the others and
+// returns the viewers.
+func (h *liveHub) join(projectID uuid.UUID, c *liveConn) []uuid.UUID {
+	h.mu.Lock()
+	room := h.rooms[projectID]
+	if room == nil {
+		room = make(map[*liveConn]bool)
+		h.rooms[projectID] = room
+	}
+	room[c] = true
+	viewers := h.viewers(room)
+	slow := h.tell(room, livePresenceMessage{Type: livePresence, ProjectID: projectID, Viewers: viewers}, c, false)
+	h.mu.Unlock()
+	closeSlow(slow)
+	return viewers
+}
+
+// leave removes the connection from the room of the project, and tells the others.
+func (h *liveHub) leave(projectID uuid.UUID, c *liveConn) {
+	h.mu.Lock()
+	room := h.rooms[projectID]
+	if !room[c] {
+		h.mu.Unlock()
+		return
+	}
+	delete(room, c)
+	var slow []*liveConn
+	if len(room) == 0 {
+		delete(h.rooms, projectID)
+	} else {
+		slow = h.tell(room, livePresenceMessage{Type: livePresence, ProjectID: projectID, Viewers: h.viewers(room)},
+			nil, false)
+	}
+	h.mu.Unlock()
+	closeSlow(slow)
+}
+
+// leaveAll removes the connection from all the rooms.
+func (h *liveHub) leaveAll(c *liveConn) {
+	c.mu.Lock()
+	projects := make([]uuid.UUID, 0, len(c.projects))
+	for projectID := range c.projects {
+		projects = append(projects, projectID)
+	}
+	c.mu.Unlock()
+	for _, projectID := range projects {
+		h.leave(projectID, c)
+	}
+}
+
+// broadcast tells the msg to the connections of the project but the from. A droppable
+// msg is dropped for the slow consumers, which are disconnected otherwise.
+func (h *liveHub) broadcast(projectID uuid.UUID, msg interface{}, from *liveConn, droppable bool) {
+	h.mu.Lock()
+	slow := h.tell(h.rooms[projectID], msg, from, droppable)
+	h.mu.Unlock()
+	closeSlow(slow)
+}
+
+// tell queues the msg to the connections of the room but the from, and returns
+// the slow ones unless the msg is droppable. h.mu must be held.
+func (h *liveHub) tell(room map[*liveConn]bool, msg interface{}, from *liveConn, droppable bool) []*liveConn {
+	var slow []*liveConn
+	for c := range room {
+		if c != from && !c.enqueue(msg) && !droppable {
+			slow = append(slow, c)
+		}
+	}
+	return slow
+}
+
+// viewers returns the users of the connections of the room, each once. h.mu must be held.
+func (h *liveHub) viewers(room map[*liveConn]bool) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(room))
+	viewers := make([]uuid.UUID, 0, len(room))
+	for c := range room {
+		if !seen[c.userID] {
+			seen[c.userID] = true
+			viewers = append(viewers, c.userID)
+		}
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].String() < viewers[j].String()
+	})
+	return viewers
+}
+
+func closeSlow(slow []*liveConn) {
+	for _, c := range slow {
+		c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
@@ -0,0 +1,175 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// liveMessage is any message of the live api, as read by the client.
+type liveMessage struct {
+	Type      string      `json:"type"`
+	Ref       string      `json:"ref"`
+	ProjectID uuid.UUID   `json:"project_id"`
+	UserID    uuid.UUID   `json:"user_id"`
+	Viewers   []uuid.UUID `json:"viewers"`
+	ID        int64       `json:"id"`
+	Event     string      `json:"event"`
+	Todo      struct {
+		ID      uuid.UUID `json:"id"`
+		Title   string    `json:"title"`
+		Version int64     `json:"version"`
+	} `json:"todo"`
+	Error struct {
+		Code string `json:"code"`
+	} `json:"error"`
+}
+
+func readLive(t *testing.T, ws *websocket.Conn) liveMessage {
+	t.Helper()
+	var msg liveMessage
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatalf("expected a message got %v", err)
+	}
+	return msg
+}
+
+func TestLive(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	projects := newMockProjectRepoStorage(repo)
+	grants := &_mockGrantRepoStorage{}
+	auth := pkg.NewAuthorizer(grants)
+	ownerID, editorID, strangerID := uuid.New(), uuid.New(), uuid.New()
+	project, other := projects.inbox(ownerID), projects.inbox(editorID)
+	_ = grants.UpsertOne(context.Background(), pkg.GrantModel{Resource: pkg.Resource{Type: pkg.ResourceProject,
+		ID: project.ID}, UserID: editorID, Role: pkg.RoleEditor, GrantedBy: ownerID})
+
+	elog := newMockEventLog()
+	stream := pkg.NewTodoStream(elog, auth)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.Run(ctx, time.Millisecond, nil)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoService(pkg.NewSharedTodoService(repo, projects, auth)),
+		WithProjectService(pkg.NewSharedProjectService(projects, auth)),
+		WithLive(stream, Rate{Limit: 10, Period: time.Minute}))
+	srv := httptest.NewServer(mh)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + routeLive
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an unauthenticated upgrade rejected got %v", err)
+	}
+	owner, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + ownerID.String()}})
+	if err != nil {
+		t.Fatalf("expected a nil error for dial got %v", err)
+	}
+	defer owner.Close()
+	// the browsers offer their token as a subprotocol
+	dialer := websocket.Dialer{Subprotocols: []string{liveSubprotocol, liveBearerPrefix + editorID.String()}}
+	editor, resp, err := dialer.Dial(url, nil)
+	if err != nil || resp.Header.Get("Sec-WebSocket-Protocol") != liveSubprotocol {
+		t.Fatalf("expected the %s subprotocol got %v", liveSubprotocol, err)
+	}
+
+	_ = owner.WriteJSON(liveRequest{Type: liveSubscribe, Ref: "1", ProjectID: project.ID})
+	if msg := readLive(t, owner); msg.Type != liveSubscribed || msg.Ref != "1" || len(msg.Viewers) != 1 {
+		t.Errorf("expected subscribed alone got %+v", msg)
+	}
+	_ = editor.WriteJSON(liveRequest{Type: liveSubscribe, Ref: "1", ProjectID: other.ID})
+	_ = editor.WriteJSON(liveRequest{Type: liveSubscribe, Ref: "2", ProjectID: project.ID})
+	readLive(t, editor)
+	if msg := readLive(t, editor); msg.Type != liveSubscribed || len(msg.Viewers) != 2 {
+		t.Errorf("expected subscribed along the owner got %+v", msg)
+	}
+	if msg := readLive(t, owner); msg.Type != livePresence || len(msg.Viewers) != 2 {
+		t.Errorf("expected the editor viewing got %+v", msg)
+	}
+
+	_ = editor.WriteJSON(liveRequest{Type: liveTyping, ProjectID: project.ID})
+	if msg := readLive(t, owner); msg.Type != liveTyping || msg.UserID != editorID {
+		t.Errorf("expected the editor typing got %+v", msg)
+	}
+	_ = editor.WriteJSON(liveRequest{Type: "shout", Ref: "3"})
+	if msg := readLive(t, editor); msg.Type != liveError || msg.Ref != "3" || msg.Error.Code != "invalid_request" {
+		t.Errorf("expected an invalid message got %+v", msg)
+	}
+
+	_ = editor.WriteJSON(liveRequest{Type: liveCreate, Ref: "4", ProjectID: project.ID, Todo: todoForm{Title: "live"}})
+	created := readLive(t, editor)
+	if created.Type != liveAck || created.Ref != "4" || created.Todo.Title != "live" {
+		t.Fatalf("expected the todo created got %+v", created)
+	}
+	// the storage logs the events of the changes, of the project or not.
+	elog.log(pkg.Event{Type: pkg.EventTodoCreated, UserID: ownerID, SubjectID: uuid.New(),
+		Data: []byte(`{"id":"` + uuid.New().String() + `","project_id":"` + other.ID.String() + `"}`)})
+	elog.log(pkg.Event{Type: pkg.EventTodoCreated, UserID: ownerID, SubjectID: created.Todo.ID,
+		Data: []byte(`{"id":"` + created.Todo.ID.String() + `","project_id":"` + project.ID.String() + `"}`)})
+	if msg := readLive(t, owner); msg.Type != liveEvent || msg.ID != 2 || msg.Event != pkg.EventTodoCreated ||
+		msg.ProjectID != project.ID {
+		t.Errorf("expected the event of the todo created got %+v", msg)
+	}
+
+	// the changes of another client aren't overwritten
+	reply := func() liveMessage {
+		t.Helper()
+		msg := readLive(t, editor)
+		for msg.Type == liveEvent {
+			msg = readLive(t, editor)
+		}
+		return msg
+	}
+	update := liveRequest{Type: liveUpdate, Ref: "5", TodoID: created.Todo.ID, Todo: todoForm{Title: "edited"}}
+	_ = editor.WriteJSON(update)
+	if msg := reply(); msg.Type != liveError || msg.Ref != "5" || msg.Error.Code != "invalid_request" {
+		t.Errorf("expected an update without version rejected got %+v", msg)
+	}
+	update.Version = created.Todo.Version
+	_ = editor.WriteJSON(update)
+	if msg := reply(); msg.Type != liveAck || msg.Todo.Title != "edited" || msg.Todo.Version != update.Version+1 {
+		t.Errorf("expected the todo updated got %+v", msg)
+	}
+	_ = editor.WriteJSON(liveRequest{Type: liveDelete, Ref: "6", TodoID: created.Todo.ID, Version: created.Todo.Version})
+	if msg := reply(); msg.Type != liveError || msg.Error.Code != "precondition_failed" {
+		t.Errorf("expected a delete of a stale version rejected got %+v", msg)
+	}
+
+	stranger, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + strangerID.String()}})
+	if err != nil {
+		t.Fatalf("expected a nil error for dial got %v", err)
+	}
+	defer stranger.Close()
+	_ = stranger.WriteJSON(liveRequest{Type: liveSubscribe, ProjectID: project.ID})
+	if msg := readLive(t, stranger); msg.Type != liveError || msg.Error.Code != "project_not_found" {
+		t.Errorf("expected the project not found got %+v", msg)
+	}
+	for i := 0; i < 10; i++ {
+		_ = stranger.WriteJSON(liveRequest{Type: liveTyping, Ref: "typing", ProjectID: project.ID})
+	}
+	for i := 0; i < 9; i++ {
+		readLive(t, stranger)
+	}
+	if msg := readLive(t, stranger); msg.Error.Code != "too_many_requests" {
+		t.Errorf("expected the connection rate limited got %+v", msg)
+	}
+
+	editor.Close()
+	if msg := readLive(t, owner); msg.Type != livePresence || len(msg.Viewers) != 1 {
+		t.Errorf("expected the editor gone got %+v", msg)
+	}
+}
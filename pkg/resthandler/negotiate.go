@@ -0,0 +1,165 @@
+package resthandler
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+var (
+	errNotAcceptable = envelope.NewError(envelope.CodeNotAcceptable,
+		"Response can only be application/json or application/problem+json.")
+	errUnsupportedMediaType = envelope.NewError(envelope.CodeUnsupportedMediaType,
+		"Unsupported Content-Type of the request body.")
+)
+
+// acceptable reports if the client accepts any of the response format.
+func acceptable(r *http.Request) bool {
+	return negotiate(r.Header.Get("Accept"), jsonContentType, envelope.ProblemContentType) != ""
+}
+
+// requireAcceptable returns a middleware that rejects upfront with 406 Not Acceptable
+// the client that can't accept any of the response format, as every response is json
+// but the ones of the eventStreams routes, left to negotiate on their own.
+func requireAcceptable(logger *zap.Logger, eventStreams ...string) func(http.Handler) http.Handler {
+	streams := make(map[string]bool, len(eventStreams))
+	for _, route := range eventStreams {
+		streams[route] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streams[r.URL.Path] || acceptable(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			code := http.StatusNotAcceptable
+			writeError(w, r, code, errNotAcceptable, logger)
+			logger.Error("not acceptable", httpReqField(code, r, nil)...)
+		})
+	}
+}
+
+// hasBody reports if the method of the r is expected to carry a request body.
+func hasBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// mediaType returns the media type of the request body without its parameters.
+func mediaType(r *http.Request) string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// requireContentType returns a middleware that rejects the requests carrying
+// a body in any other format than the mediaTypes with 415 Unsupported Media Type.
+// Requests without a body like GET are passed through as is.
+func requireContentType(logger *zap.Logger, mediaTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasBody(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mt := mediaType(r)
+			for _, allowed := range mediaTypes {
+				if mt == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			code := http.StatusUnsupportedMediaType
+			writeError(w, r, code, errUnsupportedMediaType, logger)
+			logger.Error("unsupported media type", httpReqField(code, r, nil)...)
+		})
+	}
+}
+
+// mediaRange is a single media range of an Accept header.
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// parseAccept parses the Accept header value into its media ranges.
+// Malformed ranges are skipped.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		slash := strings.Index(mt, "/")
+		if slash <= 0 || slash == len(mt)-1 {
+			continue
+		}
+		mr := mediaRange{typ: mt[:slash], subtype: mt[slash+1:], q: 1}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 || strings.ToLower(kv[0]) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(kv[1], 64)
+			if err == nil && q >= 0 && q <= 1 {
+				mr.q = q
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// quality returns the q value the most specific range matching the
+// media type is given. It returns -1 if no range matches.
+func quality(ranges []mediaRange, mediaType string) float64 {
+	slash := strings.Index(mediaType, "/")
+	typ, subtype := mediaType[:slash], mediaType[slash+1:]
+	q, specificity := -1.0, -1
+	for _, mr := range ranges {
+		var s int
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			s = 2
+		case mr.typ == typ && mr.subtype == "*":
+			s = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+// negotiate returns the offer the Accept header prefers the most,
+// ties are resolved in the order of the offers. A missing Accept
+// header accepts the first offer. It returns an empty string if none
+// of the offers is acceptable.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,32 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+	offers := []string{"application/json", "application/problem+json"}
+	tcs := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "missing accept header", accept: "", want: "application/json"},
+		{name: "any", accept: "*/*", want: "application/json"},
+		{name: "exact problem", accept: "application/problem+json", want: "application/problem+json"},
+		{name: "type wildcard", accept: "application/*", want: "application/json"},
+		{name: "q values", accept: "application/json;q=0.5, application/problem+json", want: "application/problem+json"},
+		{name: "specific range wins over wildcard", accept: "*/*;q=0.1, application/json;q=0", want: "application/problem+json"},
+		{name: "not acceptable", accept: "text/html", want: ""},
+		{name: "malformed range", accept: "json, application/json", want: "application/json"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := negotiate(tc.accept, offers...); got != tc.want {
+				t.Errorf("expected %q for Accept %q got %q", tc.want, tc.accept, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package resthandler
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/authstrategy"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errOrgNotFound    = envelope.NewError(envelope.CodeOrgNotFound, "Organization not found.")
+	errMemberNotFound = envelope.NewError(envelope.CodeMemberNotFound, "Member not found.")
+	errLastOrgOwner   = envelope.NewError(envelope.CodeLastOrgOwner, "The organization can't be left without an owner.")
+	errInvalidOrg     = envelope.NewError(envelope.CodeValidationFailed, "Invalid organization.",
+		envelope.FieldError{Field: "name", Code: envelope.CodeInvalidLength, Message: "Name is required and should be < 100."})
+	errInvalidMembership = envelope.NewError(envelope.CodeValidationFailed, "Invalid member.",
+		envelope.FieldError{Field: "role", Code: envelope.CodeInvalidValue, Message: "Should be guest, member, admin or owner."})
+)
+
+// orgs encapsulates various types of handlerFunc
+// that responds to the organization api request of the authenticated user
+type orgs struct {
+	svc       pkg.OrgService
+	tokenizer Tokenizer
+	logger    *zap.Logger
+}
+
+// orgForm type Decode the submitted json body of an organization.
+type orgForm struct {
+	Name string `json:"name"`
+}
+
+// orgResponse is the json representation of an organization.
+type orgResponse struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// memberForm type Decode the submitted json body of a member,
+// the email is only read when the member is added.
+type memberForm struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// memberResponse is the json representation of a member.
+type memberResponse struct {
+	UserID uuid.UUID `json:"user_id"`
+	Role   string    `json:"role"`
+}
+
+func newMemberResponse(member pkg.MembershipModel) memberResponse {
+	return memberResponse{UserID: member.UserID, Role: member.Role.String()}
+}
+
+// orgIDFromReq returns the organization id of the route, ok is false if it's not an uuid.
+func orgIDFromReq(r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	return id, err == nil
+}
+
+// requirePermission returns a middleware that only lets through the requests
+// of the users whose role in the organization of the route has the permission,
+// as the policy of the svc decides.
+func requirePermission(logger *zap.Logger, svc pkg.OrgService, perm pkg.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var code int
+			id, ok := orgIDFromReq(r)
+			if !ok {
+				code = writeDomainError(w, r, serror.ErrOrgNotFound, logger)
+				logger.Error("invalid org id", httpReqField(code, r, nil)...)
+				return
+			}
+			if err := svc.Authorize(r.Context(), userIDFromReqCtx(r), id, perm); err != nil {
+				code = writeDomainError(w, r, err, logger)
+				logger.Error("permission denied", append(httpReqField(code, r, err), zap.String("permission", string(perm)))...)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (oh orgs) list(w http.ResponseWriter, r *http.Request) {
+	var code int
+	list, err := oh.svc.List(r.Context(), userIDFromReqCtx(r))
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err List", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := make([]orgResponse, 0, len(list))
+	for _, org := range list {
+		resp = append(resp, orgResponse{ID: org.ID, Name: org.Name})
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, oh.logger)
+	oh.logger.Info("orgs listed", httpReqField(code, r, nil)...)
+}
+
+func (oh orgs) create(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form orgForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, oh.logger)
+		oh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	org, err := oh.svc.Create(r.Context(), userIDFromReqCtx(r), pkg.OrgModel{Name: form.Name})
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err Create", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	w.Header().Set("Location", routeOrgs+"/"+org.ID.String())
+	writeData(w, code, orgResponse{ID: org.ID, Name: org.Name}, oh.logger)
+	oh.logger.Info("org created", httpReqField(code, r, nil)...)
+}
+
+func (oh orgs) get(w http.ResponseWriter, r *http.Request) {
+	var code int
+	// the route is authorized by requirePermission
+	id, _ := orgIDFromReq(r)
+	org, err := oh.svc.Find(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err Find", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, orgResponse{ID: org.ID, Name: org.Name}, oh.logger)
+	oh.logger.Info("org found", httpReqField(code, r, nil)...)
+}
+
+// token returns a token of the user whose active organization is the one of
+// the route, the projects api of the token works with the projects of the organization.
+func (oh orgs) token(w http.ResponseWriter, r *http.Request) {
+	var code int
+	// the route is authorized by requirePermission
+	id, _ := orgIDFromReq(r)
+	token, err := oh.tokenizer.GenerateClaims(authstrategy.Claims{UserID: userIDFromReqCtx(r).String(), OrgID: id.String()})
+	if err != nil {
+		code = http.StatusInternalServerError
+		writeInternalServerError(w, r, oh.logger)
+		oh.logger.Error("err generating token", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	writeData(w, code, tokenResponse{Message: "Switched to the organization", Token: token}, oh.logger)
+	oh.logger.Info("org switched", httpReqField(code, r, nil)...)
+}
+
+func (oh orgs) listMembers(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, _ := orgIDFromReq(r)
+	members, err := oh.svc.Members(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err Members", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := make([]memberResponse, 0, len(members))
+	for _, member := range members {
+		resp = append(resp, newMemberResponse(member))
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, oh.logger)
+	oh.logger.Info("members listed", httpReqField(code, r, nil)...)
+}
+
+func (oh orgs) addMember(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form memberForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, oh.logger)
+		oh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	id, _ := orgIDFromReq(r)
+
+	// an unknown role is rejected by the service
+	role, _ := pkg.ParseOrgRole(form.Role)
+	member, err := oh.svc.AddMember(r.Context(), userIDFromReqCtx(r), id, form.Email, role)
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err AddMember", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	w.Header().Set("Location", r.URL.Path+"/"+member.UserID.String())
+	writeData(w, code, newMemberResponse(member), oh.logger)
+	oh.logger.Info("member added", httpReqField(code, r, nil)...)
+}
+
+func (oh orgs) updateMember(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form memberForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, oh.logger)
+		oh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	id, _ := orgIDFromReq(r)
+	memberID, err := uuid.Parse(mux.Vars(r)["user_id"])
+	if err != nil {
+		code = writeDomainError(w, r, serror.ErrMemberNotFound, oh.logger)
+		oh.logger.Error("invalid user id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	role, _ := pkg.ParseOrgRole(form.Role)
+	member, err := oh.svc.UpdateMember(r.Context(), userIDFromReqCtx(r), pkg.MembershipModel{OrgID: id, UserID: memberID, Role: role})
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err UpdateMember", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newMemberResponse(member), oh.logger)
+	oh.logger.Info("member updated", httpReqField(code, r, nil)...)
+}
+
+func (oh orgs) removeMember(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, _ := orgIDFromReq(r)
+	memberID, err := uuid.Parse(mux.Vars(r)["user_id"])
+	if err != nil {
+		code = writeDomainError(w, r, serror.ErrMemberNotFound, oh.logger)
+		oh.logger.Error("invalid user id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	err = oh.svc.RemoveMember(r.Context(), userIDFromReqCtx(r), id, memberID)
+	if err != nil {
+		code = writeDomainError(w, r, err, oh.logger)
+		oh.logger.Error("err RemoveMember", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	oh.logger.Info("member removed", httpReqField(code, r, nil)...)
+}
@@ -0,0 +1,151 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// orgRequest is the todoRequest of a bearer token carrying an active organization.
+func orgRequest(method, target, token, body string) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if body != "" {
+		r.Header.Set("Content-Type", jsonContentType)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestOrgs(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	projects := newMockProjectRepoStorage(repo)
+	orgRepo := newMockOrgRepoStorage(projects)
+	ownerID, inviteeID := uuid.New(), uuid.New()
+	users := &_mockUserRepoStorage{returnFunc: func() pkg.UserModel {
+		return pkg.UserModel{ID: inviteeID, Email: "invitee@example.com"}
+	}}
+	auth := pkg.NewOrgAuthorizer(nil, orgRepo)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(users), userTokenizer{}),
+		WithTodoService(pkg.NewSharedTodoService(repo, projects, auth)),
+		WithProjectService(pkg.NewSharedProjectService(projects, auth)),
+		WithOrgService(pkg.NewOrgService(orgRepo, users)))
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, orgRequest(http.MethodPost, routeOrgs, ownerID.String(), `{"name":"acme"}`))
+	var created struct {
+		Data orgResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || created.Data.Name != "acme" {
+		t.Fatalf("expected the org created got %d %s", rr.Code, rr.Body.String())
+	}
+	orgID := created.Data.ID
+	target := routeOrgs + "/" + orgID.String()
+	members := target + "/members"
+	ownerToken, inviteeToken := ownerID.String()+":"+orgID.String(), inviteeID.String()+":"+orgID.String()
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{name: "outsider reads", method: http.MethodGet, target: target, token: inviteeID.String(), wantCode: http.StatusNotFound},
+		{name: "invalid role", method: http.MethodPost, target: members, token: ownerID.String(), body: `{"email":"invitee@example.com","role":"viewer"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "add guest", method: http.MethodPost, target: members, token: ownerID.String(), body: `{"email":"invitee@example.com","role":"guest"}`, wantCode: http.StatusCreated},
+		{name: "guest reads", method: http.MethodGet, target: target, token: inviteeID.String(), wantCode: http.StatusOK},
+		{name: "guest lists members", method: http.MethodGet, target: members, token: inviteeID.String(), wantCode: http.StatusForbidden},
+		{name: "owner creates org project", method: http.MethodPost, target: routeProjects, token: ownerToken, body: `{"name":"roadmap"}`, wantCode: http.StatusCreated},
+		{name: "guest creates org project", method: http.MethodPost, target: routeProjects, token: inviteeToken, body: `{"name":"mine"}`, wantCode: http.StatusForbidden},
+		{name: "promote member", method: http.MethodPut, target: members + "/" + inviteeID.String(), token: ownerID.String(), body: `{"role":"member"}`, wantCode: http.StatusOK},
+		{name: "member creates org project", method: http.MethodPost, target: routeProjects, token: inviteeToken, body: `{"name":"mine"}`, wantCode: http.StatusCreated},
+		{name: "member manages members", method: http.MethodDelete, target: members + "/" + ownerID.String(), token: inviteeID.String(), wantCode: http.StatusForbidden},
+		{name: "last owner leaves", method: http.MethodDelete, target: members + "/" + ownerID.String(), token: ownerID.String(), wantCode: http.StatusConflict},
+		{name: "remove member", method: http.MethodDelete, target: members + "/" + inviteeID.String(), token: ownerID.String(), wantCode: http.StatusNoContent},
+		{name: "remove member twice", method: http.MethodDelete, target: members + "/" + inviteeID.String(), token: ownerID.String(), wantCode: http.StatusNotFound},
+		{name: "removed lists org projects", method: http.MethodGet, target: routeProjects, token: inviteeToken, wantCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, orgRequest(step.method, step.target, step.token, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	// the token of the org lists its projects, the personal token the personal ones
+	for token, want := range map[string]int{ownerToken: 2, ownerID.String(): 0} {
+		rr = httptest.NewRecorder()
+		mh.ServeHTTP(rr, orgRequest(http.MethodGet, routeProjects, token, ""))
+		var listed struct {
+			Data []projectResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusOK || len(listed.Data) != want {
+			t.Errorf("expected %d projects with the token %s got %d %s", want, token, rr.Code, rr.Body.String())
+		}
+		for _, project := range listed.Data {
+			if project.OrgID == nil || *project.OrgID != orgID {
+				t.Errorf("expected the project of the org got %+v", project)
+			}
+		}
+	}
+}
+
+func TestOrgs_Token(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	orgRepo := newMockOrgRepoStorage(newMockProjectRepoStorage(newMockTodoRepoStorage()))
+	users := &_mockUserRepoStorage{}
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(users), userTokenizer{}),
+		WithOrgService(pkg.NewOrgService(orgRepo, users)))
+
+	userID := uuid.New()
+	org := pkg.OrgModel{ID: uuid.New(), Name: "acme"}
+	_ = orgRepo.InsertOne(context.Background(), org, userID)
+	target := routeOrgs + "/" + org.ID.String() + "/token"
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, orgRequest(http.MethodPost, target, uuid.New().String(), ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for an outsider got %d", http.StatusNotFound, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, orgRequest(http.MethodPost, target, userID.String(), ""))
+	var switched struct {
+		Data tokenResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &switched); err != nil {
+		t.Fatal(err)
+	}
+	if want := userID.String() + ":" + org.ID.String(); rr.Code != http.StatusCreated || switched.Data.Token != want {
+		t.Errorf("expected the token %s got %d %s", want, rr.Code, rr.Body.String())
+	}
+
+	// a token of an active org that isn't an uuid is rejected
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, orgRequest(http.MethodGet, routeOrgs, userID.String()+":acme", ""))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
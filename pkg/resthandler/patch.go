@@ -0,0 +1,436 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+const (
+	// https://tools.ietf.org/html/rfc7396
+	mergePatchContentType = "application/merge-patch+json"
+	// https://tools.ietf.org/html/rfc6902
+	jsonPatchContentType = "application/json-patch+json"
+)
+
+var (
+	errInvalidPatch    = envelope.NewError(envelope.CodeInvalidPatch, "Invalid patch document.")
+	errPatchTestFailed = envelope.NewError(envelope.CodePatchTestFailed, "Patch test operation failed.")
+
+	// errPathNotFound indicates a json pointer doesn't reference an existing value
+	errPathNotFound = errors.New("path not found")
+)
+
+// documentPatch applies a patch on the json document of a resource
+// and returns the patched document.
+type documentPatch func(doc interface{}) (interface{}, error)
+
+// decodePatch decodes the request body as either a json merge patch or
+// a json patch, according to its Content-Type.
+// Any returned error is a *decodeError.
+func decodePatch(r *http.Request) (documentPatch, error) {
+	if mediaType(r) == jsonPatchContentType {
+		var raw []map[string]json.RawMessage
+		if err := decodeBody(r, &raw); err != nil {
+			return nil, err
+		}
+		ops, err := parseJSONPatch(raw)
+		if err != nil {
+			return nil, err
+		}
+		return ops.apply, nil
+	}
+
+	var patch interface{}
+	if err := decodeBody(r, &patch); err != nil {
+		return nil, err
+	}
+	return func(doc interface{}) (interface{}, error) {
+		return mergePatch(doc, patch), nil
+	}, nil
+}
+
+// invalidPatch returns a decodeError with the field level failure at the json pointer.
+// Pointers of a json patch failure points into the patch document, like /0/path,
+// while the ones of the patched document validation points into the document.
+func invalidPatch(status int, pointer string, code envelope.Code, message string) error {
+	return &decodeError{
+		status: status,
+		apiErr: envelope.NewError(envelope.CodeInvalidPatch, errInvalidPatch.Message,
+			envelope.FieldError{Field: pointer, Code: code, Message: message}),
+		err: fmt.Errorf("patch: %s %s", pointer, message),
+	}
+}
+
+// mergePatch applies the RFC 7396 merge patch on the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// patchOperation is a single RFC 6902 operation.
+type patchOperation struct {
+	op    string
+	path  []string
+	from  []string
+	value interface{}
+}
+
+// jsonPatch is a RFC 6902 json patch, its operations are applied in order.
+type jsonPatch []patchOperation
+
+// parseJSONPatch validates the operations of the patch document.
+// Members not defined for an operation are ignored, as the RFC requires.
+func parseJSONPatch(raw []map[string]json.RawMessage) (jsonPatch, error) {
+	ops := make(jsonPatch, 0, len(raw))
+	for i, m := range raw {
+		at := "/" + strconv.Itoa(i)
+		if m == nil {
+			return nil, invalidPatch(http.StatusBadRequest, at, envelope.CodeInvalidType, "Operation should be an object.")
+		}
+
+		op, err := stringMember(m, at, "op")
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add", "remove", "replace", "move", "copy", "test":
+		default:
+			return nil, invalidPatch(http.StatusBadRequest, at+"/op", envelope.CodeInvalidOperation,
+				fmt.Sprintf("Unsupported operation %q.", op))
+		}
+
+		po := patchOperation{op: op}
+		if po.path, err = pointerMember(m, at, "path"); err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add", "replace", "test":
+			v, ok := m["value"]
+			if !ok {
+				return nil, invalidPatch(http.StatusBadRequest, at+"/value", envelope.CodeRequired, "Value is required.")
+			}
+			// it has already been decoded once, it can't fail.
+			_ = json.Unmarshal(v, &po.value)
+		case "move", "copy":
+			if po.from, err = pointerMember(m, at, "from"); err != nil {
+				return nil, err
+			}
+			if op == "move" && isProperPrefix(po.from, po.path) {
+				return nil, invalidPatch(http.StatusBadRequest, at+"/from", envelope.CodeInvalidValue,
+					"A value can't be moved into one of its children.")
+			}
+		}
+		ops = append(ops, po)
+	}
+	return ops, nil
+}
+
+func stringMember(m map[string]json.RawMessage, at, name string) (string, error) {
+	v, ok := m[name]
+	if !ok {
+		return "", invalidPatch(http.StatusBadRequest, at+"/"+name, envelope.CodeRequired,
+			fmt.Sprintf("Member %s is required.", name))
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return "", invalidPatch(http.StatusBadRequest, at+"/"+name, envelope.CodeInvalidType,
+			fmt.Sprintf("Member %s should be a string.", name))
+	}
+	return s, nil
+}
+
+func pointerMember(m map[string]json.RawMessage, at, name string) ([]string, error) {
+	s, err := stringMember(m, at, name)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := parsePointer(s)
+	if err != nil {
+		return nil, invalidPatch(http.StatusBadRequest, at+"/"+name, envelope.CodeInvalidValue,
+			fmt.Sprintf("Invalid json pointer %q.", s))
+	}
+	return tokens, nil
+}
+
+// apply applies the operations on the doc in order, the first failing one
+// fails the whole patch. The doc may be modified even if it fails.
+func (jp jsonPatch) apply(doc interface{}) (interface{}, error) {
+	var err error
+	for i, po := range jp {
+		at := "/" + strconv.Itoa(i)
+		switch po.op {
+		case "add":
+			doc, err = addValue(doc, po.path, po.value)
+		case "remove":
+			doc, _, err = removeValue(doc, po.path)
+		case "replace":
+			doc, err = replaceValue(doc, po.path, po.value)
+		case "move":
+			var v interface{}
+			doc, v, err = removeValue(doc, po.from)
+			if err != nil {
+				return nil, invalidPatch(http.StatusUnprocessableEntity, at+"/from", envelope.CodePathNotFound,
+					fmt.Sprintf("No value at %s.", formatPointer(po.from)))
+			}
+			doc, err = addValue(doc, po.path, v)
+		case "copy":
+			var v interface{}
+			v, err = lookup(doc, po.from)
+			if err != nil {
+				return nil, invalidPatch(http.StatusUnprocessableEntity, at+"/from", envelope.CodePathNotFound,
+					fmt.Sprintf("No value at %s.", formatPointer(po.from)))
+			}
+			doc, err = addValue(doc, po.path, deepCopy(v))
+		case "test":
+			var v interface{}
+			v, err = lookup(doc, po.path)
+			if err == nil && !reflect.DeepEqual(v, po.value) {
+				return nil, &decodeError{
+					status: http.StatusConflict,
+					apiErr: envelope.NewError(envelope.CodePatchTestFailed, errPatchTestFailed.Message,
+						envelope.FieldError{Field: at + "/value", Code: envelope.CodeInvalidValue,
+							Message: fmt.Sprintf("Value at %s doesn't match.", formatPointer(po.path))}),
+					err: fmt.Errorf("patch: test of %s failed", formatPointer(po.path)),
+				}
+			}
+		}
+		if err != nil {
+			return nil, invalidPatch(http.StatusUnprocessableEntity, at+"/path", envelope.CodePathNotFound,
+				fmt.Sprintf("No value at %s.", formatPointer(po.path)))
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer parses the RFC 6901 json pointer into its unescaped reference tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("json pointer %q should start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		for j := 0; j < len(t); j++ {
+			if t[j] == '~' && (j+1 == len(t) || (t[j+1] != '0' && t[j+1] != '1')) {
+				return nil, fmt.Errorf("json pointer %q has an invalid ~ escape", p)
+			}
+		}
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func formatPointer(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(t))
+	}
+	return b.String()
+}
+
+func isProperPrefix(prefix, tokens []string) bool {
+	if len(prefix) >= len(tokens) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses the token as an index of an array of n elements,
+// leading zeros are not allowed.
+func arrayIndex(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || strconv.Itoa(i) != token || i < 0 || i >= n {
+		return 0, errPathNotFound
+	}
+	return i, nil
+}
+
+// lookup returns the value the tokens reference in the doc.
+func lookup(doc interface{}, tokens []string) (interface{}, error) {
+	for _, t := range tokens {
+		switch n := doc.(type) {
+		case map[string]interface{}:
+			v, ok := n[t]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(n))
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, errPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// walk descends the doc to the parent of the value the tokens reference and calls
+// the fn with it. The parent returned by the fn replaces the original one, as
+// arrays may be reallocated. The root is never passed to the fn.
+func walk(doc interface{}, tokens []string, fn func(parent interface{}, last string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		child, ok := n[tokens[0]]
+		if !ok {
+			return nil, errPathNotFound
+		}
+		child, err := walk(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[tokens[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(n))
+		if err != nil {
+			return nil, err
+		}
+		child, err := walk(n[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	default:
+		return nil, errPathNotFound
+	}
+}
+
+// addValue adds the value at the tokens, inserting it if the parent is an array.
+func addValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return walk(doc, tokens, func(parent interface{}, last string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			n[last] = value
+			return n, nil
+		case []interface{}:
+			if last == "-" {
+				return append(n, value), nil
+			}
+			// the index right after the last element is allowed
+			i, err := arrayIndex(last, len(n)+1)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+}
+
+// removeValue removes the value at the tokens and returns it.
+func removeValue(doc interface{}, tokens []string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	var removed interface{}
+	doc, err := walk(doc, tokens, func(parent interface{}, last string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			v, ok := n[last]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			removed = v
+			delete(n, last)
+			return n, nil
+		case []interface{}:
+			i, err := arrayIndex(last, len(n))
+			if err != nil {
+				return nil, err
+			}
+			removed = n[i]
+			return append(n[:i], n[i+1:]...), nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+	return doc, removed, err
+}
+
+// replaceValue replaces the existing value at the tokens.
+func replaceValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if _, err := lookup(doc, tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return walk(doc, tokens, func(parent interface{}, last string) (interface{}, error) {
+		switch n := parent.(type) {
+		case map[string]interface{}:
+			n[last] = value
+			return n, nil
+		case []interface{}:
+			i, _ := arrayIndex(last, len(n))
+			n[i] = value
+			return n, nil
+		default:
+			return nil, errPathNotFound
+		}
+	})
+}
+
+// deepCopy copies the decoded json value, so a copied value
+// doesn't share its objects and arrays with the original.
+func deepCopy(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(n))
+		for k, e := range n {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(n))
+		for i, e := range n {
+			c[i] = deepCopy(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
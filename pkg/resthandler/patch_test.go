@@ -0,0 +1,152 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		pointer string
+		want    []string
+		wantErr bool
+	}{
+		{pointer: "", want: nil},
+		{pointer: "/", want: []string{""}},
+		{pointer: "/title", want: []string{"title"}},
+		{pointer: "/a~1b/m~0n/0", want: []string{"a/b", "m~n", "0"}},
+		{pointer: "/~01", want: []string{"~1"}},
+		{pointer: "title", wantErr: true},
+		{pointer: "/a~2", wantErr: true},
+		{pointer: "/a~", wantErr: true},
+	}
+	for _, tc := range tcs {
+		got, err := parsePointer(tc.pointer)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: expected error %t got %v", tc.pointer, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected tokens %q got %q", tc.pointer, tc.want, got)
+		}
+		if !tc.wantErr && formatPointer(got) != tc.pointer {
+			t.Errorf("%q: expected to format back got %q", tc.pointer, formatPointer(got))
+		}
+	}
+}
+
+func decodeTestJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// examples of the RFC 7396 appendix A
+func TestMergePatch(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		target, patch, want string
+	}{
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{target: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{target: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range tcs {
+		got := mergePatch(decodeTestJSON(t, tc.target), decodeTestJSON(t, tc.patch))
+		if want := decodeTestJSON(t, tc.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s + %s: expected %v got %v", tc.target, tc.patch, want, got)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		name       string
+		doc        string
+		patch      string
+		want       string
+		wantStatus int
+		wantField  string
+	}{
+		{name: "add member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, want: `{"baz":"qux","foo":"bar"}`},
+		{name: "add array element", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, want: `{"foo":["bar","qux","baz"]}`},
+		{name: "add array end", doc: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/-","value":["abc"]}]`, want: `{"foo":["bar",["abc"]]}`},
+		{name: "add null value", doc: `{}`, patch: `[{"op":"add","path":"/a","value":null}]`, want: `{"a":null}`},
+		{name: "remove", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, want: `{"foo":"bar"}`},
+		{name: "remove array element", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, want: `{"foo":["bar","baz"]}`},
+		{name: "replace", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, want: `{"baz":"boo","foo":"bar"}`},
+		{name: "move", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, want: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "move array element", doc: `{"foo":["all","grass","cows","eat"]}`, patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, want: `{"foo":["all","cows","eat","grass"]}`},
+		{name: "copy is deep", doc: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, want: `{"a":{"b":1},"c":{"b":2}}`},
+		{name: "test", doc: `{"baz":"qux","foo":["a",2,"c"]}`, patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, want: `{"baz":"qux","foo":["a",2,"c"]}`},
+		{name: "ignores unknown members", doc: `{}`, patch: `[{"op":"add","path":"/a","value":1,"xyz":123}]`, want: `{"a":1}`},
+		{name: "test fails", doc: `{"baz":"qux"}`, patch: `[{"op":"replace","path":"/baz","value":"a"},{"op":"test","path":"/baz","value":"qux"}]`, wantStatus: http.StatusConflict, wantField: "/1/value"},
+		{name: "missing target", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`, wantStatus: http.StatusUnprocessableEntity, wantField: "/0/path"},
+		{name: "replace missing", doc: `{}`, patch: `[{"op":"replace","path":"/a","value":1}]`, wantStatus: http.StatusUnprocessableEntity, wantField: "/0/path"},
+		{name: "index out of bounds", doc: `{"foo":[1]}`, patch: `[{"op":"add","path":"/foo/2","value":1}]`, wantStatus: http.StatusUnprocessableEntity, wantField: "/0/path"},
+		{name: "leading zero index", doc: `{"foo":[1,2]}`, patch: `[{"op":"remove","path":"/foo/01"}]`, wantStatus: http.StatusUnprocessableEntity, wantField: "/0/path"},
+		{name: "move missing from", doc: `{}`, patch: `[{"op":"move","from":"/a","path":"/b"}]`, wantStatus: http.StatusUnprocessableEntity, wantField: "/0/from"},
+		{name: "unknown op", doc: `{}`, patch: `[{"op":"add","path":"/a","value":1},{"op":"merge","path":"/a"}]`, wantStatus: http.StatusBadRequest, wantField: "/1/op"},
+		{name: "missing value", doc: `{}`, patch: `[{"op":"add","path":"/a"}]`, wantStatus: http.StatusBadRequest, wantField: "/0/value"},
+		{name: "missing path", doc: `{}`, patch: `[{"op":"remove"}]`, wantStatus: http.StatusBadRequest, wantField: "/0/path"},
+		{name: "invalid pointer", doc: `{}`, patch: `[{"op":"remove","path":"a"}]`, wantStatus: http.StatusBadRequest, wantField: "/0/path"},
+		{name: "op not a string", doc: `{}`, patch: `[{"op":1,"path":"/a"}]`, wantStatus: http.StatusBadRequest, wantField: "/0/op"},
+		{name: "move into child", doc: `{"a":{}}`, patch: `[{"op":"move","from":"/a","path":"/a/b"}]`, wantStatus: http.StatusBadRequest, wantField: "/0/from"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var raw []map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tc.patch), &raw); err != nil {
+				t.Fatal(err)
+			}
+			ops, err := parseJSONPatch(raw)
+			var got interface{}
+			if err == nil {
+				got, err = ops.apply(decodeTestJSON(t, tc.doc))
+			}
+
+			if tc.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if want := decodeTestJSON(t, tc.want); !reflect.DeepEqual(got, want) {
+					t.Errorf("expected %v got %v", want, got)
+				}
+				return
+			}
+
+			var de *decodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("expected decode error got %v", err)
+			}
+			if de.status != tc.wantStatus {
+				t.Errorf("expected status %d got %d", tc.wantStatus, de.status)
+			}
+			if len(de.apiErr.Details) != 1 || de.apiErr.Details[0].Field != tc.wantField {
+				t.Errorf("expected error pointer %s got %+v", tc.wantField, de.apiErr.Details)
+			}
+		})
+	}
+}
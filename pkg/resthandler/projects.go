@@ -0,0 +1,220 @@
+package resthandler
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errInvalidProject = envelope.NewError(envelope.CodeValidationFailed, "Invalid project.",
+		envelope.FieldError{Field: "name", Code: envelope.CodeInvalidLength, Message: "Name is required and should be < 100."},
+		envelope.FieldError{Field: "color", Code: envelope.CodeInvalidValue, Message: "Should be an hex color like #ff0000."})
+	errInvalidDeleteMode = envelope.NewError(envelope.CodeValidationFailed, "Invalid todos.",
+		envelope.FieldError{Field: "todos", Code: envelope.CodeInvalidValue, Message: "Should be inbox or cascade."})
+	errOrgProjectInbox = envelope.NewError(envelope.CodeValidationFailed, "Invalid todos.",
+		envelope.FieldError{Field: "todos", Code: envelope.CodeInvalidValue, Message: "Should be cascade for a project of an organization."})
+)
+
+// projects encapsulates various types of handlerFunc
+// that responds to project api request of the authenticated user
+type projects struct {
+	svc    pkg.ProjectService
+	logger *zap.Logger
+}
+
+// projectForm type Decode the submitted json body of a project.
+type projectForm struct {
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Archived bool   `json:"archived"`
+}
+
+// projectResponse is the json representation of a project.
+type projectResponse struct {
+	ID uuid.UUID `json:"id"`
+	// OrgID is left out of a personal project
+	OrgID    *uuid.UUID `json:"org_id,omitempty"`
+	Name     string     `json:"name"`
+	Color    string     `json:"color"`
+	Archived bool       `json:"archived"`
+	Inbox    bool       `json:"inbox"`
+}
+
+func newProjectResponse(project pkg.ProjectModel) projectResponse {
+	resp := projectResponse{
+		ID:       project.ID,
+		Name:     project.Name,
+		Color:    project.Color,
+		Archived: project.Archived,
+		Inbox:    project.Inbox,
+	}
+	if project.OrgID != uuid.Nil {
+		resp.OrgID = &project.OrgID
+	}
+	return resp
+}
+
+// projectIDFromReq returns the project id of the route, ok is false if it's not an uuid.
+func projectIDFromReq(r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	return id, err == nil
+}
+
+// parseProjectDeleteMode tells what happens to the todos of the deleted project,
+// by default they are moved to the inbox.
+func parseProjectDeleteMode(v string) (pkg.ProjectDeleteMode, bool) {
+	switch v {
+	case "", "inbox":
+		return pkg.MoveTodosToInbox, true
+	case "cascade":
+		return pkg.CascadeTodos, true
+	default:
+		return pkg.MoveTodosToInbox, false
+	}
+}
+
+// list lists the projects of the active organization of the user,
+// or the personal ones without any.
+func (ph projects) list(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var list []pkg.ProjectModel
+	var err error
+	if orgID := orgIDFromReqCtx(r); orgID != uuid.Nil {
+		list, err = ph.svc.ListOrg(r.Context(), userIDFromReqCtx(r), orgID)
+	} else {
+		list, err = ph.svc.List(r.Context(), userIDFromReqCtx(r))
+	}
+	if err != nil {
+		code = writeDomainError(w, r, err, ph.logger)
+		ph.logger.Error("err List", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := make([]projectResponse, 0, len(list))
+	for _, project := range list {
+		resp = append(resp, newProjectResponse(project))
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, ph.logger)
+	ph.logger.Info("projects listed", httpReqField(code, r, nil)...)
+}
+
+// create creates a project in the active organization of the user,
+// or a personal one without any.
+func (ph projects) create(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form projectForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, ph.logger)
+		ph.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	project, err := ph.svc.Create(r.Context(), userIDFromReqCtx(r), pkg.ProjectModel{
+		OrgID:    orgIDFromReqCtx(r),
+		Name:     form.Name,
+		Color:    form.Color,
+		Archived: form.Archived,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, ph.logger)
+		ph.logger.Error("err Create", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	w.Header().Set("Location", routeProjects+"/"+project.ID.String())
+	writeData(w, code, newProjectResponse(project), ph.logger)
+	ph.logger.Info("project created", httpReqField(code, r, nil)...)
+}
+
+func (ph projects) get(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := projectIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrProjectNotFound, ph.logger)
+		ph.logger.Error("invalid project id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	project, err := ph.svc.Find(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, ph.logger)
+		ph.logger.Error("err Find", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newProjectResponse(project), ph.logger)
+	ph.logger.Info("project found", httpReqField(code, r, nil)...)
+}
+
+func (ph projects) update(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form projectForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, ph.logger)
+		ph.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	id, ok := projectIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrProjectNotFound, ph.logger)
+		ph.logger.Error("invalid project id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	project, err := ph.svc.Update(r.Context(), userIDFromReqCtx(r), pkg.ProjectModel{
+		ID:       id,
+		Name:     form.Name,
+		Color:    form.Color,
+		Archived: form.Archived,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, ph.logger)
+		ph.logger.Error("err Update", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newProjectResponse(project), ph.logger)
+	ph.logger.Info("project updated", httpReqField(code, r, nil)...)
+}
+
+// delete deletes the project, its todos are moved to the inbox
+// unless ?todos=cascade deletes them too.
+func (ph projects) delete(w http.ResponseWriter, r *http.Request) {
+	var code int
+	mode, ok := parseProjectDeleteMode(r.URL.Query().Get("todos"))
+	if !ok {
+		code = http.StatusBadRequest
+		writeError(w, r, code, errInvalidDeleteMode, ph.logger)
+		ph.logger.Error("invalid delete mode", httpReqField(code, r, nil)...)
+		return
+	}
+	id, ok := projectIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrProjectNotFound, ph.logger)
+		ph.logger.Error("invalid project id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	err := ph.svc.Delete(r.Context(), userIDFromReqCtx(r), id, mode)
+	if err != nil {
+		code = writeDomainError(w, r, err, ph.logger)
+		ph.logger.Error("err Delete", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	ph.logger.Info("project deleted", httpReqField(code, r, nil)...)
+}
@@ -0,0 +1,99 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func newProjectTestHandler(t *testing.T) (*MuxHandler, *_mockTodoRepoStorage, *_mockProjectRepoStorage) {
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	projects := newMockProjectRepoStorage(repo)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoService(pkg.NewTodoService(repo)), WithProjectService(pkg.NewProjectService(projects)))
+	return mh, repo, projects
+}
+
+func TestProjects(t *testing.T) {
+	t.Parallel()
+	mh, repo, projects := newProjectTestHandler(t)
+	userID := uuid.New()
+	inbox := projects.inbox(userID)
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeProjects, userID, `{"name":" work ","color":"#FF0000"}`))
+	var created struct {
+		Data projectResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || created.Data.Name != "work" || created.Data.Color != "#ff0000" {
+		t.Fatalf("expected project created got %d %s", rr.Code, rr.Body.String())
+	}
+	project := routeProjects + "/" + created.Data.ID.String()
+
+	moved := pkg.TodoModel{ID: uuid.New(), UserID: userID, ProjectID: created.Data.ID, Title: "moved", Version: 1}
+	repo.todos[moved.ID] = moved
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		userID   uuid.UUID
+		body     string
+		wantCode int
+	}{
+		{name: "invalid color", method: http.MethodPost, target: routeProjects, userID: userID, body: `{"name":"home","color":"red"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "other user", method: http.MethodGet, target: project, userID: uuid.New(), wantCode: http.StatusNotFound},
+		{name: "renamed", method: http.MethodPut, target: project, userID: userID, body: `{"name":"job"}`, wantCode: http.StatusOK},
+		{name: "inbox archived", method: http.MethodPut, target: routeProjects + "/" + inbox.ID.String(), userID: userID, body: `{"name":"Inbox","archived":true}`, wantCode: http.StatusConflict},
+		{name: "inbox deleted", method: http.MethodDelete, target: routeProjects + "/" + inbox.ID.String(), userID: userID, wantCode: http.StatusConflict},
+		{name: "project filter", method: http.MethodGet, target: routeTodos + "?project=" + created.Data.ID.String(), userID: userID, wantCode: http.StatusOK},
+		{name: "invalid project filter", method: http.MethodGet, target: routeTodos + "?project=work", userID: userID, wantCode: http.StatusBadRequest},
+		{name: "invalid delete mode", method: http.MethodDelete, target: project + "?todos=trash", userID: userID, wantCode: http.StatusBadRequest},
+		{name: "deleted", method: http.MethodDelete, target: project, userID: userID, wantCode: http.StatusNoContent},
+		{name: "deleted twice", method: http.MethodDelete, target: project, userID: userID, wantCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, step.userID, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	if got := repo.todos[moved.ID]; got.ProjectID != inbox.ID {
+		t.Errorf("expected the todo of the deleted project moved to the inbox got %+v", got)
+	}
+}
+
+func TestProjects_DeleteCascade(t *testing.T) {
+	t.Parallel()
+	mh, repo, projects := newProjectTestHandler(t)
+	userID := uuid.New()
+	projects.inbox(userID)
+	project := pkg.ProjectModel{ID: uuid.New(), UserID: userID, Name: "work"}
+	projects.projects[project.ID] = project
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, ProjectID: project.ID, Title: "cascaded", Version: 1}
+	repo.todos[todo.ID] = todo
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodDelete, routeProjects+"/"+project.ID.String()+"?todos=cascade", userID, ""))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d got %d %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if _, ok := repo.todos[todo.ID]; ok {
+		t.Errorf("expected the todo of the deleted project deleted too")
+	}
+}
@@ -0,0 +1,277 @@
+package resthandler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+var (
+	errTooManyRequests = envelope.NewError(envelope.CodeTooManyRequests, "Too many requests, retry later.")
+)
+
+// Rate is a token bucket of Limit tokens that is refilled completely
+// every Period, one token at a time.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time left until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time left until the next token, when not Allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets of the rate limiter.
+// The in-memory store limits each replica on its own, an implementation
+// over a shared Postgres or Redis compatible database allows to enforce
+// the limit across all the replicas.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, rate Rate) (RateLimitResult, error)
+}
+
+// rateLimitSweepInterval is how often at most the refilled buckets are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket is the state of a single bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// period of the rate of the bucket, it's full again a period after the last take
+	period time.Duration
+}
+
+// MemoryRateLimitStore is an in process RateLimitStore.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore returns an initialized in-memory RateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket of the key, if any is left.
+func (m *MemoryRateLimitStore) Take(_ context.Context, key string, rate Rate) (RateLimitResult, error) {
+	if rate.Limit <= 0 || rate.Period <= 0 {
+		return RateLimitResult{}, fmt.Errorf("invalid rate %d/%s", rate.Limit, rate.Period)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+	perToken := rate.Period / time.Duration(rate.Limit)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(rate.Limit), last: now}
+		m.buckets[key] = b
+	}
+	// refill for the time elapsed since the last take
+	b.tokens = math.Min(float64(rate.Limit), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+	b.period = rate.Period
+
+	res := RateLimitResult{Limit: rate.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(rate.Limit) - b.tokens) * float64(perToken))
+	return res, nil
+}
+
+// sweep drops the buckets that have been refilled completely with their own rate,
+// they are indistinguishable from a new bucket. It runs at most once per rateLimitSweepInterval.
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= b.period {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// RateLimitKeyFunc derives the key a request is rate limited by.
+// ok is false if the request can't be keyed, like an anonymous
+// request for a key by user.
+type RateLimitKeyFunc func(r *http.Request) (key string, ok bool)
+
+// KeyByIP keys the request by the client IP address. The X-Forwarded-For
+// header is only honoured when the request comes through one of the trustedProxies.
+func KeyByIP(trustedProxies []*net.IPNet) RateLimitKeyFunc {
+	return func(r *http.Request) (string, bool) {
+		ip := clientIP(r, trustedProxies)
+		if ip == "" {
+			return "", false
+		}
+		return "ip:" + ip, true
+	}
+}
+
+// KeyByUser keys the request by the id of the authenticated user.
+func KeyByUser(tokenizer Tokenizer) RateLimitKeyFunc {
+	return func(r *http.Request) (string, bool) {
+		token := bearerToken(r)
+		if token == "" {
+			return "", false
+		}
+		claims, err := tokenizer.ValidateClaims(token)
+		if err != nil || claims.UserID == "" {
+			return "", false
+		}
+		return "user:" + claims.UserID, true
+	}
+}
+
+// KeyByAPIToken keys the request by the bearer token it carries, without validating it.
+// The token is hashed, so it never ends up as it is in the store.
+func KeyByAPIToken() RateLimitKeyFunc {
+	return func(r *http.Request) (string, bool) {
+		token := bearerToken(r)
+		if token == "" {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:]), true
+	}
+}
+
+// RateLimitPolicy is the rate limit of a route.
+type RateLimitPolicy struct {
+	Rate Rate
+	// KeyFuncs are tried in order, the first one that can key the request is used.
+	// A request no KeyFuncs can key is not limited.
+	KeyFuncs []RateLimitKeyFunc
+}
+
+// rateLimit returns a middleware that limits the requests of the route with the policy.
+// Every response carries the RateLimit-* headers of the IETF draft
+// https://tools.ietf.org/html/draft-ietf-httpapi-ratelimit-headers
+func rateLimit(logger *zap.Logger, store RateLimitStore, route string, policy RateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := "", false
+			for _, keyFunc := range policy.KeyFuncs {
+				if key, ok = keyFunc(r); ok {
+					break
+				}
+			}
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := store.Take(r.Context(), route+"|"+key, policy.Rate)
+			if err != nil {
+				// fail open, an unavailable store shouldn't take the api down.
+				logger.Error("rate limit store failed", httpReqField(0, r, err)...)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Rate.Limit, ceilSeconds(policy.Rate.Period)))
+			if res.Allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			code := http.StatusTooManyRequests
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			writeError(w, r, code, errTooManyRequests, logger)
+			logger.Error("rate limited", httpReqField(code, r, nil)...)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// bearerToken returns the token of the Authorization header if any.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+// clientIP returns the IP address of the client. If the direct peer is
+// a trusted proxy the X-Forwarded-For chain is walked from the right,
+// the first address that isn't a trusted proxy is the client.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil {
+		return ""
+	}
+	if !isTrusted(peer, trustedProxies) {
+		return peer.String()
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !isTrusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(0, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	rate := Rate{Limit: 2, Period: 2 * time.Second}
+
+	for i := 0; i < 2; i++ {
+		res, err := store.Take(context.Background(), "k", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != 1-i {
+			t.Fatalf("expected take %d to be allowed with %d remaining got %+v", i, 1-i, res)
+		}
+	}
+
+	res, _ := store.Take(context.Background(), "k", rate)
+	if res.Allowed {
+		t.Fatalf("expected empty bucket to be limited")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("expected retry after %s got %s", time.Second, res.RetryAfter)
+	}
+
+	res, _ = store.Take(context.Background(), "other", rate)
+	if !res.Allowed {
+		t.Errorf("expected the buckets to be independent per key")
+	}
+
+	// one token is refilled each second
+	now = now.Add(time.Second)
+	res, _ = store.Take(context.Background(), "k", rate)
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("expected a refilled token got %+v", res)
+	}
+}
+
+func TestMemoryRateLimitStore_Sweep(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(0, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	hourly := Rate{Limit: 1, Period: time.Hour}
+	perSecond := Rate{Limit: 1, Period: time.Second}
+
+	if res, _ := store.Take(context.Background(), "login|ip:a", hourly); !res.Allowed {
+		t.Fatalf("expected the first take allowed")
+	}
+	_, _ = store.Take(context.Background(), "todos|ip:a", perSecond)
+	// the sweep of a take with a shorter period keeps the buckets not refilled yet
+	now = now.Add(2 * rateLimitSweepInterval)
+	_, _ = store.Take(context.Background(), "todos|ip:b", perSecond)
+	if _, ok := store.buckets["todos|ip:a"]; ok {
+		t.Errorf("expected the refilled bucket dropped")
+	}
+	if res, _ := store.Take(context.Background(), "login|ip:a", hourly); res.Allowed {
+		t.Errorf("expected the hourly bucket still empty got %+v", res)
+	}
+}
+
+func TestMuxHandler_TrustedProxies(t *testing.T) {
+	t.Parallel()
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	mh := NewMuxHandler(zap.NewNop(), WithTrustedProxies([]*net.IPNet{proxies}))
+	login := func(client string) int {
+		req := httptest.NewRequest(http.MethodPost, routeLogin, nil)
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("X-Forwarded-For", client)
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	for i := 0; i < 10; i++ {
+		_ = login("198.51.100.1")
+	}
+	if code := login("198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the client limited got %d", code)
+	}
+	if code := login("198.51.100.2"); code == http.StatusTooManyRequests {
+		t.Errorf("expected another client behind the proxy not limited")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+	tcs := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "spoofed header from untrusted peer", remoteAddr: "203.0.113.7:1234", xff: "198.51.100.1", want: "203.0.113.7"},
+		{name: "through trusted proxy", remoteAddr: "10.0.0.2:1234", xff: "198.51.100.1", want: "198.51.100.1"},
+		{name: "client spoofing behind proxies", remoteAddr: "10.0.0.2:1234", xff: "1.1.1.1, 198.51.100.1, 10.0.0.3", want: "198.51.100.1"},
+		{name: "only proxies", remoteAddr: "10.0.0.2:1234", xff: "10.0.0.3", want: "10.0.0.3"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if got := clientIP(req, trusted); got != tc.want {
+				t.Errorf("expected client ip %s got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRateLimit_Middleware(t *testing.T) {
+	t.Parallel()
+	policy := RateLimitPolicy{
+		Rate:     Rate{Limit: 1, Period: time.Minute},
+		KeyFuncs: []RateLimitKeyFunc{KeyByAPIToken(), KeyByIP(nil)},
+	}
+	handler := rateLimit(zap.NewNop(), NewMemoryRateLimitStore(), "/v1/todos", policy)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/todos", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("token-a")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass got %d", rr.Code)
+	}
+	if rr.Header().Get("RateLimit-Limit") != "1" || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("expected RateLimit headers got %v", rr.Header())
+	}
+
+	rr = serve("token-a")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected second request to be limited got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After header of 60 got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// another token has its own bucket, and anonymous fall back to the ip
+	if rr = serve("token-b"); rr.Code != http.StatusOK {
+		t.Errorf("expected request with other token to pass got %d", rr.Code)
+	}
+	if rr = serve(""); rr.Code != http.StatusOK {
+		t.Errorf("expected anonymous request keyed by ip to pass got %d", rr.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package resthandler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/observability"
+)
+
+// recoverPanic returns a middleware that recovers from a panic in the next
+// handler. The panic is logged with its stack trace and the request fields,
+// and client only receives the standard internal server error body.
+func recoverPanic(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw := &trackingWriter{ResponseWriter: w}
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				// net/http uses it to abort the response, let it do its job.
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				observability.HTTPPanics.Add(1)
+
+				code := http.StatusInternalServerError
+				fields := httpReqField(code, r, fmt.Errorf("panic: %v", rec))
+				fields = append(fields, zap.String("stack", string(debug.Stack())))
+				logger.Error("recovered from panic", fields...)
+
+				// a partial response can't be turned into an error anymore.
+				if tw.wroteHeader {
+					return
+				}
+				writeInternalServerError(w, r, logger)
+			}()
+			next.ServeHTTP(tw, r)
+		})
+	}
+}
+
+// trackingWriter records if the header has already been sent to the client.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (tw *trackingWriter) WriteHeader(code int) {
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *trackingWriter) Write(b []byte) (int, error) {
+	tw.wroteHeader = true
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered data to the client, if the underlying writer can.
+func (tw *trackingWriter) Flush() {
+	tw.wroteHeader = true
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, like to upgrade it to a WebSocket.
+func (tw *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := tw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't hijack")
+	}
+	tw.wroteHeader = true
+	return h.Hijack()
+}
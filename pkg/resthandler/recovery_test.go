@@ -0,0 +1,72 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/ankur-anand/prod-todo/pkg/observability"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	t.Parallel()
+	obs, logs := observer.New(zapcore.DebugLevel)
+	l := zap.New(obs)
+	before := observability.HTTPPanics.Value()
+
+	handler := recoverPanic(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("implement me: secret internal detail")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/v1/todos", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code %d got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "secret internal detail") {
+		t.Errorf("panic value leaked to the client %s", rr.Body.String())
+	}
+	if observability.HTTPPanics.Value() <= before {
+		t.Errorf("expected panic metric to be incremented")
+	}
+
+	entries := logs.FilterMessage("recovered from panic").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one recovered panic log entry got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["url"] != "/v1/todos" {
+		t.Errorf("expected request fields in the log got %v", fields)
+	}
+	stack, _ := fields["stack"].(string)
+	if !strings.Contains(stack, "TestRecoverPanic") {
+		t.Errorf("expected stack trace of the panic in the log")
+	}
+}
+
+func TestRecoverPanic_HeaderWritten(t *testing.T) {
+	t.Parallel()
+	l := zap.NewNop()
+	handler := recoverPanic(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("after header")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected already written status code to be preserved got %d", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected no error body appended to a partial response")
+	}
+}
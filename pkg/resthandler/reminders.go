@@ -0,0 +1,141 @@
+package resthandler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errReminderNotFound = envelope.NewError(envelope.CodeReminderNotFound, "Reminder not found.")
+	errInvalidReminder  = envelope.NewError(envelope.CodeValidationFailed, "Invalid reminder.",
+		envelope.FieldError{Field: "offset_minutes", Code: envelope.CodeInvalidValue, Message: "Should be 0 to 43200, 30 days."},
+		envelope.FieldError{Field: "channel", Code: envelope.CodeInvalidValue, Message: "Should be email, webhook or log."})
+)
+
+// reminderForm type Decode the submitted json body of a reminder,
+// delivered OffsetMinutes before the todo is due.
+type reminderForm struct {
+	OffsetMinutes int    `json:"offset_minutes"`
+	Channel       string `json:"channel"`
+}
+
+// reminderResponse is the json representation of a reminder, along with
+// where its delivery for the current due time of the todo stands.
+type reminderResponse struct {
+	ID            uuid.UUID `json:"id"`
+	OffsetMinutes int       `json:"offset_minutes"`
+	Channel       string    `json:"channel"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+func newReminderResponse(reminder pkg.ReminderModel) reminderResponse {
+	return reminderResponse{
+		ID:            reminder.ID,
+		OffsetMinutes: int(reminder.Offset / time.Minute),
+		Channel:       reminder.Channel,
+		Status:        string(reminder.Status),
+		Attempts:      reminder.Attempts,
+		LastError:     reminder.LastError,
+	}
+}
+
+// reminderIDsFromReq returns the todo and reminder ids of the route,
+// ok is false if either is not an uuid.
+func reminderIDsFromReq(r *http.Request) (todoID, id uuid.UUID, ok bool) {
+	todoID, ok = todoIDFromReq(r)
+	if !ok {
+		return todoID, id, false
+	}
+	id, err := uuid.Parse(mux.Vars(r)["reminder_id"])
+	return todoID, id, err == nil
+}
+
+func (th todos) listReminders(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	list, err := th.reminders.List(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err List", httpReqField(code, r, err)...)
+		return
+	}
+	resp := make([]reminderResponse, 0, len(list))
+	for _, reminder := range list {
+		resp = append(resp, newReminderResponse(reminder))
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("reminders listed", httpReqField(code, r, nil)...)
+}
+
+// createReminder adds a reminder of the user to the todo. It's delivered once the
+// todo is due within the offset, and again every time the todo is due at a new time.
+func (th todos) createReminder(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form reminderForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	todoID, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	reminder, err := th.reminders.Create(r.Context(), userIDFromReqCtx(r), pkg.ReminderModel{
+		TodoID:  todoID,
+		Offset:  time.Duration(form.OffsetMinutes) * time.Minute,
+		Channel: form.Channel,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Create", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	w.Header().Set("Location", routeTodos+"/"+todoID.String()+"/reminders/"+reminder.ID.String())
+	writeData(w, code, newReminderResponse(reminder), th.logger)
+	th.logger.Info("reminder created", httpReqField(code, r, nil)...)
+}
+
+func (th todos) deleteReminder(w http.ResponseWriter, r *http.Request) {
+	var code int
+	todoID, id, ok := reminderIDsFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrReminderNotFound, th.logger)
+		th.logger.Error("invalid reminder id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	err := th.reminders.Delete(r.Context(), userIDFromReqCtx(r), todoID, id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Delete", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	th.logger.Info("reminder deleted", httpReqField(code, r, nil)...)
+}
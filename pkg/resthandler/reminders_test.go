@@ -0,0 +1,128 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/notifier"
+)
+
+// failingNotifier never delivers.
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, n pkg.Notification) error {
+	return errors.New("webhook responded 503 Service Unavailable")
+}
+
+func TestTodos_Reminders(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	reminders := newMockReminderRepoStorage(repo)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoService(pkg.NewTodoService(repo)),
+		WithReminderService(pkg.NewReminderService(pkg.NewTodoService(repo), reminders)))
+	userID := uuid.New()
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "pay rent", Version: 1, DueAt: time.Now().Add(10 * time.Minute)}
+	repo.todos[todo.ID] = todo
+	target := routeTodos + "/" + todo.ID.String()
+
+	var ids []uuid.UUID
+	for _, body := range []string{`{"offset_minutes":30,"channel":"log"}`, `{"offset_minutes":15,"channel":"webhook"}`} {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodPost, target+"/reminders", userID, body))
+		var resp struct {
+			Data reminderResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusCreated || resp.Data.Status != string(pkg.ReminderPending) {
+			t.Fatalf("expected a pending reminder created got %d %s", rr.Code, rr.Body.String())
+		}
+		ids = append(ids, resp.Data.ID)
+	}
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		userID   uuid.UUID
+		body     string
+		wantCode int
+	}{
+		{name: "other user", method: http.MethodPost, target: target + "/reminders", userID: uuid.New(), body: `{"channel":"log"}`, wantCode: http.StatusNotFound},
+		{name: "unknown channel", method: http.MethodPost, target: target + "/reminders", userID: userID, body: `{"channel":"pigeon"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "after due", method: http.MethodPost, target: target + "/reminders", userID: userID, body: `{"offset_minutes":-5,"channel":"log"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "unknown reminder", method: http.MethodDelete, target: target + "/reminders/" + uuid.New().String(), userID: userID, wantCode: http.StatusNotFound},
+		{name: "invalid reminder id", method: http.MethodDelete, target: target + "/reminders/first", userID: userID, wantCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, step.userID, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	list := func() []reminderResponse {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodGet, target+"/reminders", userID, ""))
+		var resp struct {
+			Data []reminderResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusOK || len(resp.Data) != len(ids) {
+			t.Fatalf("expected the reminders listed got %d %s", rr.Code, rr.Body.String())
+		}
+		return resp.Data
+	}
+
+	scheduler := pkg.NewReminderScheduler(reminders, map[string]pkg.Notifier{
+		pkg.ChannelLog:     notifier.NewLog(l),
+		pkg.ChannelWebhook: failingNotifier{},
+	}, time.Minute)
+	sent, failed, err := scheduler.Deliver(context.Background())
+	if err != nil || sent != 1 || failed != 1 {
+		t.Fatalf("expected 1 reminder sent and 1 failed got %d %d %v", sent, failed, err)
+	}
+	got := list()
+	if got[0].ID != ids[0] || got[0].Status != string(pkg.ReminderSent) {
+		t.Errorf("expected the log reminder sent got %+v", got[0])
+	}
+	if got[1].Status != string(pkg.ReminderPending) || got[1].Attempts != 1 || got[1].LastError == "" {
+		t.Errorf("expected the webhook reminder to be retried got %+v", got[1])
+	}
+
+	// due at another time, the reminders are delivered again
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPut, target, userID, `{"title":"pay rent","due_at":"2031-03-01T09:00:00Z"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the todo updated got %d %s", rr.Code, rr.Body.String())
+	}
+	for _, reminder := range list() {
+		if reminder.Status != string(pkg.ReminderPending) || reminder.Attempts != 0 {
+			t.Errorf("expected the reminder scheduled again got %+v", reminder)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodDelete, target+"/reminders/"+ids[0].String(), userID, ""))
+	if rr.Code != http.StatusNoContent || len(reminders.reminders) != 1 {
+		t.Errorf("expected the reminder deleted got %d %s", rr.Code, rr.Body.String())
+	}
+}
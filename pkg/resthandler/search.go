@@ -0,0 +1,48 @@
+package resthandler
+
+import (
+	"net/http"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+var (
+	errInvalidSearch = envelope.NewError(envelope.CodeValidationFailed, "Invalid search.",
+		envelope.FieldError{Field: "q", Code: envelope.CodeInvalidValue, Message: "Should have a word and be < 256."})
+)
+
+// searchHitResponse is the json representation of a todo matching a search, its Snippet
+// is html of the text around the matches, each in a <mark>.
+type searchHitResponse struct {
+	todoResponse
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// searchTodos responds with the todos of the user whose words start with the ones of the
+// q query parameter, the most relevant first, of the filter if any.
+func (th todos) searchTodos(w http.ResponseWriter, r *http.Request) {
+	var code int
+	filter, ok := parseTodoFilter(r.URL.Query().Get("filter"))
+	if !ok {
+		code = http.StatusBadRequest
+		writeError(w, r, code, errInvalidFilter, th.logger)
+		th.logger.Error("invalid filter", httpReqField(code, r, nil)...)
+		return
+	}
+
+	hits, err := th.search.Search(r.Context(), userIDFromReqCtx(r), r.URL.Query().Get("q"), filter)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Search", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := make([]searchHitResponse, len(hits))
+	for i, hit := range hits {
+		resp[i] = searchHitResponse{todoResponse: newTodoResponse(hit.Todo), Rank: hit.Rank, Snippet: hit.Snippet}
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("todos searched", httpReqField(code, r, nil)...)
+}
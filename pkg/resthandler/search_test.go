@@ -0,0 +1,76 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/storage/memory"
+)
+
+func TestTodos_Search(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoService(pkg.NewTodoService(repo)), WithSearchService(pkg.NewSearchService(memory.NewTodoSearcher(repo))))
+	userID := uuid.New()
+	open := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "Pay <rent>", Content: "before the 5th", Version: 1}
+	done := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "Payroll", Finished: true, Version: 1}
+	repo.todos[open.ID], repo.todos[done.ID] = open, done
+
+	search := func(query url.Values) []searchHitResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeTodosSearch+"?"+query.Encode(), userID, ""))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d got %d %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data []searchHitResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	if hits := search(url.Values{"q": {"pay"}}); len(hits) != 2 {
+		t.Errorf("expected the todos starting with the word got %+v", hits)
+	}
+	hits := search(url.Values{"q": {"PAY"}, "filter": {"unfinished"}})
+	if len(hits) != 1 || hits[0].ID != open.ID || hits[0].Rank <= 0 {
+		t.Fatalf("expected the unfinished todo got %+v", hits)
+	}
+	if want := "<mark>Pay</mark> &lt;rent&gt;"; hits[0].Snippet != want {
+		t.Errorf("expected the snippet %q got %q", want, hits[0].Snippet)
+	}
+	if hits = search(url.Values{"q": {"pay 5th"}, "filter": {"finished"}}); len(hits) != 0 {
+		t.Errorf("expected no finished todo matching all the words got %+v", hits)
+	}
+
+	tcs := []struct {
+		name   string
+		query  url.Values
+		status int
+	}{
+		{name: "no word", query: url.Values{"q": {" ; "}}, status: http.StatusUnprocessableEntity},
+		{name: "invalid filter", query: url.Values{"q": {"pay"}, "filter": {"due"}}, status: http.StatusBadRequest},
+	}
+	for _, tc := range tcs {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeTodosSearch+"?"+tc.query.Encode(), userID, ""))
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %d got %d", tc.name, tc.status, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package resthandler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// maxOccurrences is the most upcoming occurrences listed at once
+const maxOccurrences = 50
+
+var (
+	errSeriesNotFound = envelope.NewError(envelope.CodeSeriesNotFound, "The todo doesn't recur.")
+	errInvalidSeries  = envelope.NewError(envelope.CodeValidationFailed, "Invalid series.",
+		envelope.FieldError{Field: "rrule", Code: envelope.CodeInvalidValue,
+			Message: "Should be an RFC 5545 RRULE of FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH."},
+		envelope.FieldError{Field: "time_zone", Code: envelope.CodeInvalidValue, Message: "Should be an IANA time zone."},
+		envelope.FieldError{Field: "start", Code: envelope.CodeRequired, Message: "Start is required if the todo isn't due."})
+	errInvalidCount = envelope.NewError(envelope.CodeValidationFailed, "Invalid count.",
+		envelope.FieldError{Field: "count", Code: envelope.CodeInvalidValue, Message: "Should be 1 to 50."})
+)
+
+// seriesForm type Decode the submitted json body of the series of a recurring todo.
+// An empty Title is the one of the todo, a nil Start keeps the current one, or
+// is the due time of the todo for a new series.
+type seriesForm struct {
+	RRule    string     `json:"rrule"`
+	TimeZone string     `json:"time_zone"`
+	Start    *time.Time `json:"start"`
+	Title    string     `json:"title"`
+	Content  string     `json:"content"`
+}
+
+// seriesResponse is the json representation of the series of a recurring todo.
+type seriesResponse struct {
+	ID       uuid.UUID `json:"id"`
+	RRule    string    `json:"rrule"`
+	TimeZone string    `json:"time_zone"`
+	Start    time.Time `json:"start"`
+	Title    string    `json:"title"`
+	Content  string    `json:"content"`
+}
+
+func newSeriesResponse(series pkg.SeriesModel) seriesResponse {
+	start := series.Start
+	if loc, err := time.LoadLocation(series.TimeZone); err == nil {
+		start = start.In(loc)
+	}
+	return seriesResponse{
+		ID:       series.ID,
+		RRule:    series.RRule,
+		TimeZone: series.TimeZone,
+		Start:    start,
+		Title:    series.Title,
+		Content:  series.Content,
+	}
+}
+
+// occurrenceResponse is the json representation of an upcoming occurrence,
+// due in the time zone of its series.
+type occurrenceResponse struct {
+	DueAt time.Time `json:"due_at"`
+}
+
+// parseOccurrenceCount returns how many occurrences are listed, 5 by default.
+func parseOccurrenceCount(v string) (int, bool) {
+	if v == "" {
+		return 5, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n > 0 && n <= maxOccurrences
+}
+
+func (th todos) getSeries(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	series, err := th.series.Find(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Find", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newSeriesResponse(series), th.logger)
+	th.logger.Info("series found", httpReqField(code, r, nil)...)
+}
+
+// saveSeries makes the todo a recurring one, or edits its series. Unlike the update
+// of the todo, which only edits that occurrence, it edits all the occurrences to come.
+func (th todos) saveSeries(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form seriesForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	series := pkg.SeriesModel{RRule: form.RRule, TimeZone: form.TimeZone, Title: form.Title, Content: form.Content}
+	if form.Start != nil {
+		series.Start = *form.Start
+	}
+	series, err = th.series.Save(r.Context(), userIDFromReqCtx(r), id, series)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Save", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newSeriesResponse(series), th.logger)
+	th.logger.Info("series saved", httpReqField(code, r, nil)...)
+}
+
+// deleteSeries stops the recurrence of the todo, its occurrences are kept.
+func (th todos) deleteSeries(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	err := th.series.Delete(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Delete", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	th.logger.Info("series deleted", httpReqField(code, r, nil)...)
+}
+
+// occurrences previews the upcoming occurrences of the series, following the todo.
+func (th todos) occurrences(w http.ResponseWriter, r *http.Request) {
+	var code int
+	n, ok := parseOccurrenceCount(r.URL.Query().Get("count"))
+	if !ok {
+		code = http.StatusBadRequest
+		writeError(w, r, code, errInvalidCount, th.logger)
+		th.logger.Error("invalid count", httpReqField(code, r, nil)...)
+		return
+	}
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	list, err := th.series.Upcoming(r.Context(), userIDFromReqCtx(r), id, n)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Upcoming", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := make([]occurrenceResponse, 0, len(list))
+	for _, dueAt := range list {
+		resp = append(resp, occurrenceResponse{DueAt: dueAt})
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("occurrences listed", httpReqField(code, r, nil)...)
+}
@@ -0,0 +1,155 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func TestTodos_Series(t *testing.T) {
+	t.Parallel()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	series := newMockSeriesRepoStorage(repo)
+	svc := pkg.NewRecurringTodoService(repo, nil, pkg.NewAuthorizer(nil), series)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoService(svc), WithSeriesService(pkg.NewSeriesService(svc, series)))
+
+	userID := uuid.New()
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeTodos, userID, `{"title":"on-call","due_at":"2021-03-08T09:00:00-05:00"}`))
+	var created struct {
+		Data todoResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || created.Data.DueAt == nil {
+		t.Fatalf("expected a due todo created got %d %s", rr.Code, rr.Body.String())
+	}
+	target := routeTodos + "/" + created.Data.ID.String()
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		wantCode int
+	}{
+		{name: "not recurring", method: http.MethodGet, target: target + "/series", wantCode: http.StatusNotFound},
+		{name: "no occurrences", method: http.MethodGet, target: target + "/series/occurrences", wantCode: http.StatusNotFound},
+		{name: "invalid rule", method: http.MethodPut, target: target + "/series", body: `{"rrule":"FREQ=WEEKLY;BYDAY=XX","time_zone":"America/New_York"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "invalid time zone", method: http.MethodPut, target: target + "/series", body: `{"rrule":"FREQ=WEEKLY","time_zone":"Mars/Olympus"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "recurring", method: http.MethodPut, target: target + "/series", body: `{"rrule":"freq=weekly;byday=mo","time_zone":"America/New_York","title":"rotate on-call"}`, wantCode: http.StatusOK},
+		{name: "invalid count", method: http.MethodGet, target: target + "/series/occurrences?count=0", wantCode: http.StatusBadRequest},
+		{name: "unknown todo", method: http.MethodGet, target: routeTodos + "/" + uuid.New().String() + "/series", wantCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, userID, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, target+"/series", userID, ""))
+	var found struct {
+		Data seriesResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Data.RRule != "FREQ=WEEKLY;BYDAY=MO" || found.Data.Title != "rotate on-call" {
+		t.Errorf("expected the canonical rule and title of the series got %s", rr.Body.String())
+	}
+
+	// the preview crosses the DST transition at the same wall clock time
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, target+"/series/occurrences?count=2", userID, ""))
+	var upcoming struct {
+		Data []occurrenceResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &upcoming); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{time.Date(2021, 3, 15, 9, 0, 0, 0, loc), time.Date(2021, 3, 22, 9, 0, 0, 0, loc)}
+	if rr.Code != http.StatusOK || len(upcoming.Data) != len(want) {
+		t.Fatalf("expected %d occurrences got %d %s", len(want), rr.Code, rr.Body.String())
+	}
+	for i := range want {
+		if !upcoming.Data[i].DueAt.Equal(want[i]) {
+			t.Errorf("expected the occurrence %v got %v", want[i], upcoming.Data[i].DueAt)
+		}
+	}
+
+	// editing the occurrence leaves the series alone, finishing it stores the next one
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPut, target, userID, `{"title":"swap with bob","finished":true,"due_at":"2021-03-08T09:00:00-05:00"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the occurrence updated got %d %s", rr.Code, rr.Body.String())
+	}
+	next, ok := nextOccurrence(repo, created.Data.ID)
+	if !ok || next.Title != "rotate on-call" || !next.DueAt.Equal(want[0]) || next.Finished {
+		t.Fatalf("expected the next occurrence of the series got %+v", next)
+	}
+
+	// editing the series edits the unfinished occurrences
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPut, target+"/series", userID, `{"rrule":"FREQ=WEEKLY;BYDAY=MO","time_zone":"America/New_York","title":"rotate on-call duty"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the series updated got %d %s", rr.Code, rr.Body.String())
+	}
+	if done := repo.todos[created.Data.ID]; done.Title != "swap with bob" {
+		t.Errorf("expected the finished occurrence left alone got %+v", done)
+	}
+	if next = repo.todos[next.ID]; next.Title != "rotate on-call duty" {
+		t.Errorf("expected the next occurrence edited got %+v", next)
+	}
+
+	// finishing it again doesn't store another one
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPut, target, userID, `{"title":"swap with bob","due_at":"2021-03-08T09:00:00-05:00"}`))
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPut, target, userID, `{"title":"swap with bob","finished":true,"due_at":"2021-03-08T09:00:00-05:00"}`))
+	if rr.Code != http.StatusOK || len(repo.todos) != 2 {
+		t.Errorf("expected a single next occurrence got %d todos", len(repo.todos))
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodDelete, routeTodos+"/"+next.ID.String()+"/series", userID, ""))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the series deleted got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, target+"/series", userID, ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the todo not recurring anymore got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// nextOccurrence returns the other occurrence of the series of the todo.
+func nextOccurrence(repo *_mockTodoRepoStorage, id uuid.UUID) (pkg.TodoModel, bool) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, todo := range repo.todos {
+		if todo.ID != id && todo.SeriesID != uuid.Nil && todo.SeriesID == repo.todos[id].SeriesID {
+			return todo, true
+		}
+	}
+	return pkg.NilTodoModel, false
+}
@@ -0,0 +1,182 @@
+package resthandler
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errInvalidGrant = envelope.NewError(envelope.CodeValidationFailed, "Invalid share.",
+		envelope.FieldError{Field: "role", Code: envelope.CodeInvalidValue, Message: "Should be viewer, editor or owner."},
+		envelope.FieldError{Field: "email", Code: envelope.CodeInvalidValue, Message: "Can't be the owner."})
+	errInviteeNotFound = envelope.NewError(envelope.CodeValidationFailed, "Invalid share.",
+		envelope.FieldError{Field: "email", Code: envelope.CodeInvalidValue, Message: "No user registered with the email."})
+)
+
+var resourceTypeNames = map[pkg.ResourceType]string{
+	pkg.ResourceTodo:    "todo",
+	pkg.ResourceProject: "project",
+}
+
+// shares encapsulates various types of handlerFunc
+// that responds to the share api request of the authenticated user
+type shares struct {
+	svc    pkg.ShareService
+	logger *zap.Logger
+}
+
+// shareForm type Decode the submitted json body of an invitation.
+type shareForm struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// grantResponse is the json representation of a grant.
+type grantResponse struct {
+	ResourceType string    `json:"resource_type"`
+	ResourceID   uuid.UUID `json:"resource_id"`
+	UserID       uuid.UUID `json:"user_id"`
+	Role         string    `json:"role"`
+	GrantedBy    uuid.UUID `json:"granted_by"`
+}
+
+func newGrantResponse(grant pkg.GrantModel) grantResponse {
+	return grantResponse{
+		ResourceType: resourceTypeNames[grant.Resource.Type],
+		ResourceID:   grant.Resource.ID,
+		UserID:       grant.UserID,
+		Role:         grant.Role.String(),
+		GrantedBy:    grant.GrantedBy,
+	}
+}
+
+func newGrantResponses(grants []pkg.GrantModel) []grantResponse {
+	resp := make([]grantResponse, 0, len(grants))
+	for _, grant := range grants {
+		resp = append(resp, newGrantResponse(grant))
+	}
+	return resp
+}
+
+// resourceFromReq returns the resource of the route, ok is false if its id is not an uuid.
+func resourceFromReq(r *http.Request, typ pkg.ResourceType) (pkg.Resource, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	return pkg.Resource{Type: typ, ID: id}, err == nil
+}
+
+// notFoundOf returns the error of a resource of the type that doesn't exist.
+func notFoundOf(typ pkg.ResourceType) error {
+	if typ == pkg.ResourceProject {
+		return serror.ErrProjectNotFound
+	}
+	return serror.ErrTodoNotFound
+}
+
+// invite shares the todo or project of the route with the user registered with the email.
+func (sh shares) invite(typ pkg.ResourceType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var code int
+		var form shareForm
+		err := decodeBody(r, &form)
+		if err != nil {
+			code = writeDecodeError(w, r, err, sh.logger)
+			sh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+			return
+		}
+		resource, ok := resourceFromReq(r, typ)
+		if !ok {
+			code = writeDomainError(w, r, notFoundOf(typ), sh.logger)
+			sh.logger.Error("invalid resource id", httpReqField(code, r, nil)...)
+			return
+		}
+
+		// an unknown role is rejected by the service
+		role, _ := pkg.ParseRole(form.Role)
+		grant, err := sh.svc.Invite(r.Context(), userIDFromReqCtx(r), resource, form.Email, role)
+		if err != nil {
+			code = writeDomainError(w, r, err, sh.logger)
+			sh.logger.Error("err Invite", httpReqField(code, r, err)...)
+			return
+		}
+
+		code = http.StatusCreated
+		w.Header().Set("Location", r.URL.Path+"/"+grant.UserID.String())
+		writeData(w, code, newGrantResponse(grant), sh.logger)
+		sh.logger.Info("share created", httpReqField(code, r, nil)...)
+	}
+}
+
+// list lists the grants shared on the todo or project of the route.
+func (sh shares) list(typ pkg.ResourceType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var code int
+		resource, ok := resourceFromReq(r, typ)
+		if !ok {
+			code = writeDomainError(w, r, notFoundOf(typ), sh.logger)
+			sh.logger.Error("invalid resource id", httpReqField(code, r, nil)...)
+			return
+		}
+
+		grants, err := sh.svc.Grants(r.Context(), userIDFromReqCtx(r), resource)
+		if err != nil {
+			code = writeDomainError(w, r, err, sh.logger)
+			sh.logger.Error("err Grants", httpReqField(code, r, err)...)
+			return
+		}
+		code = http.StatusOK
+		writeData(w, code, newGrantResponses(grants), sh.logger)
+		sh.logger.Info("shares listed", httpReqField(code, r, nil)...)
+	}
+}
+
+// revoke revokes the grant of the user of the route on the todo or project.
+func (sh shares) revoke(typ pkg.ResourceType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var code int
+		resource, ok := resourceFromReq(r, typ)
+		if !ok {
+			code = writeDomainError(w, r, notFoundOf(typ), sh.logger)
+			sh.logger.Error("invalid resource id", httpReqField(code, r, nil)...)
+			return
+		}
+		granteeID, err := uuid.Parse(mux.Vars(r)["user_id"])
+		if err != nil {
+			code = writeDomainError(w, r, serror.ErrGrantNotFound, sh.logger)
+			sh.logger.Error("invalid user id", httpReqField(code, r, nil)...)
+			return
+		}
+
+		err = sh.svc.Revoke(r.Context(), userIDFromReqCtx(r), resource, granteeID)
+		if err != nil {
+			code = writeDomainError(w, r, err, sh.logger)
+			sh.logger.Error("err Revoke", httpReqField(code, r, err)...)
+			return
+		}
+
+		code = http.StatusNoContent
+		w.Header().Del("Content-Type")
+		w.WriteHeader(code)
+		sh.logger.Info("share revoked", httpReqField(code, r, nil)...)
+	}
+}
+
+// sharedWithMe lists the todos and projects shared with the user.
+func (sh shares) sharedWithMe(w http.ResponseWriter, r *http.Request) {
+	var code int
+	grants, err := sh.svc.SharedWith(r.Context(), userIDFromReqCtx(r))
+	if err != nil {
+		code = writeDomainError(w, r, err, sh.logger)
+		sh.logger.Error("err SharedWith", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newGrantResponses(grants), sh.logger)
+	sh.logger.Info("shared with me listed", httpReqField(code, r, nil)...)
+}
@@ -0,0 +1,144 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func TestShares(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	projects := newMockProjectRepoStorage(repo)
+	ownerID, inviteeID := uuid.New(), uuid.New()
+	users := &_mockUserRepoStorage{returnFunc: func() pkg.UserModel {
+		return pkg.UserModel{ID: inviteeID, Email: "invitee@example.com"}
+	}}
+	grants := &_mockGrantRepoStorage{}
+	svc := pkg.NewShareService(repo, projects, users, grants, pkg.NewAuthorizer(grants))
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(users), userTokenizer{}),
+		WithTodoService(pkg.NewSharedTodoService(repo, projects, svc.Authorizer())),
+		WithProjectService(pkg.NewSharedProjectService(projects, svc.Authorizer())),
+		WithShareService(svc))
+
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: ownerID, Title: "shared", Version: 1}
+	repo.todos[todo.ID] = todo
+	target := routeTodos + "/" + todo.ID.String()
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		userID   uuid.UUID
+		body     string
+		wantCode int
+	}{
+		{name: "not shared yet", method: http.MethodGet, target: target, userID: inviteeID, wantCode: http.StatusNotFound},
+		{name: "invalid role", method: http.MethodPost, target: target + "/shares", userID: ownerID, body: `{"email":"invitee@example.com","role":"admin"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "invite viewer", method: http.MethodPost, target: target + "/shares", userID: ownerID, body: `{"email":"invitee@example.com","role":"viewer"}`, wantCode: http.StatusCreated},
+		{name: "viewer reads", method: http.MethodGet, target: target, userID: inviteeID, wantCode: http.StatusOK},
+		{name: "viewer writes", method: http.MethodPut, target: target, userID: inviteeID, body: `{"title":"edited"}`, wantCode: http.StatusForbidden},
+		{name: "viewer shares", method: http.MethodGet, target: target + "/shares", userID: inviteeID, wantCode: http.StatusForbidden},
+		{name: "stranger reads", method: http.MethodGet, target: target, userID: uuid.New(), wantCode: http.StatusNotFound},
+		{name: "invite editor", method: http.MethodPost, target: target + "/shares", userID: ownerID, body: `{"email":"invitee@example.com","role":"editor"}`, wantCode: http.StatusCreated},
+		{name: "editor writes", method: http.MethodPut, target: target, userID: inviteeID, body: `{"title":"edited"}`, wantCode: http.StatusOK},
+		{name: "revoked", method: http.MethodDelete, target: target + "/shares/" + inviteeID.String(), userID: ownerID, wantCode: http.StatusNoContent},
+		{name: "revoked twice", method: http.MethodDelete, target: target + "/shares/" + inviteeID.String(), userID: ownerID, wantCode: http.StatusNotFound},
+		{name: "revoked reads", method: http.MethodGet, target: target, userID: inviteeID, wantCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, step.userID, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+		if step.name == "editor writes" && repo.todos[todo.ID].UserID != ownerID {
+			t.Errorf("expected the todo still owned by its owner got %+v", repo.todos[todo.ID])
+		}
+	}
+}
+
+func TestShares_Project(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	projects := newMockProjectRepoStorage(repo)
+	ownerID, inviteeID := uuid.New(), uuid.New()
+	users := &_mockUserRepoStorage{returnFunc: func() pkg.UserModel {
+		return pkg.UserModel{ID: inviteeID, Email: "invitee@example.com"}
+	}}
+	grants := &_mockGrantRepoStorage{}
+	svc := pkg.NewShareService(repo, projects, users, grants, pkg.NewAuthorizer(grants))
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(users), userTokenizer{}),
+		WithTodoService(pkg.NewSharedTodoService(repo, projects, svc.Authorizer())),
+		WithProjectService(pkg.NewSharedProjectService(projects, svc.Authorizer())),
+		WithShareService(svc))
+
+	project := pkg.ProjectModel{ID: uuid.New(), UserID: ownerID, Name: "team"}
+	projects.projects[project.ID] = project
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: project.ID, Title: "in the project", Version: 1}
+	repo.todos[todo.ID] = todo
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeProjects+"/"+project.ID.String()+"/shares", ownerID,
+		`{"email":"invitee@example.com","role":"editor"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// the todos of the project are shared along
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeTodos+"?project="+project.ID.String(), inviteeID, ""))
+	var listed struct {
+		Data []todoResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(listed.Data) != 1 || listed.Data[0].ID != todo.ID {
+		t.Errorf("expected the todo of the shared project listed got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// a todo created by the editor is owned by the owner of the project
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeTodos, inviteeID, `{"title":"added","project_id":"`+project.ID.String()+`"}`))
+	var created struct {
+		Data todoResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || repo.todos[created.Data.ID].UserID != ownerID {
+		t.Errorf("expected the todo created in the shared project got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// only the owner deletes the project
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodDelete, routeProjects+"/"+project.ID.String(), inviteeID, ""))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status code %d got %d %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeSharedWithMe, inviteeID, ""))
+	var shared struct {
+		Data []grantResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &shared); err != nil {
+		t.Fatal(err)
+	}
+	want := grantResponse{ResourceType: "project", ResourceID: project.ID, UserID: inviteeID, Role: "editor", GrantedBy: ownerID}
+	if rr.Code != http.StatusOK || len(shared.Data) != 1 || shared.Data[0] != want {
+		t.Errorf("expected the project shared with me got %d %s", rr.Code, rr.Body.String())
+	}
+}
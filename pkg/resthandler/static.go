@@ -4,13 +4,20 @@ import (
 	"net/http"
 
 	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
 )
 
 var (
-	homeRouteStaticResponse = getRespMsg("hello world from todo rest svc")
-	healthiest              = getJSONResp(`{"alive": true}`)
+	homeRouteStaticResponse = envelope.Message("hello world from todo rest svc")
+	healthiest              = envelope.Data(healthStatus{Alive: true})
 )
 
+// healthStatus is the data of health check response.
+type healthStatus struct {
+	Alive bool `json:"alive"`
+}
+
 type staticHandler struct {
 	logger *zap.Logger
 }
@@ -21,15 +28,11 @@ func newStaticHandler(logger *zap.Logger) staticHandler {
 }
 
 func (sh staticHandler) home(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write(homeRouteStaticResponse)
-	checkResponseWriteErr(err, sh.logger)
+	writeResponse(w, http.StatusOK, homeRouteStaticResponse, sh.logger)
 	sh.logger.Info("homepage", httpReqField(http.StatusOK, r, nil)...)
 }
 
 func (sh staticHandler) healthLive(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write(healthiest)
-	checkResponseWriteErr(err, sh.logger)
+	writeResponse(w, http.StatusOK, healthiest, sh.logger)
 	sh.logger.Info("healthlive", httpReqField(http.StatusOK, r, nil)...)
 }
@@ -27,7 +27,7 @@ func TestStaticHandler_Home(t *testing.T) {
 			status, http.StatusOK)
 	}
 
-	expected := `{"message": "hello world from todo rest svc"}`
+	expected := `{"success":true,"data":{"message":"hello world from todo rest svc"}}`
 	if !strings.Contains(rr.Body.String(), expected) {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -49,7 +49,7 @@ func TestStaticHandler_HealthAliveness(t *testing.T) {
 			status, http.StatusOK)
 	}
 
-	expected := `{"alive": true}`
+	expected := `{"success":true,"data":{"alive":true}}`
 	if !strings.Contains(rr.Body.String(), expected) {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -0,0 +1,130 @@
+package resthandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	// eventStreamReset tells the client it missed events, and should read the todos again.
+	eventStreamReset = "stream.reset"
+	// the client reconnects after the retry, carrying the id of the last event it got.
+	eventStreamRetry       = 3 * time.Second
+	defaultStreamHeartbeat = 15 * time.Second
+)
+
+var (
+	errStreamNotAcceptable = envelope.NewError(envelope.CodeNotAcceptable, "Response can only be text/event-stream.")
+	errInvalidLastEventID  = envelope.NewError(envelope.CodeInvalidRequest, "Invalid Last-Event-ID header.")
+)
+
+// todoStream responds with the Server-Sent Events of the todos visible to
+// the authenticated user, as they are created, updated and deleted.
+type todoStream struct {
+	stream *pkg.TodoStream
+	logger *zap.Logger
+	// a comment is sent after the heartbeat without any event, so the idle
+	// connection isn't closed by the proxies.
+	heartbeat time.Duration
+	// the stream is ended after the lifetime, before the write timeout of the server.
+	lifetime time.Duration
+}
+
+// serve streams the events logged after the one of the Last-Event-ID header,
+// or the ones logged from now on without it.
+func (sh todoStream) serve(w http.ResponseWriter, r *http.Request) {
+	var code int
+	if negotiate(r.Header.Get("Accept"), eventStreamContentType) == "" {
+		code = http.StatusNotAcceptable
+		writeError(w, r, code, errStreamNotAcceptable, sh.logger)
+		sh.logger.Error("not acceptable", httpReqField(code, r, nil)...)
+		return
+	}
+	var lastEventID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		var err error
+		if lastEventID, err = strconv.ParseInt(v, 10, 64); err != nil || lastEventID < 0 {
+			code = http.StatusBadRequest
+			writeError(w, r, code, errInvalidLastEventID, sh.logger)
+			sh.logger.Error("invalid last event id", httpReqField(code, r, err)...)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		code = http.StatusInternalServerError
+		writeInternalServerError(w, r, sh.logger)
+		sh.logger.Error("response writer can't flush", httpReqField(code, r, nil)...)
+		return
+	}
+	userID := userIDFromReqCtx(r)
+	sub, err := sh.stream.Subscribe(r.Context(), userID, lastEventID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		writeInternalServerError(w, r, sh.logger)
+		sh.logger.Error("err subscribing to the todo stream", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusOK
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx would buffer the stream otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(code)
+	_, err = fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry.Milliseconds())
+	flusher.Flush()
+
+	ctx, cancel := context.WithTimeout(r.Context(), sh.lifetime)
+	defer cancel()
+	for err == nil {
+		waitCtx, cancelWait := context.WithTimeout(ctx, sh.heartbeat)
+		var events []pkg.Event
+		events, err = sub.Next(waitCtx)
+		idle := waitCtx.Err() != nil
+		cancelWait()
+		switch {
+		case err == nil:
+			for _, e := range events {
+				if err = writeEvent(w, e.ID, e.Type, e.Data); err != nil {
+					break
+				}
+			}
+		case ctx.Err() != nil:
+			// the client left, or the lifetime is over.
+			sh.logger.Info("todo stream ended", httpReqField(code, r, nil)...)
+			return
+		case idle:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case errors.Is(err, pkg.ErrEventGone):
+			// the events missed can't be told, only that they were.
+			if sub, err = sh.stream.Subscribe(ctx, userID, 0); err == nil {
+				err = writeEvent(w, sub.LastEventID(), eventStreamReset, json.RawMessage("{}"))
+			}
+		}
+		flusher.Flush()
+	}
+	sh.logger.Error("todo stream failed", httpReqField(code, r, err)...)
+}
+
+// writeEvent writes the event with its data on a single line.
+func writeEvent(w http.ResponseWriter, id int64, typ string, data json.RawMessage) error {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, typ, buf.Bytes())
+	return err
+}
@@ -0,0 +1,144 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// sseEvent is an event read from a text/event-stream, a comment has no event.
+type sseEvent struct {
+	id, event, data, comment string
+}
+
+// readEvent reads the next event of the stream, or the next comment.
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected an event got %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return e
+		case strings.HasPrefix(line, ":"):
+			e.comment = strings.TrimSpace(line[1:])
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[len("id: "):]
+		case strings.HasPrefix(line, "event: "):
+			e.event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			e.data = line[len("data: "):]
+		}
+	}
+}
+
+func TestTodoStream(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	elog := newMockEventLog()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := pkg.NewTodoStream(elog, pkg.Authorizer{})
+	go stream.Run(ctx, time.Millisecond, nil)
+	withHeartbeat := func(mh *MuxHandler) {
+		mh.stream.heartbeat = 50 * time.Millisecond
+	}
+	// served on their own, like by the server of the streams
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithTodoStream(stream, time.Minute), withHeartbeat)
+	srv := httptest.NewServer(mh)
+	defer srv.Close()
+
+	userID, otherID := uuid.New(), uuid.New()
+	todoEvent := func(typ string, userID uuid.UUID) pkg.Event {
+		todoID := uuid.New()
+		data := `{"id": "` + todoID.String() + `",` + "\n" + `"user_id": "` + userID.String() + `"}`
+		return pkg.Event{Type: typ, UserID: userID, SubjectID: todoID, Data: json.RawMessage(data)}
+	}
+	open := func(accept, lastEventID string) *http.Response {
+		t.Helper()
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+routeTodosStream, nil)
+		r = r.WithContext(ctx)
+		r.Header.Set("Authorization", "Bearer "+userID.String())
+		r.Header.Set("Accept", accept)
+		if lastEventID != "" {
+			r.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("expected a nil error for the stream got %v", err)
+		}
+		return resp
+	}
+
+	resp := open(jsonContentType, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected status %d for a json only client got %d", http.StatusNotAcceptable, resp.StatusCode)
+	}
+	resp = open(eventStreamContentType, "soon")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid Last-Event-ID got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp = open(eventStreamContentType, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != eventStreamContentType {
+		t.Fatalf("expected an event stream got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	body := bufio.NewReader(resp.Body)
+	readEvent(t, body) // retry
+	if e := readEvent(t, body); e.comment != "heartbeat" {
+		t.Errorf("expected a heartbeat got %+v", e)
+	}
+
+	elog.log(todoEvent(pkg.EventTodoCreated, otherID))
+	created := elog.log(todoEvent(pkg.EventTodoCreated, userID))
+	elog.log(todoEvent(pkg.EventTodoCompleted, userID))
+	e := readEvent(t, body)
+	for e.comment == "heartbeat" {
+		e = readEvent(t, body)
+	}
+	if e.id != "2" || e.event != pkg.EventTodoCreated || strings.Contains(e.data, "\n") {
+		t.Errorf("expected the todo created on one line got %+v", e)
+	}
+	if e = readEvent(t, body); e.id != "3" || e.event != pkg.EventTodoCompleted {
+		t.Errorf("expected the todo completed got %+v", e)
+	}
+
+	// resumed after the created one
+	resumed := open(eventStreamContentType, "2")
+	defer resumed.Body.Close()
+	body = bufio.NewReader(resumed.Body)
+	readEvent(t, body)
+	if e = readEvent(t, body); e.id != "3" || e.event != pkg.EventTodoCompleted {
+		t.Errorf("expected the events after %d got %+v", created, e)
+	}
+
+	// resumed after an event no longer in the log
+	gone := open(eventStreamContentType, "42")
+	defer gone.Body.Close()
+	body = bufio.NewReader(gone.Body)
+	readEvent(t, body)
+	if e = readEvent(t, body); e.id != "3" || e.event != eventStreamReset {
+		t.Errorf("expected the stream reset got %+v", e)
+	}
+}
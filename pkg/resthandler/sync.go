@@ -0,0 +1,246 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+)
+
+// maxSyncChanges is the maximum number of changes of a single sync request
+const maxSyncChanges = 500
+
+// sync change ops
+const (
+	syncUpsert = "upsert"
+	syncDelete = "delete"
+)
+
+var (
+	errInvalidSync      = envelope.NewError(envelope.CodeValidationFailed, "Invalid sync.")
+	errInvalidSyncToken = envelope.NewError(envelope.CodeValidationFailed, "Invalid sync token.",
+		envelope.FieldError{Field: "token", Code: envelope.CodeInvalidValue, Message: "Should be the token of the last sync."})
+)
+
+// syncOutcomes are the names of the outcomes of the changes.
+var syncOutcomes = map[pkg.SyncOutcome]string{
+	pkg.SyncApplied:  "applied",
+	pkg.SyncMerged:   "merged",
+	pkg.SyncConflict: "conflict",
+	pkg.SyncRejected: "rejected",
+}
+
+// todoSync responds to the delta sync of the todos of the authenticated user.
+type todoSync struct {
+	svc    pkg.SyncService
+	logger *zap.Logger
+}
+
+// syncForm type Decode the submitted json body of a sync. The Token is the one
+// of the last sync, empty to sync from scratch.
+type syncForm struct {
+	Token   string           `json:"token"`
+	Changes []syncChangeForm `json:"changes"`
+}
+
+// syncChangeForm is a change made offline to a todo, since its BaseVersion synced last,
+// zero for a todo created offline with its own ID. Changes are only the fields changed,
+// and Base their values at the BaseVersion.
+type syncChangeForm struct {
+	Op          string         `json:"op"`
+	ID          string         `json:"id"`
+	BaseVersion int64          `json:"base_version"`
+	Base        todoFieldsForm `json:"base"`
+	Changes     todoFieldsForm `json:"changes"`
+}
+
+// todoFieldsForm are some of the fields of a todo, the missing ones are left out.
+type todoFieldsForm struct {
+	Title      *string    `json:"title"`
+	Content    *string    `json:"content"`
+	Finished   *bool      `json:"finished"`
+	AutoFinish *bool      `json:"auto_finish"`
+	ProjectID  *uuid.UUID `json:"project_id"`
+	// DueAt is null for the todo to not be due
+	DueAt nullableTime `json:"due_at"`
+}
+
+func (f todoFieldsForm) toTodoFields() pkg.TodoFields {
+	fields := pkg.TodoFields{
+		Title:      f.Title,
+		Content:    f.Content,
+		Finished:   f.Finished,
+		AutoFinish: f.AutoFinish,
+		ProjectID:  f.ProjectID,
+	}
+	if f.DueAt.set {
+		dueAt := f.DueAt.time
+		fields.DueAt = &dueAt
+	}
+	return fields
+}
+
+// nullableTime is a time of a json field that can be null, set
+// tells the field is there, even if null.
+type nullableTime struct {
+	set  bool
+	time time.Time
+}
+
+func (t *nullableTime) UnmarshalJSON(data []byte) error {
+	t.set = true
+	if string(data) == "null" {
+		t.time = time.Time{}
+		return nil
+	}
+	return json.Unmarshal(data, &t.time)
+}
+
+// syncResponse carries the results of the changes in the same order, and the todos
+// changed since the token, the deleted ones as tombstones. If Reset, the todos are all
+// the ones of the user. If More, the client syncs again with the Token for the rest.
+type syncResponse struct {
+	Token      string              `json:"token"`
+	More       bool                `json:"more"`
+	Reset      bool                `json:"reset"`
+	Results    []syncResult        `json:"results"`
+	Todos      []todoResponse      `json:"todos"`
+	Tombstones []tombstoneResponse `json:"tombstones"`
+}
+
+// syncResult is the outcome of a single change, the Todo as it's stored once resolved
+// unless Deleted, with the fields of the change that kept the value of the server.
+type syncResult struct {
+	ID        uuid.UUID       `json:"id"`
+	Outcome   string          `json:"outcome"`
+	Todo      *todoResponse   `json:"todo,omitempty"`
+	Deleted   bool            `json:"deleted"`
+	Conflicts []string        `json:"conflicts,omitempty"`
+	Error     *envelope.Error `json:"error,omitempty"`
+}
+
+// tombstoneResponse is a todo deleted since the token.
+type tombstoneResponse struct {
+	ID      uuid.UUID `json:"id"`
+	Version int64     `json:"version"`
+}
+
+// toTodoChanges validates the changes of the form and returns them as todo changes.
+// The field errors of all the invalid changes are returned, pointing at them.
+func (sf syncForm) toTodoChanges() ([]pkg.TodoChange, []envelope.FieldError) {
+	if len(sf.Changes) > maxSyncChanges {
+		return nil, []envelope.FieldError{{
+			Field:   "/changes",
+			Code:    envelope.CodeInvalidLength,
+			Message: fmt.Sprintf("Should have at most %d changes.", maxSyncChanges),
+		}}
+	}
+
+	var details []envelope.FieldError
+	changes := make([]pkg.TodoChange, len(sf.Changes))
+	for i, c := range sf.Changes {
+		at := fmt.Sprintf("/changes/%d", i)
+		change := pkg.TodoChange{BaseVersion: c.BaseVersion, Base: c.Base.toTodoFields(), Changes: c.Changes.toTodoFields()}
+		switch c.Op {
+		case syncUpsert:
+			change.Op = pkg.TodoChangeUpsert
+		case syncDelete:
+			change.Op = pkg.TodoChangeDelete
+		default:
+			details = append(details, envelope.FieldError{Field: at + "/op", Code: envelope.CodeInvalidValue,
+				Message: "Should be upsert or delete."})
+			continue
+		}
+		id, err := uuid.Parse(c.ID)
+		if err != nil || id == uuid.Nil {
+			details = append(details, envelope.FieldError{Field: at + "/id", Code: envelope.CodeInvalidValue,
+				Message: "Should be the id of a todo."})
+			continue
+		}
+		change.ID = id
+		switch {
+		case c.BaseVersion < 0 || (change.Op == pkg.TodoChangeDelete && c.BaseVersion == 0):
+			details = append(details, envelope.FieldError{Field: at + "/base_version", Code: envelope.CodeInvalidValue,
+				Message: "Should be the version of the todo synced last."})
+			continue
+		case change.Op == pkg.TodoChangeUpsert && c.BaseVersion == 0 && c.Changes.Title == nil:
+			details = append(details, envelope.FieldError{Field: at + "/changes/title", Code: envelope.CodeRequired,
+				Message: "Title is required."})
+			continue
+		}
+		changes[i] = change
+	}
+	return changes, details
+}
+
+// newSyncResponse returns the response of the result, the errors of the
+// rejected changes are the ones of the equivalent single todo request.
+func newSyncResponse(result pkg.SyncResult) syncResponse {
+	resp := syncResponse{
+		Token:      result.Token,
+		More:       result.More,
+		Reset:      result.Reset,
+		Results:    make([]syncResult, len(result.Results)),
+		Todos:      make([]todoResponse, 0, len(result.Todos)),
+		Tombstones: []tombstoneResponse{},
+	}
+	for i, r := range result.Results {
+		sr := syncResult{ID: r.ID, Outcome: syncOutcomes[r.Outcome], Deleted: r.Deleted, Conflicts: r.Conflicts}
+		switch {
+		case r.Err != nil:
+			_, apiErr := domainErrorFor(r.Err)
+			sr.Error = &apiErr
+		case !r.Deleted:
+			todo := newTodoResponse(r.Todo)
+			sr.Todo = &todo
+		}
+		resp.Results[i] = sr
+	}
+	for _, synced := range result.Todos {
+		if synced.Deleted {
+			resp.Tombstones = append(resp.Tombstones, tombstoneResponse{ID: synced.Todo.ID, Version: synced.Todo.Version})
+			continue
+		}
+		resp.Todos = append(resp.Todos, newTodoResponse(synced.Todo))
+	}
+	return resp
+}
+
+// sync applies the changes the user made offline, and responds with their outcome and
+// the todos changed since the token of the last sync. The request succeeds as a whole,
+// even if some changes are in conflict or rejected, each carrying its own outcome.
+func (sh todoSync) sync(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form syncForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, sh.logger)
+		sh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	changes, details := form.toTodoChanges()
+	if len(details) != 0 {
+		code = http.StatusUnprocessableEntity
+		writeError(w, r, code, envelope.NewError(errInvalidSync.Code, errInvalidSync.Message, details...), sh.logger)
+		sh.logger.Error("invalid sync", httpReqField(code, r, nil)...)
+		return
+	}
+
+	result, err := sh.svc.Sync(r.Context(), userIDFromReqCtx(r), form.Token, changes)
+	if err != nil {
+		code = writeDomainError(w, r, err, sh.logger)
+		sh.logger.Error("err Sync", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusOK
+	writeData(w, code, newSyncResponse(result), sh.logger)
+	sh.logger.Info("todos synced", httpReqField(code, r, nil)...)
+}
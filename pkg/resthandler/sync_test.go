@@ -0,0 +1,140 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func newSyncTestHandler(t *testing.T) (*MuxHandler, *_mockTodoRepoStorage, *_mockEventLog) {
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	elog := newMockEventLog()
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithSyncService(pkg.NewSyncService(pkg.NewTodoService(repo), repo, elog)))
+	return mh, repo, elog
+}
+
+func TestSync(t *testing.T) {
+	t.Parallel()
+	mh, repo, elog := newSyncTestHandler(t)
+	userID := uuid.New()
+	due := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "due", Version: 1,
+		DueAt: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)}
+	repo.todos[due.ID] = due
+	createdID := uuid.New()
+
+	sync := func(body string) syncResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeSync, userID, body))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d got %d %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data syncResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	first := sync(fmt.Sprintf(`{"changes":[
+		{"op":"upsert","id":"%s","changes":{"title":"offline"}},
+		{"op":"upsert","id":"%s","base_version":1,"base":{"due_at":"2026-10-19T09:00:00Z"},"changes":{"due_at":null}},
+		{"op":"delete","id":"%s","base_version":1}
+	]}`, createdID, due.ID, uuid.New()))
+	if len(first.Results) != 3 {
+		t.Fatalf("expected 3 results got %+v", first.Results)
+	}
+	if r := first.Results[0]; r.Outcome != "applied" || r.Todo == nil || r.Todo.ID != createdID {
+		t.Errorf("expected the todo created with the client id got %+v", r)
+	}
+	if r := first.Results[1]; r.Outcome != "applied" || r.Todo == nil || r.Todo.DueAt != nil || r.Todo.Version != 2 {
+		t.Errorf("expected the due time cleared got %+v", r)
+	}
+	if r := first.Results[2]; r.Outcome != "applied" || !r.Deleted || r.Todo != nil {
+		t.Errorf("expected the missing todo deleted already got %+v", r)
+	}
+	if !first.Reset || len(first.Todos) != 2 || len(first.Tombstones) != 0 || first.Token != "0" {
+		t.Errorf("expected all the todos from scratch got %+v", first)
+	}
+
+	elog.log(pkg.Event{Type: pkg.EventTodoDeleted, UserID: userID, SubjectID: due.ID,
+		Data: []byte(`{"id":"` + due.ID.String() + `","user_id":"` + userID.String() + `","version":3}`)})
+	next := sync(`{"token":"` + first.Token + `"}`)
+	if next.Reset || len(next.Todos) != 0 || len(next.Tombstones) != 1 || next.Token != "1" {
+		t.Fatalf("expected the tombstone of the todo deleted since got %+v", next)
+	}
+	if ts := next.Tombstones[0]; ts.ID != due.ID || ts.Version != 3 {
+		t.Errorf("expected the tombstone of the todo got %+v", ts)
+	}
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeSync, userID, `{"token":"latest"}`))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"token"`) {
+		t.Errorf("expected the invalid token rejected got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSyncInvalid(t *testing.T) {
+	t.Parallel()
+	mh, _, _ := newSyncTestHandler(t)
+
+	tcs := []struct {
+		name      string
+		body      string
+		wantField []string
+	}{
+		{
+			name:      "too many",
+			body:      `{"changes":[` + strings.Repeat(`{"op":"delete"},`, maxSyncChanges) + `{"op":"delete"}]}`,
+			wantField: []string{"/changes"},
+		},
+		{
+			name: "invalid changes",
+			body: fmt.Sprintf(`{"changes":[{"op":"archive"},{"op":"upsert","id":"1"},{"op":"delete","id":"%s"},
+				{"op":"upsert","id":"%s","changes":{"content":"no title"}}]}`, uuid.New(), uuid.New()),
+			wantField: []string{"/changes/0/op", "/changes/1/id", "/changes/2/base_version", "/changes/3/changes/title"},
+		},
+	}
+
+	for _, tc := range tcs {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeSync, uuid.New(), tc.body))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status code %d got %d", tc.name, http.StatusUnprocessableEntity, rr.Code)
+			continue
+		}
+		var resp struct {
+			Errors []struct {
+				Details []struct {
+					Field string `json:"field"`
+				} `json:"details"`
+			} `json:"errors"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range resp.Errors[0].Details {
+			got = append(got, d.Field)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.wantField, ",") {
+			t.Errorf("%s: expected errors at %v got %v", tc.name, tc.wantField, got)
+		}
+	}
+}
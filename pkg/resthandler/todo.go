@@ -0,0 +1,554 @@
+package resthandler
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	// failure msg
+	errInvalidFilter = envelope.NewError(envelope.CodeValidationFailed, "Invalid filter.",
+		envelope.FieldError{Field: "filter", Code: envelope.CodeInvalidValue, Message: "Should be finished or unfinished."})
+	errInvalidTodo = envelope.NewError(envelope.CodeValidationFailed, "Invalid todo.",
+		envelope.FieldError{Field: "title", Code: envelope.CodeInvalidLength, Message: "Title is required and should be < 255."})
+	errInvalidInclude = envelope.NewError(envelope.CodeValidationFailed, "Invalid include.",
+		envelope.FieldError{Field: "include", Code: envelope.CodeInvalidValue, Message: "Should be items."})
+	errInvalidProjectFilter = envelope.NewError(envelope.CodeValidationFailed, "Invalid project.",
+		envelope.FieldError{Field: "project", Code: envelope.CodeInvalidValue, Message: "Should be a project id."})
+	errPreconditionFailed = envelope.NewError(envelope.CodePreconditionFailed,
+		"Todo has been modified, If-Match doesn't match the current ETag.")
+)
+
+// patchAttempts bounds how many times a patch without If-Match is applied again
+// on a todo changed between its read and its update.
+const patchAttempts = 3
+
+// todos encapsulates various types of handlerFunc
+// that responds to todo api request of the authenticated user
+type todos struct {
+	svc pkg.TodoService
+	// items is nil if the checklist items are disabled
+	items *pkg.ItemService
+	// series is nil if the recurring todos are disabled
+	series *pkg.SeriesService
+	// reminders is nil if the reminders are disabled
+	reminders *pkg.ReminderService
+	// search is nil if the search is disabled
+	search *pkg.SearchService
+	logger *zap.Logger
+}
+
+// todoForm type Decode the submitted json body of a todo.
+// A nil ProjectID files a new todo under the inbox, and keeps the project of an updated one.
+type todoForm struct {
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Finished   bool      `json:"finished"`
+	AutoFinish bool      `json:"auto_finish"`
+	ProjectID  uuid.UUID `json:"project_id"`
+	// DueAt is nil if the todo isn't due
+	DueAt *time.Time `json:"due_at"`
+}
+
+func (f todoForm) dueAt() time.Time {
+	if f.DueAt == nil {
+		return time.Time{}
+	}
+	return *f.DueAt
+}
+
+// todoResponse is the json representation of a todo.
+type todoResponse struct {
+	ID         uuid.UUID `json:"id"`
+	ProjectID  uuid.UUID `json:"project_id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Finished   bool      `json:"finished"`
+	AutoFinish bool      `json:"auto_finish"`
+	Version    int64     `json:"version"`
+	// DueAt is only set on the todos that are due
+	DueAt *time.Time `json:"due_at,omitempty"`
+	// SeriesID is only set on the recurring todos
+	SeriesID *uuid.UUID `json:"series_id,omitempty"`
+	// DeletedAt is only set on the todos in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Progress is only set if the checklist items are enabled
+	Progress *progressResponse `json:"progress,omitempty"`
+	// Items are only set if included with ?include=items
+	Items []itemResponse `json:"items,omitempty"`
+}
+
+func newTodoResponse(todo pkg.TodoModel) todoResponse {
+	resp := todoResponse{
+		ID:         todo.ID,
+		ProjectID:  todo.ProjectID,
+		Title:      todo.Title,
+		Content:    todo.Content,
+		Finished:   todo.Finished,
+		AutoFinish: todo.AutoFinish,
+		Version:    todo.Version,
+	}
+	if !todo.DueAt.IsZero() {
+		dueAt := todo.DueAt
+		resp.DueAt = &dueAt
+	}
+	if todo.SeriesID != uuid.Nil {
+		seriesID := todo.SeriesID
+		resp.SeriesID = &seriesID
+	}
+	if !todo.DeletedAt.IsZero() {
+		deletedAt := todo.DeletedAt.UTC()
+		resp.DeletedAt = &deletedAt
+	}
+	return resp
+}
+
+// todoDocument returns the json document of the todo a patch is applied on,
+// which are the members of the todoForm. The due_at is left out if the todo isn't due.
+func todoDocument(todo pkg.TodoModel) map[string]interface{} {
+	doc := map[string]interface{}{
+		"title":       todo.Title,
+		"content":     todo.Content,
+		"finished":    todo.Finished,
+		"auto_finish": todo.AutoFinish,
+		"project_id":  todo.ProjectID.String(),
+	}
+	if !todo.DueAt.IsZero() {
+		doc["due_at"] = todo.DueAt.Format(time.RFC3339Nano)
+	}
+	return doc
+}
+
+// todoFromDocument validates the patched doc against the todo schema and
+// sets its members on the todo. A removed content, finished, auto_finish or due_at
+// is reset to its zero value, a removed project_id keeps the current project,
+// while the title is required.
+func todoFromDocument(doc interface{}, todo pkg.TodoModel) (pkg.TodoModel, error) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return todo, invalidPatch(http.StatusUnprocessableEntity, "", envelope.CodeInvalidType,
+			"Patched todo should be an object.")
+	}
+	if _, ok := obj["title"]; !ok {
+		return todo, invalidPatch(http.StatusUnprocessableEntity, "/title", envelope.CodeRequired,
+			"Title is required.")
+	}
+
+	// sorted so the first failure reported is stable
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	todo.Content = ""
+	todo.Finished = false
+	todo.AutoFinish = false
+	todo.ProjectID = uuid.Nil
+	todo.DueAt = time.Time{}
+	for _, k := range keys {
+		var ok bool
+		switch k {
+		case "title":
+			todo.Title, ok = obj[k].(string)
+		case "content":
+			todo.Content, ok = obj[k].(string)
+		case "finished":
+			todo.Finished, ok = obj[k].(bool)
+		case "auto_finish":
+			todo.AutoFinish, ok = obj[k].(bool)
+		case "project_id":
+			var id string
+			if id, ok = obj[k].(string); ok {
+				var err error
+				if todo.ProjectID, err = uuid.Parse(id); err != nil {
+					return todo, invalidPatch(http.StatusUnprocessableEntity, "/project_id", envelope.CodeInvalidValue,
+						"Expected a project id.")
+				}
+			}
+		case "due_at":
+			var at string
+			if at, ok = obj[k].(string); ok {
+				var err error
+				if todo.DueAt, err = time.Parse(time.RFC3339, at); err != nil {
+					return todo, invalidPatch(http.StatusUnprocessableEntity, "/due_at", envelope.CodeInvalidValue,
+						"Expected an RFC 3339 date and time.")
+				}
+			}
+		default:
+			return todo, invalidPatch(http.StatusUnprocessableEntity, formatPointer([]string{k}),
+				envelope.CodeUnknownField, "Unknown field.")
+		}
+		if !ok {
+			want := "string"
+			if k == "finished" || k == "auto_finish" {
+				want = "boolean"
+			}
+			return todo, invalidPatch(http.StatusUnprocessableEntity, "/"+k, envelope.CodeInvalidType,
+				"Expected "+want+".")
+		}
+	}
+	return todo, nil
+}
+
+// todoIDFromReq returns the todo id of the route, ok is false if it's not an uuid.
+func todoIDFromReq(r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	return id, err == nil
+}
+
+// parseTodoInclude tells if the items are included, they can only be
+// if the checklist items are enabled.
+func parseTodoInclude(v string, itemsEnabled bool) (items bool, ok bool) {
+	switch {
+	case v == "":
+		return false, true
+	case v == "items" && itemsEnabled:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func parseTodoFilter(v string) (pkg.TodoFilter, bool) {
+	switch v {
+	case "":
+		return pkg.NilFilter, true
+	case "finished":
+		return pkg.Finished, true
+	case "unfinished":
+		return pkg.UnFinished, true
+	default:
+		return pkg.NilFilter, false
+	}
+}
+
+func (th todos) list(w http.ResponseWriter, r *http.Request) {
+	var code int
+	filter, ok := parseTodoFilter(r.URL.Query().Get("filter"))
+	if !ok {
+		code = http.StatusBadRequest
+		writeError(w, r, code, errInvalidFilter, th.logger)
+		th.logger.Error("invalid filter", httpReqField(code, r, nil)...)
+		return
+	}
+
+	var projectID uuid.UUID
+	if v := r.URL.Query().Get("project"); v != "" {
+		var err error
+		if projectID, err = uuid.Parse(v); err != nil {
+			code = http.StatusBadRequest
+			writeError(w, r, code, errInvalidProjectFilter, th.logger)
+			th.logger.Error("invalid project", httpReqField(code, r, nil)...)
+			return
+		}
+	}
+
+	list, err := th.svc.List(r.Context(), userIDFromReqCtx(r), filter, projectID)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err List", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp, err := th.responses(r, list...)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Progress", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("todos listed", httpReqField(code, r, nil)...)
+}
+
+func (th todos) create(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form todoForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	todo, err := th.svc.Create(r.Context(), userIDFromReqCtx(r), pkg.TodoModel{
+		Title:      form.Title,
+		Content:    form.Content,
+		Finished:   form.Finished,
+		AutoFinish: form.AutoFinish,
+		ProjectID:  form.ProjectID,
+		DueAt:      form.dueAt(),
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Create", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp := newTodoResponse(todo)
+	if th.items != nil {
+		// a new todo has no items yet
+		resp.Progress = &progressResponse{}
+	}
+	code = http.StatusCreated
+	w.Header().Set("ETag", etag(todo.Version))
+	w.Header().Set("Location", routeTodos+"/"+todo.ID.String())
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("todo created", httpReqField(code, r, nil)...)
+}
+
+func (th todos) get(w http.ResponseWriter, r *http.Request) {
+	var code int
+	includeItems, ok := parseTodoInclude(r.URL.Query().Get("include"), th.items != nil)
+	if !ok {
+		code = http.StatusBadRequest
+		writeError(w, r, code, errInvalidInclude, th.logger)
+		th.logger.Error("invalid include", httpReqField(code, r, nil)...)
+		return
+	}
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	todo, err := th.svc.Find(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Find", httpReqField(code, r, err)...)
+		return
+	}
+
+	tag := etag(todo.Version)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Accept-Patch", strings.Join([]string{mergePatchContentType, jsonPatchContentType}, ", "))
+	if notModified(r, tag) {
+		code = http.StatusNotModified
+		w.Header().Del("Content-Type")
+		w.WriteHeader(code)
+		th.logger.Info("todo not modified", httpReqField(code, r, nil)...)
+		return
+	}
+
+	resp, err := th.responses(r, todo)
+	if err == nil && includeItems {
+		resp[0].Items, err = th.itemResponses(r, todo.ID)
+	}
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Items", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, resp[0], th.logger)
+	th.logger.Info("todo found", httpReqField(code, r, nil)...)
+}
+
+// current returns the todo of the route and the version a conditional write
+// should expect, which is zero for an unconditional one. It writes the
+// error response and returns ok false if the write can't proceed.
+func (th todos) current(w http.ResponseWriter, r *http.Request) (todo pkg.TodoModel, expected int64, ok bool) {
+	var code int
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return todo, 0, false
+	}
+
+	todo, err := th.svc.Find(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Find", httpReqField(code, r, err)...)
+		return todo, 0, false
+	}
+
+	if preconditionFailed(r, etag(todo.Version)) {
+		code = http.StatusPreconditionFailed
+		w.Header().Set("ETag", etag(todo.Version))
+		writeError(w, r, code, errPreconditionFailed, th.logger)
+		th.logger.Error("if-match precondition failed", httpReqField(code, r, nil)...)
+		return todo, 0, false
+	}
+
+	// the storage checks again atomically, the todo may change in between.
+	if r.Header.Get("If-Match") != "" {
+		expected = todo.Version
+	}
+	return todo, expected, true
+}
+
+func (th todos) update(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form todoForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	todo, expected, ok := th.current(w, r)
+	if !ok {
+		return
+	}
+
+	todo.Title = form.Title
+	todo.Content = form.Content
+	todo.Finished = form.Finished
+	todo.AutoFinish = form.AutoFinish
+	todo.DueAt = form.dueAt()
+	if form.ProjectID != uuid.Nil {
+		todo.ProjectID = form.ProjectID
+	}
+	todo.Version = expected
+	th.save(w, r, todo)
+}
+
+// patch applies either a json merge patch or a json patch on the todo.
+func (th todos) patch(w http.ResponseWriter, r *http.Request) {
+	var code int
+	apply, err := decodePatch(r)
+	if err != nil {
+		code = writeDecodeError(w, r, err, th.logger)
+		th.logger.Error("err decoding patch", httpReqField(code, r, err)...)
+		return
+	}
+
+	// the patched todo is always saved against the version it was read at, a todo
+	// changed in between is read again and patched anew, unless the If-Match expected it.
+	conditional := r.Header.Get("If-Match") != ""
+	for attempt := 1; ; attempt++ {
+		todo, _, ok := th.current(w, r)
+		if !ok {
+			return
+		}
+
+		doc, err := apply(todoDocument(todo))
+		if err == nil {
+			todo, err = todoFromDocument(doc, todo)
+		}
+		if err != nil {
+			code = writeDecodeError(w, r, err, th.logger)
+			th.logger.Error("err applying patch", httpReqField(code, r, err)...)
+			return
+		}
+
+		updated, err := th.svc.Update(r.Context(), userIDFromReqCtx(r), todo)
+		if errors.Is(err, serror.ErrVersionConflict) && !conditional && attempt < patchAttempts {
+			continue
+		}
+		th.saved(w, r, updated, err)
+		return
+	}
+}
+
+// save updates the todo and writes it with its new ETag.
+func (th todos) save(w http.ResponseWriter, r *http.Request, todo pkg.TodoModel) {
+	todo, err := th.svc.Update(r.Context(), userIDFromReqCtx(r), todo)
+	th.saved(w, r, todo, err)
+}
+
+// saved writes the todo updated with its new ETag, or the error of its update.
+func (th todos) saved(w http.ResponseWriter, r *http.Request, todo pkg.TodoModel, err error) {
+	var code int
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Update", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp, err := th.responses(r, todo)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Progress", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	w.Header().Set("ETag", etag(todo.Version))
+	writeData(w, code, resp[0], th.logger)
+	th.logger.Info("todo updated", httpReqField(code, r, nil)...)
+}
+
+func (th todos) delete(w http.ResponseWriter, r *http.Request) {
+	var code int
+	todo, expected, ok := th.current(w, r)
+	if !ok {
+		return
+	}
+
+	err := th.svc.Delete(r.Context(), userIDFromReqCtx(r), todo.ID, expected)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Delete", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	th.logger.Info("todo deleted", httpReqField(code, r, nil)...)
+}
+
+// trash lists the deleted todos of the user, until they are purged.
+func (th todos) trash(w http.ResponseWriter, r *http.Request) {
+	var code int
+	list, err := th.svc.Trash(r.Context(), userIDFromReqCtx(r))
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Trash", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp, err := th.responses(r, list...)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Progress", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, th.logger)
+	th.logger.Info("trash listed", httpReqField(code, r, nil)...)
+}
+
+// restore moves the todo out of the trash.
+func (th todos) restore(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := todoIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrTodoNotFound, th.logger)
+		th.logger.Error("invalid todo id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	todo, err := th.svc.Restore(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Restore", httpReqField(code, r, err)...)
+		return
+	}
+
+	resp, err := th.responses(r, todo)
+	if err != nil {
+		code = writeDomainError(w, r, err, th.logger)
+		th.logger.Error("err Progress", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	w.Header().Set("ETag", etag(todo.Version))
+	writeData(w, code, resp[0], th.logger)
+	th.logger.Info("todo restored", httpReqField(code, r, nil)...)
+}
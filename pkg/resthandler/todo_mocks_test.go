@@ -0,0 +1,1022 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/google/uuid"
+)
+
+// _mockTodoRepoStorage is an in-memory pkg.TodoStorage, the deleted todos
+// are kept with their DeletedAt set.
+type _mockTodoRepoStorage struct {
+	mu    sync.Mutex
+	todos map[uuid.UUID]pkg.TodoModel
+}
+
+func newMockTodoRepoStorage() *_mockTodoRepoStorage {
+	return &_mockTodoRepoStorage{todos: make(map[uuid.UUID]pkg.TodoModel)}
+}
+
+func (m *_mockTodoRepoStorage) FindOneTodo(ctx context.Context, id uuid.UUID) (pkg.TodoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	todo, ok := m.live(id)
+	if !ok {
+		return pkg.NilTodoModel, serror.NewQueryError("find", serror.ErrTodoNotFound, "")
+	}
+	return todo, nil
+}
+
+func (m *_mockTodoRepoStorage) FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter pkg.TodoFilter, projectID uuid.UUID) ([]pkg.TodoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var todos []pkg.TodoModel
+	for _, todo := range m.todos {
+		if todo.UserID != userID || !todo.DeletedAt.IsZero() {
+			continue
+		}
+		if (filter == pkg.Finished && !todo.Finished) || (filter == pkg.UnFinished && todo.Finished) {
+			continue
+		}
+		if projectID != uuid.Nil && todo.ProjectID != projectID {
+			continue
+		}
+		todos = append(todos, todo)
+	}
+	return todos, nil
+}
+
+// FindSyncedTodos returns every todo out of the trash, the sync keeps the ones the user has a role on.
+func (m *_mockTodoRepoStorage) FindSyncedTodos(ctx context.Context, userID uuid.UUID) ([]pkg.TodoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var todos []pkg.TodoModel
+	for _, todo := range m.todos {
+		if todo.DeletedAt.IsZero() {
+			todos = append(todos, todo)
+		}
+	}
+	return todos, nil
+}
+
+func (m *_mockTodoRepoStorage) UpdateOne(ctx context.Context, todo pkg.TodoModel) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.live(todo.ID)
+	if !ok {
+		return 0, serror.NewQueryError("update", serror.ErrTodoNotFound, "")
+	}
+	if todo.Version != 0 && todo.Version != stored.Version {
+		return 0, serror.NewQueryError("update", serror.ErrVersionConflict, "")
+	}
+	if todo.ProjectID == uuid.Nil {
+		todo.ProjectID = stored.ProjectID
+	}
+	todo.SeriesID = stored.SeriesID
+	todo.Version = stored.Version + 1
+	m.todos[todo.ID] = todo
+	return todo.Version, nil
+}
+
+func (m *_mockTodoRepoStorage) InsertOne(ctx context.Context, todo pkg.TodoModel) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.todos[todo.ID] = todo
+	return todo.ID, nil
+}
+
+func (m *_mockTodoRepoStorage) DeleteOne(ctx context.Context, id uuid.UUID, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.live(id)
+	if !ok {
+		return serror.NewQueryError("delete", serror.ErrTodoNotFound, "")
+	}
+	if version != 0 && version != stored.Version {
+		return serror.NewQueryError("delete", serror.ErrVersionConflict, "")
+	}
+	m.trash(stored)
+	return nil
+}
+
+func (m *_mockTodoRepoStorage) FindTrashOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.TodoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var todos []pkg.TodoModel
+	for _, todo := range m.todos {
+		if todo.UserID == userID && !todo.DeletedAt.IsZero() {
+			todos = append(todos, todo)
+		}
+	}
+	sort.Slice(todos, func(i, j int) bool { return todos[i].DeletedAt.After(todos[j].DeletedAt) })
+	return todos, nil
+}
+
+func (m *_mockTodoRepoStorage) FindDeletedTodo(ctx context.Context, id uuid.UUID) (pkg.TodoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	todo, ok := m.todos[id]
+	if !ok || todo.DeletedAt.IsZero() {
+		return pkg.NilTodoModel, serror.NewQueryError("find deleted", serror.ErrTodoNotFound, "")
+	}
+	return todo, nil
+}
+
+func (m *_mockTodoRepoStorage) RestoreOne(ctx context.Context, id, userID uuid.UUID) (pkg.TodoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.todos[id]
+	if !ok || stored.UserID != userID || stored.DeletedAt.IsZero() {
+		return pkg.NilTodoModel, serror.NewQueryError("restore", serror.ErrTodoNotFound, "")
+	}
+	stored.DeletedAt = time.Time{}
+	stored.Version++
+	m.todos[id] = stored
+	return stored, nil
+}
+
+func (m *_mockTodoRepoStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, todo := range m.todos {
+		if !todo.DeletedAt.IsZero() && todo.DeletedAt.Before(before) {
+			delete(m.todos, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// live returns the todo if it's not in the trash.
+func (m *_mockTodoRepoStorage) live(id uuid.UUID) (pkg.TodoModel, bool) {
+	todo, ok := m.todos[id]
+	return todo, ok && todo.DeletedAt.IsZero()
+}
+
+func (m *_mockTodoRepoStorage) trash(todo pkg.TodoModel) {
+	todo.DeletedAt = time.Now()
+	todo.Version++
+	m.todos[todo.ID] = todo
+}
+
+func (m *_mockTodoRepoStorage) ApplyBatch(ctx context.Context, writes []pkg.TodoWrite, atomic bool) ([]pkg.TodoWriteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[uuid.UUID]pkg.TodoModel, len(m.todos))
+	for id, todo := range m.todos {
+		snapshot[id] = todo
+	}
+
+	results := make([]pkg.TodoWriteResult, len(writes))
+	for i, w := range writes {
+		results[i] = m.apply(w)
+		if atomic && results[i].Err != nil {
+			m.todos = snapshot
+			for j := range results {
+				if j != i {
+					results[j] = pkg.TodoWriteResult{Err: pkg.ErrBatchAborted}
+				}
+			}
+			return results, nil
+		}
+	}
+	return results, nil
+}
+
+func (m *_mockTodoRepoStorage) apply(w pkg.TodoWrite) pkg.TodoWriteResult {
+	if w.Op == pkg.TodoInsert {
+		m.todos[w.Todo.ID] = w.Todo
+		return pkg.TodoWriteResult{Todo: w.Todo}
+	}
+	stored, ok := m.live(w.Todo.ID)
+	if !ok || stored.UserID != w.Todo.UserID {
+		return pkg.TodoWriteResult{Err: serror.NewQueryError("batch", serror.ErrTodoNotFound, "")}
+	}
+	if w.Todo.Version != 0 && w.Todo.Version != stored.Version {
+		return pkg.TodoWriteResult{Err: serror.NewQueryError("batch", serror.ErrVersionConflict, "")}
+	}
+	switch w.Op {
+	case pkg.TodoDelete:
+		m.trash(stored)
+		return pkg.TodoWriteResult{}
+	case pkg.TodoUpdate:
+		stored.Title, stored.Content, stored.Finished = w.Todo.Title, w.Todo.Content, w.Todo.Finished
+		stored.AutoFinish, stored.DueAt = w.Todo.AutoFinish, w.Todo.DueAt
+		if w.Todo.ProjectID != uuid.Nil {
+			stored.ProjectID = w.Todo.ProjectID
+		}
+	case pkg.TodoComplete:
+		stored.Finished = true
+	}
+	stored.Version++
+	m.todos[stored.ID] = stored
+	return pkg.TodoWriteResult{Todo: stored}
+}
+
+// _mockSeriesRepoStorage is an in-memory pkg.SeriesStorage over the todos of the
+// _mockTodoRepoStorage, holding its lock.
+type _mockSeriesRepoStorage struct {
+	todos  *_mockTodoRepoStorage
+	series map[uuid.UUID]pkg.SeriesModel
+}
+
+func newMockSeriesRepoStorage(todos *_mockTodoRepoStorage) *_mockSeriesRepoStorage {
+	return &_mockSeriesRepoStorage{todos: todos, series: make(map[uuid.UUID]pkg.SeriesModel)}
+}
+
+func (m *_mockSeriesRepoStorage) FindOneSeries(ctx context.Context, id uuid.UUID) (pkg.SeriesModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	series, ok := m.series[id]
+	if !ok {
+		return pkg.NilSeriesModel, serror.NewQueryError("find", serror.ErrSeriesNotFound, "")
+	}
+	return series, nil
+}
+
+func (m *_mockSeriesRepoStorage) InsertOne(ctx context.Context, series pkg.SeriesModel, todoID uuid.UUID) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	todo, ok := m.todos.live(todoID)
+	if !ok {
+		return serror.NewQueryError("insert", serror.ErrTodoNotFound, "")
+	}
+	m.series[series.ID] = series
+	todo.SeriesID, todo.Title, todo.Content = series.ID, series.Title, series.Content
+	todo.Version++
+	m.todos.todos[todo.ID] = todo
+	return nil
+}
+
+func (m *_mockSeriesRepoStorage) UpdateOne(ctx context.Context, series pkg.SeriesModel) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, ok := m.series[series.ID]; !ok {
+		return serror.NewQueryError("update", serror.ErrSeriesNotFound, "")
+	}
+	m.series[series.ID] = series
+	for id, todo := range m.todos.todos {
+		if todo.SeriesID == series.ID && !todo.Finished && todo.DeletedAt.IsZero() {
+			todo.Title, todo.Content = series.Title, series.Content
+			todo.Version++
+			m.todos.todos[id] = todo
+		}
+	}
+	return nil
+}
+
+func (m *_mockSeriesRepoStorage) DeleteOne(ctx context.Context, id uuid.UUID) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, ok := m.series[id]; !ok {
+		return serror.NewQueryError("delete", serror.ErrSeriesNotFound, "")
+	}
+	delete(m.series, id)
+	for todoID, todo := range m.todos.todos {
+		if todo.SeriesID == id {
+			todo.SeriesID = uuid.Nil
+			todo.Version++
+			m.todos.todos[todoID] = todo
+		}
+	}
+	return nil
+}
+
+func (m *_mockSeriesRepoStorage) EndOne(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (m *_mockSeriesRepoStorage) FindStalledOccurrences(ctx context.Context, n int) ([]pkg.TodoModel, error) {
+	return nil, nil
+}
+
+func (m *_mockSeriesRepoStorage) InsertOccurrence(ctx context.Context, todo pkg.TodoModel) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, ok := m.todos.todos[todo.ID]; !ok {
+		m.todos.todos[todo.ID] = todo
+	}
+	return nil
+}
+
+// _mockReminderRepoStorage is an in-memory pkg.ReminderStorage over the todos of the
+// _mockTodoRepoStorage, holding its lock. The due time a reminder is delivered for is
+// kept apart, like the one of the reminders table.
+type _mockReminderRepoStorage struct {
+	todos     *_mockTodoRepoStorage
+	reminders map[uuid.UUID]pkg.ReminderModel
+	dueAt     map[uuid.UUID]time.Time
+}
+
+func newMockReminderRepoStorage(todos *_mockTodoRepoStorage) *_mockReminderRepoStorage {
+	return &_mockReminderRepoStorage{todos: todos, reminders: make(map[uuid.UUID]pkg.ReminderModel),
+		dueAt: make(map[uuid.UUID]time.Time)}
+}
+
+func (m *_mockReminderRepoStorage) FindRemindersOfTodo(ctx context.Context, todoID uuid.UUID) ([]pkg.ReminderModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	var reminders []pkg.ReminderModel
+	for _, reminder := range m.reminders {
+		if reminder.TodoID != todoID {
+			continue
+		}
+		if !m.dueAt[reminder.ID].Equal(m.todos.todos[todoID].DueAt) {
+			reminder.Status, reminder.Attempts, reminder.LastError = pkg.ReminderPending, 0, ""
+		}
+		reminders = append(reminders, reminder)
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].Offset > reminders[j].Offset })
+	return reminders, nil
+}
+
+func (m *_mockReminderRepoStorage) InsertOne(ctx context.Context, reminder pkg.ReminderModel) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, ok := m.todos.live(reminder.TodoID); !ok {
+		return serror.NewQueryError("insert", serror.ErrTodoNotFound, "")
+	}
+	m.reminders[reminder.ID] = reminder
+	return nil
+}
+
+func (m *_mockReminderRepoStorage) DeleteOne(ctx context.Context, todoID, id uuid.UUID) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if reminder, ok := m.reminders[id]; !ok || reminder.TodoID != todoID {
+		return serror.NewQueryError("delete", serror.ErrReminderNotFound, "")
+	}
+	delete(m.reminders, id)
+	return nil
+}
+
+// ClaimDue ignores the leases and the retries, every pending reminder is due.
+func (m *_mockReminderRepoStorage) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, n int) ([]pkg.Notification, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	var list []pkg.Notification
+	for id, reminder := range m.reminders {
+		todo, ok := m.todos.live(reminder.TodoID)
+		if !ok || todo.Finished || todo.DueAt.IsZero() || todo.DueAt.Add(-reminder.Offset).After(now) {
+			continue
+		}
+		if m.dueAt[id].Equal(todo.DueAt) && reminder.Status != pkg.ReminderPending {
+			continue
+		}
+		if !m.dueAt[id].Equal(todo.DueAt) {
+			reminder.Attempts = 0
+		}
+		reminder.Status = pkg.ReminderPending
+		reminder.Attempts++
+		m.reminders[id] = reminder
+		m.dueAt[id] = todo.DueAt
+		list = append(list, pkg.Notification{ReminderID: id, TodoID: todo.ID, UserID: reminder.UserID,
+			Channel: reminder.Channel, Title: todo.Title, DueAt: todo.DueAt, Attempt: reminder.Attempts})
+	}
+	return list, nil
+}
+
+func (m *_mockReminderRepoStorage) MarkSent(ctx context.Context, n pkg.Notification) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	reminder := m.reminders[n.ReminderID]
+	reminder.Status, reminder.LastError = pkg.ReminderSent, ""
+	m.reminders[n.ReminderID] = reminder
+	return nil
+}
+
+func (m *_mockReminderRepoStorage) MarkFailed(ctx context.Context, n pkg.Notification, reason string, retryAt time.Time, dead bool) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	reminder := m.reminders[n.ReminderID]
+	reminder.LastError = reason
+	if dead {
+		reminder.Status = pkg.ReminderDead
+	}
+	m.reminders[n.ReminderID] = reminder
+	return nil
+}
+
+// _mockItemRepoStorage is an in-memory pkg.ItemStorage over the todos of the
+// _mockTodoRepoStorage, holding its lock.
+type _mockItemRepoStorage struct {
+	todos *_mockTodoRepoStorage
+	items map[uuid.UUID]pkg.ItemModel
+}
+
+func newMockItemRepoStorage(todos *_mockTodoRepoStorage) *_mockItemRepoStorage {
+	return &_mockItemRepoStorage{todos: todos, items: make(map[uuid.UUID]pkg.ItemModel)}
+}
+
+func (m *_mockItemRepoStorage) FindItemsOfTodo(ctx context.Context, todoID uuid.UUID) ([]pkg.ItemModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	var items []pkg.ItemModel
+	for _, item := range m.items {
+		if item.TodoID == todoID {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Position < items[j].Position })
+	return items, nil
+}
+
+func (m *_mockItemRepoStorage) ProgressOfTodos(ctx context.Context, todoIDs []uuid.UUID) (map[uuid.UUID]pkg.Progress, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	progress := make(map[uuid.UUID]pkg.Progress)
+	for _, id := range todoIDs {
+		if p := m.progress(id); p.Total != 0 {
+			progress[id] = p
+		}
+	}
+	return progress, nil
+}
+
+func (m *_mockItemRepoStorage) InsertOne(ctx context.Context, userID uuid.UUID, item pkg.ItemModel) (pkg.ItemModel, pkg.TodoModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, err := m.todoOf(item.TodoID, userID); err != nil {
+		return pkg.NilItemModel, pkg.NilTodoModel, err
+	}
+	if item.Position == 0 {
+		item.Position = 1
+		for _, other := range m.items {
+			if other.TodoID == item.TodoID && other.Position >= item.Position {
+				item.Position = other.Position + 1
+			}
+		}
+	}
+	m.items[item.ID] = item
+	return item, m.touch(item.TodoID), nil
+}
+
+func (m *_mockItemRepoStorage) UpdateOne(ctx context.Context, userID uuid.UUID, item pkg.ItemModel) (pkg.ItemModel, pkg.TodoModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, err := m.todoOf(item.TodoID, userID); err != nil {
+		return pkg.NilItemModel, pkg.NilTodoModel, err
+	}
+	stored, ok := m.items[item.ID]
+	if !ok || stored.TodoID != item.TodoID {
+		return pkg.NilItemModel, pkg.NilTodoModel, serror.NewQueryError("update item", serror.ErrItemNotFound, "")
+	}
+	if item.Position == 0 {
+		item.Position = stored.Position
+	}
+	m.items[item.ID] = item
+	return item, m.touch(item.TodoID), nil
+}
+
+func (m *_mockItemRepoStorage) DeleteOne(ctx context.Context, userID, todoID, id uuid.UUID) (pkg.TodoModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, err := m.todoOf(todoID, userID); err != nil {
+		return pkg.NilTodoModel, err
+	}
+	stored, ok := m.items[id]
+	if !ok || stored.TodoID != todoID {
+		return pkg.NilTodoModel, serror.NewQueryError("delete item", serror.ErrItemNotFound, "")
+	}
+	delete(m.items, id)
+	return m.touch(todoID), nil
+}
+
+func (m *_mockItemRepoStorage) todoOf(todoID, userID uuid.UUID) (pkg.TodoModel, error) {
+	todo, ok := m.todos.live(todoID)
+	if !ok || todo.UserID != userID {
+		return pkg.NilTodoModel, serror.NewQueryError("lock todo", serror.ErrTodoNotFound, "")
+	}
+	return todo, nil
+}
+
+func (m *_mockItemRepoStorage) progress(todoID uuid.UUID) pkg.Progress {
+	var p pkg.Progress
+	for _, item := range m.items {
+		if item.TodoID != todoID {
+			continue
+		}
+		p.Total++
+		if item.Done {
+			p.Done++
+		}
+	}
+	return p
+}
+
+func (m *_mockItemRepoStorage) touch(todoID uuid.UUID) pkg.TodoModel {
+	todo := m.todos.todos[todoID]
+	todo.Version++
+	if p := m.progress(todoID); todo.AutoFinish && p.Total > 0 && p.Done == p.Total {
+		todo.Finished = true
+	}
+	m.todos.todos[todoID] = todo
+	return todo
+}
+
+// _mockProjectRepoStorage is an in-memory pkg.ProjectStorage over the todos of the
+// _mockTodoRepoStorage, holding its lock.
+type _mockProjectRepoStorage struct {
+	todos    *_mockTodoRepoStorage
+	projects map[uuid.UUID]pkg.ProjectModel
+}
+
+func newMockProjectRepoStorage(todos *_mockTodoRepoStorage) *_mockProjectRepoStorage {
+	return &_mockProjectRepoStorage{todos: todos, projects: make(map[uuid.UUID]pkg.ProjectModel)}
+}
+
+func (m *_mockProjectRepoStorage) FindOneProject(ctx context.Context, id uuid.UUID) (pkg.ProjectModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	project, ok := m.projects[id]
+	if !ok {
+		return pkg.NilProjectModel, serror.NewQueryError("find", serror.ErrProjectNotFound, "")
+	}
+	return project, nil
+}
+
+func (m *_mockProjectRepoStorage) FindAllProjectOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.ProjectModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	var projects []pkg.ProjectModel
+	for _, project := range m.projects {
+		if project.UserID == userID && project.OrgID == uuid.Nil {
+			projects = append(projects, project)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		if projects[i].Inbox != projects[j].Inbox {
+			return projects[i].Inbox
+		}
+		return projects[i].Name < projects[j].Name
+	})
+	return projects, nil
+}
+
+func (m *_mockProjectRepoStorage) FindAllProjectOfOrg(ctx context.Context, orgID uuid.UUID) ([]pkg.ProjectModel, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	var projects []pkg.ProjectModel
+	for _, project := range m.projects {
+		if project.OrgID == orgID {
+			projects = append(projects, project)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Name < projects[j].Name })
+	return projects, nil
+}
+
+func (m *_mockProjectRepoStorage) InsertOne(ctx context.Context, project pkg.ProjectModel) (uuid.UUID, error) {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	m.projects[project.ID] = project
+	return project.ID, nil
+}
+
+func (m *_mockProjectRepoStorage) UpdateOne(ctx context.Context, project pkg.ProjectModel) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	if _, ok := m.projects[project.ID]; !ok {
+		return serror.NewQueryError("update", serror.ErrProjectNotFound, "")
+	}
+	m.projects[project.ID] = project
+	return nil
+}
+
+func (m *_mockProjectRepoStorage) DeleteOne(ctx context.Context, id uuid.UUID, mode pkg.ProjectDeleteMode) error {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	project, ok := m.projects[id]
+	if !ok || project.Inbox {
+		return serror.NewQueryError("delete", serror.ErrProjectNotFound, "")
+	}
+	var inbox pkg.ProjectModel
+	for _, other := range m.projects {
+		if other.UserID == project.UserID && other.Inbox {
+			inbox = other
+		}
+	}
+	for todoID, todo := range m.todos.todos {
+		if todo.ProjectID != id {
+			continue
+		}
+		if mode == pkg.CascadeTodos {
+			delete(m.todos.todos, todoID)
+			continue
+		}
+		todo.ProjectID = inbox.ID
+		todo.Version++
+		m.todos.todos[todoID] = todo
+	}
+	delete(m.projects, id)
+	return nil
+}
+
+// inbox stores the inbox project of the user, as the signup does.
+func (m *_mockProjectRepoStorage) inbox(userID uuid.UUID) pkg.ProjectModel {
+	m.todos.mu.Lock()
+	defer m.todos.mu.Unlock()
+	inbox := pkg.ProjectModel{ID: uuid.New(), UserID: userID, Name: pkg.InboxProjectName, Inbox: true}
+	m.projects[inbox.ID] = inbox
+	return inbox
+}
+
+// _mockGrantRepoStorage is an in-memory pkg.GrantStorage.
+type _mockGrantRepoStorage struct {
+	mu     sync.Mutex
+	grants []pkg.GrantModel
+}
+
+func (m *_mockGrantRepoStorage) RoleOf(ctx context.Context, userID, todoID, projectID uuid.UUID) (pkg.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	role := pkg.RoleNone
+	for _, grant := range m.grants {
+		on := (grant.Resource.Type == pkg.ResourceTodo && grant.Resource.ID == todoID) ||
+			(grant.Resource.Type == pkg.ResourceProject && grant.Resource.ID == projectID)
+		if on && grant.UserID == userID && grant.Role > role {
+			role = grant.Role
+		}
+	}
+	return role, nil
+}
+
+func (m *_mockGrantRepoStorage) FindGrantsOf(ctx context.Context, resource pkg.Resource) ([]pkg.GrantModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var grants []pkg.GrantModel
+	for _, grant := range m.grants {
+		if grant.Resource == resource {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+func (m *_mockGrantRepoStorage) FindGrantsOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.GrantModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var grants []pkg.GrantModel
+	for _, grant := range m.grants {
+		if grant.UserID == userID {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+func (m *_mockGrantRepoStorage) UpsertOne(ctx context.Context, grant pkg.GrantModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.grants {
+		if other.Resource == grant.Resource && other.UserID == grant.UserID {
+			m.grants[i] = grant
+			return nil
+		}
+	}
+	m.grants = append(m.grants, grant)
+	return nil
+}
+
+func (m *_mockGrantRepoStorage) DeleteOne(ctx context.Context, resource pkg.Resource, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, grant := range m.grants {
+		if grant.Resource == resource && grant.UserID == userID {
+			m.grants = append(m.grants[:i], m.grants[i+1:]...)
+			return nil
+		}
+	}
+	return serror.NewQueryError("delete", serror.ErrGrantNotFound, "")
+}
+
+// _mockOrgRepoStorage is an in-memory pkg.OrgStorage, finding the
+// organization of a project in the _mockProjectRepoStorage.
+type _mockOrgRepoStorage struct {
+	mu       sync.Mutex
+	projects *_mockProjectRepoStorage
+	orgs     map[uuid.UUID]pkg.OrgModel
+	members  []pkg.MembershipModel
+}
+
+func newMockOrgRepoStorage(projects *_mockProjectRepoStorage) *_mockOrgRepoStorage {
+	return &_mockOrgRepoStorage{projects: projects, orgs: make(map[uuid.UUID]pkg.OrgModel)}
+}
+
+func (m *_mockOrgRepoStorage) FindOneOrg(ctx context.Context, id uuid.UUID) (pkg.OrgModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	org, ok := m.orgs[id]
+	if !ok {
+		return pkg.NilOrgModel, serror.NewQueryError("find", serror.ErrOrgNotFound, "")
+	}
+	return org, nil
+}
+
+func (m *_mockOrgRepoStorage) FindOrgsOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.OrgModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orgs []pkg.OrgModel
+	for _, member := range m.members {
+		if member.UserID == userID {
+			orgs = append(orgs, m.orgs[member.OrgID])
+		}
+	}
+	return orgs, nil
+}
+
+func (m *_mockOrgRepoStorage) InsertOne(ctx context.Context, org pkg.OrgModel, ownerID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orgs[org.ID] = org
+	m.members = append(m.members, pkg.MembershipModel{OrgID: org.ID, UserID: ownerID, Role: pkg.OrgRoleOwner})
+	return nil
+}
+
+func (m *_mockOrgRepoStorage) RoleOf(ctx context.Context, orgID, userID uuid.UUID) (pkg.OrgRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, member := range m.members {
+		if member.OrgID == orgID && member.UserID == userID {
+			return member.Role, nil
+		}
+	}
+	return pkg.OrgRoleNone, nil
+}
+
+func (m *_mockOrgRepoStorage) RoleOnProject(ctx context.Context, userID, projectID uuid.UUID) (uuid.UUID, pkg.OrgRole, error) {
+	project, err := m.projects.FindOneProject(ctx, projectID)
+	if err != nil || project.OrgID == uuid.Nil {
+		return uuid.Nil, pkg.OrgRoleNone, nil
+	}
+	role, err := m.RoleOf(ctx, project.OrgID, userID)
+	return project.OrgID, role, err
+}
+
+func (m *_mockOrgRepoStorage) FindMembers(ctx context.Context, orgID uuid.UUID) ([]pkg.MembershipModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var members []pkg.MembershipModel
+	for _, member := range m.members {
+		if member.OrgID == orgID {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *_mockOrgRepoStorage) UpsertMember(ctx context.Context, member pkg.MembershipModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.members {
+		if other.OrgID == member.OrgID && other.UserID == member.UserID {
+			m.members[i] = member
+			return nil
+		}
+	}
+	m.members = append(m.members, member)
+	return nil
+}
+
+func (m *_mockOrgRepoStorage) DeleteMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, member := range m.members {
+		if member.OrgID == orgID && member.UserID == userID {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			return nil
+		}
+	}
+	return serror.NewQueryError("delete", serror.ErrMemberNotFound, "")
+}
+
+// _mockWebhookRepoStorage is an in-memory pkg.WebhookStorage, the events
+// dispatched to it are kept to be delivered.
+type _mockWebhookRepoStorage struct {
+	mu         sync.Mutex
+	webhooks   map[uuid.UUID]pkg.WebhookModel
+	events     map[int64]pkg.Event
+	deliveries []pkg.DeliveryModel
+}
+
+func newMockWebhookRepoStorage() *_mockWebhookRepoStorage {
+	return &_mockWebhookRepoStorage{webhooks: make(map[uuid.UUID]pkg.WebhookModel), events: make(map[int64]pkg.Event)}
+}
+
+func (m *_mockWebhookRepoStorage) FindOneWebhook(ctx context.Context, id uuid.UUID) (pkg.WebhookModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return pkg.NilWebhookModel, serror.NewQueryError("find", serror.ErrWebhookNotFound, "")
+	}
+	return webhook, nil
+}
+
+func (m *_mockWebhookRepoStorage) FindWebhooksOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.WebhookModel, error) {
+	return m.findWebhooks(func(webhook pkg.WebhookModel) bool {
+		return webhook.OrgID == uuid.Nil && webhook.UserID == userID
+	}), nil
+}
+
+func (m *_mockWebhookRepoStorage) FindWebhooksOfOrg(ctx context.Context, orgID uuid.UUID) ([]pkg.WebhookModel, error) {
+	return m.findWebhooks(func(webhook pkg.WebhookModel) bool { return webhook.OrgID == orgID }), nil
+}
+
+func (m *_mockWebhookRepoStorage) findWebhooks(match func(webhook pkg.WebhookModel) bool) []pkg.WebhookModel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var webhooks []pkg.WebhookModel
+	for _, webhook := range m.webhooks {
+		if match(webhook) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks
+}
+
+func (m *_mockWebhookRepoStorage) InsertOne(ctx context.Context, webhook pkg.WebhookModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+func (m *_mockWebhookRepoStorage) UpdateOne(ctx context.Context, webhook pkg.WebhookModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[webhook.ID]; !ok {
+		return serror.NewQueryError("update", serror.ErrWebhookNotFound, "")
+	}
+	m.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+func (m *_mockWebhookRepoStorage) DeleteOne(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[id]; !ok {
+		return serror.NewQueryError("delete", serror.ErrWebhookNotFound, "")
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *_mockWebhookRepoStorage) FindDeliveries(ctx context.Context, webhookID uuid.UUID, n int) ([]pkg.DeliveryModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deliveries []pkg.DeliveryModel
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < n; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *_mockWebhookRepoStorage) DispatchEvent(ctx context.Context, e pkg.Event, staffOrgID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.events[e.ID]; ok {
+		return nil
+	}
+	m.events[e.ID] = e
+	for _, webhook := range m.webhooks {
+		subscribed := false
+		for _, eventType := range webhook.Events {
+			subscribed = subscribed || eventType == e.Type
+		}
+		var match bool
+		if e.Type == pkg.EventUserSignedUp {
+			match = staffOrgID != uuid.Nil && webhook.OrgID == staffOrgID
+		} else {
+			match = (webhook.OrgID == uuid.Nil && webhook.UserID == e.UserID) ||
+				(webhook.OrgID != uuid.Nil && webhook.OrgID == e.OrgID)
+		}
+		if subscribed && match && !webhook.Disabled {
+			m.deliveries = append(m.deliveries, pkg.DeliveryModel{ID: int64(len(m.deliveries) + 1),
+				WebhookID: webhook.ID, EventID: e.ID, EventType: e.Type, Status: pkg.DeliveryPending,
+				CreatedAt: time.Now()})
+		}
+	}
+	return nil
+}
+
+// ClaimDeliveries ignores the leases and the retries, every pending delivery is due.
+func (m *_mockWebhookRepoStorage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, n int) ([]pkg.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []pkg.WebhookDelivery
+	for i, delivery := range m.deliveries {
+		webhook := m.webhooks[delivery.WebhookID]
+		if delivery.Status != pkg.DeliveryPending || webhook.Disabled || len(list) == n {
+			continue
+		}
+		m.deliveries[i].Attempts++
+		e := m.events[delivery.EventID]
+		list = append(list, pkg.WebhookDelivery{ID: delivery.ID, WebhookID: webhook.ID, URL: webhook.URL,
+			Secret: webhook.Secret, EventID: e.ID, EventType: e.Type, Data: e.Data, OccurredAt: e.OccurredAt,
+			Attempt: m.deliveries[i].Attempts})
+	}
+	return list, nil
+}
+
+func (m *_mockWebhookRepoStorage) MarkDelivered(ctx context.Context, d pkg.WebhookDelivery, code int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery := &m.deliveries[d.ID-1]
+	delivery.Status, delivery.ResponseCode, delivery.LastError, delivery.DeliveredAt = pkg.DeliveryDelivered, code, "",
+		time.Now()
+	webhook := m.webhooks[d.WebhookID]
+	webhook.Failures = 0
+	m.webhooks[d.WebhookID] = webhook
+	return nil
+}
+
+func (m *_mockWebhookRepoStorage) MarkFailed(ctx context.Context, d pkg.WebhookDelivery, code int, reason string,
+	retryAt time.Time, dead bool, disableAfter int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery := &m.deliveries[d.ID-1]
+	delivery.ResponseCode, delivery.LastError = code, reason
+	if dead {
+		delivery.Status = pkg.DeliveryDead
+	}
+	webhook := m.webhooks[d.WebhookID]
+	webhook.Failures++
+	disabled := !webhook.Disabled && webhook.Failures >= disableAfter
+	webhook.Disabled = webhook.Disabled || disabled
+	m.webhooks[d.WebhookID] = webhook
+	return disabled, nil
+}
+
+func (m *_mockWebhookRepoStorage) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	panic("implement me")
+}
+
+// _mockEventLog is an in-memory pkg.EventLog, the events logged are numbered
+// from 1 and the ones before the start are purged.
+type _mockEventLog struct {
+	mu     sync.Mutex
+	start  int64
+	events []pkg.Event
+	notify chan struct{}
+}
+
+func newMockEventLog() *_mockEventLog {
+	return &_mockEventLog{notify: make(chan struct{}, 100)}
+}
+
+func (m *_mockEventLog) log(e pkg.Event) int64 {
+	m.mu.Lock()
+	e.ID = int64(len(m.events) + 1)
+	m.events = append(m.events, e)
+	m.mu.Unlock()
+	m.notify <- struct{}{}
+	return e.ID
+}
+
+func (m *_mockEventLog) LastEventID(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.events)), nil
+}
+
+func (m *_mockEventLog) EventsAfter(ctx context.Context, afterID int64, types []string, n int) ([]pkg.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if afterID < m.start || afterID > int64(len(m.events)) {
+		return nil, serror.ErrEventNotFound
+	}
+	var events []pkg.Event
+	for _, e := range m.events[afterID:] {
+		for _, typ := range types {
+			if e.Type == typ && len(events) < n {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+func (m *_mockEventLog) ListenEvents(ctx context.Context, notify func()) error {
+	notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.notify:
+			notify()
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/authstrategy"
+)
+
+// userTokenizer treats the token itself as the id of the user,
+// followed by the id of the active organization after a colon, if any.
+type userTokenizer struct {
+}
+
+func (t userTokenizer) ValidateClaims(token string) (authstrategy.Claims, error) {
+	if token == "" {
+		return authstrategy.Claims{}, errors.New("empty token")
+	}
+	ids := strings.SplitN(token, ":", 2)
+	c := authstrategy.Claims{UserID: ids[0]}
+	if len(ids) == 2 {
+		c.OrgID = ids[1]
+	}
+	return c, nil
+}
+
+func (t userTokenizer) GenerateClaims(c authstrategy.Claims) (string, error) {
+	if c.OrgID == "" {
+		return c.UserID, nil
+	}
+	return c.UserID + ":" + c.OrgID, nil
+}
+
+func newTodoTestHandler(t *testing.T) (*MuxHandler, *_mockTodoRepoStorage) {
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := newMockTodoRepoStorage()
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}), WithTodoService(pkg.NewTodoService(repo)))
+	return mh, repo
+}
+
+func todoRequest(method, target string, userID uuid.UUID, body string) *http.Request {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", jsonContentType)
+	}
+	r.Header.Set("Authorization", "Bearer "+userID.String())
+	return r
+}
+
+func TestTodos_Unauthenticated(t *testing.T) {
+	t.Parallel()
+	mh, _ := newTodoTestHandler(t)
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, routeTodos, nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected WWW-Authenticate header")
+	}
+}
+
+func TestTodos_ConditionalRequests(t *testing.T) {
+	t.Parallel()
+	mh, repo := newTodoTestHandler(t)
+	userID := uuid.New()
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeTodos, userID, `{"title":"write tests"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("ETag") != `"1"` {
+		t.Errorf("expected ETag \"1\" got %s", rr.Header().Get("ETag"))
+	}
+	var created struct {
+		Data todoResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	target := routeTodos + "/" + created.Data.ID.String()
+
+	tcs := []struct {
+		name     string
+		method   string
+		header   string
+		value    string
+		body     string
+		wantCode int
+		wantTag  string
+	}{
+		{name: "get", method: http.MethodGet, wantCode: http.StatusOK, wantTag: `"1"`},
+		{name: "if-none-match fresh", method: http.MethodGet, header: "If-None-Match", value: `W/"1"`, wantCode: http.StatusNotModified, wantTag: `"1"`},
+		{name: "if-none-match stale", method: http.MethodGet, header: "If-None-Match", value: `"0"`, wantCode: http.StatusOK, wantTag: `"1"`},
+		{name: "put stale if-match", method: http.MethodPut, header: "If-Match", value: `"7"`, body: `{"title":"stale"}`, wantCode: http.StatusPreconditionFailed, wantTag: `"1"`},
+		{name: "put weak if-match", method: http.MethodPut, header: "If-Match", value: `W/"1"`, body: `{"title":"weak"}`, wantCode: http.StatusPreconditionFailed, wantTag: `"1"`},
+		{name: "put if-match", method: http.MethodPut, header: "If-Match", value: `"1"`, body: `{"title":"fresh","finished":true}`, wantCode: http.StatusOK, wantTag: `"2"`},
+		{name: "put unconditional", method: http.MethodPut, body: `{"title":"again"}`, wantCode: http.StatusOK, wantTag: `"3"`},
+		{name: "delete stale if-match", method: http.MethodDelete, header: "If-Match", value: `"2"`, wantCode: http.StatusPreconditionFailed, wantTag: `"3"`},
+		{name: "delete if-match", method: http.MethodDelete, header: "If-Match", value: `"3"`, wantCode: http.StatusNoContent},
+		{name: "get deleted", method: http.MethodGet, wantCode: http.StatusNotFound},
+	}
+
+	// the cases run in order, each one depends on the state left by the previous.
+	for _, tc := range tcs {
+		req := todoRequest(tc.method, target, userID, tc.body)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, req)
+		if rr.Code != tc.wantCode {
+			t.Fatalf("%s: expected status code %d got %d %s", tc.name, tc.wantCode, rr.Code, rr.Body.String())
+		}
+		if tag := rr.Header().Get("ETag"); tag != tc.wantTag {
+			t.Errorf("%s: expected ETag %q got %q", tc.name, tc.wantTag, tag)
+		}
+		if tc.wantCode == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: expected empty body", tc.name)
+		}
+	}
+
+	if _, ok := repo.live(created.Data.ID); ok {
+		t.Errorf("expected todo to be deleted")
+	}
+}
+
+func TestTodos_OtherUser(t *testing.T) {
+	t.Parallel()
+	mh, repo := newTodoTestHandler(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: uuid.New(), Title: "private", Version: 1}
+	repo.todos[todo.ID] = todo
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeTodos+"/"+todo.ID.String(), uuid.New(), ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestTodos_Patch(t *testing.T) {
+	t.Parallel()
+	mh, repo := newTodoTestHandler(t)
+	userID := uuid.New()
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "patch me", Content: "body", Version: 1}
+	repo.todos[todo.ID] = todo
+	target := routeTodos + "/" + todo.ID.String()
+
+	tcs := []struct {
+		name        string
+		contentType string
+		ifMatch     string
+		body        string
+		wantCode    int
+		wantField   string
+		want        pkg.TodoModel
+	}{
+		{
+			name: "merge patch", contentType: mergePatchContentType, ifMatch: `"1"`,
+			body: `{"finished":true}`, wantCode: http.StatusOK,
+			want: pkg.TodoModel{Title: "patch me", Content: "body", Finished: true, Version: 2},
+		},
+		{
+			name: "merge patch removes content", contentType: mergePatchContentType,
+			body: `{"content":null}`, wantCode: http.StatusOK,
+			want: pkg.TodoModel{Title: "patch me", Finished: true, Version: 3},
+		},
+		{
+			name: "json patch", contentType: jsonPatchContentType, ifMatch: `"3"`,
+			body:     `[{"op":"test","path":"/finished","value":true},{"op":"replace","path":"/title","value":"patched"}]`,
+			wantCode: http.StatusOK,
+			want:     pkg.TodoModel{Title: "patched", Finished: true, Version: 4},
+		},
+		{
+			name: "stale if-match", contentType: mergePatchContentType, ifMatch: `"1"`,
+			body: `{"finished":false}`, wantCode: http.StatusPreconditionFailed,
+		},
+		{
+			name: "invalid type", contentType: mergePatchContentType,
+			body: `{"finished":"yes"}`, wantCode: http.StatusUnprocessableEntity, wantField: "/finished",
+		},
+		{
+			name: "unknown field", contentType: jsonPatchContentType,
+			body: `[{"op":"add","path":"/owner","value":"me"}]`, wantCode: http.StatusUnprocessableEntity, wantField: "/owner",
+		},
+		{
+			name: "title removed", contentType: jsonPatchContentType,
+			body: `[{"op":"remove","path":"/title"}]`, wantCode: http.StatusUnprocessableEntity, wantField: "/title",
+		},
+		{
+			name: "blank title", contentType: mergePatchContentType,
+			body: `{"title":" "}`, wantCode: http.StatusUnprocessableEntity, wantField: "title",
+		},
+		{
+			name: "failed test", contentType: jsonPatchContentType,
+			body: `[{"op":"test","path":"/finished","value":false}]`, wantCode: http.StatusConflict, wantField: "/0/value",
+		},
+		{
+			name: "plain json", contentType: jsonContentType,
+			body: `{"finished":false}`, wantCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	// the cases run in order, each one depends on the state left by the previous.
+	for _, tc := range tcs {
+		req := todoRequest(http.MethodPatch, target, userID, tc.body)
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, req)
+		if rr.Code != tc.wantCode {
+			t.Fatalf("%s: expected status code %d got %d %s", tc.name, tc.wantCode, rr.Code, rr.Body.String())
+		}
+
+		if tc.wantCode != http.StatusOK {
+			var resp struct {
+				Errors []struct {
+					Details []struct {
+						Field string `json:"field"`
+					} `json:"details"`
+				} `json:"errors"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantField != "" && (len(resp.Errors) != 1 || len(resp.Errors[0].Details) != 1 ||
+				resp.Errors[0].Details[0].Field != tc.wantField) {
+				t.Errorf("%s: expected error at %s got %s", tc.name, tc.wantField, rr.Body.String())
+			}
+			continue
+		}
+
+		got := repo.todos[todo.ID]
+		tc.want.ID, tc.want.UserID = todo.ID, userID
+		if got != tc.want {
+			t.Errorf("%s: expected stored todo %+v got %+v", tc.name, tc.want, got)
+		}
+		if tag := rr.Header().Get("ETag"); tag != etag(tc.want.Version) {
+			t.Errorf("%s: expected ETag %s got %s", tc.name, etag(tc.want.Version), tag)
+		}
+	}
+}
+
+// racedTodoRepoStorage runs the race once, just before the first update.
+type racedTodoRepoStorage struct {
+	*_mockTodoRepoStorage
+	raced bool
+	run   func()
+}
+
+func (m *racedTodoRepoStorage) UpdateOne(ctx context.Context, todo pkg.TodoModel) (int64, error) {
+	if !m.raced {
+		m.raced = true
+		m.run()
+	}
+	return m._mockTodoRepoStorage.UpdateOne(ctx, todo)
+}
+
+func TestTodos_ConcurrentPatches(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	repo := &racedTodoRepoStorage{_mockTodoRepoStorage: newMockTodoRepoStorage()}
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}), WithTodoService(pkg.NewTodoService(repo)))
+	userID := uuid.New()
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "patch me", Content: "body", Version: 1}
+	repo.todos[todo.ID] = todo
+	target := routeTodos + "/" + todo.ID.String()
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := todoRequest(http.MethodPatch, target, userID, body)
+		req.Header.Set("Content-Type", mergePatchContentType)
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, req)
+		return rr
+	}
+	// the second patch lands between the read and the update of the first one
+	var second *httptest.ResponseRecorder
+	repo.run = func() { second = patch(`{"content":"patched"}`) }
+	first := patch(`{"finished":true}`)
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("expected both patches ok got %d %d", first.Code, second.Code)
+	}
+	want := pkg.TodoModel{ID: todo.ID, UserID: userID, Title: "patch me", Content: "patched", Finished: true, Version: 3}
+	if got := repo.todos[todo.ID]; got != want {
+		t.Errorf("expected both patches stored %+v got %+v", want, got)
+	}
+}
+
+func TestTodos_TrashAndRestore(t *testing.T) {
+	t.Parallel()
+	mh, repo := newTodoTestHandler(t)
+	userID := uuid.New()
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "trash me", Version: 1}
+	repo.todos[todo.ID] = todo
+	target := routeTodos + "/" + todo.ID.String()
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		userID   uuid.UUID
+		wantCode int
+	}{
+		{name: "delete", method: http.MethodDelete, target: target, userID: userID, wantCode: http.StatusNoContent},
+		{name: "get deleted", method: http.MethodGet, target: target, userID: userID, wantCode: http.StatusNotFound},
+		{name: "delete again", method: http.MethodDelete, target: target, userID: userID, wantCode: http.StatusNotFound},
+		{name: "restore of other user", method: http.MethodPost, target: target + ":restore", userID: uuid.New(), wantCode: http.StatusNotFound},
+		{name: "restore", method: http.MethodPost, target: target + ":restore", userID: userID, wantCode: http.StatusOK},
+		{name: "restore again", method: http.MethodPost, target: target + ":restore", userID: userID, wantCode: http.StatusNotFound},
+		{name: "get restored", method: http.MethodGet, target: target, userID: userID, wantCode: http.StatusOK},
+	}
+
+	for _, step := range steps {
+		if step.name == "restore" {
+			rr := httptest.NewRecorder()
+			mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeTodosTrash, userID, ""))
+			var resp struct {
+				Data []todoResponse `json:"data"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if rr.Code != http.StatusOK || len(resp.Data) != 1 || resp.Data[0].ID != todo.ID || resp.Data[0].DeletedAt == nil {
+				t.Errorf("expected deleted todo in the trash got %d %s", rr.Code, rr.Body.String())
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, step.userID, ""))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	if got := repo.todos[todo.ID]; !got.DeletedAt.IsZero() || got.Version != 3 {
+		t.Errorf("expected restored todo at version 3 got %+v", got)
+	}
+}
@@ -1,51 +1,50 @@
 package resthandler
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	"go.uber.org/zap"
 
 	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/authstrategy"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
 )
 
 var (
 	// failure msg
-	errInvalidJSON         = getAPIErrMsg("Invalid request.")
-	errInvalidEmailAddress = getAPIErrMsg("Invalid email address.")
-	errInvalidPassword     = getAPIErrMsg("Invalid Password Length should be > 8 and < 254.")
-	errInvalidCredential   = getAPIErrMsg("Invalid credentials.")
-	errDuplicateReg        = getAPIErrMsg("Email ID already registered.")
+	errInvalidJSON       = envelope.NewError(envelope.CodeInvalidRequest, "Invalid request.")
+	errInvalidCredential = envelope.NewError(envelope.CodeInvalidCredentials, "Invalid credentials.")
+	errDuplicateReg      = envelope.NewError(envelope.CodeDuplicateRegistration, "Email ID already registered.")
+
+	// field validation failure msg
+	errFieldEmail = envelope.FieldError{
+		Field:   "email_id",
+		Code:    envelope.CodeInvalidEmail,
+		Message: "Invalid email address.",
+	}
+	errFieldPassword = envelope.FieldError{
+		Field:   "password",
+		Code:    envelope.CodeInvalidLength,
+		Message: "Invalid Password Length should be > 8 and < 254.",
+	}
 
 	// successMsg
-	rspUsrReg   = getRespMsg("Email successfully registered.")
-	tokenString = `{"message": "User logged in successfully", data: {token: %s}}`
+	rspUsrReg = envelope.Message("Email successfully registered.")
 )
 
-// follow sort of https://jsonapi.org/format/
-func getAPIErrMsg(m string) []byte {
-	return []byte(fmt.Sprintf(`{"success": "false", 
-	"errors": [{"message": "%s""}]}`, m))
-}
-
-// follow sort of https://jsonapi.org/format/
-func getRespMsg(m string) []byte {
-	return []byte(fmt.Sprintf(`{"success": "true", 
-	"data": {"message": "%s"}}`, m))
-}
-
-func getJSONResp(jsonM string) []byte {
-	return []byte(fmt.Sprintf(`{"success": "true", 
-	"data": %s}`, jsonM))
+// tokenResponse is the data of a successful login response.
+type tokenResponse struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
 }
 
 // Tokenizer provide an abstraction to work with
 // Validation and Generation of an Auth Token
 type Tokenizer interface {
-	Validate(token string) (string, error)
-	Generate(id string) (string, error)
+	// ValidateClaims returns the user and active organization of the token.
+	ValidateClaims(token string) (authstrategy.Claims, error)
+	// GenerateClaims returns a token of the user and active organization.
+	GenerateClaims(c authstrategy.Claims) (string, error)
 }
 
 // auth encapsulates various types of handlerFunc
@@ -56,7 +55,7 @@ type auth struct {
 	tokenizer Tokenizer
 }
 
-// signUpForm type Decode the submitted json body.
+// signUpForm type Decode the submitted json or form body.
 type signUpForm struct {
 	EmailID   string `json:"email_id"`
 	Password  string `json:"password"`
@@ -68,9 +67,7 @@ type signUpForm struct {
 func (ar auth) signUp(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var code int
-	var body []byte
 
-	body, err = ioutil.ReadAll(r.Body)
 	defer func() {
 		err := r.Body.Close()
 		if err != nil {
@@ -78,48 +75,33 @@ func (ar auth) signUp(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	if err != nil {
-		code = http.StatusInternalServerError
-		writeInternalServerError(w, ar.logger)
-
-		ar.logger.Error("err reading body", httpReqField(code, r, err)...)
-		return
-	}
-	// decode the json body.
+	// decode the json or form body.
 	var signForm signUpForm
-	err = json.Unmarshal(body, &signForm)
-
+	err = decodeBody(r, &signForm)
 	if err != nil {
-		code = http.StatusBadRequest
-		w.WriteHeader(code)
-		_, err = w.Write(errInvalidJSON)
-		checkResponseWriteErr(err, ar.logger)
+		code = writeDecodeError(w, r, err, ar.logger)
 
-		ar.logger.Error("err unmarshalling json", httpReqField(code, r, err)...)
+		ar.logger.Error("err decoding body", httpReqField(code, r, err)...)
 		return
 	}
 
 	// precondition
-	code, err = ar.precondition(w, signForm.EmailID, signForm.Password)
-	if err != nil {
-		ar.logger.Error("precondition check failed", httpReqField(code, r, err)...)
+	if ok := ar.precondition(w, r, signForm.EmailID, signForm.Password); !ok {
+		return
 	}
 
 	ok, err := ar.svc.IsDuplicateRegistration(r.Context(),
 		signForm.EmailID)
 	if err != nil {
 		code = http.StatusInternalServerError
-		writeInternalServerError(w, ar.logger)
+		writeInternalServerError(w, r, ar.logger)
 
 		ar.logger.Error("err IsDuplicateRegistration", httpReqField(code, r, err)...)
 		return
 	}
 
 	if ok {
-		code = http.StatusConflict
-		w.WriteHeader(code)
-		_, err = w.Write(errDuplicateReg)
-		checkResponseWriteErr(err, ar.logger)
+		code = writeDomainError(w, r, pkg.ErrDuplicateRegistration, ar.logger)
 
 		ar.logger.Error("email already registered", httpReqField(code, r, err)...)
 		return
@@ -134,15 +116,14 @@ func (ar auth) signUp(w http.ResponseWriter, r *http.Request) {
 	}
 	_, err = ar.svc.StoreUser(r.Context(), user)
 	if err != nil {
-		writeInternalServerError(w, ar.logger)
+		code = http.StatusInternalServerError
+		writeInternalServerError(w, r, ar.logger)
 		ar.logger.Error("err StoreUser", httpReqField(code, r, err)...)
 		return
 	}
 
 	code = http.StatusCreated
-	w.WriteHeader(code)
-	_, err = w.Write(rspUsrReg)
-	checkResponseWriteErr(err, ar.logger)
+	writeResponse(w, code, rspUsrReg, ar.logger)
 
 	ar.logger.Info("user created", httpReqField(code, r, err)...)
 }
@@ -155,9 +136,6 @@ type loginForm struct {
 func (ar auth) login(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var code int
-	var body []byte
-
-	body, err = ioutil.ReadAll(r.Body)
 
 	defer func() {
 		err := r.Body.Close()
@@ -166,85 +144,66 @@ func (ar auth) login(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	if err != nil {
-		code = http.StatusInternalServerError
-		writeInternalServerError(w, ar.logger)
-
-		ar.logger.Error("err reading body", httpReqField(code, r, err)...)
-		return
-	}
-
-	// decode the json body.
+	// decode the json or form body.
 	var logForm loginForm
-	err = json.Unmarshal(body, &logForm)
+	err = decodeBody(r, &logForm)
 	if err != nil {
-		code = http.StatusBadRequest
-		w.WriteHeader(code)
-		_, err = w.Write(errInvalidJSON)
-		checkResponseWriteErr(err, ar.logger)
+		code = writeDecodeError(w, r, err, ar.logger)
 
-		ar.logger.Error("err unmarshalling json", httpReqField(code, r, err)...)
+		ar.logger.Error("err decoding body", httpReqField(code, r, err)...)
 		return
 	}
 
 	// precondition
-	code, err = ar.precondition(w, logForm.EmailID, logForm.Password)
-	if err != nil {
-		ar.logger.Error("precondition check failed", httpReqField(code, r, err)...)
+	if ok := ar.precondition(w, r, logForm.EmailID, logForm.Password); !ok {
+		return
 	}
 
 	ok, user, err := ar.svc.IsCredentialValid(r.Context(), logForm.EmailID, logForm.Password)
 	if err != nil {
 		code = http.StatusInternalServerError
-		writeInternalServerError(w, ar.logger)
+		writeInternalServerError(w, r, ar.logger)
 		ar.logger.Error("err IsCredentialValid", httpReqField(code, r, err)...)
 		return
 	}
 
 	if !ok {
-		code = http.StatusUnprocessableEntity
-		w.WriteHeader(code)
-		_, err = w.Write(errInvalidCredential)
-		checkResponseWriteErr(err, ar.logger)
+		code = writeDomainError(w, r, pkg.ErrInvalidCredentials, ar.logger)
 		ar.logger.Error("invalid Credential", httpReqField(code, r, err)...)
 		return
 	}
 
-	token, err := ar.tokenizer.Generate(user.ID.String())
+	token, err := ar.tokenizer.GenerateClaims(authstrategy.Claims{UserID: user.ID.String()})
 	if err != nil {
 		code = http.StatusInternalServerError
-		writeInternalServerError(w, ar.logger)
+		writeInternalServerError(w, r, ar.logger)
 		ar.logger.Error("err generating token", httpReqField(code, r, err)...)
 		return
 	}
 
-	resJSON := getJSONResp(fmt.Sprintf(tokenString, token))
-
 	code = http.StatusCreated
-	w.WriteHeader(code)
-	_, err = w.Write(resJSON)
-	checkResponseWriteErr(err, ar.logger)
+	writeData(w, code, tokenResponse{Message: "User logged in successfully", Token: token}, ar.logger)
 
 	ar.logger.Info("user logged in", httpReqField(code, r, err)...)
 }
 
-func (ar auth) precondition(w http.ResponseWriter, email, password string) (code int, err error) {
-
+// precondition validates the email and password and writes the field level
+// validation errors if any. It reports if the request can proceed.
+func (ar auth) precondition(w http.ResponseWriter, r *http.Request, email, password string) bool {
+	var details []envelope.FieldError
 	if !ar.svc.IsValidEmail(email) {
-		code = http.StatusPreconditionFailed
-		w.WriteHeader(code)
-		_, err = w.Write(errInvalidEmailAddress)
-		checkResponseWriteErr(err, ar.logger)
-		return
+		details = append(details, errFieldEmail)
 	}
 
 	if !ar.svc.IsValidPassword(password) {
-		code = http.StatusPreconditionFailed
-		w.WriteHeader(code)
-		_, err = w.Write(errInvalidPassword)
-		checkResponseWriteErr(err, ar.logger)
+		details = append(details, errFieldPassword)
+	}
 
-		return
+	if len(details) == 0 {
+		return true
 	}
-	return
+	code := http.StatusPreconditionFailed
+	writeError(w, r, code, envelope.NewError(envelope.CodeValidationFailed, "Validation failed.", details...), ar.logger)
+	ar.logger.Error("precondition check failed", httpReqField(code, r, nil)...)
+	return false
 }
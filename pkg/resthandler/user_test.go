@@ -7,9 +7,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/authstrategy"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
@@ -19,11 +22,11 @@ import (
 type testTokenizer struct {
 }
 
-func (t testTokenizer) Validate(token string) (string, error) {
-	return "", nil
+func (t testTokenizer) ValidateClaims(token string) (authstrategy.Claims, error) {
+	return authstrategy.Claims{}, nil
 }
 
-func (t testTokenizer) Generate(id string) (string, error) {
+func (t testTokenizer) GenerateClaims(c authstrategy.Claims) (string, error) {
 	return "token", nil
 }
 
@@ -45,6 +48,11 @@ func TestSignUpHandler(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	user.EmailID = "ankur.example.com"
+	invalidBody, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	tc := []struct {
 		name        string
@@ -66,6 +74,19 @@ func TestSignUpHandler(t *testing.T) {
 				return model.ID, nil
 			},
 		},
+		{
+			name: "invalid email precondition",
+			want: 412,
+			body: invalidBody,
+			returnFunc: func() pkg.UserModel {
+				t.Fatal("this should not have been called")
+				return pkg.UserModel{}
+			},
+			returnStore: func(model pkg.UserModel) (uuid.UUID, error) {
+				t.Fatal("this should not have been called")
+				return model.ID, nil
+			},
+		},
 		{
 			name: "duplicate registration",
 			want: 409,
@@ -104,6 +125,9 @@ func TestSignUpHandler(t *testing.T) {
 			if rr.Code != c.want {
 				t.Errorf("Expected Status Code %d Got %d", c.want, rr.Code)
 			}
+			if !json.Valid(rr.Body.Bytes()) {
+				t.Errorf("Expected a valid json body Got %s", rr.Body.String())
+			}
 		})
 	}
 
@@ -205,3 +229,39 @@ func TestLoginHandler(t *testing.T) {
 	}
 
 }
+
+func TestLoginHandler_Form(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	defer l.Sync()
+	password := "ankuranand"
+	encryptedPass, err := bcrypt.GenerateFromPassword([]byte(password),
+		bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockRep := &_mockUserRepoStorage{
+		returnFunc: func() pkg.UserModel {
+			return pkg.UserModel{
+				Email:    "ankur@example.com",
+				Password: string(encryptedPass),
+			}
+		},
+	}
+	a := auth{logger: l, svc: pkg.NewRegAndAuthService(mockRep), tokenizer: testTokenizer{}}
+
+	form := url.Values{}
+	form.Set("email_id", "ankur@example.com")
+	form.Set("password", password)
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	a.login(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected Status Code %d Got %d", http.StatusCreated, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"token":"token"`) {
+		t.Errorf("Expected token in the response Got %s", rr.Body.String())
+	}
+}
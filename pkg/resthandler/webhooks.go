@@ -0,0 +1,265 @@
+package resthandler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/resthandler/envelope"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	errWebhookNotFound = envelope.NewError(envelope.CodeWebhookNotFound, "Webhook not found.")
+	errInvalidWebhook  = envelope.NewError(envelope.CodeValidationFailed, "Invalid webhook.",
+		envelope.FieldError{Field: "url", Code: envelope.CodeInvalidValue, Message: "Should be an absolute http or https url."},
+		envelope.FieldError{Field: "events", Code: envelope.CodeInvalidValue,
+			Message: "Should be todo.created, todo.updated, todo.completed, todo.deleted, todo.restored or user.signed_up, each once."})
+	errInvalidOrgFilter = envelope.NewError(envelope.CodeValidationFailed, "Invalid organization.",
+		envelope.FieldError{Field: "org_id", Code: envelope.CodeInvalidValue, Message: "Should be an organization id."})
+)
+
+// webhooks encapsulates various types of handlerFunc
+// that responds to the webhook api request of the authenticated user
+type webhooks struct {
+	svc    pkg.WebhookService
+	logger *zap.Logger
+}
+
+// webhookForm type Decode the submitted json body of a webhook,
+// the org_id is only read when the webhook is created.
+type webhookForm struct {
+	OrgID    uuid.UUID `json:"org_id"`
+	URL      string    `json:"url"`
+	Events   []string  `json:"events"`
+	Disabled bool      `json:"disabled"`
+}
+
+// webhookResponse is the json representation of a webhook.
+type webhookResponse struct {
+	ID uuid.UUID `json:"id"`
+	// OrgID is left out of a personal webhook
+	OrgID  *uuid.UUID `json:"org_id,omitempty"`
+	URL    string     `json:"url"`
+	Events []string   `json:"events"`
+	// Secret is only told once the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	Failures  int       `json:"failures"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookResponse(webhook pkg.WebhookModel) webhookResponse {
+	resp := webhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		Disabled:  webhook.Disabled,
+		Failures:  webhook.Failures,
+		CreatedAt: webhook.CreatedAt,
+	}
+	if webhook.OrgID != uuid.Nil {
+		resp.OrgID = &webhook.OrgID
+	}
+	return resp
+}
+
+// deliveryResponse is the json representation of an entry of the delivery log.
+type deliveryResponse struct {
+	ID           int64      `json:"id"`
+	EventID      int64      `json:"event_id"`
+	EventType    string     `json:"event_type"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	ResponseCode int        `json:"response_code,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+}
+
+func newDeliveryResponse(delivery pkg.DeliveryModel) deliveryResponse {
+	resp := deliveryResponse{
+		ID:           delivery.ID,
+		EventID:      delivery.EventID,
+		EventType:    delivery.EventType,
+		Status:       string(delivery.Status),
+		Attempts:     delivery.Attempts,
+		ResponseCode: delivery.ResponseCode,
+		LastError:    delivery.LastError,
+		CreatedAt:    delivery.CreatedAt,
+	}
+	if !delivery.DeliveredAt.IsZero() {
+		resp.DeliveredAt = &delivery.DeliveredAt
+	}
+	return resp
+}
+
+// webhookIDFromReq returns the webhook id of the route, ok is false if it's not an uuid.
+func webhookIDFromReq(r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	return id, err == nil
+}
+
+// list lists the personal webhooks of the user, or the ones of the organization of the ?org_id.
+func (wh webhooks) list(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var orgID uuid.UUID
+	if v := r.URL.Query().Get("org_id"); v != "" {
+		var err error
+		if orgID, err = uuid.Parse(v); err != nil {
+			code = http.StatusBadRequest
+			writeError(w, r, code, errInvalidOrgFilter, wh.logger)
+			wh.logger.Error("invalid org id", httpReqField(code, r, nil)...)
+			return
+		}
+	}
+
+	list, err := wh.svc.List(r.Context(), userIDFromReqCtx(r), orgID)
+	if err != nil {
+		code = writeDomainError(w, r, err, wh.logger)
+		wh.logger.Error("err List", httpReqField(code, r, err)...)
+		return
+	}
+	resp := make([]webhookResponse, 0, len(list))
+	for _, webhook := range list {
+		resp = append(resp, newWebhookResponse(webhook))
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, wh.logger)
+	wh.logger.Info("webhooks listed", httpReqField(code, r, nil)...)
+}
+
+// create registers a webhook, the response is the only one telling its secret.
+func (wh webhooks) create(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form webhookForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, wh.logger)
+		wh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+
+	webhook, err := wh.svc.Create(r.Context(), userIDFromReqCtx(r), pkg.WebhookModel{
+		OrgID:  form.OrgID,
+		URL:    form.URL,
+		Events: form.Events,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, wh.logger)
+		wh.logger.Error("err Create", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusCreated
+	w.Header().Set("Location", routeWebhooks+"/"+webhook.ID.String())
+	resp := newWebhookResponse(webhook)
+	resp.Secret = webhook.Secret
+	writeData(w, code, resp, wh.logger)
+	wh.logger.Info("webhook created", httpReqField(code, r, nil)...)
+}
+
+func (wh webhooks) get(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := webhookIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrWebhookNotFound, wh.logger)
+		wh.logger.Error("invalid webhook id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	webhook, err := wh.svc.Find(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, wh.logger)
+		wh.logger.Error("err Find", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newWebhookResponse(webhook), wh.logger)
+	wh.logger.Info("webhook found", httpReqField(code, r, nil)...)
+}
+
+// update updates the url, events and disabled of the webhook,
+// a webhook disabled by its failures resumes once enabled again.
+func (wh webhooks) update(w http.ResponseWriter, r *http.Request) {
+	var code int
+	var form webhookForm
+	err := decodeBody(r, &form)
+	if err != nil {
+		code = writeDecodeError(w, r, err, wh.logger)
+		wh.logger.Error("err decoding body", httpReqField(code, r, err)...)
+		return
+	}
+	id, ok := webhookIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrWebhookNotFound, wh.logger)
+		wh.logger.Error("invalid webhook id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	webhook, err := wh.svc.Update(r.Context(), userIDFromReqCtx(r), pkg.WebhookModel{
+		ID:       id,
+		URL:      form.URL,
+		Events:   form.Events,
+		Disabled: form.Disabled,
+	})
+	if err != nil {
+		code = writeDomainError(w, r, err, wh.logger)
+		wh.logger.Error("err Update", httpReqField(code, r, err)...)
+		return
+	}
+	code = http.StatusOK
+	writeData(w, code, newWebhookResponse(webhook), wh.logger)
+	wh.logger.Info("webhook updated", httpReqField(code, r, nil)...)
+}
+
+func (wh webhooks) delete(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := webhookIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrWebhookNotFound, wh.logger)
+		wh.logger.Error("invalid webhook id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	err := wh.svc.Delete(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, wh.logger)
+		wh.logger.Error("err Delete", httpReqField(code, r, err)...)
+		return
+	}
+
+	code = http.StatusNoContent
+	w.Header().Del("Content-Type")
+	w.WriteHeader(code)
+	wh.logger.Info("webhook deleted", httpReqField(code, r, nil)...)
+}
+
+// deliveries lists the latest deliveries of the webhook, latest first.
+func (wh webhooks) deliveries(w http.ResponseWriter, r *http.Request) {
+	var code int
+	id, ok := webhookIDFromReq(r)
+	if !ok {
+		code = writeDomainError(w, r, serror.ErrWebhookNotFound, wh.logger)
+		wh.logger.Error("invalid webhook id", httpReqField(code, r, nil)...)
+		return
+	}
+
+	list, err := wh.svc.Deliveries(r.Context(), userIDFromReqCtx(r), id)
+	if err != nil {
+		code = writeDomainError(w, r, err, wh.logger)
+		wh.logger.Error("err Deliveries", httpReqField(code, r, err)...)
+		return
+	}
+	resp := make([]deliveryResponse, 0, len(list))
+	for _, delivery := range list {
+		resp = append(resp, newDeliveryResponse(delivery))
+	}
+	code = http.StatusOK
+	writeData(w, code, resp, wh.logger)
+	wh.logger.Info("deliveries listed", httpReqField(code, r, nil)...)
+}
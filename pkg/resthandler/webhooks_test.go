@@ -0,0 +1,176 @@
+// +build unit_tests all_tests
+
+package resthandler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+	l := zaptest.NewLogger(t, zaptest.Level(zap.FatalLevel))
+	orgRepo := newMockOrgRepoStorage(newMockProjectRepoStorage(newMockTodoRepoStorage()))
+	hooks := newMockWebhookRepoStorage()
+	ownerID, memberID, userID := uuid.New(), uuid.New(), uuid.New()
+	orgID := uuid.New()
+	_ = orgRepo.InsertOne(context.Background(), pkg.OrgModel{ID: orgID, Name: "acme"}, ownerID)
+	_ = orgRepo.UpsertMember(context.Background(), pkg.MembershipModel{OrgID: orgID, UserID: memberID, Role: pkg.OrgRoleMember})
+	svc := pkg.NewWebhookService(hooks, pkg.NewOrgAuthorizer(nil, orgRepo), uuid.Nil)
+	mh := NewMuxHandler(l, WithAuth(pkg.NewRegAndAuthService(&_mockUserRepoStorage{}), userTokenizer{}),
+		WithWebhookService(svc))
+
+	var secret string
+	status := http.StatusNoContent
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		err := webhook.Verify(secret, r.Header.Get("Webhook-Signature"), r.Header.Get("Webhook-Timestamp"), body,
+			time.Now(), time.Minute)
+		if err != nil {
+			t.Errorf("expected a signed delivery got %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	// the webhook is registered at a public url, and posted to the test server
+	hookURL := "https://hooks.example.com/todos"
+	client := srv.Client()
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+
+	rr := httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPost, routeWebhooks, userID, `{"url":"`+hookURL+`","events":["todo.created"]}`))
+	var created struct {
+		Data webhookResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || created.Data.Secret == "" || created.Data.OrgID != nil {
+		t.Fatalf("expected the webhook created with its secret got %d %s", rr.Code, rr.Body.String())
+	}
+	secret = created.Data.Secret
+	target := routeWebhooks + "/" + created.Data.ID.String()
+
+	orgHook := `{"org_id":"` + orgID.String() + `","url":"https://example.com/hook","events":["todo.completed"]}`
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		userID   uuid.UUID
+		body     string
+		wantCode int
+	}{
+		{name: "invalid url", method: http.MethodPost, target: routeWebhooks, userID: userID, body: `{"url":"example.com","events":["todo.created"]}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "unknown event", method: http.MethodPost, target: routeWebhooks, userID: userID, body: `{"url":"https://example.com","events":["todo.archived"]}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "signups", method: http.MethodPost, target: routeWebhooks, userID: userID, body: `{"url":"https://example.com","events":["user.signed_up"]}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "org member", method: http.MethodPost, target: routeWebhooks, userID: memberID, body: orgHook, wantCode: http.StatusForbidden},
+		{name: "non member", method: http.MethodPost, target: routeWebhooks, userID: userID, body: orgHook, wantCode: http.StatusNotFound},
+		{name: "org owner", method: http.MethodPost, target: routeWebhooks, userID: ownerID, body: orgHook, wantCode: http.StatusCreated},
+		{name: "org list of member", method: http.MethodGet, target: routeWebhooks + "?org_id=" + orgID.String(), userID: memberID, wantCode: http.StatusForbidden},
+		{name: "invalid org", method: http.MethodGet, target: routeWebhooks + "?org_id=acme", userID: ownerID, wantCode: http.StatusBadRequest},
+		{name: "other user", method: http.MethodGet, target: target, userID: ownerID, wantCode: http.StatusNotFound},
+		{name: "other user deliveries", method: http.MethodGet, target: target + "/deliveries", userID: ownerID, wantCode: http.StatusNotFound},
+		{name: "invalid webhook id", method: http.MethodGet, target: routeWebhooks + "/first", userID: userID, wantCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(step.method, step.target, step.userID, step.body))
+		if rr.Code != step.wantCode {
+			t.Errorf("%s: expected status code %d got %d %s", step.name, step.wantCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, routeWebhooks, userID, ""))
+	var list struct {
+		Data []webhookResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(list.Data) != 1 || list.Data[0].Secret != "" {
+		t.Fatalf("expected the personal webhook listed without its secret got %d %s", rr.Code, rr.Body.String())
+	}
+
+	deliveries := func() []deliveryResponse {
+		rr := httptest.NewRecorder()
+		mh.ServeHTTP(rr, todoRequest(http.MethodGet, target+"/deliveries", userID, ""))
+		var resp struct {
+			Data []deliveryResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected the deliveries listed got %d %s", rr.Code, rr.Body.String())
+		}
+		return resp.Data
+	}
+
+	dispatcher := pkg.NewWebhookDispatcher(hooks, webhook.NewHTTPSenderWithClient(client), uuid.Nil, time.Minute)
+	bus := pkg.NewEventBus()
+	bus.Subscribe("webhooks", dispatcher)
+	var eventID int64
+	publish := func(eventType string, userID uuid.UUID, data string) {
+		eventID++
+		err := bus.Publish(context.Background(), pkg.Event{ID: eventID, Type: eventType, UserID: userID,
+			Data: []byte(data), OccurredAt: time.Now(), Attempt: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(pkg.EventTodoCreated, userID, `{"title":"pay rent"}`)
+	publish(pkg.EventTodoCompleted, userID, `{"title":"pay rent"}`)
+	publish(pkg.EventTodoCreated, ownerID, `{"title":"hire"}`)
+	delivered, failed, err := dispatcher.Deliver(context.Background(), nil)
+	if err != nil || delivered != 1 || failed != 0 {
+		t.Fatalf("expected the subscribed event delivered got %d %d %v", delivered, failed, err)
+	}
+	got := deliveries()
+	if len(got) != 1 || got[0].Status != string(pkg.DeliveryDelivered) || got[0].ResponseCode != http.StatusNoContent ||
+		got[0].EventType != pkg.EventTodoCreated || got[0].DeliveredAt == nil {
+		t.Errorf("expected the delivery logged got %+v", got)
+	}
+
+	status = http.StatusInternalServerError
+	publish(pkg.EventTodoCreated, userID, `{"title":"pay taxes"}`)
+	if delivered, failed, err = dispatcher.Deliver(context.Background(), nil); err != nil || delivered != 0 || failed != 1 {
+		t.Fatalf("expected the delivery failed got %d %d %v", delivered, failed, err)
+	}
+	got = deliveries()
+	if len(got) != 2 || got[0].Status != string(pkg.DeliveryPending) || got[0].Attempts != 1 ||
+		got[0].ResponseCode != http.StatusInternalServerError || got[0].LastError == "" {
+		t.Errorf("expected the failed delivery to be retried got %+v", got)
+	}
+
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodPut, target, userID, `{"url":"`+hookURL+`","events":["todo.created"],"disabled":true}`))
+	if rr.Code != http.StatusOK || !hooks.webhooks[created.Data.ID].Disabled {
+		t.Errorf("expected the webhook disabled got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodDelete, target, userID, ""))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected the webhook deleted got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	mh.ServeHTTP(rr, todoRequest(http.MethodGet, target, userID, ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the deleted webhook not found got %d", rr.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidSearch indicates the search query has no word, or is too long
+var ErrInvalidSearch = errors.New("invalid search")
+
+// Limits of the search.
+const (
+	maxSearchQuery = 256
+	maxSearchTerms = 8
+	searchLimit    = 50
+)
+
+// The searchers mark the matches of a snippet between the MatchStart and MatchStop,
+// control characters a todo has no use of.
+const (
+	MatchStart = "\x02"
+	MatchStop  = "\x03"
+)
+
+// highlighter renders the marks of the matches of an escaped snippet as html.
+var highlighter = strings.NewReplacer(MatchStart, "<mark>", MatchStop, "</mark>")
+
+// TodoHit is a todo matching a search.
+type TodoHit struct {
+	Todo TodoModel
+	// Rank is the relevance of the todo to the search, the higher the more relevant.
+	// It's only comparable to the ranks of the same search.
+	Rank float64
+	// Snippet is the text of the todo around the matches.
+	Snippet string
+}
+
+// TodoSearcher define a contract for storage, to search the todos by the words
+// of their title and content. A storage without a full text index can supply
+// a simpler one, like a scan of the todos.
+type TodoSearcher interface {
+	// SearchTodosOfUser returns at most n todos of the user out of the trash, and out of
+	// the projects of an organization as the TodoStorage.FindAllTodoOfUser, matching
+	// the filter, whose words start with all the terms, the most relevant first.
+	// The terms are lower case words. The matches of the snippets are marked between
+	// the MatchStart and MatchStop.
+	SearchTodosOfUser(ctx context.Context, userID uuid.UUID, terms []string, filter TodoFilter, n int) ([]TodoHit, error)
+}
+
+// SearchService provides the full text search of the todos of a user.
+type SearchService struct {
+	searcher TodoSearcher
+}
+
+// NewSearchService returns a new SearchService initialized with
+// a concrete searcher implementation.
+func NewSearchService(searcher TodoSearcher) SearchService {
+	return SearchService{
+		searcher: searcher,
+	}
+}
+
+// Search returns the todos of the user matching the filter whose words start with the
+// ones of the query, the most relevant first. The snippets are html, the matches in <mark>.
+func (ss SearchService) Search(ctx context.Context, userID uuid.UUID, query string, filter TodoFilter) ([]TodoHit, error) {
+	if len(query) > maxSearchQuery {
+		return nil, ErrInvalidSearch
+	}
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, ErrInvalidSearch
+	}
+	hits, err := ss.searcher.SearchTodosOfUser(ctx, userID, terms, filter, searchLimit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Snippet = highlighter.Replace(html.EscapeString(hits[i].Snippet))
+	}
+	return hits, nil
+}
+
+// SearchTerms returns the distinct words of the text in lower case, the first
+// maxSearchTerms ones. A word is a run of letters and digits.
+func SearchTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
@@ -0,0 +1,67 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// dummySearcher returns its hits to any search, recording the last one.
+type dummySearcher struct {
+	hits   []TodoHit
+	terms  []string
+	filter TodoFilter
+}
+
+func (d *dummySearcher) SearchTodosOfUser(ctx context.Context, userID uuid.UUID, terms []string, filter TodoFilter, n int) ([]TodoHit, error) {
+	d.terms, d.filter = terms, filter
+	return d.hits, nil
+}
+
+func TestSearchTerms(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		text  string
+		terms []string
+	}{
+		{text: "  Buy GROCERIES, buy milk! ", terms: []string{"buy", "groceries", "milk"}},
+		{text: "e-mail größe 2026", terms: []string{"e", "mail", "größe", "2026"}},
+		{text: `'); DROP TABLE todos; --`, terms: []string{"drop", "table", "todos"}},
+		{text: "a b c d e f g h i j", terms: []string{"a", "b", "c", "d", "e", "f", "g", "h"}},
+		{text: " ?! ", terms: nil},
+	}
+	for _, tc := range tcs {
+		if terms := SearchTerms(tc.text); !reflect.DeepEqual(terms, tc.terms) {
+			t.Errorf("%q: expected %v got %v", tc.text, tc.terms, terms)
+		}
+	}
+}
+
+func TestSearchService_Search(t *testing.T) {
+	t.Parallel()
+	searcher := &dummySearcher{hits: []TodoHit{{Snippet: "<b>Buy</b> " + MatchStart + "milk" + MatchStop + " & eggs"}}}
+	ss := NewSearchService(searcher)
+
+	hits, err := ss.Search(context.Background(), uuid.New(), "Milk ", UnFinished)
+	if err != nil {
+		t.Fatalf("expected a nil error for search got %v", err)
+	}
+	if !reflect.DeepEqual(searcher.terms, []string{"milk"}) || searcher.filter != UnFinished {
+		t.Errorf("expected the terms of the query filtered got %v %v", searcher.terms, searcher.filter)
+	}
+	if want := "&lt;b&gt;Buy&lt;/b&gt; <mark>milk</mark> &amp; eggs"; hits[0].Snippet != want {
+		t.Errorf("expected the snippet escaped with its matches marked %q got %q", want, hits[0].Snippet)
+	}
+
+	for _, query := range []string{"", " -- ", strings.Repeat("milk ", 60)} {
+		if _, err = ss.Search(context.Background(), uuid.New(), query, NilFilter); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("expected invalid search for %q got %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/recurrence"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	// NilSeriesModel is empty SeriesModel, all zeros
+	NilSeriesModel SeriesModel
+)
+
+var (
+	// ErrInvalidSeries indicates the series fails the validation
+	ErrInvalidSeries = errors.New("invalid series")
+)
+
+// SeriesModel is the recurrence of a recurring todo, whose occurrences are
+// each a todo of the series.
+type SeriesModel struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// RRule is the RFC 5545 recurrence rule, like FREQ=WEEKLY;BYDAY=MO.
+	RRule string
+	// TimeZone is the IANA time zone the rule repeats in, like Europe/Paris.
+	TimeZone string
+	// Start is the due time of the first occurrence, the DTSTART of the rule.
+	Start time.Time
+	// Title and Content are the ones of the occurrences to come.
+	Title   string
+	Content string
+}
+
+// Occurrences returns at most n occurrences of the series following the after
+// time, in the time zone of the series.
+func (s SeriesModel) Occurrences(after time.Time, n int) ([]time.Time, error) {
+	rule, err := recurrence.Parse(s.RRule)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	return rule.After(s.Start.In(loc), after, n), nil
+}
+
+// occurrenceID returns the ID of the occurrence of the series due at the time, the
+// same for every write of the occurrence so it's only ever stored once.
+func occurrenceID(seriesID uuid.UUID, dueAt time.Time) uuid.UUID {
+	return uuid.NewSHA1(seriesID, []byte(dueAt.UTC().Format(time.RFC3339Nano)))
+}
+
+// SeriesStorage define a contract for storage, to interact
+// with the SeriesModel of the recurring todos.
+type SeriesStorage interface {
+	FindOneSeries(ctx context.Context, id uuid.UUID) (SeriesModel, error)
+	// InsertOne stores the series and makes the todo its first occurrence, with
+	// the title and content of the series. serror.ErrTodoNotFound is returned if
+	// the todo doesn't exist.
+	InsertOne(ctx context.Context, series SeriesModel, todoID uuid.UUID) error
+	// UpdateOne updates the series, along with the title and content of
+	// its unfinished occurrences.
+	UpdateOne(ctx context.Context, series SeriesModel) error
+	// DeleteOne deletes the series, its occurrences are kept as todos that don't recur.
+	DeleteOne(ctx context.Context, id uuid.UUID) error
+	// InsertOccurrence stores the todo occurrence of its series,
+	// unless a todo with its ID is already stored.
+	InsertOccurrence(ctx context.Context, todo TodoModel) error
+	// EndOne marks the series ended, none of its occurrences follows the last one.
+	// Updating the series makes it go on again.
+	EndOne(ctx context.Context, id uuid.UUID) error
+	// FindStalledOccurrences returns at most n finished occurrences out of the trash
+	// that are the latest of their series, of the series not ended.
+	FindStalledOccurrences(ctx context.Context, n int) ([]TodoModel, error)
+}
+
+// SeriesService provides the use cases implementation to work
+// with the series of the recurring todos of a user.
+//
+// Editing the series applies to all its unfinished occurrences and the ones to
+// come, while the update of a todo of the TodoService only edits that occurrence.
+type SeriesService struct {
+	todos TodoService
+	repo  SeriesStorage
+}
+
+// NewSeriesService returns a new SeriesService initialized with a concrete repo
+// implementation, the series are authorized as their todo by the todos.
+// The todos should be a recurring one over the same repo, to complete the occurrences.
+func NewSeriesService(todos TodoService, repo SeriesStorage) SeriesService {
+	return SeriesService{
+		todos: todos,
+		repo:  repo,
+	}
+}
+
+// IsValidSeries validate if the series is valid or not
+func (ss SeriesService) IsValidSeries(series SeriesModel) bool {
+	if _, err := recurrence.Parse(series.RRule); err != nil {
+		return false
+	}
+	// the Local one is the one of the server
+	if series.TimeZone == "" || series.TimeZone == "Local" {
+		return false
+	}
+	if _, err := time.LoadLocation(series.TimeZone); err != nil {
+		return false
+	}
+	title := strings.TrimSpace(series.Title)
+	return title != "" && len(title) <= 255 && !series.Start.IsZero()
+}
+
+// Find returns the series of the recurring todo the user can read.
+func (ss SeriesService) Find(ctx context.Context, userID, todoID uuid.UUID) (SeriesModel, error) {
+	todo, err := ss.todos.Find(ctx, userID, todoID)
+	if err != nil {
+		return NilSeriesModel, err
+	}
+	return ss.seriesOf(ctx, todo)
+}
+
+func (ss SeriesService) seriesOf(ctx context.Context, todo TodoModel) (SeriesModel, error) {
+	if todo.SeriesID == uuid.Nil {
+		return NilSeriesModel, serror.ErrSeriesNotFound
+	}
+	return ss.repo.FindOneSeries(ctx, todo.SeriesID)
+}
+
+// Save makes the todo the user can edit a recurring one, or edits its series if
+// it's already one, and returns the series. An empty title is the one of the todo,
+// and a zero start keeps the current one, which for a new series is the due time of the todo.
+func (ss SeriesService) Save(ctx context.Context, userID, todoID uuid.UUID, series SeriesModel) (SeriesModel, error) {
+	todo, err := ss.todos.authorized(ctx, userID, todoID, RoleEditor)
+	if err != nil {
+		return NilSeriesModel, err
+	}
+	series.ID = todo.SeriesID
+	if series.ID != uuid.Nil && series.Start.IsZero() {
+		current, err := ss.repo.FindOneSeries(ctx, series.ID)
+		if err != nil {
+			return NilSeriesModel, err
+		}
+		series.Start = current.Start
+	}
+	if series.Start.IsZero() {
+		series.Start = todo.DueAt
+	}
+	if strings.TrimSpace(series.Title) == "" {
+		series.Title = todo.Title
+	}
+	if !ss.IsValidSeries(series) {
+		return NilSeriesModel, ErrInvalidSeries
+	}
+
+	// stored in the canonical form
+	rule, _ := recurrence.Parse(series.RRule)
+	series.RRule = rule.String()
+	series.Title = strings.TrimSpace(series.Title)
+	series.UserID = todo.UserID
+	if series.ID != uuid.Nil {
+		err = ss.repo.UpdateOne(ctx, series)
+	} else {
+		series.ID = uuid.New()
+		err = ss.repo.InsertOne(ctx, series, todo.ID)
+	}
+	if err != nil {
+		return NilSeriesModel, err
+	}
+	return series, nil
+}
+
+// Delete stops the recurrence of the todo the user can edit,
+// its occurrences are kept as todos that don't recur.
+func (ss SeriesService) Delete(ctx context.Context, userID, todoID uuid.UUID) error {
+	todo, err := ss.todos.authorized(ctx, userID, todoID, RoleEditor)
+	if err != nil {
+		return err
+	}
+	if todo.SeriesID == uuid.Nil {
+		return serror.ErrSeriesNotFound
+	}
+	return ss.repo.DeleteOne(ctx, todo.SeriesID)
+}
+
+// Upcoming returns at most n occurrences of the series of the recurring todo the
+// user can read, that follow the todo. They follow now if the todo isn't due.
+func (ss SeriesService) Upcoming(ctx context.Context, userID, todoID uuid.UUID, n int) ([]time.Time, error) {
+	todo, err := ss.todos.Find(ctx, userID, todoID)
+	if err != nil {
+		return nil, err
+	}
+	series, err := ss.seriesOf(ctx, todo)
+	if err != nil {
+		return nil, err
+	}
+	after := todo.DueAt
+	if after.IsZero() {
+		after = time.Now()
+	}
+	return series.Occurrences(after, n)
+}
+
+// advanceBatch is how many stalled occurrences are advanced at most per run.
+const advanceBatch = 100
+
+// SeriesAdvancer stores the next occurrence of the recurring todos finished without it,
+// the ones finished through their items or whose advance failed along their update.
+type SeriesAdvancer struct {
+	todos    TodoService
+	interval time.Duration
+}
+
+// NewSeriesAdvancer returns a SeriesAdvancer that advances the series of the
+// recurring todos every interval.
+func NewSeriesAdvancer(todos TodoService, interval time.Duration) SeriesAdvancer {
+	return SeriesAdvancer{
+		todos:    todos,
+		interval: interval,
+	}
+}
+
+// Advance stores the occurrence that follows each stalled one, and returns how many
+// were advanced. The todos without a recurring series have none.
+func (sa SeriesAdvancer) Advance(ctx context.Context) (int, error) {
+	if sa.todos.series == nil {
+		return 0, nil
+	}
+	stalled, err := sa.todos.series.FindStalledOccurrences(ctx, advanceBatch)
+	if err != nil {
+		return 0, err
+	}
+	for i, todo := range stalled {
+		if err = sa.todos.advance(ctx, todo); err != nil {
+			return i, err
+		}
+	}
+	return len(stalled), nil
+}
+
+// Run advances right away and then every interval, until the ctx is done.
+// The outcome of every run is reported to the onAdvance, if not nil.
+func (sa SeriesAdvancer) Run(ctx context.Context, onAdvance func(n int, err error)) {
+	ticker := time.NewTicker(sa.interval)
+	defer ticker.Stop()
+	for {
+		n, err := sa.Advance(ctx)
+		if onAdvance != nil && ctx.Err() == nil {
+			onAdvance(n, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummySeriesRepo stores the series along the todos of the dummyTodoRepo.
+type dummySeriesRepo struct {
+	todos  *dummyTodoRepo
+	series map[uuid.UUID]SeriesModel
+	ended  map[uuid.UUID]bool
+	// failInsert fails the InsertOccurrence
+	failInsert bool
+}
+
+func newDummySeriesRepo(todos *dummyTodoRepo) *dummySeriesRepo {
+	return &dummySeriesRepo{todos: todos, series: make(map[uuid.UUID]SeriesModel), ended: make(map[uuid.UUID]bool)}
+}
+
+func (d *dummySeriesRepo) FindOneSeries(ctx context.Context, id uuid.UUID) (SeriesModel, error) {
+	series, ok := d.series[id]
+	if !ok {
+		return NilSeriesModel, serror.ErrSeriesNotFound
+	}
+	return series, nil
+}
+
+func (d *dummySeriesRepo) InsertOne(ctx context.Context, series SeriesModel, todoID uuid.UUID) error {
+	todo, ok := d.todos.todos[todoID]
+	if !ok {
+		return serror.ErrTodoNotFound
+	}
+	d.series[series.ID] = series
+	todo.SeriesID, todo.Title, todo.Content = series.ID, series.Title, series.Content
+	d.todos.todos[todoID] = todo
+	return nil
+}
+
+func (d *dummySeriesRepo) UpdateOne(ctx context.Context, series SeriesModel) error {
+	d.series[series.ID] = series
+	d.ended[series.ID] = false
+	return nil
+}
+
+func (d *dummySeriesRepo) DeleteOne(ctx context.Context, id uuid.UUID) error {
+	panic("implement me")
+}
+
+func (d *dummySeriesRepo) InsertOccurrence(ctx context.Context, todo TodoModel) error {
+	if d.failInsert {
+		return errors.New("insert failed")
+	}
+	if _, ok := d.todos.todos[todo.ID]; !ok {
+		d.todos.todos[todo.ID] = todo
+	}
+	return nil
+}
+
+func (d *dummySeriesRepo) EndOne(ctx context.Context, id uuid.UUID) error {
+	d.ended[id] = true
+	return nil
+}
+
+func (d *dummySeriesRepo) FindStalledOccurrences(ctx context.Context, n int) ([]TodoModel, error) {
+	latest := make(map[uuid.UUID]TodoModel)
+	for _, todo := range d.todos.todos {
+		if todo.SeriesID == uuid.Nil || d.ended[todo.SeriesID] {
+			continue
+		}
+		if last, ok := latest[todo.SeriesID]; !ok || todo.DueAt.After(last.DueAt) {
+			latest[todo.SeriesID] = todo
+		}
+	}
+	var stalled []TodoModel
+	for _, todo := range latest {
+		if todo.Finished && todo.DeletedAt.IsZero() && len(stalled) < n {
+			stalled = append(stalled, todo)
+		}
+	}
+	return stalled, nil
+}
+
+func TestSeriesService_Save(t *testing.T) {
+	t.Parallel()
+	todos := newDummyTodoRepo()
+	repo := newDummySeriesRepo(todos)
+	ss := NewSeriesService(NewRecurringTodoService(todos, nil, NewAuthorizer(nil), repo), repo)
+	userID := uuid.New()
+	todoID := uuid.New()
+	todos.todos[todoID] = TodoModel{ID: todoID, UserID: userID, Title: "stand-up", Version: 1}
+
+	invalid := []SeriesModel{
+		{RRule: "FREQ=DAILY;BYHOUR=9", TimeZone: "UTC", Start: time.Now()},
+		{RRule: "FREQ=DAILY", TimeZone: "Local", Start: time.Now()},
+		{RRule: "FREQ=DAILY", TimeZone: "Mars/Olympus", Start: time.Now()},
+		// neither a start nor a due todo
+		{RRule: "FREQ=DAILY", TimeZone: "UTC"},
+	}
+	for _, series := range invalid {
+		if _, err := ss.Save(context.Background(), userID, todoID, series); !errors.Is(err, ErrInvalidSeries) {
+			t.Errorf("expected invalid series error for %+v got %v", series, err)
+		}
+	}
+	series := SeriesModel{RRule: "freq=daily;count=2", TimeZone: "UTC"}
+	if _, err := ss.Save(context.Background(), uuid.New(), todoID, series); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for another user got %v", err)
+	}
+
+	dueAt := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	todo := todos.todos[todoID]
+	todo.DueAt = dueAt
+	todos.todos[todoID] = todo
+	series, err := ss.Save(context.Background(), userID, todoID, series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.ID == uuid.Nil || series.RRule != "FREQ=DAILY;COUNT=2" || series.Title != "stand-up" || !series.Start.Equal(dueAt) {
+		t.Errorf("unexpected saved series %+v", series)
+	}
+	if todos.todos[todoID].SeriesID != series.ID {
+		t.Errorf("expected the todo the first occurrence of the series got %+v", todos.todos[todoID])
+	}
+
+	// the start is kept
+	edited, err := ss.Save(context.Background(), userID, todoID, SeriesModel{RRule: series.RRule, TimeZone: "UTC", Title: "daily"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.ID != series.ID || !edited.Start.Equal(dueAt) || repo.series[series.ID].Title != "daily" {
+		t.Errorf("unexpected edited series %+v", edited)
+	}
+}
+
+func TestTodoService_Advance(t *testing.T) {
+	t.Parallel()
+	todos := newDummyTodoRepo()
+	repo := newDummySeriesRepo(todos)
+	ts := NewRecurringTodoService(todos, nil, NewAuthorizer(nil), repo)
+	userID := uuid.New()
+	seriesID := uuid.New()
+	dueAt := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	repo.series[seriesID] = SeriesModel{ID: seriesID, UserID: userID, RRule: "FREQ=DAILY;COUNT=2", TimeZone: "UTC",
+		Start: dueAt, Title: "daily"}
+	first := TodoModel{ID: uuid.New(), UserID: userID, Title: "daily", Version: 1, DueAt: dueAt, SeriesID: seriesID}
+	todos.todos[first.ID] = first
+
+	first.Finished = true
+	if _, err := ts.Update(context.Background(), userID, first); err != nil {
+		t.Fatal(err)
+	}
+	next, ok := todos.todos[occurrenceID(seriesID, dueAt.AddDate(0, 0, 1))]
+	if !ok || next.Finished || next.SeriesID != seriesID || !next.DueAt.Equal(dueAt.AddDate(0, 0, 1)) {
+		t.Fatalf("expected the next occurrence stored got %+v", next)
+	}
+
+	// the last one of the count
+	next.Finished = true
+	if _, err := ts.Update(context.Background(), userID, next); err != nil {
+		t.Fatal(err)
+	}
+	if len(todos.todos) != 2 || !repo.ended[seriesID] {
+		t.Errorf("expected the series ended with no occurrence after the last got %d todos", len(todos.todos))
+	}
+}
+
+func TestSeriesAdvancer_Advance(t *testing.T) {
+	t.Parallel()
+	todos := newDummyTodoRepo()
+	repo := newDummySeriesRepo(todos)
+	ts := NewRecurringTodoService(todos, nil, NewAuthorizer(nil), repo)
+	sa := NewSeriesAdvancer(ts, time.Minute)
+	userID := uuid.New()
+	seriesID := uuid.New()
+	dueAt := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	repo.series[seriesID] = SeriesModel{ID: seriesID, UserID: userID, RRule: "FREQ=DAILY;COUNT=2", TimeZone: "UTC",
+		Start: dueAt, Title: "daily"}
+	first := TodoModel{ID: uuid.New(), UserID: userID, Title: "daily", Version: 1, DueAt: dueAt, SeriesID: seriesID}
+	todos.todos[first.ID] = first
+
+	// the update is saved even though its next occurrence isn't
+	repo.failInsert = true
+	first.Finished = true
+	if _, err := ts.Update(context.Background(), userID, first); err != nil {
+		t.Fatalf("expected the update saved got %v", err)
+	}
+	if n, err := sa.Advance(context.Background()); err == nil || n != 0 {
+		t.Fatalf("expected the advance to fail got %d %v", n, err)
+	}
+
+	repo.failInsert = false
+	if n, err := sa.Advance(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected the stalled occurrence advanced got %d %v", n, err)
+	}
+	nextID := occurrenceID(seriesID, dueAt.AddDate(0, 0, 1))
+	next, ok := todos.todos[nextID]
+	if !ok || next.Finished || !next.DueAt.Equal(dueAt.AddDate(0, 0, 1)) {
+		t.Fatalf("expected the next occurrence stored got %+v", next)
+	}
+	if n, _ := sa.Advance(context.Background()); n != 0 {
+		t.Errorf("expected nothing stalled once advanced got %d", n)
+	}
+
+	// the last one, finished behind the back of the service like by its items
+	next.Finished = true
+	todos.todos[nextID] = next
+	if n, err := sa.Advance(context.Background()); err != nil || n != 1 || !repo.ended[seriesID] {
+		t.Errorf("expected the series ended got %d %v", n, err)
+	}
+	if n, _ := sa.Advance(context.Background()); n != 0 || len(todos.todos) != 2 {
+		t.Errorf("expected an ended series not advanced got %d with %d todos", n, len(todos.todos))
+	}
+}
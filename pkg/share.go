@@ -0,0 +1,323 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	// ErrForbidden indicates the role of the user on the todo or project doesn't allow the action
+	ErrForbidden = errors.New("forbidden")
+	// ErrInvalidGrant indicates the grant fails the validation
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrInviteeNotFound indicates no user is registered with the email of the invitation
+	ErrInviteeNotFound = errors.New("no user registered with the email")
+)
+
+// Role is what a user can do on a todo or a project, each role
+// allows all the actions of the lower ones.
+type Role int8
+
+const (
+	// RoleNone doesn't allow anything, the todo or project isn't even found
+	RoleNone Role = iota
+	// RoleViewer can read
+	RoleViewer
+	// RoleEditor can read and write, including the checklist items
+	RoleEditor
+	// RoleOwner can also delete the projects and share
+	RoleOwner
+)
+
+var roleNames = map[Role]string{
+	RoleViewer: "viewer",
+	RoleEditor: "editor",
+	RoleOwner:  "owner",
+}
+
+// String returns the name of the role, as the api shows it
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", r)
+}
+
+// ParseRole returns the role of the name, ok is false if it's none of the grantable roles.
+func ParseRole(name string) (role Role, ok bool) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, true
+		}
+	}
+	return RoleNone, false
+}
+
+// ResourceType tells what a grant is shared on
+type ResourceType int8
+
+const (
+	// ResourceTodo is a single todo
+	ResourceTodo ResourceType = iota + 1
+	// ResourceProject is a project, along with all its todos
+	ResourceProject
+)
+
+// Resource is the todo or project a grant is shared on
+type Resource struct {
+	Type ResourceType
+	ID   uuid.UUID
+}
+
+// GrantModel shares a todo or a project with a user other than its owner
+type GrantModel struct {
+	Resource  Resource
+	UserID    uuid.UUID
+	Role      Role
+	GrantedBy uuid.UUID
+}
+
+// GrantStorage define a contract for storage, to interact
+// with the GrantModel.
+type GrantStorage interface {
+	// RoleOf returns the highest role granted to the user either on the todo
+	// or on the project, RoleNone if there is none. A nil id is ignored.
+	RoleOf(ctx context.Context, userID, todoID, projectID uuid.UUID) (Role, error)
+	// FindGrantsOf returns the grants shared on the resource.
+	FindGrantsOf(ctx context.Context, resource Resource) ([]GrantModel, error)
+	// FindGrantsOfUser returns the grants shared with the user.
+	FindGrantsOfUser(ctx context.Context, userID uuid.UUID) ([]GrantModel, error)
+	// UpsertOne stores the grant, replacing the role the user had on the resource.
+	UpsertOne(ctx context.Context, grant GrantModel) error
+	// DeleteOne revokes the grant of the user on the resource,
+	// serror.ErrGrantNotFound is returned if there is none.
+	DeleteOne(ctx context.Context, resource Resource, userID uuid.UUID) error
+}
+
+// Authorizer is the single component deciding what a user can do on a todo or
+// a project. The owner of either has the RoleOwner, any other user the highest
+// role granted on the todo, or on the project it's filed under, or the one its
+// org role has on the projects of the organization as the policy decides.
+// Of a project of an organization and its todos, nobody is the owner: the
+// member who created them only has the role of their current membership.
+// Its zero value only knows about the owners.
+type Authorizer struct {
+	// grants is nil if the sharing is disabled
+	grants GrantStorage
+	// orgs is nil if the organizations are disabled
+	orgs   OrgStorage
+	policy Policy
+}
+
+// NewAuthorizer returns an Authorizer of the owners and the grants of the repo.
+func NewAuthorizer(grants GrantStorage) Authorizer {
+	return Authorizer{grants: grants}
+}
+
+// NewOrgAuthorizer returns an Authorizer of the owners, the grants and
+// the members of the organizations, with the DefaultPolicy.
+// A nil grants disables the sharing.
+func NewOrgAuthorizer(grants GrantStorage, orgs OrgStorage) Authorizer {
+	return Authorizer{grants: grants, orgs: orgs, policy: DefaultPolicy}
+}
+
+// RoleOnTodo returns the role of the user on the todo.
+func (a Authorizer) RoleOnTodo(ctx context.Context, userID uuid.UUID, todo TodoModel) (Role, error) {
+	return a.roleOf(ctx, userID, todo.UserID, todo.ID, todo.ProjectID)
+}
+
+// RoleOnProject returns the role of the user on the project.
+func (a Authorizer) RoleOnProject(ctx context.Context, userID uuid.UUID, project ProjectModel) (Role, error) {
+	return a.roleOf(ctx, userID, project.UserID, uuid.Nil, project.ID)
+}
+
+func (a Authorizer) roleOf(ctx context.Context, userID, ownerID, todoID, projectID uuid.UUID) (Role, error) {
+	orgID, orgRole := uuid.Nil, OrgRoleNone
+	if a.orgs != nil && projectID != uuid.Nil {
+		var err error
+		if orgID, orgRole, err = a.orgs.RoleOnProject(ctx, userID, projectID); err != nil {
+			return RoleNone, err
+		}
+	}
+	// a removed member keeps no role on what it created in the organization
+	if userID == ownerID && orgID == uuid.Nil {
+		return RoleOwner, nil
+	}
+	role := RoleNone
+	if a.grants != nil {
+		var err error
+		if role, err = a.grants.RoleOf(ctx, userID, todoID, projectID); err != nil {
+			return RoleNone, err
+		}
+	}
+	if r := a.policy.projectRole(orgRole); r > role {
+		role = r
+	}
+	return role, nil
+}
+
+// RoleInOrg returns the role of the user in the organization.
+func (a Authorizer) RoleInOrg(ctx context.Context, userID, orgID uuid.UUID) (OrgRole, error) {
+	if a.orgs == nil {
+		return OrgRoleNone, nil
+	}
+	return a.orgs.RoleOf(ctx, orgID, userID)
+}
+
+// AuthorizeOrg returns nil if the role of the user in the organization has the
+// permission. The organization is reported as not found to a non member.
+func (a Authorizer) AuthorizeOrg(ctx context.Context, userID, orgID uuid.UUID, perm Permission) error {
+	role, err := a.RoleInOrg(ctx, userID, orgID)
+	if err != nil {
+		return err
+	}
+	return a.permit(role, perm)
+}
+
+func (a Authorizer) permit(role OrgRole, perm Permission) error {
+	switch {
+	case role == OrgRoleNone:
+		return serror.ErrOrgNotFound
+	case !a.policy.Can(role, perm):
+		return ErrForbidden
+	default:
+		return nil
+	}
+}
+
+// AuthorizeTodo returns nil if the role of the user on the todo is at least the
+// needed one. The todo is reported as not found to a user without any role, so
+// its existence isn't leaked, and ErrForbidden is returned to the others.
+func (a Authorizer) AuthorizeTodo(ctx context.Context, userID uuid.UUID, todo TodoModel, need Role) error {
+	role, err := a.RoleOnTodo(ctx, userID, todo)
+	if err != nil {
+		return err
+	}
+	return authorize(role, need, serror.ErrTodoNotFound)
+}
+
+// AuthorizeProject is the AuthorizeTodo of the projects.
+func (a Authorizer) AuthorizeProject(ctx context.Context, userID uuid.UUID, project ProjectModel, need Role) error {
+	role, err := a.RoleOnProject(ctx, userID, project)
+	if err != nil {
+		return err
+	}
+	return authorize(role, need, serror.ErrProjectNotFound)
+}
+
+func authorize(role, need Role, notFound error) error {
+	switch {
+	case role == RoleNone:
+		return notFound
+	case role < need:
+		return ErrForbidden
+	default:
+		return nil
+	}
+}
+
+// ShareService provides the use cases implementation to share
+// the todos and projects of a user with the other users.
+type ShareService struct {
+	auth     Authorizer
+	todos    TodoStorage
+	projects ProjectStorage
+	users    UserStorage
+	repo     GrantStorage
+}
+
+// NewShareService returns a new ShareService initialized with
+// the concrete repo implementations, deciding who can share with the auth.
+// The auth must know the organizations, if they are enabled, so only the
+// current members can share the projects of an organization and its todos.
+func NewShareService(todos TodoStorage, projects ProjectStorage, users UserStorage, repo GrantStorage, auth Authorizer) ShareService {
+	return ShareService{
+		auth:     auth,
+		todos:    todos,
+		projects: projects,
+		users:    users,
+		repo:     repo,
+	}
+}
+
+// Authorizer returns the Authorizer deciding who can share,
+// the todo and project services should decide with.
+func (ss ShareService) Authorizer() Authorizer {
+	return ss.auth
+}
+
+// authorize returns the owner of the resource if the user can share it.
+func (ss ShareService) authorize(ctx context.Context, userID uuid.UUID, resource Resource) (uuid.UUID, error) {
+	switch resource.Type {
+	case ResourceTodo:
+		todo, err := ss.todos.FindOneTodo(ctx, resource.ID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return todo.UserID, ss.auth.AuthorizeTodo(ctx, userID, todo, RoleOwner)
+	case ResourceProject:
+		project, err := ss.projects.FindOneProject(ctx, resource.ID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return project.UserID, ss.auth.AuthorizeProject(ctx, userID, project, RoleOwner)
+	default:
+		return uuid.Nil, ErrInvalidGrant
+	}
+}
+
+// Invite shares the resource of the user with the user registered with the email,
+// replacing the role it may already have.
+func (ss ShareService) Invite(ctx context.Context, userID uuid.UUID, resource Resource, email string, role Role) (GrantModel, error) {
+	if _, ok := roleNames[role]; !ok {
+		return GrantModel{}, ErrInvalidGrant
+	}
+	ownerID, err := ss.authorize(ctx, userID, resource)
+	if err != nil {
+		return GrantModel{}, err
+	}
+
+	invitee, err := ss.users.FindByEmail(ctx, normalize(email))
+	if errors.Is(err, serror.ErrUserNotFound) {
+		return GrantModel{}, ErrInviteeNotFound
+	}
+	if err != nil {
+		return GrantModel{}, err
+	}
+	// the owner has every role already
+	if invitee.ID == ownerID {
+		return GrantModel{}, ErrInvalidGrant
+	}
+
+	grant := GrantModel{Resource: resource, UserID: invitee.ID, Role: role, GrantedBy: userID}
+	if err = ss.repo.UpsertOne(ctx, grant); err != nil {
+		return GrantModel{}, err
+	}
+	return grant, nil
+}
+
+// Grants returns the grants shared on the resource of the user.
+func (ss ShareService) Grants(ctx context.Context, userID uuid.UUID, resource Resource) ([]GrantModel, error) {
+	if _, err := ss.authorize(ctx, userID, resource); err != nil {
+		return nil, err
+	}
+	return ss.repo.FindGrantsOf(ctx, resource)
+}
+
+// Revoke revokes the grant of the grantee on the resource of the user.
+func (ss ShareService) Revoke(ctx context.Context, userID uuid.UUID, resource Resource, granteeID uuid.UUID) error {
+	if _, err := ss.authorize(ctx, userID, resource); err != nil {
+		return err
+	}
+	return ss.repo.DeleteOne(ctx, resource, granteeID)
+}
+
+// SharedWith returns the grants shared with the user.
+func (ss ShareService) SharedWith(ctx context.Context, userID uuid.UUID) ([]GrantModel, error) {
+	return ss.repo.FindGrantsOfUser(ctx, userID)
+}
@@ -0,0 +1,167 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyGrantRepo returns the role of the user from the grants of the todos and projects.
+type dummyGrantRepo struct {
+	roles map[uuid.UUID]map[uuid.UUID]Role
+}
+
+func (d *dummyGrantRepo) RoleOf(ctx context.Context, userID, todoID, projectID uuid.UUID) (Role, error) {
+	role := d.roles[todoID][userID]
+	if r := d.roles[projectID][userID]; r > role {
+		role = r
+	}
+	return role, nil
+}
+
+func (d *dummyGrantRepo) FindGrantsOf(ctx context.Context, resource Resource) ([]GrantModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyGrantRepo) FindGrantsOfUser(ctx context.Context, userID uuid.UUID) ([]GrantModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyGrantRepo) UpsertOne(ctx context.Context, grant GrantModel) error {
+	if d.roles[grant.Resource.ID] == nil {
+		d.roles[grant.Resource.ID] = make(map[uuid.UUID]Role)
+	}
+	d.roles[grant.Resource.ID][grant.UserID] = grant.Role
+	return nil
+}
+
+func (d *dummyGrantRepo) DeleteOne(ctx context.Context, resource Resource, userID uuid.UUID) error {
+	panic("implement me")
+}
+
+// dummyInviteeRepo only knows the invitee.
+type dummyInviteeRepo struct {
+	UserStorage
+	invitee UserModel
+}
+
+func (d dummyInviteeRepo) FindByEmail(ctx context.Context, email string) (UserModel, error) {
+	if email != d.invitee.Email {
+		return NilUserModel, serror.ErrUserNotFound
+	}
+	return d.invitee, nil
+}
+
+func TestAuthorizer(t *testing.T) {
+	t.Parallel()
+	ownerID, viewerID, editorID := uuid.New(), uuid.New(), uuid.New()
+	todo := TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: uuid.New()}
+	a := NewAuthorizer(&dummyGrantRepo{roles: map[uuid.UUID]map[uuid.UUID]Role{
+		todo.ID:        {viewerID: RoleViewer, editorID: RoleViewer},
+		todo.ProjectID: {editorID: RoleEditor},
+	}})
+
+	tcs := []struct {
+		name   string
+		userID uuid.UUID
+		need   Role
+		want   error
+	}{
+		{name: "owner", userID: ownerID, need: RoleOwner},
+		{name: "viewer reads", userID: viewerID, need: RoleViewer},
+		{name: "viewer writes", userID: viewerID, need: RoleEditor, want: ErrForbidden},
+		{name: "editor through the project", userID: editorID, need: RoleEditor},
+		{name: "editor shares", userID: editorID, need: RoleOwner, want: ErrForbidden},
+		{name: "stranger", userID: uuid.New(), need: RoleViewer, want: serror.ErrTodoNotFound},
+	}
+	for _, tc := range tcs {
+		err := a.AuthorizeTodo(context.Background(), tc.userID, todo, tc.need)
+		if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, err)
+		}
+	}
+
+	// without the grants only the owner has a role
+	if err := (Authorizer{}).AuthorizeTodo(context.Background(), viewerID, todo, RoleViewer); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found without the grants got %v", err)
+	}
+}
+
+func TestShareService_Invite(t *testing.T) {
+	t.Parallel()
+	ownerID := uuid.New()
+	invitee := UserModel{ID: uuid.New(), Email: "invitee@example.com"}
+	todos := newDummyTodoRepo()
+	todo := TodoModel{ID: uuid.New(), UserID: ownerID, Title: "shared", Version: 1}
+	todos.todos[todo.ID] = todo
+	grants := &dummyGrantRepo{roles: make(map[uuid.UUID]map[uuid.UUID]Role)}
+	ss := NewShareService(todos, nil, dummyInviteeRepo{invitee: invitee}, grants, NewAuthorizer(grants))
+	resource := Resource{Type: ResourceTodo, ID: todo.ID}
+
+	if _, err := ss.Invite(context.Background(), ownerID, resource, "nobody@example.com", RoleViewer); !errors.Is(err, ErrInviteeNotFound) {
+		t.Errorf("expected invitee not found got %v", err)
+	}
+	if _, err := ss.Invite(context.Background(), ownerID, resource, invitee.Email, RoleNone); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("expected invalid grant for no role got %v", err)
+	}
+	if _, err := ss.Invite(context.Background(), uuid.New(), resource, invitee.Email, RoleViewer); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for a stranger got %v", err)
+	}
+
+	grant, err := ss.Invite(context.Background(), ownerID, resource, " Invitee@Example.com ", RoleViewer)
+	if err != nil || grant.UserID != invitee.ID || grant.GrantedBy != ownerID {
+		t.Fatalf("unexpected grant %+v %v", grant, err)
+	}
+
+	ts := NewSharedTodoService(todos, nil, ss.Authorizer())
+	if _, err = ts.Find(context.Background(), invitee.ID, todo.ID); err != nil {
+		t.Errorf("expected the viewer to read the todo got %v", err)
+	}
+	if err = ts.Delete(context.Background(), invitee.ID, todo.ID, 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected the viewer not to delete the todo got %v", err)
+	}
+	// a viewer can't share further
+	if _, err = ss.Invite(context.Background(), invitee.ID, resource, invitee.Email, RoleOwner); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the viewer got %v", err)
+	}
+}
+
+func TestShareService_Org(t *testing.T) {
+	t.Parallel()
+	orgID, creatorID, adminID := uuid.New(), uuid.New(), uuid.New()
+	invitee := UserModel{ID: uuid.New(), Email: "invitee@example.com"}
+	orgs := newDummyOrgRepo()
+	orgs.members[orgID] = map[uuid.UUID]OrgRole{creatorID: OrgRoleMember, adminID: OrgRoleAdmin}
+	project := ProjectModel{ID: uuid.New(), UserID: creatorID, OrgID: orgID}
+	orgs.projectOrgs[project.ID] = orgID
+	projects := &dummyProjectRepo{projects: map[uuid.UUID]ProjectModel{project.ID: project}}
+	grants := &dummyGrantRepo{roles: make(map[uuid.UUID]map[uuid.UUID]Role)}
+	ss := NewShareService(nil, projects, dummyInviteeRepo{invitee: invitee}, grants, NewOrgAuthorizer(grants, orgs))
+	resource := Resource{Type: ResourceProject, ID: project.ID}
+
+	// the admin manages the sharing of the projects it didn't create
+	if _, err := ss.Invite(context.Background(), adminID, resource, invitee.Email, RoleViewer); err != nil {
+		t.Errorf("expected the admin to share the project got %v", err)
+	}
+	if _, err := ss.Invite(context.Background(), creatorID, resource, invitee.Email, RoleOwner); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden for the creator member got %v", err)
+	}
+
+	// the creator removed from the organization can't share itself back in
+	delete(orgs.members[orgID], creatorID)
+	if _, err := ss.Invite(context.Background(), creatorID, resource, invitee.Email, RoleOwner); !errors.Is(err, serror.ErrProjectNotFound) {
+		t.Errorf("expected project not found for the creator removed got %v", err)
+	}
+	if err := ss.Revoke(context.Background(), creatorID, resource, invitee.ID); !errors.Is(err, serror.ErrProjectNotFound) {
+		t.Errorf("expected project not found revoking for the creator removed got %v", err)
+	}
+	if role := grants.roles[project.ID][invitee.ID]; role != RoleViewer {
+		t.Errorf("expected the grant of the admin to be kept got %v", role)
+	}
+}
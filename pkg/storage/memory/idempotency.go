@@ -0,0 +1,82 @@
+// Package memory provides storage implementations that keep their state in
+// the process memory, suited for tests and single replica deployments.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// Compile-time check for ensuring IdempotencyStorage implements pkg.IdempotencyStorage.
+var _ pkg.IdempotencyStorage = (*IdempotencyStorage)(nil)
+
+// sweepInterval is how often the expired records are dropped
+const sweepInterval = time.Minute
+
+// IdempotencyStorage provides an in-memory pkg.IdempotencyStorage implementation.
+type IdempotencyStorage struct {
+	mu        sync.Mutex
+	records   map[idempotencyKey]pkg.IdempotencyRecord
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type idempotencyKey struct {
+	scope string
+	key   string
+}
+
+// NewIdempotencyStore returns an initialized in-memory IdempotencyStorage.
+func NewIdempotencyStore() *IdempotencyStorage {
+	return &IdempotencyStorage{
+		records: make(map[idempotencyKey]pkg.IdempotencyRecord),
+		now:     time.Now,
+	}
+}
+
+// Reserve stores the record unless an unexpired one exists for its scope and key.
+func (m *IdempotencyStorage) Reserve(_ context.Context, record pkg.IdempotencyRecord) (pkg.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+	k := idempotencyKey{scope: record.Scope, key: record.Key}
+	if existing, ok := m.records[k]; ok && now.Before(existing.ExpiresAt) {
+		return existing, false, nil
+	}
+	m.records[k] = record
+	return record, true, nil
+}
+
+// Complete saves the response of the reserved record.
+func (m *IdempotencyStorage) Complete(_ context.Context, record pkg.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[idempotencyKey{scope: record.Scope, key: record.Key}] = record
+	return nil
+}
+
+// Release deletes the record of the scope and key.
+func (m *IdempotencyStorage) Release(_ context.Context, scope, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, idempotencyKey{scope: scope, key: key})
+	return nil
+}
+
+// sweep drops the expired records, it runs at most once per sweepInterval.
+func (m *IdempotencyStorage) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for k, r := range m.records {
+		if !now.Before(r.ExpiresAt) {
+			delete(m.records, k)
+		}
+	}
+}
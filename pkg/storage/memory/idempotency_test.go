@@ -0,0 +1,60 @@
+// +build unit_tests all_tests
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func TestIdempotencyStorage(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Now()
+	store := NewIdempotencyStore()
+	store.now = func() time.Time { return now }
+
+	record := pkg.IdempotencyRecord{Scope: "user", Key: "k1", Fingerprint: "f1", ExpiresAt: now.Add(time.Hour)}
+	_, reserved, err := store.Reserve(ctx, record)
+	if err != nil || !reserved {
+		t.Fatalf("expected first reserve to succeed got %t %v", reserved, err)
+	}
+
+	existing, reserved, _ := store.Reserve(ctx, record)
+	if reserved || existing.Completed() {
+		t.Errorf("expected in flight record to be returned")
+	}
+
+	record.StatusCode = 201
+	record.Body = []byte(`{}`)
+	if err = store.Complete(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	existing, reserved, _ = store.Reserve(ctx, pkg.IdempotencyRecord{Scope: "user", Key: "k1", ExpiresAt: now.Add(time.Hour)})
+	if reserved || existing.StatusCode != 201 || existing.Fingerprint != "f1" {
+		t.Errorf("expected completed record to be returned got %+v", existing)
+	}
+
+	// same key of another scope is independent
+	if _, reserved, _ = store.Reserve(ctx, pkg.IdempotencyRecord{Scope: "other", Key: "k1", ExpiresAt: now.Add(time.Hour)}); !reserved {
+		t.Errorf("expected key to be scoped")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, reserved, _ = store.Reserve(ctx, record); !reserved {
+		t.Errorf("expected expired record to be replaced")
+	}
+	if len(store.records) != 1 {
+		t.Errorf("expected expired records to be swept got %d", len(store.records))
+	}
+
+	if err = store.Release(ctx, "user", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, reserved, _ = store.Reserve(ctx, record); !reserved {
+		t.Errorf("expected released key to be reserved again")
+	}
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// Compile-time check for ensuring TodoSearcher implements pkg.TodoSearcher.
+var _ pkg.TodoSearcher = (*TodoSearcher)(nil)
+
+// TodoSearcher provides a pkg.TodoSearcher scanning the todos of a storage without
+// a full text index, only the ones it lists of the user are searched.
+type TodoSearcher struct {
+	repo pkg.TodoStorage
+}
+
+// NewTodoSearcher returns a TodoSearcher of the todos of the repo.
+func NewTodoSearcher(repo pkg.TodoStorage) *TodoSearcher {
+	return &TodoSearcher{repo: repo}
+}
+
+// SearchTodosOfUser ranks the todos by the number of words matched, the ones of the title
+// counting twice. The snippet is the title, followed by the content if it matches too.
+func (s *TodoSearcher) SearchTodosOfUser(ctx context.Context, userID uuid.UUID, terms []string, filter pkg.TodoFilter, n int) ([]pkg.TodoHit, error) {
+	todos, err := s.repo.FindAllTodoOfUser(ctx, userID, filter, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	var hits []pkg.TodoHit
+	for _, todo := range todos {
+		matched := make(map[string]bool, len(terms))
+		title, inTitle := markMatches(todo.Title, terms, matched)
+		content, inContent := markMatches(todo.Content, terms, matched)
+		if len(matched) != len(terms) {
+			continue
+		}
+		hit := pkg.TodoHit{Todo: todo, Rank: float64(2*inTitle + inContent), Snippet: title}
+		if inContent > 0 {
+			hit.Snippet += "\n" + content
+		}
+		hits = append(hits, hit)
+	}
+	// the storage order breaks the ties
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Rank > hits[j].Rank })
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	return hits, nil
+}
+
+// markMatches returns the text with its words starting with any of the terms marked,
+// and how many words matched. The terms matched are added to the matched.
+func markMatches(text string, terms []string, matched map[string]bool) (string, int) {
+	var b strings.Builder
+	var count int
+	start := -1
+	mark := func(end int) {
+		word := text[start:end]
+		lower := strings.ToLower(word)
+		for _, term := range terms {
+			if strings.HasPrefix(lower, term) {
+				matched[term] = true
+				count++
+				b.WriteString(pkg.MatchStart + word + pkg.MatchStop)
+				return
+			}
+		}
+		b.WriteString(word)
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			mark(i)
+			start = -1
+		}
+		b.WriteRune(r)
+	}
+	if start >= 0 {
+		mark(len(text))
+	}
+	return b.String(), count
+}
@@ -0,0 +1,62 @@
+// +build unit_tests all_tests
+
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// listedTodos is a pkg.TodoStorage that only lists its todos.
+type listedTodos struct {
+	pkg.TodoStorage
+	todos []pkg.TodoModel
+}
+
+func (l listedTodos) FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter pkg.TodoFilter, projectID uuid.UUID) ([]pkg.TodoModel, error) {
+	var todos []pkg.TodoModel
+	for _, todo := range l.todos {
+		if todo.UserID == userID && (filter != pkg.Finished || todo.Finished) && (filter != pkg.UnFinished || !todo.Finished) {
+			todos = append(todos, todo)
+		}
+	}
+	return todos, nil
+}
+
+func TestTodoSearcher(t *testing.T) {
+	t.Parallel()
+	userID := uuid.New()
+	inContent := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "Weekend", Content: "Buy groceries, then water the plants"}
+	inTitle := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "Groceries for the week", Finished: true}
+	other := pkg.TodoModel{ID: uuid.New(), UserID: uuid.New(), Title: "Groceries"}
+	searcher := NewTodoSearcher(listedTodos{todos: []pkg.TodoModel{inContent, inTitle, other}})
+
+	hits, err := searcher.SearchTodosOfUser(context.Background(), userID, []string{"grocer", "wee"}, pkg.NilFilter, 10)
+	if err != nil {
+		t.Fatalf("expected a nil error for search got %v", err)
+	}
+	if len(hits) != 2 || hits[0].Todo.ID != inTitle.ID || hits[1].Todo.ID != inContent.ID || hits[0].Rank <= hits[1].Rank {
+		t.Fatalf("expected the todos matching in their title first got %+v", hits)
+	}
+	if want := "\x02Groceries\x03 for the \x02week\x03"; hits[0].Snippet != want {
+		t.Errorf("expected the snippet %q got %q", want, hits[0].Snippet)
+	}
+	if want := "\x02Weekend\x03\nBuy \x02groceries\x03, then water the plants"; hits[1].Snippet != want {
+		t.Errorf("expected the snippet %q got %q", want, hits[1].Snippet)
+	}
+
+	hits, _ = searcher.SearchTodosOfUser(context.Background(), userID, []string{"grocer"}, pkg.UnFinished, 10)
+	if len(hits) != 1 || hits[0].Todo.ID != inContent.ID {
+		t.Errorf("expected only the unfinished todo got %+v", hits)
+	}
+	if hits, _ = searcher.SearchTodosOfUser(context.Background(), userID, []string{"grocer", "plant"}, pkg.NilFilter, 10); len(hits) != 1 {
+		t.Errorf("expected only the todo matching all the terms got %+v", hits)
+	}
+	if hits, _ = searcher.SearchTodosOfUser(context.Background(), userID, []string{"grocer"}, pkg.NilFilter, 1); len(hits) != 1 {
+		t.Errorf("expected at most n hits got %+v", hits)
+	}
+}
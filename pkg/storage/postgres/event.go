@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring EventStorage implements pkg.EventStorage and pkg.EventLog.
+var (
+	_ pkg.EventStorage = (*EventStorage)(nil)
+	_ pkg.EventLog     = (*EventStorage)(nil)
+)
+
+// EventStorage provides an Event Storage implementation over a PostgreSQL database.
+// The events are written to the outbox_events by the triggers of the todos and users
+// tables, so in the same transaction as the change, whichever storage makes it.
+type EventStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewEventStore returns an initialized EventStorage storage with connection pool
+func NewEventStore(db *pgxpool.Pool) (EventStorage, error) {
+	if db == nil {
+		return EventStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return EventStorage{db: db}, nil
+}
+
+// ClaimEvents locks and leases the due events in the DB, and returns them
+func (s EventStorage) ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, n int) ([]pkg.Event, error) {
+	rows, err := s.db.Query(ctx, claimEventsQuery, now, now.Add(lease), n)
+	if err != nil {
+		return nil, serror.NewQueryError(claimEventsQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var events []pkg.Event
+	for rows.Next() {
+		var e pkg.Event
+		if err = scanEvent(rows, &e, &e.Attempt); err != nil {
+			return nil, serror.NewQueryError(claimEventsQuery, err, err.Error())
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(claimEventsQuery, err, err.Error())
+	}
+	// the UPDATE doesn't return the rows in the order of the due ones.
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// MarkRelayed marks the event relayed inside the DB
+func (s EventStorage) MarkRelayed(ctx context.Context, e pkg.Event) error {
+	if _, err := s.db.Exec(ctx, markEventRelayedQuery, e.ID, e.Attempt); err != nil {
+		return serror.NewQueryError(markEventRelayedQuery, err, err.Error())
+	}
+	return nil
+}
+
+// MarkEventFailed records the failed attempt to relay the event inside the DB
+func (s EventStorage) MarkEventFailed(ctx context.Context, e pkg.Event, reason string, retryAt time.Time, dead bool) error {
+	status := "pending"
+	if dead {
+		status = "dead"
+	}
+	if _, err := s.db.Exec(ctx, markEventFailedQuery, e.ID, e.Attempt, status, retryAt, reason); err != nil {
+		return serror.NewQueryError(markEventFailedQuery, err, err.Error())
+	}
+	return nil
+}
+
+// PurgeEvents deletes the relayed and dead events from the DB, the
+// ones a webhook delivery is left of are kept.
+func (s EventStorage) PurgeEvents(ctx context.Context, before time.Time) (int64, error) {
+	cmd, err := s.db.Exec(ctx, purgeEventsQuery, before)
+	if err != nil {
+		return 0, serror.NewQueryError(purgeEventsQuery, err, err.Error())
+	}
+	return cmd.RowsAffected(), nil
+}
+
+// LastEventID returns the id of the latest event of the log in the DB
+func (s EventStorage) LastEventID(ctx context.Context) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(ctx, lastEventIDQuery).Scan(&id)
+	switch err {
+	case nil, pgx.ErrNoRows:
+		return id, nil
+	default:
+		return 0, serror.NewQueryError(lastEventIDQuery, err, err.Error())
+	}
+}
+
+// EventsAfter returns the events of the types logged after the event of the afterID in the DB
+func (s EventStorage) EventsAfter(ctx context.Context, afterID int64, types []string, n int) ([]pkg.Event, error) {
+	var txid int64
+	if afterID != 0 {
+		err := s.db.QueryRow(ctx, findEventTxidQuery, afterID).Scan(&txid)
+		switch err {
+		case nil:
+		case pgx.ErrNoRows:
+			return nil, serror.NewQueryError(findEventTxidQuery, serror.ErrEventNotFound, err.Error())
+		default:
+			return nil, serror.NewQueryError(findEventTxidQuery, err, err.Error())
+		}
+	}
+
+	rows, err := s.db.Query(ctx, eventsAfterQuery, txid, afterID, types, n)
+	if err != nil {
+		return nil, serror.NewQueryError(eventsAfterQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var events []pkg.Event
+	for rows.Next() {
+		var e pkg.Event
+		if err = scanEvent(rows, &e); err != nil {
+			return nil, serror.NewQueryError(eventsAfterQuery, err, err.Error())
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(eventsAfterQuery, err, err.Error())
+	}
+	return events, nil
+}
+
+// ListenEvents listens to the outbox_events channel on a connection of its own,
+// taken from the pool until the ctx is done or the connection fails.
+func (s EventStorage) ListenEvents(ctx context.Context, notify func()) error {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// a connection still listening isn't handed back to the pool.
+		if !conn.Conn().IsClosed() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := conn.Exec(ctx, unlistenEventsQuery); err != nil {
+				conn.Conn().Close(ctx)
+			}
+		}
+		conn.Release()
+	}()
+
+	if _, err = conn.Exec(ctx, listenEventsQuery); err != nil {
+		return serror.NewQueryError(listenEventsQuery, err, err.Error())
+	}
+	// the events logged before the LISTEN are only told by this call.
+	notify()
+	for {
+		if _, err = conn.Conn().WaitForNotification(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		notify()
+	}
+}
+
+// scanEvent scans the row of the event columns, followed by the dest, the NULL org_id is zero.
+func scanEvent(row pgx.Row, e *pkg.Event, dest ...interface{}) error {
+	var orgID *uuid.UUID
+	var data []byte
+	err := row.Scan(append([]interface{}{&e.ID, &e.Type, &e.UserID, &orgID, &e.SubjectID, &data, &e.OccurredAt},
+		dest...)...)
+	if err != nil {
+		return err
+	}
+	if orgID != nil {
+		e.OrgID = *orgID
+	}
+	e.Data = data
+	return nil
+}
@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring GrantStorage implements pkg.GrantStorage.
+var _ pkg.GrantStorage = (*GrantStorage)(nil)
+
+// GrantStorage provides a shared Grant Storage implementation over a PostgreSQL database
+type GrantStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewGrantStore returns an initialized GrantStorage storage with connection pool
+func NewGrantStore(db *pgxpool.Pool) (GrantStorage, error) {
+	if db == nil {
+		return GrantStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return GrantStorage{db: db}, nil
+}
+
+// RoleOf returns the highest role granted to the user on the todo or the project in the DB
+func (s GrantStorage) RoleOf(ctx context.Context, userID, todoID, projectID uuid.UUID) (pkg.Role, error) {
+	var role int16
+	err := s.db.QueryRow(ctx, roleOfUserQuery, userID, nullUUID(todoID), nullUUID(projectID)).Scan(&role)
+	if err != nil {
+		return pkg.RoleNone, serror.NewQueryError(roleOfUserQuery, err, err.Error())
+	}
+	return pkg.Role(role), nil
+}
+
+// FindGrantsOf returns the grants on the resource in the DB
+func (s GrantStorage) FindGrantsOf(ctx context.Context, resource pkg.Resource) ([]pkg.GrantModel, error) {
+	query := findGrantsOfTodoQuery
+	if resource.Type == pkg.ResourceProject {
+		query = findGrantsOfProjectQuery
+	}
+	return s.findGrants(ctx, query, resource.ID)
+}
+
+// FindGrantsOfUser returns the grants shared with the user in the DB
+func (s GrantStorage) FindGrantsOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.GrantModel, error) {
+	return s.findGrants(ctx, findGrantsOfUserQuery, userID)
+}
+
+func (s GrantStorage) findGrants(ctx context.Context, query string, id uuid.UUID) ([]pkg.GrantModel, error) {
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	defer rows.Close()
+
+	var grants []pkg.GrantModel
+	for rows.Next() {
+		var grant pkg.GrantModel
+		var todoID, projectID *uuid.UUID
+		var role int16
+		err = rows.Scan(&todoID, &projectID, &grant.UserID, &role, &grant.GrantedBy)
+		if err != nil {
+			return nil, serror.NewQueryError(query, err, err.Error())
+		}
+		grant.Role = pkg.Role(role)
+		if todoID != nil {
+			grant.Resource = pkg.Resource{Type: pkg.ResourceTodo, ID: *todoID}
+		} else if projectID != nil {
+			grant.Resource = pkg.Resource{Type: pkg.ResourceProject, ID: *projectID}
+		}
+		grants = append(grants, grant)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	return grants, nil
+}
+
+// UpsertOne stores the grant inside the DB, replacing the role of the user on the resource
+func (s GrantStorage) UpsertOne(ctx context.Context, grant pkg.GrantModel) error {
+	query := upsertTodoGrantQuery
+	if grant.Resource.Type == pkg.ResourceProject {
+		query = upsertProjectGrantQuery
+	}
+	_, err := s.db.Exec(ctx, query, grant.Resource.ID, grant.UserID, int16(grant.Role), grant.GrantedBy)
+	if err != nil {
+		return serror.NewQueryError(query, err, err.Error())
+	}
+	return nil
+}
+
+// DeleteOne deletes the grant of the user on the resource from the DB
+func (s GrantStorage) DeleteOne(ctx context.Context, resource pkg.Resource, userID uuid.UUID) error {
+	query := deleteTodoGrantQuery
+	if resource.Type == pkg.ResourceProject {
+		query = deleteProjectGrantQuery
+	}
+	cmd, err := s.db.Exec(ctx, query, resource.ID, userID)
+	if err != nil {
+		return serror.NewQueryError(query, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(query, serror.ErrGrantNotFound, "")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring IdempotencyStorage implements pkg.IdempotencyStorage.
+var _ pkg.IdempotencyStorage = (*IdempotencyStorage)(nil)
+
+// IdempotencyStorage provides an Idempotency Key Storage implementation over a PostgreSQL database,
+// which is shared by all the replicas.
+type IdempotencyStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewIdempotencyStore returns an initialized IdempotencyStorage storage with connection pool
+func NewIdempotencyStore(db *pgxpool.Pool) (IdempotencyStorage, error) {
+	if db == nil {
+		return IdempotencyStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return IdempotencyStorage{db: db}, nil
+}
+
+// Reserve stores the record unless an unexpired one exists for its scope and key,
+// the unique constraint on both makes it atomic.
+func (s IdempotencyStorage) Reserve(ctx context.Context, record pkg.IdempotencyRecord) (pkg.IdempotencyRecord, bool, error) {
+	var key string
+	err := s.db.QueryRow(ctx, reserveIdempotencyKeyQuery, record.Scope, record.Key, record.Fingerprint, record.ExpiresAt).Scan(&key)
+	switch err {
+	case nil:
+		return record, true, nil
+	case pgx.ErrNoRows:
+		// a live record already exists
+	default:
+		return pkg.IdempotencyRecord{}, false, serror.NewQueryError(reserveIdempotencyKeyQuery, err, err.Error())
+	}
+
+	var existing pkg.IdempotencyRecord
+	var header []byte
+	err = s.db.QueryRow(ctx, findIdempotencyKeyQuery, record.Scope, record.Key).Scan(&existing.Scope, &existing.Key,
+		&existing.Fingerprint, &existing.StatusCode, &header, &existing.Body, &existing.ExpiresAt)
+	if err != nil {
+		return pkg.IdempotencyRecord{}, false, serror.NewQueryError(findIdempotencyKeyQuery, err, err.Error())
+	}
+	if header != nil {
+		if err = json.Unmarshal(header, &existing.Header); err != nil {
+			return pkg.IdempotencyRecord{}, false, serror.NewQueryError(findIdempotencyKeyQuery, err, err.Error())
+		}
+	}
+	return existing, false, nil
+}
+
+// Complete saves the response of the reserved record.
+func (s IdempotencyStorage) Complete(ctx context.Context, record pkg.IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return serror.NewQueryError(completeIdempotencyKeyQuery, err, err.Error())
+	}
+	cmd, err := s.db.Exec(ctx, completeIdempotencyKeyQuery, record.Scope, record.Key, record.StatusCode, header, record.Body)
+	if err != nil {
+		return serror.NewQueryError(completeIdempotencyKeyQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(completeIdempotencyKeyQuery, serror.ErrUpdateCommand, "")
+	}
+	return nil
+}
+
+// Release deletes the record of the scope and key.
+func (s IdempotencyStorage) Release(ctx context.Context, scope, key string) error {
+	_, err := s.db.Exec(ctx, releaseIdempotencyKeyQuery, scope, key)
+	if err != nil {
+		return serror.NewQueryError(releaseIdempotencyKeyQuery, err, err.Error())
+	}
+	return nil
+}
+
+// PurgeExpired deletes the expired records and returns how many were deleted.
+func (s IdempotencyStorage) PurgeExpired(ctx context.Context) (int64, error) {
+	cmd, err := s.db.Exec(ctx, purgeIdempotencyKeysQuery)
+	if err != nil {
+		return 0, serror.NewQueryError(purgeIdempotencyKeysQuery, err, err.Error())
+	}
+	return cmd.RowsAffected(), nil
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring ItemStorage implements pkg.ItemStorage.
+var _ pkg.ItemStorage = (*ItemStorage)(nil)
+
+// ItemStorage provides a checklist Item Storage implementation over a PostgreSQL database
+type ItemStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewItemStore returns an initialized ItemStorage storage with connection pool
+func NewItemStore(db *pgxpool.Pool) (ItemStorage, error) {
+	if db == nil {
+		return ItemStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return ItemStorage{db: db}, nil
+}
+
+// FindItemsOfTodo returns the items of the todo in the DB
+func (s ItemStorage) FindItemsOfTodo(ctx context.Context, todoID uuid.UUID) ([]pkg.ItemModel, error) {
+	rows, err := s.db.Query(ctx, findItemsOfTodoQuery, todoID)
+	if err != nil {
+		return nil, serror.NewQueryError(findItemsOfTodoQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var items []pkg.ItemModel
+	for rows.Next() {
+		var item pkg.ItemModel
+		err = rows.Scan(&item.ID, &item.TodoID, &item.Text, &item.Position, &item.Done)
+		if err != nil {
+			return nil, serror.NewQueryError(findItemsOfTodoQuery, err, err.Error())
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findItemsOfTodoQuery, err, err.Error())
+	}
+	return items, nil
+}
+
+// ProgressOfTodos counts the items and the done ones of the todos in a single query
+func (s ItemStorage) ProgressOfTodos(ctx context.Context, todoIDs []uuid.UUID) (map[uuid.UUID]pkg.Progress, error) {
+	ids := make([]string, len(todoIDs))
+	for i, id := range todoIDs {
+		ids[i] = id.String()
+	}
+	rows, err := s.db.Query(ctx, progressOfTodosQuery, ids)
+	if err != nil {
+		return nil, serror.NewQueryError(progressOfTodosQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	progress := make(map[uuid.UUID]pkg.Progress, len(todoIDs))
+	for rows.Next() {
+		var id uuid.UUID
+		var p pkg.Progress
+		if err = rows.Scan(&id, &p.Total, &p.Done); err != nil {
+			return nil, serror.NewQueryError(progressOfTodosQuery, err, err.Error())
+		}
+		progress[id] = p
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(progressOfTodosQuery, err, err.Error())
+	}
+	return progress, nil
+}
+
+// InsertOne stores the item inside the DB
+func (s ItemStorage) InsertOne(ctx context.Context, userID uuid.UUID, item pkg.ItemModel) (pkg.ItemModel, pkg.TodoModel, error) {
+	todo, err := s.write(ctx, item.TodoID, userID, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, storeItemQuery, item.ID, item.TodoID, item.Text, item.Position, item.Done).Scan(&item.Position)
+		if err != nil {
+			return serror.NewQueryError(storeItemQuery, err, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return pkg.NilItemModel, pkg.NilTodoModel, err
+	}
+	return item, todo, nil
+}
+
+// UpdateOne stores the updated item inside the DB
+func (s ItemStorage) UpdateOne(ctx context.Context, userID uuid.UUID, item pkg.ItemModel) (pkg.ItemModel, pkg.TodoModel, error) {
+	todo, err := s.write(ctx, item.TodoID, userID, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, updateItemQuery, item.ID, item.TodoID, item.Text, item.Position, item.Done).Scan(&item.Position)
+		switch err {
+		case nil:
+			return nil
+		case pgx.ErrNoRows:
+			return serror.NewQueryError(updateItemQuery, serror.ErrItemNotFound, err.Error())
+		default:
+			return serror.NewQueryError(updateItemQuery, err, err.Error())
+		}
+	})
+	if err != nil {
+		return pkg.NilItemModel, pkg.NilTodoModel, err
+	}
+	return item, todo, nil
+}
+
+// DeleteOne deletes the item from the DB
+func (s ItemStorage) DeleteOne(ctx context.Context, userID, todoID, id uuid.UUID) (pkg.TodoModel, error) {
+	return s.write(ctx, todoID, userID, func(tx pgx.Tx) error {
+		cmd, err := tx.Exec(ctx, deleteItemQuery, id, todoID)
+		if err != nil {
+			return serror.NewQueryError(deleteItemQuery, err, err.Error())
+		}
+		if cmd.RowsAffected() != 1 {
+			return serror.NewQueryError(deleteItemQuery, serror.ErrItemNotFound, "")
+		}
+		return nil
+	})
+}
+
+// write runs the item write fn inside a transaction, holding the lock of the todo
+// so concurrent writes on its items are serialized, and returns the touched todo.
+func (s ItemStorage) write(ctx context.Context, todoID, userID uuid.UUID, fn func(tx pgx.Tx) error) (pkg.TodoModel, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return pkg.NilTodoModel, serror.NewQueryError("BEGIN", err, err.Error())
+	}
+	// no-op once committed
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var version int64
+	err = tx.QueryRow(ctx, lockTodoOfUserQuery, todoID, userID).Scan(&version)
+	switch err {
+	case nil:
+	case pgx.ErrNoRows:
+		return pkg.NilTodoModel, serror.NewQueryError(lockTodoOfUserQuery, serror.ErrTodoNotFound, err.Error())
+	default:
+		return pkg.NilTodoModel, serror.NewQueryError(lockTodoOfUserQuery, err, err.Error())
+	}
+
+	if err = fn(tx); err != nil {
+		return pkg.NilTodoModel, err
+	}
+
+	var todo pkg.TodoModel
+	err = scanTodo(tx.QueryRow(ctx, touchTodoOfItemQuery, todoID), &todo)
+	if err != nil {
+		return pkg.NilTodoModel, serror.NewQueryError(touchTodoOfItemQuery, err, err.Error())
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return pkg.NilTodoModel, serror.NewQueryError("COMMIT", err, err.Error())
+	}
+	return todo, nil
+}
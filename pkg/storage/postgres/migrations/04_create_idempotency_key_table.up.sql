@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope varchar(64) NOT NULL,
+    idempotency_key varchar(255) NOT NULL,
+    fingerprint varchar(64) NOT NULL,
+    status_code integer NOT NULL DEFAULT 0,
+    header jsonb,
+    body bytea,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    CONSTRAINT idempotency_key_pk PRIMARY KEY(scope, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS todo_items;
+DROP INDEX IF EXISTS todos_todo_id_key;
+ALTER TABLE todos DROP COLUMN IF EXISTS auto_finish;
\ No newline at end of file
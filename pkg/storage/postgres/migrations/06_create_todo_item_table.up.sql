@@ -0,0 +1,14 @@
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS auto_finish boolean NOT NULL DEFAULT FALSE;
+-- the items reference the todo_id alone, the todo_pk also has the created_at.
+CREATE UNIQUE INDEX IF NOT EXISTS todos_todo_id_key ON todos (todo_id);
+CREATE TABLE IF NOT EXISTS todo_items (
+    item_id uuid NOT NULL,
+    todo_id uuid NOT NULL,
+    text varchar(255) NOT NULL,
+    position integer NOT NULL,
+    done boolean NOT NULL DEFAULT FALSE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT todo_item_pk PRIMARY KEY(item_id),
+    CONSTRAINT todo_item_fk FOREIGN KEY (todo_id) REFERENCES todos (todo_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS todo_items_todo_id_idx ON todo_items (todo_id, position);
\ No newline at end of file
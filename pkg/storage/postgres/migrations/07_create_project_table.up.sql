@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS projects (
+    project_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    name varchar(100) NOT NULL,
+    color varchar(7) NOT NULL DEFAULT '',
+    archived boolean NOT NULL DEFAULT FALSE,
+    inbox boolean NOT NULL DEFAULT FALSE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT project_pk PRIMARY KEY(project_id),
+    CONSTRAINT project_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+-- a single inbox by user
+CREATE UNIQUE INDEX IF NOT EXISTS projects_inbox_idx ON projects (user_id) WHERE inbox;
+INSERT INTO projects (project_id, user_id, name, inbox)
+SELECT md5(random()::text || user_id::text)::uuid, user_id, 'Inbox', TRUE FROM users
+ON CONFLICT DO NOTHING;
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS project_id uuid;
+UPDATE todos SET project_id = projects.project_id FROM projects
+WHERE projects.user_id = todos.user_id AND projects.inbox AND todos.project_id IS NULL;
+ALTER TABLE todos ALTER COLUMN project_id SET NOT NULL;
+ALTER TABLE todos ADD CONSTRAINT todo_project_fk FOREIGN KEY (project_id) REFERENCES projects (project_id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS todos_project_id_idx ON todos (project_id);
\ No newline at end of file
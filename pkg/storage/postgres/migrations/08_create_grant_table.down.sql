@@ -0,0 +1 @@
+DROP TABLE IF EXISTS grants;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS grants (
+    todo_id uuid,
+    project_id uuid,
+    user_id uuid NOT NULL,
+    role smallint NOT NULL,
+    granted_by uuid NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    -- a grant is either on a todo or on a project
+    CONSTRAINT grant_resource_check CHECK ((todo_id IS NULL) <> (project_id IS NULL)),
+    CONSTRAINT grant_role_check CHECK (role BETWEEN 1 AND 3),
+    CONSTRAINT grant_todo_fk FOREIGN KEY (todo_id) REFERENCES todos (todo_id) ON DELETE CASCADE,
+    CONSTRAINT grant_project_fk FOREIGN KEY (project_id) REFERENCES projects (project_id) ON DELETE CASCADE,
+    CONSTRAINT grant_user_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+-- the NULL resource of the other kind never conflicts
+CREATE UNIQUE INDEX IF NOT EXISTS grants_todo_user_key ON grants (todo_id, user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS grants_project_user_key ON grants (project_id, user_id);
+CREATE INDEX IF NOT EXISTS grants_user_id_idx ON grants (user_id);
\ No newline at end of file
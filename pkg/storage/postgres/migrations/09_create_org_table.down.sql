@@ -0,0 +1,3 @@
+ALTER TABLE projects DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS orgs;
\ No newline at end of file
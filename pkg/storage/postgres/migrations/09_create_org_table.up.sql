@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS orgs (
+    org_id uuid NOT NULL,
+    name varchar(100) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT org_pk PRIMARY KEY(org_id)
+);
+CREATE TABLE IF NOT EXISTS memberships (
+    org_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    -- guest, member, admin or owner
+    role smallint NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT membership_pk PRIMARY KEY(org_id, user_id),
+    CONSTRAINT membership_role_check CHECK (role BETWEEN 1 AND 4),
+    CONSTRAINT membership_org_fk FOREIGN KEY (org_id) REFERENCES orgs (org_id) ON DELETE CASCADE,
+    CONSTRAINT membership_user_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS memberships_user_id_idx ON memberships (user_id);
+-- a NULL org_id is a personal project, the inbox is always one
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS org_id uuid;
+ALTER TABLE projects ADD CONSTRAINT project_org_fk FOREIGN KEY (org_id) REFERENCES orgs (org_id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS projects_org_id_idx ON projects (org_id);
\ No newline at end of file
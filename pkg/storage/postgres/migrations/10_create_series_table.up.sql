@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS series (
+    series_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    -- RFC 5545 RRULE value, repeating in the IANA time_zone from the start_at
+    rrule varchar(255) NOT NULL,
+    time_zone varchar(64) NOT NULL,
+    start_at timestamptz NOT NULL,
+    -- of the occurrences to come
+    title varchar(255) NOT NULL,
+    content text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT series_pk PRIMARY KEY(series_id),
+    CONSTRAINT series_user_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_at timestamptz;
+-- a NULL series_id is a todo that doesn't recur
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS series_id uuid;
+ALTER TABLE todos ADD CONSTRAINT todo_series_fk FOREIGN KEY (series_id) REFERENCES series (series_id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS todos_series_id_idx ON todos (series_id);
\ No newline at end of file
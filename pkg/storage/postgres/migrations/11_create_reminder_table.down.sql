@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS todos_due_at_idx;
+DROP TABLE IF EXISTS reminders;
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS reminders (
+    reminder_id uuid NOT NULL,
+    todo_id uuid NOT NULL,
+    -- the user reminded
+    user_id uuid NOT NULL,
+    -- delivered that many seconds before the todo is due
+    offset_seconds integer NOT NULL,
+    channel varchar(16) NOT NULL,
+    -- the due time of the todo the delivery is about, a new one schedules it again
+    due_at timestamptz,
+    -- pending, sent or dead once given up on
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    -- the retry of a pending delivery, or the end of the lease of a claimed one
+    next_attempt_at timestamptz,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT reminder_pk PRIMARY KEY(reminder_id),
+    CONSTRAINT reminder_todo_fk FOREIGN KEY (todo_id) REFERENCES todos (todo_id) ON DELETE CASCADE,
+    CONSTRAINT reminder_user_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS reminders_todo_id_idx ON reminders (todo_id);
+CREATE INDEX IF NOT EXISTS todos_due_at_idx ON todos (due_at) WHERE due_at IS NOT NULL AND NOT finished AND deleted_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TRIGGER IF EXISTS users_outbox_signed_up ON users;
+DROP FUNCTION IF EXISTS outbox_user_event();
+DROP TRIGGER IF EXISTS todos_outbox_completed ON todos;
+DROP TRIGGER IF EXISTS todos_outbox_created ON todos;
+DROP FUNCTION IF EXISTS outbox_todo_event();
+DROP TABLE IF EXISTS outbox_events;
\ No newline at end of file
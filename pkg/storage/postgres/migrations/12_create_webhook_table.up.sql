@@ -0,0 +1,87 @@
+-- the outbox of the events, written by the triggers in the transaction of the change
+CREATE TABLE IF NOT EXISTS outbox_events (
+    event_id bigserial NOT NULL,
+    event_type varchar(64) NOT NULL,
+    -- the user the event is about, the owner of the todo or the user signed up
+    user_id uuid NOT NULL,
+    -- the organization of the project of the todo, NULL for a personal one
+    org_id uuid,
+    subject_id uuid NOT NULL,
+    payload jsonb NOT NULL,
+    occurred_at timestamptz NOT NULL DEFAULT now(),
+    -- set once turned into the deliveries of the subscribed webhooks
+    dispatched_at timestamptz,
+    CONSTRAINT outbox_event_pk PRIMARY KEY(event_id)
+);
+CREATE INDEX IF NOT EXISTS outbox_events_undispatched_idx ON outbox_events (event_id) WHERE dispatched_at IS NULL;
+CREATE OR REPLACE FUNCTION outbox_todo_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO outbox_events (event_type, user_id, org_id, subject_id, payload)
+    VALUES (
+        CASE TG_OP WHEN 'INSERT' THEN 'todo.created' ELSE 'todo.completed' END,
+        NEW.user_id,
+        (SELECT org_id FROM projects WHERE project_id = NEW.project_id),
+        NEW.todo_id,
+        jsonb_build_object('id', NEW.todo_id, 'user_id', NEW.user_id, 'project_id', NEW.project_id,
+            'title', NEW.title, 'content', NEW.content, 'finished', NEW.finished, 'version', NEW.version,
+            'due_at', NEW.due_at)
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER todos_outbox_created AFTER INSERT ON todos
+FOR EACH ROW EXECUTE PROCEDURE outbox_todo_event();
+CREATE TRIGGER todos_outbox_completed AFTER UPDATE OF finished ON todos
+FOR EACH ROW WHEN (NOT OLD.finished AND NEW.finished) EXECUTE PROCEDURE outbox_todo_event();
+CREATE OR REPLACE FUNCTION outbox_user_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO outbox_events (event_type, user_id, subject_id, payload)
+    VALUES ('user.signed_up', NEW.user_id, NEW.user_id,
+        jsonb_build_object('id', NEW.user_id, 'email', NEW.email_id, 'first_name', NEW.first_name,
+            'last_name', NEW.last_name, 'username', NEW.user_name));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER users_outbox_signed_up AFTER INSERT ON users
+FOR EACH ROW EXECUTE PROCEDURE outbox_user_event();
+CREATE TABLE IF NOT EXISTS webhooks (
+    webhook_id uuid NOT NULL,
+    -- the user who registered it
+    user_id uuid NOT NULL,
+    -- NULL for a personal webhook
+    org_id uuid,
+    url text NOT NULL,
+    secret varchar(128) NOT NULL,
+    events text[] NOT NULL,
+    disabled boolean NOT NULL DEFAULT FALSE,
+    -- the failed attempts in a row
+    failures integer NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT webhook_pk PRIMARY KEY(webhook_id),
+    CONSTRAINT webhook_user_fk FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    CONSTRAINT webhook_org_fk FOREIGN KEY (org_id) REFERENCES orgs (org_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id) WHERE org_id IS NULL;
+CREATE INDEX IF NOT EXISTS webhooks_org_id_idx ON webhooks (org_id);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id bigserial NOT NULL,
+    webhook_id uuid NOT NULL,
+    event_id bigint NOT NULL,
+    event_type varchar(64) NOT NULL,
+    -- pending, delivered or dead once given up on
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    -- the status code of the last attempt, 0 without response
+    response_code integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    -- the retry of a pending delivery, or the end of the lease of a claimed one
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    delivered_at timestamptz,
+    CONSTRAINT webhook_delivery_pk PRIMARY KEY(delivery_id),
+    CONSTRAINT webhook_delivery_event_key UNIQUE (webhook_id, event_id),
+    CONSTRAINT webhook_delivery_webhook_fk FOREIGN KEY (webhook_id) REFERENCES webhooks (webhook_id) ON DELETE CASCADE,
+    CONSTRAINT webhook_delivery_event_fk FOREIGN KEY (event_id) REFERENCES outbox_events (event_id)
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event_id_idx ON webhook_deliveries (event_id);
\ No newline at end of file
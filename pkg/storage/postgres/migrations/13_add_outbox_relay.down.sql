@@ -0,0 +1,26 @@
+DROP TRIGGER IF EXISTS todos_outbox_deleted ON todos;
+DROP TRIGGER IF EXISTS todos_outbox_updated ON todos;
+CREATE OR REPLACE FUNCTION outbox_todo_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO outbox_events (event_type, user_id, org_id, subject_id, payload)
+    VALUES (
+        CASE TG_OP WHEN 'INSERT' THEN 'todo.created' ELSE 'todo.completed' END,
+        NEW.user_id,
+        (SELECT org_id FROM projects WHERE project_id = NEW.project_id),
+        NEW.todo_id,
+        jsonb_build_object('id', NEW.todo_id, 'user_id', NEW.user_id, 'project_id', NEW.project_id,
+            'title', NEW.title, 'content', NEW.content, 'finished', NEW.finished, 'version', NEW.version,
+            'due_at', NEW.due_at)
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER todos_outbox_completed AFTER UPDATE OF finished ON todos
+FOR EACH ROW WHEN (NOT OLD.finished AND NEW.finished) EXECUTE PROCEDURE outbox_todo_event();
+DROP INDEX IF EXISTS outbox_events_pending_idx;
+CREATE INDEX IF NOT EXISTS outbox_events_undispatched_idx ON outbox_events (event_id) WHERE relayed_at IS NULL;
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS last_error;
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS attempts;
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS status;
+ALTER TABLE outbox_events RENAME COLUMN relayed_at TO dispatched_at;
\ No newline at end of file
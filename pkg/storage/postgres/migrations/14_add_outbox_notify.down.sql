@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS outbox_events_notify ON outbox_events;
+DROP FUNCTION IF EXISTS notify_outbox_events();
+DROP INDEX IF EXISTS outbox_events_txid_idx;
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS txid;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- the events are read in the order of the txid of their transaction, once all the
+-- transactions with a lower one are over, so none is read after a later one.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS txid bigint NOT NULL DEFAULT txid_current();
+CREATE INDEX IF NOT EXISTS outbox_events_txid_idx ON outbox_events (txid, event_id);
+-- the listeners are told once per transaction that events were logged.
+CREATE OR REPLACE FUNCTION notify_outbox_events() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_events', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER outbox_events_notify AFTER INSERT ON outbox_events
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_outbox_events();
\ No newline at end of file
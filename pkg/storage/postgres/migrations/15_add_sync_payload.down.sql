@@ -0,0 +1,43 @@
+CREATE OR REPLACE FUNCTION outbox_todo_event() RETURNS trigger AS $$
+DECLARE
+    todo todos;
+    typ varchar(64);
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        todo := NEW;
+        typ := 'todo.created';
+    ELSIF TG_OP = 'DELETE' THEN
+        -- the todos in the trash were told deleted already
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        todo := OLD;
+        typ := 'todo.deleted';
+    ELSE
+        todo := NEW;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            typ := 'todo.deleted';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            typ := 'todo.restored';
+        ELSIF NOT OLD.finished AND NEW.finished THEN
+            typ := 'todo.completed';
+        ELSIF (OLD.title, OLD.content, OLD.finished, OLD.due_at, OLD.project_id)
+            IS DISTINCT FROM (NEW.title, NEW.content, NEW.finished, NEW.due_at, NEW.project_id) THEN
+            typ := 'todo.updated';
+        ELSE
+            RETURN NULL;
+        END IF;
+    END IF;
+    INSERT INTO outbox_events (event_type, user_id, org_id, subject_id, payload)
+    VALUES (
+        typ,
+        todo.user_id,
+        (SELECT org_id FROM projects WHERE project_id = todo.project_id),
+        todo.todo_id,
+        jsonb_build_object('id', todo.todo_id, 'user_id', todo.user_id, 'project_id', todo.project_id,
+            'title', todo.title, 'content', todo.content, 'finished', todo.finished, 'version', todo.version,
+            'due_at', todo.due_at, 'deleted_at', todo.deleted_at)
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,6 @@
+-- the words of the title and content of the todos, the ones of the title ranked higher.
+-- the simple config neither stems nor drops any word, the todos being in any language.
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', content), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS todos_search_idx ON todos USING GIN (search);
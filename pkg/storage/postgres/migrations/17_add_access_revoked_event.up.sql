@@ -0,0 +1,70 @@
+-- the synced clients forget the todos a user can no longer see: a grant or a membership
+-- removed is logged for the user who lost it, so its next sync starts from scratch.
+CREATE OR REPLACE FUNCTION outbox_access_revoked_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'grants' THEN
+        INSERT INTO outbox_events (event_type, user_id, subject_id, payload)
+        VALUES ('access.revoked', OLD.user_id, COALESCE(OLD.todo_id, OLD.project_id),
+            jsonb_build_object('user_id', OLD.user_id, 'todo_id', OLD.todo_id, 'project_id', OLD.project_id));
+    ELSE
+        INSERT INTO outbox_events (event_type, user_id, org_id, subject_id, payload)
+        VALUES ('access.revoked', OLD.user_id, OLD.org_id, OLD.org_id,
+            jsonb_build_object('user_id', OLD.user_id, 'org_id', OLD.org_id));
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER grants_outbox_revoked AFTER DELETE ON grants
+FOR EACH ROW EXECUTE PROCEDURE outbox_access_revoked_event();
+CREATE TRIGGER memberships_outbox_revoked AFTER DELETE ON memberships
+FOR EACH ROW EXECUTE PROCEDURE outbox_access_revoked_event();
+
+-- a todo moved to another project tells the one it left, whose viewers may no longer see it
+CREATE OR REPLACE FUNCTION outbox_todo_event() RETURNS trigger AS $$
+DECLARE
+    todo todos;
+    typ varchar(64);
+    moved_from uuid;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        todo := NEW;
+        typ := 'todo.created';
+    ELSIF TG_OP = 'DELETE' THEN
+        -- the todos in the trash were told deleted already
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        todo := OLD;
+        typ := 'todo.deleted';
+    ELSE
+        todo := NEW;
+        IF OLD.project_id IS DISTINCT FROM NEW.project_id THEN
+            moved_from := OLD.project_id;
+        END IF;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            typ := 'todo.deleted';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            typ := 'todo.restored';
+        ELSIF NOT OLD.finished AND NEW.finished THEN
+            typ := 'todo.completed';
+        ELSIF (OLD.title, OLD.content, OLD.finished, OLD.auto_finish, OLD.due_at, OLD.project_id)
+            IS DISTINCT FROM (NEW.title, NEW.content, NEW.finished, NEW.auto_finish, NEW.due_at, NEW.project_id) THEN
+            typ := 'todo.updated';
+        ELSE
+            RETURN NULL;
+        END IF;
+    END IF;
+    INSERT INTO outbox_events (event_type, user_id, org_id, subject_id, payload)
+    VALUES (
+        typ,
+        todo.user_id,
+        (SELECT org_id FROM projects WHERE project_id = todo.project_id),
+        todo.todo_id,
+        jsonb_build_object('id', todo.todo_id, 'user_id', todo.user_id, 'project_id', todo.project_id,
+            'title', todo.title, 'content', todo.content, 'finished', todo.finished,
+            'auto_finish', todo.auto_finish, 'version', todo.version, 'due_at', todo.due_at, 'deleted_at', todo.deleted_at,
+            'series_id', todo.series_id, 'moved_from', moved_from)
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+-- an ended series has no occurrence to come, until its rule is edited
+ALTER TABLE series ADD COLUMN IF NOT EXISTS ended boolean NOT NULL DEFAULT FALSE;
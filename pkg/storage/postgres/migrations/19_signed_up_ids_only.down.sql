@@ -0,0 +1,10 @@
+-- the personal data scrubbed from the logged signups isn't restored
+CREATE OR REPLACE FUNCTION outbox_user_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO outbox_events (event_type, user_id, subject_id, payload)
+    VALUES ('user.signed_up', NEW.user_id, NEW.user_id,
+        jsonb_build_object('id', NEW.user_id, 'email', NEW.email_id, 'first_name', NEW.first_name,
+            'last_name', NEW.last_name, 'username', NEW.user_name));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
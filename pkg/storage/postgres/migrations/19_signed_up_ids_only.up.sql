@@ -0,0 +1,10 @@
+-- the signups are told with the id of the user only, the staff look the user up.
+-- The events and their webhook deliveries outlive the user, they hold no personal data.
+CREATE OR REPLACE FUNCTION outbox_user_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO outbox_events (event_type, user_id, subject_id, payload)
+    VALUES ('user.signed_up', NEW.user_id, NEW.user_id, jsonb_build_object('id', NEW.user_id));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+UPDATE outbox_events SET payload = jsonb_build_object('id', payload->'id') WHERE event_type = 'user.signed_up';
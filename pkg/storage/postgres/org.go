@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring OrgStorage implements pkg.OrgStorage.
+var _ pkg.OrgStorage = (*OrgStorage)(nil)
+
+// OrgStorage provides an Organization Storage implementation over a PostgreSQL database
+type OrgStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewOrgStore returns an initialized OrgStorage storage with connection pool
+func NewOrgStore(db *pgxpool.Pool) (OrgStorage, error) {
+	if db == nil {
+		return OrgStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return OrgStorage{db: db}, nil
+}
+
+// FindOneOrg returns the OrgModel associated with the ID in the DB
+func (s OrgStorage) FindOneOrg(ctx context.Context, id uuid.UUID) (pkg.OrgModel, error) {
+	var org pkg.OrgModel
+	err := s.db.QueryRow(ctx, findOrgByIDQuery, id).Scan(&org.ID, &org.Name)
+	switch err {
+	case nil:
+		return org, nil
+	case pgx.ErrNoRows:
+		return pkg.NilOrgModel, serror.NewQueryError(findOrgByIDQuery, serror.ErrOrgNotFound, err.Error())
+	default:
+		return pkg.NilOrgModel, serror.NewQueryError(findOrgByIDQuery, err, err.Error())
+	}
+}
+
+// FindOrgsOfUser returns the organizations the user is a member of in the DB
+func (s OrgStorage) FindOrgsOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.OrgModel, error) {
+	rows, err := s.db.Query(ctx, findOrgsOfUserQuery, userID)
+	if err != nil {
+		return nil, serror.NewQueryError(findOrgsOfUserQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var orgs []pkg.OrgModel
+	for rows.Next() {
+		var org pkg.OrgModel
+		if err = rows.Scan(&org.ID, &org.Name); err != nil {
+			return nil, serror.NewQueryError(findOrgsOfUserQuery, err, err.Error())
+		}
+		orgs = append(orgs, org)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findOrgsOfUserQuery, err, err.Error())
+	}
+	return orgs, nil
+}
+
+// InsertOne stores the organization inside the DB, along with its
+// first owner in the same transaction.
+func (s OrgStorage) InsertOne(ctx context.Context, org pkg.OrgModel, ownerID uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return serror.NewQueryError("BEGIN", err, err.Error())
+	}
+	// no-op once committed
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err = tx.Exec(ctx, storeOrgQuery, org.ID, org.Name); err != nil {
+		return serror.NewQueryError(storeOrgQuery, err, err.Error())
+	}
+	if _, err = tx.Exec(ctx, upsertMemberQuery, org.ID, ownerID, int16(pkg.OrgRoleOwner)); err != nil {
+		return serror.NewQueryError(upsertMemberQuery, err, err.Error())
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return serror.NewQueryError("COMMIT", err, err.Error())
+	}
+	return nil
+}
+
+// RoleOf returns the role of the user in the organization in the DB
+func (s OrgStorage) RoleOf(ctx context.Context, orgID, userID uuid.UUID) (pkg.OrgRole, error) {
+	var role int16
+	err := s.db.QueryRow(ctx, roleInOrgQuery, orgID, userID).Scan(&role)
+	if err != nil {
+		return pkg.OrgRoleNone, serror.NewQueryError(roleInOrgQuery, err, err.Error())
+	}
+	return pkg.OrgRole(role), nil
+}
+
+// RoleOnProject returns the organization of the project and the role of the user in it in the DB
+func (s OrgStorage) RoleOnProject(ctx context.Context, userID, projectID uuid.UUID) (uuid.UUID, pkg.OrgRole, error) {
+	var orgID *uuid.UUID
+	var role int16
+	err := s.db.QueryRow(ctx, roleOnOrgProjectQuery, userID, projectID).Scan(&orgID, &role)
+	switch {
+	case err == pgx.ErrNoRows:
+		return uuid.Nil, pkg.OrgRoleNone, nil
+	case err != nil:
+		return uuid.Nil, pkg.OrgRoleNone, serror.NewQueryError(roleOnOrgProjectQuery, err, err.Error())
+	case orgID == nil:
+		return uuid.Nil, pkg.OrgRoleNone, nil
+	}
+	return *orgID, pkg.OrgRole(role), nil
+}
+
+// FindMembers returns the members of the organization in the DB
+func (s OrgStorage) FindMembers(ctx context.Context, orgID uuid.UUID) ([]pkg.MembershipModel, error) {
+	rows, err := s.db.Query(ctx, findMembersQuery, orgID)
+	if err != nil {
+		return nil, serror.NewQueryError(findMembersQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var members []pkg.MembershipModel
+	for rows.Next() {
+		var member pkg.MembershipModel
+		var role int16
+		if err = rows.Scan(&member.OrgID, &member.UserID, &role); err != nil {
+			return nil, serror.NewQueryError(findMembersQuery, err, err.Error())
+		}
+		member.Role = pkg.OrgRole(role)
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findMembersQuery, err, err.Error())
+	}
+	return members, nil
+}
+
+// UpsertMember stores the membership inside the DB, replacing the role of the user
+func (s OrgStorage) UpsertMember(ctx context.Context, member pkg.MembershipModel) error {
+	_, err := s.db.Exec(ctx, upsertMemberQuery, member.OrgID, member.UserID, int16(member.Role))
+	if err != nil {
+		return serror.NewQueryError(upsertMemberQuery, err, err.Error())
+	}
+	return nil
+}
+
+// DeleteMember deletes the membership of the user from the DB
+func (s OrgStorage) DeleteMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	cmd, err := s.db.Exec(ctx, deleteMemberQuery, orgID, userID)
+	if err != nil {
+		return serror.NewQueryError(deleteMemberQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(deleteMemberQuery, serror.ErrMemberNotFound, "")
+	}
+	return nil
+}
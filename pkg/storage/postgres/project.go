@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring ProjectStorage implements pkg.ProjectStorage.
+var _ pkg.ProjectStorage = (*ProjectStorage)(nil)
+
+// ProjectStorage provides a Project Storage implementation over a PostgreSQL database
+type ProjectStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewProjectStore returns an initialized ProjectStorage storage with connection pool
+func NewProjectStore(db *pgxpool.Pool) (ProjectStorage, error) {
+	if db == nil {
+		return ProjectStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return ProjectStorage{db: db}, nil
+}
+
+// FindOneProject returns the ProjectModel associated with the ID in the DB
+func (s ProjectStorage) FindOneProject(ctx context.Context, id uuid.UUID) (pkg.ProjectModel, error) {
+	var project pkg.ProjectModel
+	var orgID *uuid.UUID
+	err := s.db.QueryRow(ctx, findProjectByIDQuery, id).Scan(&project.ID, &project.UserID, &orgID, &project.Name, &project.Color, &project.Archived, &project.Inbox)
+	switch err {
+	case nil:
+		if orgID != nil {
+			project.OrgID = *orgID
+		}
+		return project, nil
+	case pgx.ErrNoRows:
+		return pkg.NilProjectModel, serror.NewQueryError(findProjectByIDQuery, serror.ErrProjectNotFound, err.Error())
+	default:
+		return pkg.NilProjectModel, serror.NewQueryError(findProjectByIDQuery, err, err.Error())
+	}
+}
+
+// FindAllProjectOfUser returns the personal projects of the user
+func (s ProjectStorage) FindAllProjectOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.ProjectModel, error) {
+	return s.findProjects(ctx, findAllProjectByUser, userID)
+}
+
+// FindAllProjectOfOrg returns the projects of the organization
+func (s ProjectStorage) FindAllProjectOfOrg(ctx context.Context, orgID uuid.UUID) ([]pkg.ProjectModel, error) {
+	return s.findProjects(ctx, findAllProjectByOrg, orgID)
+}
+
+func (s ProjectStorage) findProjects(ctx context.Context, query string, id uuid.UUID) ([]pkg.ProjectModel, error) {
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	defer rows.Close()
+
+	var projects []pkg.ProjectModel
+	for rows.Next() {
+		var project pkg.ProjectModel
+		var orgID *uuid.UUID
+		err = rows.Scan(&project.ID, &project.UserID, &orgID, &project.Name, &project.Color, &project.Archived, &project.Inbox)
+		if err != nil {
+			return nil, serror.NewQueryError(query, err, err.Error())
+		}
+		if orgID != nil {
+			project.OrgID = *orgID
+		}
+		projects = append(projects, project)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	return projects, nil
+}
+
+// InsertOne stores the project inside the DB
+func (s ProjectStorage) InsertOne(ctx context.Context, project pkg.ProjectModel) (uuid.UUID, error) {
+	cmd, err := s.db.Exec(ctx, storeProjectQuery, project.ID, project.UserID, nullUUID(project.OrgID), project.Name, project.Color, project.Archived)
+	if err != nil {
+		return uuid.Nil, serror.NewQueryError(storeProjectQuery, err, err.Error())
+	}
+	if !cmd.Insert() && cmd.RowsAffected() != 1 {
+		return uuid.Nil, serror.NewQueryError(storeProjectQuery, serror.ErrInsertCommand, "")
+	}
+	return project.ID, nil
+}
+
+// UpdateOne stores the updated project inside the DB
+func (s ProjectStorage) UpdateOne(ctx context.Context, project pkg.ProjectModel) error {
+	cmd, err := s.db.Exec(ctx, updateProjectQuery, project.ID, project.Name, project.Color, project.Archived)
+	if err != nil {
+		return serror.NewQueryError(updateProjectQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(updateProjectQuery, serror.ErrProjectNotFound, "")
+	}
+	return nil
+}
+
+// DeleteOne deletes the project from the DB, in the same transaction
+// as the move of its todos to the inbox.
+func (s ProjectStorage) DeleteOne(ctx context.Context, id uuid.UUID, mode pkg.ProjectDeleteMode) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return serror.NewQueryError("BEGIN", err, err.Error())
+	}
+	// no-op once committed
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if mode == pkg.MoveTodosToInbox {
+		if _, err = tx.Exec(ctx, moveTodosToInboxQuery, id); err != nil {
+			return serror.NewQueryError(moveTodosToInboxQuery, err, err.Error())
+		}
+	}
+	cmd, err := tx.Exec(ctx, deleteProjectQuery, id)
+	if err != nil {
+		return serror.NewQueryError(deleteProjectQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(deleteProjectQuery, serror.ErrProjectNotFound, "")
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return serror.NewQueryError("COMMIT", err, err.Error())
+	}
+	return nil
+}
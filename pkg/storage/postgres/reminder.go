@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring ReminderStorage implements pkg.ReminderStorage.
+var _ pkg.ReminderStorage = (*ReminderStorage)(nil)
+
+// ReminderStorage provides a todo Reminder Storage implementation over a PostgreSQL database.
+// The claims lock the reminders with SKIP LOCKED, so many schedulers can share the table.
+type ReminderStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewReminderStore returns an initialized ReminderStorage storage with connection pool
+func NewReminderStore(db *pgxpool.Pool) (ReminderStorage, error) {
+	if db == nil {
+		return ReminderStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return ReminderStorage{db: db}, nil
+}
+
+// FindRemindersOfTodo returns the reminders of the todo in the DB
+func (s ReminderStorage) FindRemindersOfTodo(ctx context.Context, todoID uuid.UUID) ([]pkg.ReminderModel, error) {
+	rows, err := s.db.Query(ctx, findRemindersOfTodoQuery, todoID)
+	if err != nil {
+		return nil, serror.NewQueryError(findRemindersOfTodoQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var reminders []pkg.ReminderModel
+	for rows.Next() {
+		var reminder pkg.ReminderModel
+		var offset int64
+		var status string
+		err = rows.Scan(&reminder.ID, &reminder.TodoID, &reminder.UserID, &offset, &reminder.Channel, &status,
+			&reminder.Attempts, &reminder.LastError)
+		if err != nil {
+			return nil, serror.NewQueryError(findRemindersOfTodoQuery, err, err.Error())
+		}
+		reminder.Offset = time.Duration(offset) * time.Second
+		reminder.Status = pkg.ReminderStatus(status)
+		reminders = append(reminders, reminder)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findRemindersOfTodoQuery, err, err.Error())
+	}
+	return reminders, nil
+}
+
+// InsertOne stores the reminder of a todo not in the trash inside the DB
+func (s ReminderStorage) InsertOne(ctx context.Context, reminder pkg.ReminderModel) error {
+	cmd, err := s.db.Exec(ctx, storeReminderQuery, reminder.ID, reminder.TodoID, reminder.UserID,
+		int64(reminder.Offset/time.Second), reminder.Channel)
+	if err != nil {
+		return serror.NewQueryError(storeReminderQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(storeReminderQuery, serror.ErrTodoNotFound, "")
+	}
+	return nil
+}
+
+// DeleteOne deletes the reminder of the todo from the DB
+func (s ReminderStorage) DeleteOne(ctx context.Context, todoID, id uuid.UUID) error {
+	cmd, err := s.db.Exec(ctx, deleteReminderQuery, id, todoID)
+	if err != nil {
+		return serror.NewQueryError(deleteReminderQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(deleteReminderQuery, serror.ErrReminderNotFound, "")
+	}
+	return nil
+}
+
+// ClaimDue locks and leases the due reminders in the DB, and returns their notifications
+func (s ReminderStorage) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, n int) ([]pkg.Notification, error) {
+	rows, err := s.db.Query(ctx, claimDueRemindersQuery, now, now.Add(lease), n)
+	if err != nil {
+		return nil, serror.NewQueryError(claimDueRemindersQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var list []pkg.Notification
+	for rows.Next() {
+		var notification pkg.Notification
+		err = rows.Scan(&notification.ReminderID, &notification.TodoID, &notification.UserID, &notification.Email,
+			&notification.Channel, &notification.Title, &notification.DueAt, &notification.Attempt)
+		if err != nil {
+			return nil, serror.NewQueryError(claimDueRemindersQuery, err, err.Error())
+		}
+		list = append(list, notification)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(claimDueRemindersQuery, err, err.Error())
+	}
+	return list, nil
+}
+
+// MarkSent marks the reminder of the notification delivered inside the DB
+func (s ReminderStorage) MarkSent(ctx context.Context, n pkg.Notification) error {
+	if _, err := s.db.Exec(ctx, markReminderSentQuery, n.ReminderID, n.DueAt); err != nil {
+		return serror.NewQueryError(markReminderSentQuery, err, err.Error())
+	}
+	return nil
+}
+
+// MarkFailed records the failed delivery of the reminder of the notification inside the DB
+func (s ReminderStorage) MarkFailed(ctx context.Context, n pkg.Notification, reason string, retryAt time.Time, dead bool) error {
+	status := pkg.ReminderPending
+	if dead {
+		status = pkg.ReminderDead
+	}
+	_, err := s.db.Exec(ctx, markReminderFailedQuery, n.ReminderID, n.DueAt, string(status), retryAt, reason)
+	if err != nil {
+		return serror.NewQueryError(markReminderFailedQuery, err, err.Error())
+	}
+	return nil
+}
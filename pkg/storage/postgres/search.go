@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// Compile-time check for ensuring TodoStorage implements pkg.TodoSearcher.
+var _ pkg.TodoSearcher = (*TodoStorage)(nil)
+
+// headlineOptions are the ts_headline options of the snippets, up to two
+// fragments of the title and content around the matches.
+var headlineOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxFragments=2, MaxWords=16, MinWords=6, FragmentDelimiter=" … "`,
+	pkg.MatchStart, pkg.MatchStop)
+
+// SearchTodosOfUser returns the todos of the user matching the terms in the full text index of the
+// todos, ranked by the density of the matches, the ones of the title weighing more.
+func (t TodoStorage) SearchTodosOfUser(ctx context.Context, userID uuid.UUID, terms []string, filter pkg.TodoFilter, n int) ([]pkg.TodoHit, error) {
+	query, err := searchFilterValue(filter)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := t.db.Query(ctx, query, userID, terms, n, headlineOptions)
+	if err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	defer rows.Close()
+
+	var hits []pkg.TodoHit
+	for rows.Next() {
+		var hit pkg.TodoHit
+		err = scanTodo(rows, &hit.Todo, &hit.Rank, &hit.Snippet)
+		if err != nil {
+			return nil, serror.NewQueryError(query, err, err.Error())
+		}
+		hits = append(hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	return hits, nil
+}
+
+func searchFilterValue(filter pkg.TodoFilter) (string, error) {
+	switch filter {
+	case pkg.NilFilter:
+		return fmt.Sprintf(searchTodosOfUserQuery, ""), nil
+	case pkg.Finished:
+		return fmt.Sprintf(searchTodosOfUserQuery, " AND finished = "+filterPostgresTrue), nil
+	case pkg.UnFinished:
+		return fmt.Sprintf(searchTodosOfUserQuery, " AND finished = "+filterPostgresFalse), nil
+	default:
+		return "", fmt.Errorf("unsupported filter")
+	}
+}
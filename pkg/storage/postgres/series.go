@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring SeriesStorage implements pkg.SeriesStorage.
+var _ pkg.SeriesStorage = (*SeriesStorage)(nil)
+
+// SeriesStorage provides a recurring todo Series Storage implementation over a PostgreSQL database
+type SeriesStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewSeriesStore returns an initialized SeriesStorage storage with connection pool
+func NewSeriesStore(db *pgxpool.Pool) (SeriesStorage, error) {
+	if db == nil {
+		return SeriesStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return SeriesStorage{db: db}, nil
+}
+
+// FindOneSeries returns the SeriesModel associated with the ID in the DB
+func (s SeriesStorage) FindOneSeries(ctx context.Context, id uuid.UUID) (pkg.SeriesModel, error) {
+	var series pkg.SeriesModel
+	err := s.db.QueryRow(ctx, findSeriesByIDQuery, id).Scan(&series.ID, &series.UserID, &series.RRule, &series.TimeZone,
+		&series.Start, &series.Title, &series.Content)
+	switch err {
+	case nil:
+		return series, nil
+	case pgx.ErrNoRows:
+		return pkg.NilSeriesModel, serror.NewQueryError(findSeriesByIDQuery, serror.ErrSeriesNotFound, err.Error())
+	default:
+		return pkg.NilSeriesModel, serror.NewQueryError(findSeriesByIDQuery, err, err.Error())
+	}
+}
+
+// InsertOne stores the series inside the DB, and makes the todo its first
+// occurrence in the same transaction.
+func (s SeriesStorage) InsertOne(ctx context.Context, series pkg.SeriesModel, todoID uuid.UUID) error {
+	return s.write(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, storeSeriesQuery, series.ID, series.UserID, series.RRule, series.TimeZone, series.Start,
+			series.Title, series.Content)
+		if err != nil {
+			return serror.NewQueryError(storeSeriesQuery, err, err.Error())
+		}
+		cmd, err := tx.Exec(ctx, startSeriesQuery, todoID, series.ID, series.Title, series.Content)
+		if err != nil {
+			return serror.NewQueryError(startSeriesQuery, err, err.Error())
+		}
+		if cmd.RowsAffected() != 1 {
+			return serror.NewQueryError(startSeriesQuery, serror.ErrTodoNotFound, "")
+		}
+		return nil
+	})
+}
+
+// UpdateOne updates the series inside the DB, along with its unfinished
+// occurrences in the same transaction.
+func (s SeriesStorage) UpdateOne(ctx context.Context, series pkg.SeriesModel) error {
+	return s.write(ctx, func(tx pgx.Tx) error {
+		cmd, err := tx.Exec(ctx, updateSeriesQuery, series.ID, series.RRule, series.TimeZone, series.Start, series.Title,
+			series.Content)
+		if err != nil {
+			return serror.NewQueryError(updateSeriesQuery, err, err.Error())
+		}
+		if cmd.RowsAffected() != 1 {
+			return serror.NewQueryError(updateSeriesQuery, serror.ErrSeriesNotFound, "")
+		}
+		if _, err = tx.Exec(ctx, updateSeriesOccurrencesQuery, series.ID, series.Title, series.Content); err != nil {
+			return serror.NewQueryError(updateSeriesOccurrencesQuery, err, err.Error())
+		}
+		return nil
+	})
+}
+
+// DeleteOne deletes the series from the DB, its occurrences don't recur anymore
+func (s SeriesStorage) DeleteOne(ctx context.Context, id uuid.UUID) error {
+	return s.write(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, stopSeriesQuery, id); err != nil {
+			return serror.NewQueryError(stopSeriesQuery, err, err.Error())
+		}
+		cmd, err := tx.Exec(ctx, deleteSeriesQuery, id)
+		if err != nil {
+			return serror.NewQueryError(deleteSeriesQuery, err, err.Error())
+		}
+		if cmd.RowsAffected() != 1 {
+			return serror.NewQueryError(deleteSeriesQuery, serror.ErrSeriesNotFound, "")
+		}
+		return nil
+	})
+}
+
+// InsertOccurrence stores the occurrence inside the DB, unless already stored
+func (s SeriesStorage) InsertOccurrence(ctx context.Context, todo pkg.TodoModel) error {
+	_, err := s.db.Exec(ctx, storeOccurrenceQuery, todo.ID, todo.UserID, todo.ProjectID, todo.Title, todo.Content,
+		todo.AutoFinish, nullTime(todo.DueAt), todo.SeriesID)
+	if err != nil {
+		return todoWriteError(storeOccurrenceQuery, err)
+	}
+	return nil
+}
+
+// EndOne marks the series ended inside the DB
+func (s SeriesStorage) EndOne(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.db.Exec(ctx, endSeriesQuery, id); err != nil {
+		return serror.NewQueryError(endSeriesQuery, err, err.Error())
+	}
+	return nil
+}
+
+// FindStalledOccurrences returns at most n finished occurrences inside the DB
+// that are the latest of their series, which isn't ended
+func (s SeriesStorage) FindStalledOccurrences(ctx context.Context, n int) ([]pkg.TodoModel, error) {
+	rows, err := s.db.Query(ctx, findStalledOccurrencesQuery, n)
+	if err != nil {
+		return nil, serror.NewQueryError(findStalledOccurrencesQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var todos []pkg.TodoModel
+	for rows.Next() {
+		var todo pkg.TodoModel
+		if err = scanTodo(rows, &todo); err != nil {
+			return nil, serror.NewQueryError(findStalledOccurrencesQuery, err, err.Error())
+		}
+		todos = append(todos, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findStalledOccurrencesQuery, err, err.Error())
+	}
+	return todos, nil
+}
+
+// write runs the series write fn inside a transaction.
+func (s SeriesStorage) write(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return serror.NewQueryError("BEGIN", err, err.Error())
+	}
+	// no-op once committed
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return serror.NewQueryError("COMMIT", err, err.Error())
+	}
+	return nil
+}
@@ -8,18 +8,424 @@ var (
 	storeUserQuery       = `
 INSERT INTO users (user_id, email_id, password_hash, first_name, last_name, user_name) VALUES ($1, $2, $3, $4, $5, $6)
 `
+	storeInboxProjectQuery = "INSERT INTO projects (project_id, user_id, name, inbox) VALUES ($1, $2, $3, TRUE)"
 )
 
 var (
-
 	// SQL Query
-	findAllTodo                         = "SELECT todo_id, user_id, title, content, finished FROM todos ORDER BY created_at DESC LIMIT 50"
-	findTodoByIDQuery                   = "SELECT * FROM todos WHERE todo_id=$1"
-	findAllTodoByUser                   = "SELECT * FROM todos WHERE user_id=$1 ORDER BY created_at DESC LIMIT 50"
-	findAllTodoByUserWithFinishedFilter = "SELECT * FROM todos WHERE user_id=$1 AND finished = %s ORDER BY created_at DESC LIMIT 50"
+	findProjectByIDQuery  = "SELECT project_id, user_id, org_id, name, color, archived, inbox FROM projects WHERE project_id=$1"
+	findAllProjectByUser  = "SELECT project_id, user_id, org_id, name, color, archived, inbox FROM projects WHERE user_id=$1 AND org_id IS NULL ORDER BY inbox DESC, created_at"
+	findAllProjectByOrg   = "SELECT project_id, user_id, org_id, name, color, archived, inbox FROM projects WHERE org_id=$1 ORDER BY created_at"
+	storeProjectQuery     = "INSERT INTO projects (project_id, user_id, org_id, name, color, archived) VALUES ($1, $2, $3, $4, $5, $6)"
+	updateProjectQuery    = "UPDATE projects SET name = $2, color = $3, archived = $4 WHERE project_id = $1"
+	moveTodosToInboxQuery = `
+UPDATE todos SET version = version + 1,
+    project_id = (SELECT p.project_id FROM projects p WHERE p.user_id = todos.user_id AND p.inbox)
+WHERE project_id = $1
+`
+	// the todos left are deleted along by the foreign key
+	deleteProjectQuery = "DELETE FROM projects WHERE project_id = $1 AND NOT inbox"
+)
 
+const (
+	// the todos of a user are the ones out of the projects of an organization,
+	// the member who created them may have left it.
+	personalTodo = "NOT EXISTS (SELECT 1 FROM projects p WHERE p.project_id = todos.project_id AND p.org_id IS NOT NULL)"
+	// of the project ($2) if any, the todos of the user otherwise
+	inProjectOrPersonal = "(project_id = $2 OR ($2::uuid IS NULL AND " + personalTodo + "))"
+)
+
+var (
+	// SQL Query, the todos in the trash (deleted_at IS NOT NULL) are left out
+	findTodoByIDQuery                   = "SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id FROM todos WHERE todo_id=$1 AND deleted_at IS NULL"
+	findAllTodoByUser                   = "SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id FROM todos WHERE user_id=$1 AND " + inProjectOrPersonal + " AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 50"
+	findAllTodoByUserWithFinishedFilter = "SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id FROM todos WHERE user_id=$1 AND " + inProjectOrPersonal + " AND finished = %s AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 50"
+	findTodoVersionQuery                = "SELECT version FROM todos WHERE todo_id=$1 AND deleted_at IS NULL"
+
+	// the project ($7) must be one of the user, a nil one is the inbox of the user.
+	// the project_id is NULL otherwise, failing the insert.
 	storeTodoQuery = `
-INSERT INTO todos (user_id, email_id, password_hash, first_name, last_name, user_name) VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO todos (todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at)
+VALUES ($1, $2, (SELECT project_id FROM projects WHERE user_id = $2 AND (project_id = $7 OR ($7::uuid IS NULL AND inbox))), $3, $4, $5, $6, 1, $8)
+RETURNING project_id
+`
+	// version check and increment are a single statement, so concurrent writers can't both succeed.
+	// a zero expected version ($6) skips the check, a nil project ($7) keeps the current one.
+	// the series_id is only written by the series queries.
+	updateTodoQuery = `
+UPDATE todos SET title = $2, content = $3, finished = $4, auto_finish = $5, due_at = $8, version = version + 1,
+    project_id = CASE WHEN $7::uuid IS NULL THEN project_id ELSE (SELECT p.project_id FROM projects p WHERE p.project_id = $7 AND p.user_id = todos.user_id) END
+WHERE todo_id = $1 AND deleted_at IS NULL AND ($6::bigint = 0 OR version = $6::bigint) RETURNING version
+`
+	// delete moves the todo to the trash
+	deleteTodoByID = `
+UPDATE todos SET deleted_at = now(), version = version + 1
+WHERE todo_id = $1 AND deleted_at IS NULL AND ($2::bigint = 0 OR version = $2::bigint)
+`
+
+	// batch writes are restricted to the todos of the user ($2)
+	findUserTodoVersionQuery = "SELECT version FROM todos WHERE todo_id = $1 AND user_id = $2 AND deleted_at IS NULL"
+	updateUserTodoQuery      = `
+UPDATE todos SET title = $3, content = $4, finished = $5, auto_finish = $6, due_at = $9, version = version + 1,
+    project_id = CASE WHEN $8::uuid IS NULL THEN project_id ELSE (SELECT p.project_id FROM projects p WHERE p.project_id = $8 AND p.user_id = todos.user_id) END
+WHERE todo_id = $1 AND user_id = $2 AND deleted_at IS NULL AND ($7::bigint = 0 OR version = $7::bigint)
+RETURNING todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id
+`
+	completeUserTodoQuery = `
+UPDATE todos SET finished = TRUE, version = version + 1
+WHERE todo_id = $1 AND user_id = $2 AND deleted_at IS NULL AND ($3::bigint = 0 OR version = $3::bigint)
+RETURNING todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id
+`
+	deleteUserTodoQuery = `
+UPDATE todos SET deleted_at = now(), version = version + 1
+WHERE todo_id = $1 AND user_id = $2 AND deleted_at IS NULL AND ($3::bigint = 0 OR version = $3::bigint)
+`
+
+	// trash
+	findTrashOfUserQuery = `
+SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id, deleted_at FROM todos
+WHERE user_id = $1 AND deleted_at IS NOT NULL AND ` + personalTodo + ` ORDER BY deleted_at DESC LIMIT 50
+`
+	findDeletedTodoByIDQuery = "SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id, deleted_at FROM todos WHERE todo_id=$1 AND deleted_at IS NOT NULL"
+	restoreUserTodoQuery     = `
+UPDATE todos SET deleted_at = NULL, version = version + 1
+WHERE todo_id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+RETURNING todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id
+`
+	purgeDeletedTodosQuery = "DELETE FROM todos WHERE deleted_at < $1"
+
+	// sync, all the todos at once the user ($1) may have a role on: as their owner,
+	// by a grant on them or on their project, or as a member of its organization.
+	findSyncedTodosOfUserQuery = `
+SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id FROM todos
+WHERE deleted_at IS NULL AND (
+    user_id = $1
+    OR todo_id IN (SELECT g.todo_id FROM grants g WHERE g.user_id = $1 AND g.todo_id IS NOT NULL)
+    OR project_id IN (SELECT g.project_id FROM grants g WHERE g.user_id = $1 AND g.project_id IS NOT NULL)
+    OR project_id IN (SELECT p.project_id FROM projects p JOIN memberships m ON m.org_id = p.org_id WHERE m.user_id = $1)
+) ORDER BY created_at
+`
+
+	// search, each term ($2) matches the words starting with it. The snippet is the headline
+	// of the options ($4), and %s filters the finished todos.
+	searchTodosOfUserQuery = `
+WITH q AS (
+    SELECT to_tsquery('simple', string_agg(quote_literal(term) || ':*', ' & ')) AS query FROM unnest($2::text[]) AS term
+)
+SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id,
+    ts_rank_cd(search, q.query)::float8 AS rank, ts_headline('simple', title || E'\n' || content, q.query, $4)
+FROM todos, q
+WHERE user_id = $1 AND deleted_at IS NULL AND ` + personalTodo + ` AND search @@ q.query%s
+ORDER BY rank DESC, created_at DESC
+LIMIT $3
+`
+)
+
+var (
+	// SQL Query, the item writes run in a transaction holding the lock of the todo.
+	findItemsOfTodoQuery = "SELECT item_id, todo_id, text, position, done FROM todo_items WHERE todo_id=$1 ORDER BY position, created_at"
+	progressOfTodosQuery = `
+SELECT todo_id, count(*), count(*) FILTER (WHERE done) FROM todo_items
+WHERE todo_id = ANY($1::uuid[]) GROUP BY todo_id
+`
+	lockTodoOfUserQuery = "SELECT version FROM todos WHERE todo_id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE"
+	// a zero position ($4) puts the item last
+	storeItemQuery = `
+INSERT INTO todo_items (item_id, todo_id, text, position, done)
+SELECT $1, $2, $3, CASE WHEN $4::int > 0 THEN $4::int ELSE COALESCE(MAX(position), 0) + 1 END, $5
+FROM todo_items WHERE todo_id = $2
+RETURNING position
+`
+	// a zero position ($4) keeps the current one
+	updateItemQuery = `
+UPDATE todo_items SET text = $3, position = CASE WHEN $4::int > 0 THEN $4::int ELSE position END, done = $5
+WHERE item_id = $1 AND todo_id = $2 RETURNING position
+`
+	deleteItemQuery = "DELETE FROM todo_items WHERE item_id = $1 AND todo_id = $2"
+	// the todo of an item write gets a new version, and is finished if it auto finishes
+	// and all its items are done.
+	touchTodoOfItemQuery = `
+UPDATE todos SET version = todos.version + 1,
+    finished = todos.finished OR (todos.auto_finish AND p.total > 0 AND p.done = p.total)
+FROM (SELECT count(*) AS total, count(*) FILTER (WHERE done) AS done FROM todo_items WHERE todo_id = $1) p
+WHERE todos.todo_id = $1
+RETURNING todos.todo_id, todos.user_id, todos.project_id, todos.title, todos.content, todos.finished, todos.auto_finish, todos.version,
+    todos.due_at, todos.series_id
+`
+)
+
+var (
+	// an expired record is taken over by the new reservation, a live one is left as is
+	// and no row is returned.
+	reserveIdempotencyKeyQuery = `
+INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, expires_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (scope, idempotency_key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint, status_code = 0, header = NULL, body = NULL,
+    created_at = now(), expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= now()
+RETURNING idempotency_key
+`
+	findIdempotencyKeyQuery = `
+SELECT scope, idempotency_key, fingerprint, status_code, header, body, expires_at
+FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2
+`
+	completeIdempotencyKeyQuery = "UPDATE idempotency_keys SET status_code = $3, header = $4, body = $5 WHERE scope = $1 AND idempotency_key = $2"
+	releaseIdempotencyKeyQuery  = "DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2"
+	purgeIdempotencyKeysQuery   = "DELETE FROM idempotency_keys WHERE expires_at <= now()"
+)
+
+const (
+	// SQL Query, a grant is either on a todo or on a project.
+	roleOfUserQuery = `
+SELECT COALESCE(MAX(role), 0) FROM grants
+WHERE user_id = $1 AND (todo_id = $2 OR project_id = $3)
+`
+	findGrantsOfTodoQuery    = "SELECT todo_id, project_id, user_id, role, granted_by FROM grants WHERE todo_id=$1 ORDER BY created_at"
+	findGrantsOfProjectQuery = "SELECT todo_id, project_id, user_id, role, granted_by FROM grants WHERE project_id=$1 ORDER BY created_at"
+	findGrantsOfUserQuery    = "SELECT todo_id, project_id, user_id, role, granted_by FROM grants WHERE user_id=$1 ORDER BY created_at DESC"
+	upsertTodoGrantQuery     = `
+INSERT INTO grants (todo_id, user_id, role, granted_by) VALUES ($1, $2, $3, $4)
+ON CONFLICT (todo_id, user_id) DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by
+`
+	upsertProjectGrantQuery = `
+INSERT INTO grants (project_id, user_id, role, granted_by) VALUES ($1, $2, $3, $4)
+ON CONFLICT (project_id, user_id) DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by
+`
+	deleteTodoGrantQuery    = "DELETE FROM grants WHERE todo_id = $1 AND user_id = $2"
+	deleteProjectGrantQuery = "DELETE FROM grants WHERE project_id = $1 AND user_id = $2"
+)
+
+const (
+	// SQL Query
+	findOrgByIDQuery    = "SELECT org_id, name FROM orgs WHERE org_id=$1"
+	findOrgsOfUserQuery = `
+SELECT o.org_id, o.name FROM orgs o JOIN memberships m ON m.org_id = o.org_id
+WHERE m.user_id = $1 ORDER BY o.created_at
+`
+	storeOrgQuery         = "INSERT INTO orgs (org_id, name) VALUES ($1, $2)"
+	roleInOrgQuery        = "SELECT COALESCE(MAX(role), 0) FROM memberships WHERE org_id = $1 AND user_id = $2"
+	roleOnOrgProjectQuery = `
+SELECT p.org_id, COALESCE(m.role, 0) FROM projects p LEFT JOIN memberships m ON m.org_id = p.org_id AND m.user_id = $1
+WHERE p.project_id = $2
+`
+	findMembersQuery  = "SELECT org_id, user_id, role FROM memberships WHERE org_id=$1 ORDER BY created_at"
+	upsertMemberQuery = `
+INSERT INTO memberships (org_id, user_id, role) VALUES ($1, $2, $3)
+ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role
+`
+	deleteMemberQuery = "DELETE FROM memberships WHERE org_id = $1 AND user_id = $2"
+)
+
+const (
+	// SQL Query, only the unfinished occurrences follow the edits of their series.
+	findSeriesByIDQuery = "SELECT series_id, user_id, rrule, time_zone, start_at, title, content FROM series WHERE series_id=$1"
+	storeSeriesQuery    = "INSERT INTO series (series_id, user_id, rrule, time_zone, start_at, title, content) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	// an edited rule may have occurrences to come again
+	updateSeriesQuery = "UPDATE series SET rrule = $2, time_zone = $3, start_at = $4, title = $5, content = $6, ended = FALSE WHERE series_id = $1"
+	endSeriesQuery    = "UPDATE series SET ended = TRUE WHERE series_id = $1"
+	startSeriesQuery  = `
+UPDATE todos SET series_id = $2, title = $3, content = $4, version = version + 1
+WHERE todo_id = $1 AND deleted_at IS NULL
+`
+	updateSeriesOccurrencesQuery = `
+UPDATE todos SET title = $2, content = $3, version = version + 1
+WHERE series_id = $1 AND NOT finished AND deleted_at IS NULL
+`
+	// the occurrences of the series are left alone, with a new version as they don't recur anymore.
+	stopSeriesQuery   = "UPDATE todos SET series_id = NULL, version = version + 1 WHERE series_id = $1"
+	deleteSeriesQuery = "DELETE FROM series WHERE series_id = $1"
+	// an occurrence is only ever stored once, its todo_id is derived from its series and due time.
+	storeOccurrenceQuery = `
+INSERT INTO todos (todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id)
+VALUES ($1, $2, $3, $4, $5, FALSE, $6, 1, $7, $8)
+ON CONFLICT (todo_id) DO NOTHING
+`
+	// the latest occurrence of a series not ended is stalled once finished, an
+	// occurrence deleted is the choice of the user not to have it.
+	findStalledOccurrencesQuery = `
+SELECT todo_id, user_id, project_id, title, content, finished, auto_finish, version, due_at, series_id FROM (
+    SELECT DISTINCT ON (t.series_id) t.todo_id, t.user_id, t.project_id, t.title, t.content, t.finished, t.auto_finish,
+        t.version, t.due_at, t.series_id, t.deleted_at
+    FROM todos t JOIN series s ON s.series_id = t.series_id
+    WHERE NOT s.ended
+    ORDER BY t.series_id, t.due_at DESC NULLS LAST
+) latest
+WHERE finished AND deleted_at IS NULL
+LIMIT $1
+`
+)
+
+const (
+	// SQL Query, a reminder delivered for another due time than the one of its todo is pending again.
+	findRemindersOfTodoQuery = `
+SELECT r.reminder_id, r.todo_id, r.user_id, r.offset_seconds, r.channel,
+CASE WHEN r.due_at IS DISTINCT FROM t.due_at THEN 'pending' ELSE r.status END,
+CASE WHEN r.due_at IS DISTINCT FROM t.due_at THEN 0 ELSE r.attempts END,
+CASE WHEN r.due_at IS DISTINCT FROM t.due_at THEN '' ELSE r.last_error END
+FROM reminders r JOIN todos t ON t.todo_id = r.todo_id
+WHERE r.todo_id = $1 ORDER BY r.offset_seconds DESC, r.created_at
+`
+	storeReminderQuery = `
+INSERT INTO reminders (reminder_id, todo_id, user_id, offset_seconds, channel)
+SELECT $1, todo_id, $3, $4, $5 FROM todos WHERE todo_id = $2 AND deleted_at IS NULL
+`
+	deleteReminderQuery = "DELETE FROM reminders WHERE reminder_id = $1 AND todo_id = $2"
+	// the due reminders are locked and leased until $2 in a single statement, the ones
+	// locked by another claim are skipped. The attempts start over for a new due time.
+	claimDueRemindersQuery = `
+WITH due AS (
+	SELECT r.reminder_id, t.due_at, t.title, u.email_id
+	FROM reminders r
+	JOIN todos t ON t.todo_id = r.todo_id
+	JOIN users u ON u.user_id = r.user_id
+	WHERE t.due_at IS NOT NULL AND NOT t.finished AND t.deleted_at IS NULL
+	AND t.due_at - r.offset_seconds * interval '1 second' <= $1
+	AND (r.due_at IS DISTINCT FROM t.due_at OR (r.status = 'pending' AND r.next_attempt_at <= $1))
+	ORDER BY t.due_at
+	LIMIT $3
+	FOR UPDATE OF r SKIP LOCKED
+)
+UPDATE reminders r SET
+due_at = due.due_at,
+status = 'pending',
+attempts = CASE WHEN r.due_at IS DISTINCT FROM due.due_at THEN 1 ELSE r.attempts + 1 END,
+next_attempt_at = $2
+FROM due WHERE r.reminder_id = due.reminder_id
+RETURNING r.reminder_id, r.todo_id, r.user_id, due.email_id, r.channel, due.title, r.due_at, r.attempts
+`
+	markReminderSentQuery = `
+UPDATE reminders SET status = 'sent', next_attempt_at = NULL, last_error = ''
+WHERE reminder_id = $1 AND due_at = $2
+`
+	markReminderFailedQuery = `
+UPDATE reminders SET status = $3, next_attempt_at = $4, last_error = $5
+WHERE reminder_id = $1 AND due_at = $2
+`
+)
+
+const (
+	findWebhookByIDQuery = `
+SELECT webhook_id, user_id, org_id, url, secret, events, disabled, failures, created_at
+FROM webhooks WHERE webhook_id = $1
+`
+	findWebhooksOfUserQuery = `
+SELECT webhook_id, user_id, org_id, url, secret, events, disabled, failures, created_at
+FROM webhooks WHERE user_id = $1 AND org_id IS NULL ORDER BY created_at
+`
+	findWebhooksOfOrgQuery = `
+SELECT webhook_id, user_id, org_id, url, secret, events, disabled, failures, created_at
+FROM webhooks WHERE org_id = $1 ORDER BY created_at
+`
+	storeWebhookQuery = `
+INSERT INTO webhooks (webhook_id, user_id, org_id, url, secret, events, disabled, failures, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+	updateWebhookQuery = `
+UPDATE webhooks SET url = $2, events = $3, disabled = $4, failures = $5
+WHERE webhook_id = $1
+`
+	deleteWebhookQuery  = "DELETE FROM webhooks WHERE webhook_id = $1"
+	findDeliveriesQuery = `
+SELECT delivery_id, webhook_id, event_id, event_type, status, attempts, response_code, last_error,
+created_at, delivered_at
+FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY delivery_id DESC LIMIT $2
+`
+	// the signups only go to the webhooks of the staff organization $5, the events of
+	// an organization $4 to its webhooks only, and not to the personal ones of their user $3.
+	dispatchEventQuery = `
+INSERT INTO webhook_deliveries (webhook_id, event_id, event_type)
+SELECT w.webhook_id, $1::bigint, $2::text FROM webhooks w
+WHERE $2::text = ANY (w.events) AND NOT w.disabled
+AND CASE WHEN $2::text = 'user.signed_up' THEN w.org_id = $5::uuid
+ELSE (w.org_id IS NULL AND $4::uuid IS NULL AND w.user_id = $3::uuid) OR w.org_id = $4::uuid END
+ON CONFLICT (webhook_id, event_id) DO NOTHING
+`
+	// the due deliveries are locked and leased until $2 in a single statement,
+	// the ones locked by another claim are skipped.
+	claimDeliveriesQuery = `
+WITH due AS (
+	SELECT d.delivery_id
+	FROM webhook_deliveries d
+	JOIN webhooks w ON w.webhook_id = d.webhook_id
+	WHERE d.status = 'pending' AND d.next_attempt_at <= $1 AND NOT w.disabled
+	ORDER BY d.next_attempt_at
+	LIMIT $3
+	FOR UPDATE OF d SKIP LOCKED
+)
+UPDATE webhook_deliveries d SET attempts = d.attempts + 1, next_attempt_at = $2
+FROM due, webhooks w, outbox_events e
+WHERE d.delivery_id = due.delivery_id AND w.webhook_id = d.webhook_id AND e.event_id = d.event_id
+RETURNING d.delivery_id, d.webhook_id, w.url, w.secret, d.event_id, d.event_type, e.payload, e.occurred_at, d.attempts
+`
+	// the marks of an attempt whose lease expired, and so was claimed again, are ignored.
+	markDeliveredQuery = `
+WITH delivered AS (
+	UPDATE webhook_deliveries SET status = 'delivered', response_code = $3, last_error = '', delivered_at = now()
+	WHERE delivery_id = $1 AND attempts = $2 AND status = 'pending'
+	RETURNING webhook_id
+)
+UPDATE webhooks SET failures = 0 WHERE webhook_id IN (SELECT webhook_id FROM delivered)
+`
+	markDeliveryFailedQuery = `
+WITH failed AS (
+	UPDATE webhook_deliveries SET status = $3, response_code = $4, last_error = $5, next_attempt_at = $6
+	WHERE delivery_id = $1 AND attempts = $2 AND status = 'pending'
+	RETURNING webhook_id
+)
+UPDATE webhooks w SET failures = w.failures + 1, disabled = w.disabled OR w.failures + 1 >= $7
+FROM failed WHERE w.webhook_id = failed.webhook_id
+RETURNING w.disabled AND w.failures = $7
+`
+	purgeDeliveriesQuery = `
+DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < $1
+`
+)
+
+const (
+	// the due events are locked and leased until $2 in a single statement,
+	// the ones locked by another claim are skipped.
+	claimEventsQuery = `
+WITH due AS (
+	SELECT event_id FROM outbox_events
+	WHERE status = 'pending' AND next_attempt_at <= $1
+	ORDER BY event_id
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+UPDATE outbox_events e SET attempts = e.attempts + 1, next_attempt_at = $2
+FROM due WHERE e.event_id = due.event_id
+RETURNING e.event_id, e.event_type, e.user_id, e.org_id, e.subject_id, e.payload, e.occurred_at, e.attempts
+`
+	// the marks of an attempt whose lease expired, and so was claimed again, are ignored.
+	markEventRelayedQuery = `
+UPDATE outbox_events SET status = 'relayed', relayed_at = now(), last_error = ''
+WHERE event_id = $1 AND attempts = $2 AND status = 'pending'
+`
+	markEventFailedQuery = `
+UPDATE outbox_events SET status = $3, next_attempt_at = $4, last_error = $5
+WHERE event_id = $1 AND attempts = $2 AND status = 'pending'
+`
+	// the events are kept as long as a webhook delivery of theirs is.
+	purgeEventsQuery = `
+DELETE FROM outbox_events e WHERE e.status <> 'pending' AND e.occurred_at < $1
+AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.event_id = e.event_id)
+`
+)
+
+// the log of the events is read in the order of the txid of their transaction,
+// up to the oldest transaction still running, so none is read after a later one.
+const (
+	lastEventIDQuery = `
+SELECT event_id FROM outbox_events WHERE txid < txid_snapshot_xmin(txid_current_snapshot())
+ORDER BY txid DESC, event_id DESC LIMIT 1
+`
+	findEventTxidQuery = "SELECT txid FROM outbox_events WHERE event_id = $1"
+	eventsAfterQuery   = `
+SELECT event_id, event_type, user_id, org_id, subject_id, payload, occurred_at FROM outbox_events
+WHERE (txid, event_id) > ($1, $2) AND txid < txid_snapshot_xmin(txid_current_snapshot())
+AND event_type = ANY ($3)
+ORDER BY txid, event_id LIMIT $4
 `
-	deleteTodoByID = "DROP FROM todos WHERE todo_id=$1"
+	listenEventsQuery   = "LISTEN outbox_events"
+	unlistenEventsQuery = "UNLISTEN outbox_events"
 )
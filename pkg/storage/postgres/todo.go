@@ -2,12 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
 
 	"github.com/ankur-anand/prod-todo/pkg"
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
@@ -17,15 +20,33 @@ const (
 	filterPostgresFalse = "FALSE"
 )
 
+// notNullViolation is the postgres error code of a NULL in a NOT NULL column
+const notNullViolation = "23502"
+
+// Compile-time check for ensuring TodoStorage implements pkg.TodoStorage.
+var _ pkg.TodoStorage = (*TodoStorage)(nil)
+
+// Compile-time check for ensuring TodoStorage implements pkg.SyncStorage.
+var _ pkg.SyncStorage = (*TodoStorage)(nil)
+
 // TodoStorage provides a ToDO Storage implementation over a PostgreSQL database
 type TodoStorage struct {
 	// db holds connection in a pool for optimal performance
 	db *pgxpool.Pool
 }
 
+// NewTodoStore returns an initialized TodoStorage storage with connection pool
+func NewTodoStore(db *pgxpool.Pool) (TodoStorage, error) {
+	if db == nil {
+		return TodoStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return TodoStorage{db: db}, nil
+}
+
+// FindOneTodo returns the TodoModel associated with the ID in the DB
 func (t TodoStorage) FindOneTodo(ctx context.Context, id uuid.UUID) (pkg.TodoModel, error) {
 	var todo pkg.TodoModel
-	err := t.db.QueryRow(ctx, findTodoByIDQuery, id).Scan(&todo.ID, &todo.UserID, &todo.Title, &todo.Content, &todo.Finished)
+	err := scanTodo(t.db.QueryRow(ctx, findTodoByIDQuery, id), &todo)
 	switch err {
 	case nil:
 		return todo, nil
@@ -36,27 +57,365 @@ func (t TodoStorage) FindOneTodo(ctx context.Context, id uuid.UUID) (pkg.TodoMod
 	}
 }
 
-func (t TodoStorage) FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter pkg.TodoFilter) ([]pkg.TodoModel, error) {
+// FindAllTodoOfUser returns the latest todos of the user matching the filter,
+// of the project if not nil
+func (t TodoStorage) FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter pkg.TodoFilter, projectID uuid.UUID) ([]pkg.TodoModel, error) {
 	query, err := getFilterValue(filter)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := t.db.Query(ctx, query, userID)
+	rows, err := t.db.Query(ctx, query, userID, nullUUID(projectID))
 	if err != nil {
 		return nil, serror.NewQueryError(query, err, err.Error())
 	}
+	defer rows.Close()
+
+	var todos []pkg.TodoModel
+	for rows.Next() {
+		var todo pkg.TodoModel
+		err = scanTodo(rows, &todo)
+		if err != nil {
+			return nil, serror.NewQueryError(query, err, err.Error())
+		}
+		todos = append(todos, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	return todos, nil
 }
 
-func (t TodoStorage) UpdateOne(ctx context.Context, todo pkg.TodoModel) error {
-	panic("implement me")
+// UpdateOne stores the updated todo inside the DB and returns its new version
+func (t TodoStorage) UpdateOne(ctx context.Context, todo pkg.TodoModel) (int64, error) {
+	var version int64
+	err := t.db.QueryRow(ctx, updateTodoQuery, todo.ID, todo.Title, todo.Content, todo.Finished, todo.AutoFinish, todo.Version,
+		nullUUID(todo.ProjectID), nullTime(todo.DueAt)).Scan(&version)
+	switch err {
+	case nil:
+		return version, nil
+	case pgx.ErrNoRows:
+		return 0, writeMissError(ctx, t.db, updateTodoQuery, todo.ID, uuid.Nil)
+	default:
+		return 0, todoWriteError(updateTodoQuery, err)
+	}
 }
 
+// InsertOne stores the todo inside the DB
 func (t TodoStorage) InsertOne(ctx context.Context, todo pkg.TodoModel) (uuid.UUID, error) {
-	panic("implement me")
+	cmd, err := t.db.Exec(ctx, storeTodoQuery, todo.ID, todo.UserID, todo.Title, todo.Content, todo.Finished, todo.AutoFinish,
+		nullUUID(todo.ProjectID), nullTime(todo.DueAt))
+	if err != nil {
+		return uuid.Nil, todoWriteError(storeTodoQuery, err)
+	}
+	if !cmd.Insert() && cmd.RowsAffected() != 1 {
+		return uuid.Nil, serror.NewQueryError(storeTodoQuery, serror.ErrInsertCommand, "")
+	}
+	return todo.ID, nil
+}
+
+// DeleteOne moves the todo to the trash
+func (t TodoStorage) DeleteOne(ctx context.Context, id uuid.UUID, version int64) error {
+	cmd, err := t.db.Exec(ctx, deleteTodoByID, id, version)
+	if err != nil {
+		return serror.NewQueryError(deleteTodoByID, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return writeMissError(ctx, t.db, deleteTodoByID, id, uuid.Nil)
+	}
+	return nil
+}
+
+// FindTrashOfUser returns the latest deleted todos of the user
+func (t TodoStorage) FindTrashOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.TodoModel, error) {
+	rows, err := t.db.Query(ctx, findTrashOfUserQuery, userID)
+	if err != nil {
+		return nil, serror.NewQueryError(findTrashOfUserQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var todos []pkg.TodoModel
+	for rows.Next() {
+		var todo pkg.TodoModel
+		err = scanTodo(rows, &todo, &todo.DeletedAt)
+		if err != nil {
+			return nil, serror.NewQueryError(findTrashOfUserQuery, err, err.Error())
+		}
+		todos = append(todos, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findTrashOfUserQuery, err, err.Error())
+	}
+	return todos, nil
+}
+
+// FindSyncedTodos returns all the todos out of the trash the user may have a role on in the DB, oldest first
+func (t TodoStorage) FindSyncedTodos(ctx context.Context, userID uuid.UUID) ([]pkg.TodoModel, error) {
+	rows, err := t.db.Query(ctx, findSyncedTodosOfUserQuery, userID)
+	if err != nil {
+		return nil, serror.NewQueryError(findSyncedTodosOfUserQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var todos []pkg.TodoModel
+	for rows.Next() {
+		var todo pkg.TodoModel
+		err = scanTodo(rows, &todo)
+		if err != nil {
+			return nil, serror.NewQueryError(findSyncedTodosOfUserQuery, err, err.Error())
+		}
+		todos = append(todos, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findSyncedTodosOfUserQuery, err, err.Error())
+	}
+	return todos, nil
+}
+
+// FindDeletedTodo returns the TodoModel in the trash associated with the ID in the DB
+func (t TodoStorage) FindDeletedTodo(ctx context.Context, id uuid.UUID) (pkg.TodoModel, error) {
+	var todo pkg.TodoModel
+	err := scanTodo(t.db.QueryRow(ctx, findDeletedTodoByIDQuery, id), &todo, &todo.DeletedAt)
+	switch err {
+	case nil:
+		return todo, nil
+	case pgx.ErrNoRows:
+		return pkg.NilTodoModel, serror.NewQueryError(findDeletedTodoByIDQuery, serror.ErrTodoNotFound, err.Error())
+	default:
+		return pkg.NilTodoModel, serror.NewQueryError(findDeletedTodoByIDQuery, err, err.Error())
+	}
+}
+
+// RestoreOne moves the todo of the user out of the trash
+func (t TodoStorage) RestoreOne(ctx context.Context, id, userID uuid.UUID) (pkg.TodoModel, error) {
+	var todo pkg.TodoModel
+	err := scanTodo(t.db.QueryRow(ctx, restoreUserTodoQuery, id, userID), &todo)
+	switch err {
+	case nil:
+		return todo, nil
+	case pgx.ErrNoRows:
+		return pkg.NilTodoModel, serror.NewQueryError(restoreUserTodoQuery, serror.ErrTodoNotFound, err.Error())
+	default:
+		return pkg.NilTodoModel, serror.NewQueryError(restoreUserTodoQuery, err, err.Error())
+	}
+}
+
+// PurgeDeleted deletes from the DB the todos deleted before the time
+func (t TodoStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	cmd, err := t.db.Exec(ctx, purgeDeletedTodosQuery, before)
+	if err != nil {
+		return 0, serror.NewQueryError(purgeDeletedTodosQuery, err, err.Error())
+	}
+	return cmd.RowsAffected(), nil
+}
+
+// rowQuerier is implemented by both the pool and a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// batchQuerier is implemented by both the pool and a transaction.
+type batchQuerier interface {
+	rowQuerier
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// writeMissError tells apart why a conditional write on the todo didn't affect
+// any row, either the todo doesn't exist or its version has changed.
+// A todo of another user than a non nil userID doesn't exist.
+func writeMissError(ctx context.Context, q rowQuerier, query string, id, userID uuid.UUID) error {
+	versionQuery, args := findTodoVersionQuery, []interface{}{id}
+	if userID != uuid.Nil {
+		versionQuery, args = findUserTodoVersionQuery, []interface{}{id, userID}
+	}
+	var version int64
+	err := q.QueryRow(ctx, versionQuery, args...).Scan(&version)
+	switch err {
+	case nil:
+		return serror.NewQueryError(query, serror.ErrVersionConflict, "")
+	case pgx.ErrNoRows:
+		return serror.NewQueryError(query, serror.ErrTodoNotFound, err.Error())
+	default:
+		return serror.NewQueryError(versionQuery, err, err.Error())
+	}
+}
+
+// ApplyBatch applies the writes in a single round trip with a pgx batch.
+// An atomic batch runs inside a transaction that is only committed if every write succeeds.
+func (t TodoStorage) ApplyBatch(ctx context.Context, writes []pkg.TodoWrite, atomic bool) ([]pkg.TodoWriteResult, error) {
+	for _, w := range writes {
+		if batchWriteQuery(w.Op) == "" {
+			return nil, fmt.Errorf("unsupported todo write op %d", w.Op)
+		}
+	}
+
+	if !atomic {
+		results, failed := t.sendBatch(ctx, t.db, writes)
+		if failed < 0 {
+			return results, nil
+		}
+		// postgres runs a batch in an implicit transaction, so the failed statement rolled
+		// back the whole batch. The writes are applied again one at a time to not fail together.
+		for i := range writes {
+			one, _ := t.sendBatch(ctx, t.db, writes[i:i+1])
+			results[i] = one[0]
+		}
+		return results, nil
+	}
+
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return nil, serror.NewQueryError("BEGIN", err, err.Error())
+	}
+	// no-op once committed
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	results, failed := t.sendBatch(ctx, tx, writes)
+	for i := 0; failed < 0 && i < len(results); i++ {
+		if results[i].Err != nil {
+			failed = i
+		}
+	}
+	if failed >= 0 {
+		for i := range results {
+			if i != failed {
+				results[i] = pkg.TodoWriteResult{Err: pkg.ErrBatchAborted}
+			}
+		}
+		return results, nil
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, serror.NewQueryError("COMMIT", err, err.Error())
+	}
+	return results, nil
+}
+
+// sendBatch sends the writes in a single batch and returns their results, along
+// with the index of the first statement that failed with an error, or -1.
+// Writes that didn't match the expected todo are not failed statements.
+func (t TodoStorage) sendBatch(ctx context.Context, q batchQuerier, writes []pkg.TodoWrite) ([]pkg.TodoWriteResult, int) {
+	b := &pgx.Batch{}
+	for _, w := range writes {
+		todo := w.Todo
+		switch w.Op {
+		case pkg.TodoInsert:
+			b.Queue(storeTodoQuery, todo.ID, todo.UserID, todo.Title, todo.Content, todo.Finished, todo.AutoFinish,
+				nullUUID(todo.ProjectID), nullTime(todo.DueAt))
+		case pkg.TodoUpdate:
+			b.Queue(updateUserTodoQuery, todo.ID, todo.UserID, todo.Title, todo.Content, todo.Finished, todo.AutoFinish, todo.Version,
+				nullUUID(todo.ProjectID), nullTime(todo.DueAt))
+		case pkg.TodoComplete:
+			b.Queue(completeUserTodoQuery, todo.ID, todo.UserID, todo.Version)
+		case pkg.TodoDelete:
+			b.Queue(deleteUserTodoQuery, todo.ID, todo.UserID, todo.Version)
+		}
+	}
+
+	br := q.SendBatch(ctx, b)
+	results := make([]pkg.TodoWriteResult, len(writes))
+	failed := -1
+	var misses []int
+	for i, w := range writes {
+		var err error
+		switch w.Op {
+		case pkg.TodoInsert:
+			results[i].Todo = w.Todo
+			err = br.QueryRow().Scan(&results[i].Todo.ProjectID)
+		case pkg.TodoUpdate, pkg.TodoComplete:
+			todo := &results[i].Todo
+			err = scanTodo(br.QueryRow(), todo)
+		case pkg.TodoDelete:
+			var cmd pgconn.CommandTag
+			cmd, err = br.Exec()
+			if err == nil && cmd.RowsAffected() != 1 {
+				err = pgx.ErrNoRows
+			}
+		}
+		switch {
+		case err == pgx.ErrNoRows:
+			misses = append(misses, i)
+		case err != nil:
+			results[i] = pkg.TodoWriteResult{Err: todoWriteError(batchWriteQuery(w.Op), err)}
+			if failed < 0 {
+				failed = i
+			}
+		}
+	}
+	// the connection can't be used before the batch is closed.
+	if err := br.Close(); err != nil && failed < 0 {
+		failed = len(writes) - 1
+		results[failed] = pkg.TodoWriteResult{Err: serror.NewQueryError(batchWriteQuery(writes[failed].Op), err, err.Error())}
+	}
+
+	// a failed statement aborted the transaction, only the committed todos can be checked.
+	var mq rowQuerier = q
+	if failed >= 0 {
+		mq = t.db
+	}
+	for _, i := range misses {
+		w := writes[i]
+		results[i] = pkg.TodoWriteResult{Err: writeMissError(ctx, mq, batchWriteQuery(w.Op), w.Todo.ID, w.Todo.UserID)}
+	}
+	return results, failed
+}
+
+// todoWriteError wraps the err of the todo write query, reporting as serror.ErrProjectNotFound
+// the project that didn't resolve to one of the user.
+func todoWriteError(query string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == notNullViolation && pgErr.ColumnName == "project_id" {
+		return serror.NewQueryError(query, serror.ErrProjectNotFound, err.Error())
+	}
+	return serror.NewQueryError(query, err, err.Error())
 }
 
-func (t TodoStorage) DeleteOne(ctx context.Context, id uuid.UUID) error {
-	panic("implement me")
+// nullUUID returns the id as a query argument, a nil one being NULL.
+func nullUUID(id uuid.UUID) interface{} {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id
+}
+
+// nullTime returns the time as a query argument, a zero one being NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// scanTodo scans the row of the todo columns, in the order of the todo queries,
+// followed by the extra columns. The NULL due_at and series_id are zero.
+func scanTodo(row pgx.Row, todo *pkg.TodoModel, extra ...interface{}) error {
+	var dueAt *time.Time
+	var seriesID *uuid.UUID
+	dest := append([]interface{}{&todo.ID, &todo.UserID, &todo.ProjectID, &todo.Title, &todo.Content, &todo.Finished,
+		&todo.AutoFinish, &todo.Version, &dueAt, &seriesID}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if dueAt != nil {
+		todo.DueAt = *dueAt
+	}
+	if seriesID != nil {
+		todo.SeriesID = *seriesID
+	}
+	return nil
+}
+
+func batchWriteQuery(op pkg.TodoWriteOp) string {
+	switch op {
+	case pkg.TodoInsert:
+		return storeTodoQuery
+	case pkg.TodoUpdate:
+		return updateUserTodoQuery
+	case pkg.TodoComplete:
+		return completeUserTodoQuery
+	case pkg.TodoDelete:
+		return deleteUserTodoQuery
+	default:
+		return ""
+	}
 }
 
 func getFilterValue(filter pkg.TodoFilter) (string, error) {
@@ -64,9 +423,9 @@ func getFilterValue(filter pkg.TodoFilter) (string, error) {
 	case pkg.NilFilter:
 		return findAllTodoByUser, nil
 	case pkg.Finished:
-		return fmt.Sprintf(findAllTodoByUserWithFinishedFilter, "TRUE"), nil
+		return fmt.Sprintf(findAllTodoByUserWithFinishedFilter, filterPostgresTrue), nil
 	case pkg.UnFinished:
-		return fmt.Sprintf(findAllTodoByUserWithFinishedFilter, "FALSE"), nil
+		return fmt.Sprintf(findAllTodoByUserWithFinishedFilter, filterPostgresFalse), nil
 	default:
 		return "", fmt.Errorf("unsupported filter")
 	}
@@ -73,14 +73,28 @@ func (p UserStorage) Update(ctx context.Context, user pkg.UserModel) error {
 	return nil
 }
 
-// Store stores the user mode inside the DB
+// Store stores the user mode inside the DB, along with its inbox project
+// in the same transaction.
 func (p UserStorage) Store(ctx context.Context, user pkg.UserModel) (uuid.UUID, error) {
-	cmd, err := p.db.Exec(ctx, storeUserQuery, user.ID, user.Email, user.Password, user.FirstName, user.LastName, user.Username)
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, serror.NewQueryError("BEGIN", err, err.Error())
+	}
+	// no-op once committed
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	cmd, err := tx.Exec(ctx, storeUserQuery, user.ID, user.Email, user.Password, user.FirstName, user.LastName, user.Username)
 	if err != nil {
 		return uuid.Nil, serror.NewQueryError(storeUserQuery, err, err.Error())
 	}
 	if !cmd.Insert() && cmd.RowsAffected() != 1 {
 		return uuid.Nil, serror.NewQueryError(storeUserQuery, serror.ErrInsertCommand, "")
 	}
+	if _, err = tx.Exec(ctx, storeInboxProjectQuery, uuid.New(), user.ID, pkg.InboxProjectName); err != nil {
+		return uuid.Nil, serror.NewQueryError(storeInboxProjectQuery, err, err.Error())
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return uuid.Nil, serror.NewQueryError("COMMIT", err, err.Error())
+	}
 	return user.ID, nil
 }
@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Compile-time check for ensuring WebhookStorage implements pkg.WebhookStorage.
+var _ pkg.WebhookStorage = (*WebhookStorage)(nil)
+
+// WebhookStorage provides a Webhook Storage implementation over a PostgreSQL database.
+// The events are dispatched to the webhooks as they're relayed from the EventStorage.
+type WebhookStorage struct {
+	// db holds connection in a pool for optimal performance
+	db *pgxpool.Pool
+}
+
+// NewWebhookStore returns an initialized WebhookStorage storage with connection pool
+func NewWebhookStore(db *pgxpool.Pool) (WebhookStorage, error) {
+	if db == nil {
+		return WebhookStorage{}, fmt.Errorf("db proxy pool is nil")
+	}
+	return WebhookStorage{db: db}, nil
+}
+
+// FindOneWebhook returns the WebhookModel associated with the ID in the DB
+func (s WebhookStorage) FindOneWebhook(ctx context.Context, id uuid.UUID) (pkg.WebhookModel, error) {
+	var webhook pkg.WebhookModel
+	err := scanWebhook(s.db.QueryRow(ctx, findWebhookByIDQuery, id), &webhook)
+	switch err {
+	case nil:
+		return webhook, nil
+	case pgx.ErrNoRows:
+		return pkg.NilWebhookModel, serror.NewQueryError(findWebhookByIDQuery, serror.ErrWebhookNotFound, err.Error())
+	default:
+		return pkg.NilWebhookModel, serror.NewQueryError(findWebhookByIDQuery, err, err.Error())
+	}
+}
+
+// FindWebhooksOfUser returns the personal webhooks of the user in the DB
+func (s WebhookStorage) FindWebhooksOfUser(ctx context.Context, userID uuid.UUID) ([]pkg.WebhookModel, error) {
+	return s.findWebhooks(ctx, findWebhooksOfUserQuery, userID)
+}
+
+// FindWebhooksOfOrg returns the webhooks of the organization in the DB
+func (s WebhookStorage) FindWebhooksOfOrg(ctx context.Context, orgID uuid.UUID) ([]pkg.WebhookModel, error) {
+	return s.findWebhooks(ctx, findWebhooksOfOrgQuery, orgID)
+}
+
+func (s WebhookStorage) findWebhooks(ctx context.Context, query string, id uuid.UUID) ([]pkg.WebhookModel, error) {
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	defer rows.Close()
+
+	var webhooks []pkg.WebhookModel
+	for rows.Next() {
+		var webhook pkg.WebhookModel
+		if err = scanWebhook(rows, &webhook); err != nil {
+			return nil, serror.NewQueryError(query, err, err.Error())
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(query, err, err.Error())
+	}
+	return webhooks, nil
+}
+
+// InsertOne stores the webhook inside the DB
+func (s WebhookStorage) InsertOne(ctx context.Context, webhook pkg.WebhookModel) error {
+	_, err := s.db.Exec(ctx, storeWebhookQuery, webhook.ID, webhook.UserID, nullUUID(webhook.OrgID), webhook.URL,
+		webhook.Secret, webhook.Events, webhook.Disabled, webhook.Failures, webhook.CreatedAt)
+	if err != nil {
+		return serror.NewQueryError(storeWebhookQuery, err, err.Error())
+	}
+	return nil
+}
+
+// UpdateOne updates the webhook inside the DB
+func (s WebhookStorage) UpdateOne(ctx context.Context, webhook pkg.WebhookModel) error {
+	cmd, err := s.db.Exec(ctx, updateWebhookQuery, webhook.ID, webhook.URL, webhook.Events, webhook.Disabled,
+		webhook.Failures)
+	if err != nil {
+		return serror.NewQueryError(updateWebhookQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(updateWebhookQuery, serror.ErrWebhookNotFound, "")
+	}
+	return nil
+}
+
+// DeleteOne deletes the webhook from the DB, its deliveries along
+func (s WebhookStorage) DeleteOne(ctx context.Context, id uuid.UUID) error {
+	cmd, err := s.db.Exec(ctx, deleteWebhookQuery, id)
+	if err != nil {
+		return serror.NewQueryError(deleteWebhookQuery, err, err.Error())
+	}
+	if cmd.RowsAffected() != 1 {
+		return serror.NewQueryError(deleteWebhookQuery, serror.ErrWebhookNotFound, "")
+	}
+	return nil
+}
+
+// FindDeliveries returns the latest deliveries of the webhook in the DB
+func (s WebhookStorage) FindDeliveries(ctx context.Context, webhookID uuid.UUID, n int) ([]pkg.DeliveryModel, error) {
+	rows, err := s.db.Query(ctx, findDeliveriesQuery, webhookID, n)
+	if err != nil {
+		return nil, serror.NewQueryError(findDeliveriesQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var deliveries []pkg.DeliveryModel
+	for rows.Next() {
+		var delivery pkg.DeliveryModel
+		var status string
+		var deliveredAt *time.Time
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &status,
+			&delivery.Attempts, &delivery.ResponseCode, &delivery.LastError, &delivery.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, serror.NewQueryError(findDeliveriesQuery, err, err.Error())
+		}
+		delivery.Status = pkg.DeliveryStatus(status)
+		if deliveredAt != nil {
+			delivery.DeliveredAt = *deliveredAt
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(findDeliveriesQuery, err, err.Error())
+	}
+	return deliveries, nil
+}
+
+// DispatchEvent stores the deliveries of the event to the subscribed webhooks inside the DB
+func (s WebhookStorage) DispatchEvent(ctx context.Context, e pkg.Event, staffOrgID uuid.UUID) error {
+	_, err := s.db.Exec(ctx, dispatchEventQuery, e.ID, e.Type, e.UserID, nullUUID(e.OrgID), nullUUID(staffOrgID))
+	if err != nil {
+		return serror.NewQueryError(dispatchEventQuery, err, err.Error())
+	}
+	return nil
+}
+
+// ClaimDeliveries locks and leases the due deliveries in the DB, and returns them
+func (s WebhookStorage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, n int) ([]pkg.WebhookDelivery, error) {
+	rows, err := s.db.Query(ctx, claimDeliveriesQuery, now, now.Add(lease), n)
+	if err != nil {
+		return nil, serror.NewQueryError(claimDeliveriesQuery, err, err.Error())
+	}
+	defer rows.Close()
+
+	var list []pkg.WebhookDelivery
+	for rows.Next() {
+		var d pkg.WebhookDelivery
+		var data []byte
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.EventID, &d.EventType, &data, &d.OccurredAt,
+			&d.Attempt)
+		if err != nil {
+			return nil, serror.NewQueryError(claimDeliveriesQuery, err, err.Error())
+		}
+		d.Data = data
+		list = append(list, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, serror.NewQueryError(claimDeliveriesQuery, err, err.Error())
+	}
+	return list, nil
+}
+
+// MarkDelivered marks the delivery delivered inside the DB
+func (s WebhookStorage) MarkDelivered(ctx context.Context, d pkg.WebhookDelivery, code int) error {
+	if _, err := s.db.Exec(ctx, markDeliveredQuery, d.ID, d.Attempt, code); err != nil {
+		return serror.NewQueryError(markDeliveredQuery, err, err.Error())
+	}
+	return nil
+}
+
+// MarkFailed records the failed attempt of the delivery inside the DB
+func (s WebhookStorage) MarkFailed(ctx context.Context, d pkg.WebhookDelivery, code int, reason string, retryAt time.Time,
+	dead bool, disableAfter int) (bool, error) {
+	status := pkg.DeliveryPending
+	if dead {
+		status = pkg.DeliveryDead
+	}
+	var disabled bool
+	err := s.db.QueryRow(ctx, markDeliveryFailedQuery, d.ID, d.Attempt, string(status), code, reason, retryAt,
+		disableAfter).Scan(&disabled)
+	switch err {
+	case nil:
+		return disabled, nil
+	case pgx.ErrNoRows:
+		// the lease expired and the delivery was claimed again.
+		return false, nil
+	default:
+		return false, serror.NewQueryError(markDeliveryFailedQuery, err, err.Error())
+	}
+}
+
+// PurgeDeliveries deletes the delivered and dead deliveries from the DB
+func (s WebhookStorage) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	cmd, err := s.db.Exec(ctx, purgeDeliveriesQuery, before)
+	if err != nil {
+		return 0, serror.NewQueryError(purgeDeliveriesQuery, err, err.Error())
+	}
+	return cmd.RowsAffected(), nil
+}
+
+// scanWebhook scans the row of the webhook columns, the NULL org_id is zero.
+func scanWebhook(row pgx.Row, webhook *pkg.WebhookModel) error {
+	var orgID *uuid.UUID
+	err := row.Scan(&webhook.ID, &webhook.UserID, &orgID, &webhook.URL, &webhook.Secret, &webhook.Events,
+		&webhook.Disabled, &webhook.Failures, &webhook.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if orgID != nil {
+		webhook.OrgID = *orgID
+	}
+	return nil
+}
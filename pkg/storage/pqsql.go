@@ -12,6 +12,16 @@ type PostgreSQL struct {
 	// db holds connection in a pool for optimal performance
 	db          *pgxpool.Pool
 	userStorage postgres.UserStorage
+	todoStorage postgres.TodoStorage
+	idemStorage postgres.IdempotencyStorage
+	itemStorage postgres.ItemStorage
+	projStorage postgres.ProjectStorage
+	grntStorage postgres.GrantStorage
+	orgStorage  postgres.OrgStorage
+	serStorage  postgres.SeriesStorage
+	remStorage  postgres.ReminderStorage
+	hookStorage postgres.WebhookStorage
+	evtStorage  postgres.EventStorage
 }
 
 // NewPostgreSQL returns an initialized PostgreSQL storage with connection pool
@@ -29,7 +39,49 @@ func NewPostgreSQL(dbURL string) (PostgreSQL, error) {
 	if err != nil {
 		return PostgreSQL{}, err
 	}
-	return PostgreSQL{db: db, userStorage: authPg}, nil
+	todoPg, err := postgres.NewTodoStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	idemPg, err := postgres.NewIdempotencyStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	itemPg, err := postgres.NewItemStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	projPg, err := postgres.NewProjectStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	grntPg, err := postgres.NewGrantStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	orgPg, err := postgres.NewOrgStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	serPg, err := postgres.NewSeriesStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	remPg, err := postgres.NewReminderStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	hookPg, err := postgres.NewWebhookStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	evtPg, err := postgres.NewEventStore(db)
+	if err != nil {
+		return PostgreSQL{}, err
+	}
+	return PostgreSQL{db: db, userStorage: authPg, todoStorage: todoPg, idemStorage: idemPg, itemStorage: itemPg,
+		projStorage: projPg, grntStorage: grntPg, orgStorage: orgPg, serStorage: serPg, remStorage: remPg,
+		hookStorage: hookPg, evtStorage: evtPg}, nil
 }
 
 // UserStorageSQL return AUTH Repository implementation over a PostgreSQL database for User
@@ -37,6 +89,56 @@ func (p PostgreSQL) UserStorageSQL() postgres.UserStorage {
 	return p.userStorage
 }
 
+// TodoStorageSQL return Todo Repository implementation over a PostgreSQL database
+func (p PostgreSQL) TodoStorageSQL() postgres.TodoStorage {
+	return p.todoStorage
+}
+
+// IdempotencyStorageSQL return Idempotency Key Repository implementation over a PostgreSQL database
+func (p PostgreSQL) IdempotencyStorageSQL() postgres.IdempotencyStorage {
+	return p.idemStorage
+}
+
+// ItemStorageSQL return checklist Item Repository implementation over a PostgreSQL database
+func (p PostgreSQL) ItemStorageSQL() postgres.ItemStorage {
+	return p.itemStorage
+}
+
+// ProjectStorageSQL return Project Repository implementation over a PostgreSQL database
+func (p PostgreSQL) ProjectStorageSQL() postgres.ProjectStorage {
+	return p.projStorage
+}
+
+// GrantStorageSQL return shared Grant Repository implementation over a PostgreSQL database
+func (p PostgreSQL) GrantStorageSQL() postgres.GrantStorage {
+	return p.grntStorage
+}
+
+// OrgStorageSQL return Organization Repository implementation over a PostgreSQL database
+func (p PostgreSQL) OrgStorageSQL() postgres.OrgStorage {
+	return p.orgStorage
+}
+
+// SeriesStorageSQL return recurring todo Series Repository implementation over a PostgreSQL database
+func (p PostgreSQL) SeriesStorageSQL() postgres.SeriesStorage {
+	return p.serStorage
+}
+
+// ReminderStorageSQL return todo Reminder Repository implementation over a PostgreSQL database
+func (p PostgreSQL) ReminderStorageSQL() postgres.ReminderStorage {
+	return p.remStorage
+}
+
+// WebhookStorageSQL return Webhook Repository implementation over a PostgreSQL database
+func (p PostgreSQL) WebhookStorageSQL() postgres.WebhookStorage {
+	return p.hookStorage
+}
+
+// EventStorageSQL return the outbox Event Repository implementation over a PostgreSQL database
+func (p PostgreSQL) EventStorageSQL() postgres.EventStorage {
+	return p.evtStorage
+}
+
 // Close all the connection
 func (p PostgreSQL) Close() {
 	p.db.Close()
@@ -178,3 +178,141 @@ func TestUserUpdateStorePqSQL(t *testing.T) {
 	suiteBase.SetRepo(repo.UserStorageSQL())
 	suiteBase.TestUpdateUserPqSQL(t)
 }
+
+func TestTodoInsertAndFindPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.TestInsertAndFind(t)
+}
+
+func TestTodoUpdateVersionPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.TestUpdateVersion(t)
+}
+
+func TestTodoDeleteVersionPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.TestDeleteVersion(t)
+}
+
+func TestIdempotencyReserveAndCompletePqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.IdempotencySuiteBase{}
+	suiteBase.SetRepo(repo.IdempotencyStorageSQL())
+	suiteBase.TestReserveAndComplete(t)
+}
+
+func TestIdempotencyExpireAndReleasePqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.IdempotencySuiteBase{}
+	suiteBase.SetRepo(repo.IdempotencyStorageSQL())
+	suiteBase.TestExpireAndRelease(t)
+}
+
+func TestTodoBatchPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.TestBatch(t)
+}
+
+func TestTodoTrashPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.TestTrash(t)
+}
+
+func TestTodoItemsPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetItemRepo(repo.ItemStorageSQL())
+	suiteBase.TestItems(t)
+}
+
+func TestTodoProjectsPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetProjectRepo(repo.ProjectStorageSQL())
+	suiteBase.TestProjects(t)
+}
+
+func TestTodoGrantsPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetProjectRepo(repo.ProjectStorageSQL())
+	suiteBase.SetGrantRepo(repo.GrantStorageSQL())
+	suiteBase.TestGrants(t)
+}
+
+func TestTodoOrgsPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetProjectRepo(repo.ProjectStorageSQL())
+	suiteBase.SetOrgRepo(repo.OrgStorageSQL())
+	suiteBase.TestOrgs(t)
+}
+
+func TestTodoSeriesPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetSeriesRepo(repo.SeriesStorageSQL())
+	suiteBase.TestSeries(t)
+}
+
+func TestTodoRemindersPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetReminderRepo(repo.ReminderStorageSQL())
+	suiteBase.TestReminders(t)
+}
+
+// TestOutboxPqSQL runs the tests of the outbox one after the other, they both claim all the events.
+func TestOutboxPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetOrgRepo(repo.OrgStorageSQL())
+	suiteBase.SetProjectRepo(repo.ProjectStorageSQL())
+	suiteBase.SetEventRepo(repo.EventStorageSQL())
+	suiteBase.SetWebhookRepo(repo.WebhookStorageSQL())
+	suiteBase.TestEvents(t)
+	suiteBase.TestWebhooks(t)
+}
+
+func TestEventLogPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetEventLog(repo.EventStorageSQL())
+	suiteBase.TestEventLog(t)
+}
+
+func TestTodoSyncPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetEventLog(repo.EventStorageSQL())
+	suiteBase.SetGrantRepo(repo.GrantStorageSQL())
+	suiteBase.SetSyncRepo(repo.TodoStorageSQL())
+	suiteBase.TestSync(t)
+}
+
+func TestTodoSearchPqSQL(t *testing.T) {
+	t.Parallel()
+	suiteBase := &testsuite.TodoSuiteBase{}
+	suiteBase.SetRepo(repo.TodoStorageSQL(), repo.UserStorageSQL())
+	suiteBase.SetSearchRepo(repo.TodoStorageSQL())
+	suiteBase.TestSearch(t)
+}
@@ -25,6 +25,51 @@ var (
 var (
 	// ErrTodoNotFound indicates no todo associated with either todoID or userID
 	ErrTodoNotFound = errors.New("no todo found")
+	// ErrVersionConflict indicates the stored version is not the one the
+	// write expected, it has been modified concurrently
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+var (
+	// ErrProjectNotFound indicates no project associated with either projectID or userID
+	ErrProjectNotFound = errors.New("no project found")
+)
+
+var (
+	// ErrGrantNotFound indicates no grant associated with the todo or project and the userID
+	ErrGrantNotFound = errors.New("no grant found")
+)
+
+var (
+	// ErrOrgNotFound indicates no organization associated with the orgID, or the user isn't a member
+	ErrOrgNotFound = errors.New("no organization found")
+	// ErrMemberNotFound indicates the user isn't a member of the organization
+	ErrMemberNotFound = errors.New("no member found")
+)
+
+var (
+	// ErrSeriesNotFound indicates the todo doesn't recur, or no series associated with the seriesID
+	ErrSeriesNotFound = errors.New("no series found")
+)
+
+var (
+	// ErrReminderNotFound indicates no reminder associated with either reminderID or todoID
+	ErrReminderNotFound = errors.New("no reminder found")
+)
+
+var (
+	// ErrWebhookNotFound indicates no webhook associated with the webhookID the user can manage
+	ErrWebhookNotFound = errors.New("no webhook found")
+)
+
+var (
+	// ErrItemNotFound indicates no checklist item associated with either itemID or todoID
+	ErrItemNotFound = errors.New("no item found")
+)
+
+var (
+	// ErrEventNotFound indicates no event associated with the eventID is left in the log
+	ErrEventNotFound = errors.New("no event found")
 )
 
 // QueryError reports the error and QueryType in compact form
@@ -62,6 +107,12 @@ func (qe QueryError) Error() string {
 	return fmt.Sprintf("[%v] - underlying error [%s]", qe.Err, qe.UnderlyingErrorString)
 }
 
+// Unwrap returns the Err, so errors.Is can match
+// the sentinel errors of this package.
+func (qe QueryError) Unwrap() error {
+	return qe.Err
+}
+
 // NewQueryError returns an initialized error of Error type
 func NewQueryError(queryName string, err error, originalErrS string) error {
 	pe := QueryError{
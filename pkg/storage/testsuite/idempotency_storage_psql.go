@@ -0,0 +1,85 @@
+package testsuite
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+)
+
+// IdempotencySuiteBase defines a re-usable set of idempotency key storage related tests
+// that can be executed against any type that implements pkg.IdempotencyStorage.
+type IdempotencySuiteBase struct {
+	r pkg.IdempotencyStorage
+}
+
+// SetRepo configures the test-suite to run all tests against particular repo.
+func (s *IdempotencySuiteBase) SetRepo(r pkg.IdempotencyStorage) {
+	s.r = r
+}
+
+// TestReserveAndComplete verifies a key is reserved once and its saved response returned.
+func (s *IdempotencySuiteBase) TestReserveAndComplete(t *testing.T) {
+	ctx := context.Background()
+	record := pkg.IdempotencyRecord{
+		Scope:       uuid.New().String(),
+		Key:         uuid.New().String(),
+		Fingerprint: "fingerprint",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	_, reserved, err := s.r.Reserve(ctx, record)
+	if err != nil || !reserved {
+		t.Fatalf("exected the key to be reserved got %t %v", reserved, err)
+	}
+
+	existing, reserved, err := s.r.Reserve(ctx, record)
+	if err != nil {
+		t.Fatalf("exected a nil error for reserve got %v", err)
+	}
+	if reserved || existing.Completed() || existing.Fingerprint != record.Fingerprint {
+		t.Errorf("expected the in flight record got %+v", existing)
+	}
+
+	record.StatusCode = http.StatusCreated
+	record.Header = http.Header{"Location": []string{"/v1/todos/1"}}
+	record.Body = []byte(`{"success":true}`)
+	if err = s.r.Complete(ctx, record); err != nil {
+		t.Fatalf("exected a nil error for complete got %v", err)
+	}
+	existing, reserved, err = s.r.Reserve(ctx, record)
+	if err != nil || reserved {
+		t.Fatalf("expected the completed record got %t %v", reserved, err)
+	}
+	if existing.StatusCode != record.StatusCode || existing.Header.Get("Location") != "/v1/todos/1" ||
+		string(existing.Body) != string(record.Body) {
+		t.Errorf("expected the saved response got %+v", existing)
+	}
+}
+
+// TestExpireAndRelease verifies an expired or released key can be reserved again.
+func (s *IdempotencySuiteBase) TestExpireAndRelease(t *testing.T) {
+	ctx := context.Background()
+	record := pkg.IdempotencyRecord{
+		Scope:       uuid.New().String(),
+		Key:         uuid.New().String(),
+		Fingerprint: "fingerprint",
+		ExpiresAt:   time.Now().Add(-time.Minute),
+	}
+	if _, reserved, err := s.r.Reserve(ctx, record); err != nil || !reserved {
+		t.Fatalf("exected the key to be reserved got %t %v", reserved, err)
+	}
+	record.ExpiresAt = time.Now().Add(time.Hour)
+	if _, reserved, err := s.r.Reserve(ctx, record); err != nil || !reserved {
+		t.Fatalf("exected the expired key to be reserved again got %t %v", reserved, err)
+	}
+
+	if err := s.r.Release(ctx, record.Scope, record.Key); err != nil {
+		t.Fatalf("exected a nil error for release got %v", err)
+	}
+	if _, reserved, err := s.r.Reserve(ctx, record); err != nil || !reserved {
+		t.Fatalf("exected the released key to be reserved again got %t %v", reserved, err)
+	}
+}
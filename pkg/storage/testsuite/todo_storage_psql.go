@@ -0,0 +1,1278 @@
+package testsuite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+	"github.com/google/uuid"
+)
+
+// TodoSuiteBase defines a re-usable set of todo storage related tests that can
+// be executed against any type that implements pkg.TodoStorage.
+type TodoSuiteBase struct {
+	r     pkg.TodoStorage
+	users pkg.UserStorage
+	items pkg.ItemStorage
+	projs pkg.ProjectStorage
+	grnts pkg.GrantStorage
+	orgs  pkg.OrgStorage
+	srs   pkg.SeriesStorage
+	rems  pkg.ReminderStorage
+	hooks pkg.WebhookStorage
+	evts  pkg.EventStorage
+	elog  pkg.EventLog
+	sync  pkg.SyncStorage
+	srch  pkg.TodoSearcher
+}
+
+// SetRepo configures the test-suite to run all tests against particular repo.
+// Todos are owned by a user, so the users repo is needed to store them.
+func (s *TodoSuiteBase) SetRepo(r pkg.TodoStorage, users pkg.UserStorage) {
+	s.r = r
+	s.users = users
+}
+
+// storeUser stores a new user that owns the todos of a test.
+func (s *TodoSuiteBase) storeUser(t *testing.T) uuid.UUID {
+	id := uuid.New()
+	_, err := s.users.Store(context.Background(), pkg.UserModel{
+		ID:        id,
+		Email:     id.String() + "@example.com",
+		Password:  "somegibrish&^5$(075",
+		FirstName: "Ankur",
+		Username:  "ankur-anand",
+	})
+	if err != nil {
+		t.Fatalf("exected a nil error for store user got %v", err)
+	}
+	return id
+}
+
+// TestInsertAndFind verifies the insert of a todo and find by ID and user.
+func (s *TodoSuiteBase) TestInsertAndFind(t *testing.T) {
+	_, err := s.r.FindOneTodo(context.Background(), uuid.New())
+	if !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected error type value [`no todo found`] got `%v`", err)
+	}
+
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{
+		ID:      uuid.New(),
+		UserID:  userID,
+		Title:   "write tests",
+		Content: "for the todo storage",
+	}
+	id, err := s.r.InsertOne(context.Background(), todo)
+	if err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	found, err := s.r.FindOneTodo(context.Background(), id)
+	if err != nil {
+		t.Fatalf("exected a nil error for find got %v", err)
+	}
+	// a todo without a project goes to the inbox of the user
+	if found.ProjectID == uuid.Nil {
+		t.Errorf("expected found todo to be in the inbox got nil project")
+	}
+	todo.Version = 1
+	todo.ProjectID = found.ProjectID
+	if found != todo {
+		t.Errorf("expected found todo [%+v] to be equal to inserted todo [%+v]", found, todo)
+	}
+
+	todos, err := s.r.FindAllTodoOfUser(context.Background(), userID, pkg.UnFinished, uuid.Nil)
+	if err != nil {
+		t.Fatalf("exected a nil error for find all got %v", err)
+	}
+	if len(todos) != 1 || todos[0] != todo {
+		t.Errorf("expected the inserted todo in the unfinished todos got %+v", todos)
+	}
+
+	todos, err = s.r.FindAllTodoOfUser(context.Background(), userID, pkg.Finished, uuid.Nil)
+	if err != nil {
+		t.Fatalf("exected a nil error for find all got %v", err)
+	}
+	if len(todos) != 0 {
+		t.Errorf("expected no finished todos got %+v", todos)
+	}
+
+	todos, err = s.r.FindAllTodoOfUser(context.Background(), userID, pkg.NilFilter, uuid.New())
+	if err != nil {
+		t.Fatalf("exected a nil error for find all got %v", err)
+	}
+	if len(todos) != 0 {
+		t.Errorf("expected no todos in an unknown project got %+v", todos)
+	}
+}
+
+// TestUpdateVersion verifies the optimistic concurrency check of the update.
+func (s *TodoSuiteBase) TestUpdateVersion(t *testing.T) {
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "version me"}
+	if _, err := s.r.InsertOne(context.Background(), todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	todo.Version = 1
+	todo.Finished = true
+	version, err := s.r.UpdateOne(context.Background(), todo)
+	if err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	if version != 2 {
+		t.Errorf("expected version 2 after update got %d", version)
+	}
+
+	// stale version
+	_, err = s.r.UpdateOne(context.Background(), todo)
+	if !errors.Is(err, serror.ErrVersionConflict) {
+		t.Errorf("expected version conflict for stale update got %v", err)
+	}
+
+	// unconditional
+	todo.Version = 0
+	version, err = s.r.UpdateOne(context.Background(), todo)
+	if err != nil || version != 3 {
+		t.Errorf("expected unconditional update to version 3 got %d %v", version, err)
+	}
+
+	todo.ID = uuid.New()
+	_, err = s.r.UpdateOne(context.Background(), todo)
+	if !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for unknown todo update got %v", err)
+	}
+}
+
+// TestDeleteVersion verifies the optimistic concurrency check of the delete.
+func (s *TodoSuiteBase) TestDeleteVersion(t *testing.T) {
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "delete me"}
+	if _, err := s.r.InsertOne(context.Background(), todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	err := s.r.DeleteOne(context.Background(), todo.ID, 2)
+	if !errors.Is(err, serror.ErrVersionConflict) {
+		t.Errorf("expected version conflict for stale delete got %v", err)
+	}
+
+	if err = s.r.DeleteOne(context.Background(), todo.ID, 1); err != nil {
+		t.Fatalf("exected a nil error for delete got %v", err)
+	}
+
+	err = s.r.DeleteOne(context.Background(), todo.ID, 0)
+	if !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for deleted todo got %v", err)
+	}
+}
+
+// TestBatch verifies the writes of an atomic batch are rolled back together,
+// and the ones of a non atomic batch are applied on their own.
+func (s *TodoSuiteBase) TestBatch(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "batch", Content: "content"}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	created := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "created in batch", Version: 1}
+	writes := []pkg.TodoWrite{
+		{Op: pkg.TodoInsert, Todo: created},
+		{Op: pkg.TodoComplete, Todo: pkg.TodoModel{ID: todo.ID, UserID: userID, Version: 1}},
+		{Op: pkg.TodoDelete, Todo: pkg.TodoModel{ID: todo.ID, UserID: uuid.New()}},
+	}
+
+	results, err := s.r.ApplyBatch(ctx, writes, true)
+	if err != nil {
+		t.Fatalf("exected a nil error for atomic batch got %v", err)
+	}
+	if !errors.Is(results[2].Err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo of another user to be not found got %v", results[2].Err)
+	}
+	if !errors.Is(results[0].Err, pkg.ErrBatchAborted) || !errors.Is(results[1].Err, pkg.ErrBatchAborted) {
+		t.Errorf("expected the other writes to be aborted got %v %v", results[0].Err, results[1].Err)
+	}
+	if _, err = s.r.FindOneTodo(ctx, created.ID); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected the insert to be rolled back got %v", err)
+	}
+
+	results, err = s.r.ApplyBatch(ctx, writes, false)
+	if err != nil {
+		t.Fatalf("exected a nil error for batch got %v", err)
+	}
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("exected the valid writes to succeed got %v %v", results[0].Err, results[1].Err)
+	}
+	if !results[1].Todo.Finished || results[1].Todo.Version != 2 || results[1].Todo.Title != todo.Title {
+		t.Errorf("expected the completed todo got %+v", results[1].Todo)
+	}
+	if _, err = s.r.FindOneTodo(ctx, created.ID); err != nil {
+		t.Errorf("expected the inserted todo to be found got %v", err)
+	}
+
+	// the version is now 2
+	results, err = s.r.ApplyBatch(ctx, writes[1:2], false)
+	if err != nil {
+		t.Fatalf("exected a nil error for batch got %v", err)
+	}
+	if !errors.Is(results[0].Err, serror.ErrVersionConflict) {
+		t.Errorf("expected version conflict got %v", results[0].Err)
+	}
+}
+
+// SetItemRepo sets the item storage of the todos, for the TestItems.
+func (s *TodoSuiteBase) SetItemRepo(items pkg.ItemStorage) {
+	s.items = items
+}
+
+// TestItems verifies the item writes are restricted to the todo of the user,
+// and that they touch the todo.
+func (s *TodoSuiteBase) TestItems(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "with steps", AutoFinish: true}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	first, touched, err := s.items.InsertOne(ctx, userID, pkg.ItemModel{ID: uuid.New(), TodoID: todo.ID, Text: "first"})
+	if err != nil {
+		t.Fatalf("exected a nil error for item insert got %v", err)
+	}
+	if first.Position != 1 || touched.Version != 2 {
+		t.Errorf("expected item at position 1 and todo at version 2 got %+v %+v", first, touched)
+	}
+	second, _, err := s.items.InsertOne(ctx, userID, pkg.ItemModel{ID: uuid.New(), TodoID: todo.ID, Text: "second", Done: true})
+	if err != nil {
+		t.Fatalf("exected a nil error for item insert got %v", err)
+	}
+	if second.Position != 2 {
+		t.Errorf("expected item put last got position %d", second.Position)
+	}
+
+	_, _, err = s.items.InsertOne(ctx, uuid.New(), pkg.ItemModel{ID: uuid.New(), TodoID: todo.ID, Text: "intruder"})
+	if !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for item of another user got %v", err)
+	}
+	_, _, err = s.items.UpdateOne(ctx, userID, pkg.ItemModel{ID: uuid.New(), TodoID: todo.ID, Text: "ghost"})
+	if !errors.Is(err, serror.ErrItemNotFound) {
+		t.Errorf("expected item not found got %v", err)
+	}
+
+	progress, err := s.items.ProgressOfTodos(ctx, []uuid.UUID{todo.ID, uuid.New()})
+	if err != nil {
+		t.Fatalf("exected a nil error for progress got %v", err)
+	}
+	if len(progress) != 1 || progress[todo.ID] != (pkg.Progress{Done: 1, Total: 2}) {
+		t.Errorf("expected progress 1/2 got %+v", progress)
+	}
+
+	first.Done = true
+	first.Position = 0
+	first, touched, err = s.items.UpdateOne(ctx, userID, first)
+	if err != nil {
+		t.Fatalf("exected a nil error for item update got %v", err)
+	}
+	if first.Position != 1 || !touched.Finished {
+		t.Errorf("expected position kept and todo auto finished got %+v %+v", first, touched)
+	}
+
+	if _, err = s.items.DeleteOne(ctx, userID, todo.ID, second.ID); err != nil {
+		t.Fatalf("exected a nil error for item delete got %v", err)
+	}
+	items, err := s.items.FindItemsOfTodo(ctx, todo.ID)
+	if err != nil {
+		t.Fatalf("exected a nil error for items got %v", err)
+	}
+	if len(items) != 1 || items[0].ID != first.ID {
+		t.Errorf("expected the first item left got %+v", items)
+	}
+}
+
+// TestTrash verifies a deleted todo is only visible in the trash until
+// it's restored or purged.
+func (s *TodoSuiteBase) TestTrash(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "trash me"}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	if err := s.r.DeleteOne(ctx, todo.ID, 0); err != nil {
+		t.Fatalf("exected a nil error for delete got %v", err)
+	}
+
+	if _, err := s.r.FindOneTodo(ctx, todo.ID); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for deleted todo got %v", err)
+	}
+	trash, err := s.r.FindTrashOfUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("exected a nil error for trash got %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != todo.ID || trash[0].DeletedAt.IsZero() {
+		t.Errorf("expected the deleted todo in the trash got %+v", trash)
+	}
+	if deleted, err := s.r.FindDeletedTodo(ctx, todo.ID); err != nil || deleted.ID != todo.ID {
+		t.Errorf("expected the deleted todo found in the trash got %+v %v", deleted, err)
+	}
+
+	if _, err = s.r.RestoreOne(ctx, todo.ID, uuid.New()); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for restore of another user got %v", err)
+	}
+	restored, err := s.r.RestoreOne(ctx, todo.ID, userID)
+	if err != nil {
+		t.Fatalf("exected a nil error for restore got %v", err)
+	}
+	if restored.Version != 3 || restored.Title != todo.Title {
+		t.Errorf("expected the restored todo at version 3 got %+v", restored)
+	}
+	if _, err = s.r.FindOneTodo(ctx, todo.ID); err != nil {
+		t.Errorf("expected the restored todo to be found got %v", err)
+	}
+
+	if err = s.r.DeleteOne(ctx, todo.ID, 0); err != nil {
+		t.Fatalf("exected a nil error for delete got %v", err)
+	}
+	if _, err = s.r.PurgeDeleted(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("exected a nil error for purge got %v", err)
+	}
+	if trash, _ = s.r.FindTrashOfUser(ctx, userID); len(trash) != 1 {
+		t.Errorf("expected the recently deleted todo to be kept got %+v", trash)
+	}
+	n, err := s.r.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("exected a nil error for purge got %v", err)
+	}
+	if n < 1 {
+		t.Errorf("expected the deleted todo to be purged got %d", n)
+	}
+	if _, err = s.r.RestoreOne(ctx, todo.ID, userID); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found for restore of purged todo got %v", err)
+	}
+}
+
+// SetProjectRepo sets the project storage of the todos, for the TestProjects.
+func (s *TodoSuiteBase) SetProjectRepo(projs pkg.ProjectStorage) {
+	s.projs = projs
+}
+
+// TestProjects verifies the inbox stored at signup, the project of the todos
+// and the two delete modes of a project.
+func (s *TodoSuiteBase) TestProjects(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	projects, err := s.projs.FindAllProjectOfUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("exected a nil error for find all projects got %v", err)
+	}
+	if len(projects) != 1 || !projects[0].Inbox || projects[0].Name != pkg.InboxProjectName {
+		t.Fatalf("expected the inbox stored at signup got %+v", projects)
+	}
+	inbox := projects[0]
+
+	moved := pkg.ProjectModel{ID: uuid.New(), UserID: userID, Name: "moved", Color: "#ff0000"}
+	cascaded := pkg.ProjectModel{ID: uuid.New(), UserID: userID, Name: "cascaded"}
+	for _, project := range []pkg.ProjectModel{moved, cascaded} {
+		if _, err = s.projs.InsertOne(ctx, project); err != nil {
+			t.Fatalf("exected a nil error for insert project got %v", err)
+		}
+	}
+	found, err := s.projs.FindOneProject(ctx, moved.ID)
+	if err != nil || found != moved {
+		t.Errorf("expected found project [%+v] to be equal to inserted project [%+v] got %v", found, moved, err)
+	}
+
+	todos := []pkg.TodoModel{
+		{ID: uuid.New(), UserID: userID, ProjectID: moved.ID, Title: "moved"},
+		{ID: uuid.New(), UserID: userID, ProjectID: cascaded.ID, Title: "cascaded"},
+	}
+	for _, todo := range todos {
+		if _, err = s.r.InsertOne(ctx, todo); err != nil {
+			t.Fatalf("exected a nil error for insert got %v", err)
+		}
+	}
+	// another user can't file a todo under the project
+	intruder := pkg.TodoModel{ID: uuid.New(), UserID: s.storeUser(t), ProjectID: moved.ID, Title: "intruder"}
+	if _, err = s.r.InsertOne(ctx, intruder); !errors.Is(err, serror.ErrProjectNotFound) {
+		t.Errorf("expected project not found for the project of another user got %v", err)
+	}
+
+	of, err := s.r.FindAllTodoOfUser(ctx, userID, pkg.NilFilter, moved.ID)
+	if err != nil {
+		t.Fatalf("exected a nil error for find all got %v", err)
+	}
+	if len(of) != 1 || of[0].ID != todos[0].ID {
+		t.Errorf("expected only the todo of the project got %+v", of)
+	}
+
+	if err = s.projs.DeleteOne(ctx, inbox.ID, pkg.CascadeTodos); !errors.Is(err, serror.ErrProjectNotFound) {
+		t.Errorf("expected the inbox not deleted got %v", err)
+	}
+	if err = s.projs.DeleteOne(ctx, moved.ID, pkg.MoveTodosToInbox); err != nil {
+		t.Fatalf("exected a nil error for delete project got %v", err)
+	}
+	todo, err := s.r.FindOneTodo(ctx, todos[0].ID)
+	if err != nil || todo.ProjectID != inbox.ID {
+		t.Errorf("expected the todo moved to the inbox got %+v %v", todo, err)
+	}
+	if err = s.projs.DeleteOne(ctx, cascaded.ID, pkg.CascadeTodos); err != nil {
+		t.Fatalf("exected a nil error for delete project got %v", err)
+	}
+	if _, err = s.r.FindOneTodo(ctx, todos[1].ID); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected the todo deleted with its project got %v", err)
+	}
+}
+
+// SetGrantRepo sets the grant storage of the todos and projects, for the TestGrants.
+func (s *TodoSuiteBase) SetGrantRepo(grnts pkg.GrantStorage) {
+	s.grnts = grnts
+}
+
+// TestGrants verifies the role of a user is the highest one granted on
+// the todo or its project, and the grants upsert and revoke.
+func (s *TodoSuiteBase) TestGrants(t *testing.T) {
+	ctx := context.Background()
+	ownerID, userID := s.storeUser(t), s.storeUser(t)
+	project := pkg.ProjectModel{ID: uuid.New(), UserID: ownerID, Name: "shared"}
+	if _, err := s.projs.InsertOne(ctx, project); err != nil {
+		t.Fatalf("exected a nil error for insert project got %v", err)
+	}
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: project.ID, Title: "shared"}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	todoGrant := pkg.GrantModel{Resource: pkg.Resource{Type: pkg.ResourceTodo, ID: todo.ID}, UserID: userID, Role: pkg.RoleViewer, GrantedBy: ownerID}
+	projectGrant := pkg.GrantModel{Resource: pkg.Resource{Type: pkg.ResourceProject, ID: project.ID}, UserID: userID, Role: pkg.RoleEditor, GrantedBy: ownerID}
+	for _, grant := range []pkg.GrantModel{todoGrant, projectGrant} {
+		if err := s.grnts.UpsertOne(ctx, grant); err != nil {
+			t.Fatalf("exected a nil error for upsert grant got %v", err)
+		}
+	}
+
+	role, err := s.grnts.RoleOf(ctx, userID, todo.ID, project.ID)
+	if err != nil || role != pkg.RoleEditor {
+		t.Errorf("expected the editor role of the project got %v %v", role, err)
+	}
+	role, err = s.grnts.RoleOf(ctx, userID, todo.ID, uuid.Nil)
+	if err != nil || role != pkg.RoleViewer {
+		t.Errorf("expected the viewer role of the todo got %v %v", role, err)
+	}
+	if role, _ = s.grnts.RoleOf(ctx, ownerID, todo.ID, project.ID); role != pkg.RoleNone {
+		t.Errorf("expected no role granted to the owner got %v", role)
+	}
+
+	// upserted in place
+	todoGrant.Role = pkg.RoleOwner
+	if err = s.grnts.UpsertOne(ctx, todoGrant); err != nil {
+		t.Fatalf("exected a nil error for upsert grant got %v", err)
+	}
+	grants, err := s.grnts.FindGrantsOf(ctx, todoGrant.Resource)
+	if err != nil || len(grants) != 1 || grants[0] != todoGrant {
+		t.Errorf("expected the upserted grant [%+v] got %+v %v", todoGrant, grants, err)
+	}
+	grants, err = s.grnts.FindGrantsOfUser(ctx, userID)
+	if err != nil || len(grants) != 2 {
+		t.Errorf("expected the 2 grants shared with the user got %+v %v", grants, err)
+	}
+
+	if err = s.grnts.DeleteOne(ctx, todoGrant.Resource, userID); err != nil {
+		t.Fatalf("exected a nil error for delete grant got %v", err)
+	}
+	if err = s.grnts.DeleteOne(ctx, todoGrant.Resource, userID); !errors.Is(err, serror.ErrGrantNotFound) {
+		t.Errorf("expected grant not found got %v", err)
+	}
+	// the grants go along with their project
+	if err = s.projs.DeleteOne(ctx, project.ID, pkg.CascadeTodos); err != nil {
+		t.Fatalf("exected a nil error for delete project got %v", err)
+	}
+	if grants, _ = s.grnts.FindGrantsOfUser(ctx, userID); len(grants) != 0 {
+		t.Errorf("expected no grant left got %+v", grants)
+	}
+}
+
+// SetOrgRepo sets the organization storage of the projects, for the TestOrgs.
+func (s *TodoSuiteBase) SetOrgRepo(orgs pkg.OrgStorage) {
+	s.orgs = orgs
+}
+
+// TestOrgs verifies the first owner stored along the organization, the roles of
+// the members on its projects, and the projects of the organization kept apart
+// from the personal ones.
+func (s *TodoSuiteBase) TestOrgs(t *testing.T) {
+	ctx := context.Background()
+	ownerID, userID := s.storeUser(t), s.storeUser(t)
+	org := pkg.OrgModel{ID: uuid.New(), Name: "acme"}
+	if err := s.orgs.InsertOne(ctx, org, ownerID); err != nil {
+		t.Fatalf("exected a nil error for insert org got %v", err)
+	}
+	found, err := s.orgs.FindOneOrg(ctx, org.ID)
+	if err != nil || found != org {
+		t.Errorf("expected the org [%+v] got %+v %v", org, found, err)
+	}
+	if _, err = s.orgs.FindOneOrg(ctx, uuid.New()); !errors.Is(err, serror.ErrOrgNotFound) {
+		t.Errorf("expected org not found got %v", err)
+	}
+	if role, _ := s.orgs.RoleOf(ctx, org.ID, ownerID); role != pkg.OrgRoleOwner {
+		t.Errorf("expected the first owner got %v", role)
+	}
+
+	member := pkg.MembershipModel{OrgID: org.ID, UserID: userID, Role: pkg.OrgRoleGuest}
+	if err = s.orgs.UpsertMember(ctx, member); err != nil {
+		t.Fatalf("exected a nil error for upsert member got %v", err)
+	}
+	// upserted in place
+	member.Role = pkg.OrgRoleMember
+	if err = s.orgs.UpsertMember(ctx, member); err != nil {
+		t.Fatalf("exected a nil error for upsert member got %v", err)
+	}
+	members, err := s.orgs.FindMembers(ctx, org.ID)
+	if err != nil || len(members) != 2 || members[1] != member {
+		t.Errorf("expected the owner and the member [%+v] got %+v %v", member, members, err)
+	}
+	orgs, err := s.orgs.FindOrgsOfUser(ctx, userID)
+	if err != nil || len(orgs) != 1 || orgs[0] != org {
+		t.Errorf("expected the org of the member got %+v %v", orgs, err)
+	}
+
+	project := pkg.ProjectModel{ID: uuid.New(), UserID: ownerID, OrgID: org.ID, Name: "roadmap"}
+	if _, err = s.projs.InsertOne(ctx, project); err != nil {
+		t.Fatalf("exected a nil error for insert project got %v", err)
+	}
+	if orgID, role, err := s.orgs.RoleOnProject(ctx, userID, project.ID); err != nil || orgID != org.ID || role != pkg.OrgRoleMember {
+		t.Errorf("expected the member role on the org project got %v %v %v", orgID, role, err)
+	}
+	projects, err := s.projs.FindAllProjectOfOrg(ctx, org.ID)
+	if err != nil || len(projects) != 1 || projects[0] != project {
+		t.Errorf("expected the org project [%+v] got %+v %v", project, projects, err)
+	}
+	if projects, _ = s.projs.FindAllProjectOfUser(ctx, ownerID); len(projects) != 1 || !projects[0].Inbox {
+		t.Fatalf("expected only the inbox in the personal projects got %+v", projects)
+	}
+	if orgID, role, err := s.orgs.RoleOnProject(ctx, ownerID, projects[0].ID); err != nil || orgID != uuid.Nil || role != pkg.OrgRoleNone {
+		t.Errorf("expected no org of a personal project got %v %v %v", orgID, role, err)
+	}
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: project.ID, Title: "plan"}
+	if _, err = s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	if todos, _ := s.r.FindAllTodoOfUser(ctx, ownerID, pkg.NilFilter, uuid.Nil); len(todos) != 0 {
+		t.Errorf("expected no org todo in the personal ones got %+v", todos)
+	}
+	if todos, _ := s.r.FindAllTodoOfUser(ctx, ownerID, pkg.NilFilter, project.ID); len(todos) != 1 {
+		t.Errorf("expected the todo of the org project got %+v", todos)
+	}
+
+	if err = s.orgs.DeleteMember(ctx, org.ID, userID); err != nil {
+		t.Fatalf("exected a nil error for delete member got %v", err)
+	}
+	if err = s.orgs.DeleteMember(ctx, org.ID, userID); !errors.Is(err, serror.ErrMemberNotFound) {
+		t.Errorf("expected member not found got %v", err)
+	}
+	if orgID, role, _ := s.orgs.RoleOnProject(ctx, userID, project.ID); orgID != org.ID || role != pkg.OrgRoleNone {
+		t.Errorf("expected no role left in the org got %v %v", orgID, role)
+	}
+}
+
+// SetSeriesRepo sets the series storage of the recurring todos, for the TestSeries.
+func (s *TodoSuiteBase) SetSeriesRepo(srs pkg.SeriesStorage) {
+	s.srs = srs
+}
+
+// TestSeries verifies the todo made the first occurrence of its series, the
+// edits of the series reaching the unfinished occurrences only, an occurrence
+// stored once, the latest occurrence finished stalled unless the series ended,
+// and the occurrences kept once the series is deleted.
+func (s *TodoSuiteBase) TestSeries(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	dueAt := time.Date(2021, 3, 8, 14, 0, 0, 0, time.UTC)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "on-call", DueAt: dueAt}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+
+	series := pkg.SeriesModel{ID: uuid.New(), UserID: userID, RRule: "FREQ=WEEKLY;BYDAY=MO",
+		TimeZone: "America/New_York", Start: dueAt, Title: "rotate on-call"}
+	if err := s.srs.InsertOne(ctx, series, uuid.New()); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found got %v", err)
+	}
+	if err := s.srs.InsertOne(ctx, series, todo.ID); err != nil {
+		t.Fatalf("exected a nil error for insert series got %v", err)
+	}
+	found, err := s.srs.FindOneSeries(ctx, series.ID)
+	if err != nil || found.RRule != series.RRule || !found.Start.Equal(series.Start) || found.Title != series.Title {
+		t.Errorf("expected the series [%+v] got %+v %v", series, found, err)
+	}
+	if _, err = s.srs.FindOneSeries(ctx, uuid.New()); !errors.Is(err, serror.ErrSeriesNotFound) {
+		t.Errorf("expected series not found got %v", err)
+	}
+	first, err := s.r.FindOneTodo(ctx, todo.ID)
+	if err != nil || first.SeriesID != series.ID || first.Title != series.Title || !first.DueAt.Equal(dueAt) {
+		t.Errorf("expected the todo the first occurrence got %+v %v", first, err)
+	}
+
+	stalled := func(id uuid.UUID) bool {
+		t.Helper()
+		todos, err := s.srs.FindStalledOccurrences(ctx, 1000)
+		if err != nil {
+			t.Fatalf("exected a nil error for find stalled occurrences got %v", err)
+		}
+		for _, todo := range todos {
+			if todo.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	if stalled(todo.ID) {
+		t.Errorf("expected an unfinished occurrence not stalled")
+	}
+	first.Finished = true
+	if _, err = s.r.UpdateOne(ctx, first); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	if !stalled(todo.ID) {
+		t.Errorf("expected the latest occurrence finished stalled")
+	}
+	next := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: series.Title, Version: 1,
+		DueAt: dueAt.AddDate(0, 0, 7), SeriesID: series.ID}
+	for i := 0; i < 2; i++ {
+		// stored once
+		if err = s.srs.InsertOccurrence(ctx, next); err != nil {
+			t.Fatalf("exected a nil error for insert occurrence got %v", err)
+		}
+	}
+
+	if stalled(todo.ID) {
+		t.Errorf("expected the occurrence followed by the next one not stalled")
+	}
+	next.Finished = true
+	if _, err = s.r.UpdateOne(ctx, next); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	if err = s.srs.EndOne(ctx, series.ID); err != nil {
+		t.Fatalf("exected a nil error for end series got %v", err)
+	}
+	if stalled(next.ID) {
+		t.Errorf("expected the last occurrence of an ended series not stalled")
+	}
+	next.Finished = false
+	if next.Version, err = s.r.UpdateOne(ctx, next); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+
+	// edited, the series goes on
+	series.Title = "rotate on-call duty"
+	if err = s.srs.UpdateOne(ctx, series); err != nil {
+		t.Fatalf("exected a nil error for update series got %v", err)
+	}
+	if err = s.srs.UpdateOne(ctx, pkg.SeriesModel{ID: uuid.New(), RRule: "FREQ=DAILY", TimeZone: "UTC"}); !errors.Is(err, serror.ErrSeriesNotFound) {
+		t.Errorf("expected series not found got %v", err)
+	}
+	if first, _ = s.r.FindOneTodo(ctx, todo.ID); first.Title != "rotate on-call" {
+		t.Errorf("expected the finished occurrence left alone got %+v", first)
+	}
+	if next, _ = s.r.FindOneTodo(ctx, next.ID); next.Title != series.Title || next.SeriesID != series.ID {
+		t.Errorf("expected the next occurrence edited got %+v", next)
+	}
+
+	if err = s.srs.DeleteOne(ctx, series.ID); err != nil {
+		t.Fatalf("exected a nil error for delete series got %v", err)
+	}
+	if err = s.srs.DeleteOne(ctx, series.ID); !errors.Is(err, serror.ErrSeriesNotFound) {
+		t.Errorf("expected series not found got %v", err)
+	}
+	if next, err = s.r.FindOneTodo(ctx, next.ID); err != nil || next.SeriesID != uuid.Nil {
+		t.Errorf("expected the occurrence kept without series got %+v %v", next, err)
+	}
+}
+
+// SetReminderRepo sets the reminder storage of the todos, for the TestReminders.
+func (s *TodoSuiteBase) SetReminderRepo(rems pkg.ReminderStorage) {
+	s.rems = rems
+}
+
+// TestReminders verifies a due reminder claimed once until its lease or retry is
+// over, the dead ones left alone, and a reminder claimed again for a new due time.
+func (s *TodoSuiteBase) TestReminders(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	now := time.Now().Truncate(time.Second)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "pay rent", DueAt: now.Add(10 * time.Minute)}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	early := pkg.ReminderModel{ID: uuid.New(), TodoID: todo.ID, UserID: userID, Offset: 30 * time.Minute, Channel: pkg.ChannelEmail}
+	late := pkg.ReminderModel{ID: uuid.New(), TodoID: todo.ID, UserID: userID, Offset: 5 * time.Minute, Channel: pkg.ChannelLog}
+	for _, reminder := range []pkg.ReminderModel{early, late} {
+		if err := s.rems.InsertOne(ctx, reminder); err != nil {
+			t.Fatalf("exected a nil error for insert reminder got %v", err)
+		}
+	}
+	if err := s.rems.InsertOne(ctx, pkg.ReminderModel{ID: uuid.New(), TodoID: uuid.New(), UserID: userID, Channel: pkg.ChannelLog}); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo not found got %v", err)
+	}
+
+	claimed, err := s.rems.ClaimDue(ctx, now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("exected a nil error for claim got %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].ReminderID != early.ID || claimed[0].Attempt != 1 || claimed[0].Email == "" {
+		t.Fatalf("expected the early reminder claimed got %+v", claimed)
+	}
+	// leased
+	if claimed, _ = s.rems.ClaimDue(ctx, now, time.Minute, 10); len(claimed) != 0 {
+		t.Errorf("expected no reminder claimed during the lease got %+v", claimed)
+	}
+	n, _ := s.rems.ClaimDue(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	if len(n) != 1 || n[0].Attempt != 2 {
+		t.Fatalf("expected the early reminder claimed again once the lease is over got %+v", n)
+	}
+	if err = s.rems.MarkFailed(ctx, n[0], "relay down", now.Add(time.Hour), true); err != nil {
+		t.Fatalf("exected a nil error for mark failed got %v", err)
+	}
+
+	at := now.Add(6 * time.Minute)
+	claimed, _ = s.rems.ClaimDue(ctx, at, time.Minute, 10)
+	if len(claimed) != 1 || claimed[0].ReminderID != late.ID {
+		t.Fatalf("expected only the late reminder claimed, not the dead one got %+v", claimed)
+	}
+	if err = s.rems.MarkSent(ctx, claimed[0]); err != nil {
+		t.Fatalf("exected a nil error for mark sent got %v", err)
+	}
+	reminders, err := s.rems.FindRemindersOfTodo(ctx, todo.ID)
+	if err != nil || len(reminders) != 2 {
+		t.Fatalf("expected the reminders of the todo got %+v %v", reminders, err)
+	}
+	if reminders[0].Status != pkg.ReminderDead || reminders[0].Attempts != 2 || reminders[0].LastError != "relay down" ||
+		reminders[1].Status != pkg.ReminderSent {
+		t.Errorf("expected the early reminder dead and the late one sent got %+v", reminders)
+	}
+
+	todo.DueAt = todo.DueAt.Add(24 * time.Hour)
+	if _, err = s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	claimed, _ = s.rems.ClaimDue(ctx, todo.DueAt, time.Minute, 10)
+	if len(claimed) != 2 || claimed[0].Attempt != 1 || claimed[1].Attempt != 1 {
+		t.Errorf("expected both reminders claimed for the new due time got %+v", claimed)
+	}
+
+	if err = s.rems.DeleteOne(ctx, todo.ID, late.ID); err != nil {
+		t.Fatalf("exected a nil error for delete reminder got %v", err)
+	}
+	if err = s.rems.DeleteOne(ctx, todo.ID, late.ID); !errors.Is(err, serror.ErrReminderNotFound) {
+		t.Errorf("expected reminder not found got %v", err)
+	}
+}
+
+// SetEventRepo sets the outbox of the events, for the TestEvents and TestWebhooks.
+// Both claim all the due events, so they can't run in parallel with one another.
+func (s *TodoSuiteBase) SetEventRepo(evts pkg.EventStorage) {
+	s.evts = evts
+}
+
+// relay claims the due events at the time until none is left, hands
+// them to the handle and marks them relayed.
+func (s *TodoSuiteBase) relay(t *testing.T, at time.Time, handle func(e pkg.Event)) {
+	ctx := context.Background()
+	for {
+		events, err := s.evts.ClaimEvents(ctx, at, time.Minute, 100)
+		if err != nil {
+			t.Fatalf("exected a nil error for claim events got %v", err)
+		}
+		for _, e := range events {
+			handle(e)
+			if err = s.evts.MarkRelayed(ctx, e); err != nil {
+				t.Fatalf("exected a nil error for mark relayed got %v", err)
+			}
+		}
+		if len(events) < 100 {
+			return
+		}
+	}
+}
+
+// TestEvents verifies the events written to the outbox along the users and
+// the changes of their todos, an event claimed once until its lease or retry
+// is over, the stale marks ignored, and the dead events left alone.
+func (s *TodoSuiteBase) TestEvents(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "pay rent"}
+	if _, err := s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	todo.Title = "pay the rent"
+	if _, err := s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	// nothing changed
+	if _, err := s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	todo.Finished = true
+	if _, err := s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	if err := s.r.DeleteOne(ctx, todo.ID, 0); err != nil {
+		t.Fatalf("exected a nil error for delete got %v", err)
+	}
+	if _, err := s.r.RestoreOne(ctx, todo.ID, userID); err != nil {
+		t.Fatalf("exected a nil error for restore got %v", err)
+	}
+
+	now := time.Now()
+	var types []string
+	var last pkg.Event
+	s.relay(t, now, func(e pkg.Event) {
+		if e.UserID != userID {
+			return
+		}
+		types = append(types, e.Type)
+		if e.Type == pkg.EventUserSignedUp {
+			// no personal data
+			var data map[string]interface{}
+			if err := json.Unmarshal(e.Data, &data); err != nil || len(data) != 1 || data["id"] != userID.String() {
+				t.Errorf("expected only the id of the user signed up got %s %v", e.Data, err)
+			}
+		} else if e.SubjectID != todo.ID || len(e.Data) == 0 || e.Attempt != 1 {
+			t.Errorf("expected the event of the todo got %+v", e)
+		}
+		last = e
+	})
+	want := []string{pkg.EventUserSignedUp, pkg.EventTodoCreated, pkg.EventTodoUpdated, pkg.EventTodoCompleted,
+		pkg.EventTodoDeleted, pkg.EventTodoRestored}
+	if len(types) != len(want) {
+		t.Fatalf("expected the events %v got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("expected the events %v got %v", want, types)
+			break
+		}
+	}
+	// the mark of a relayed event is stale
+	if err := s.evts.MarkEventFailed(ctx, last, "boom", now, true); err != nil {
+		t.Fatalf("exected a nil error for mark failed got %v", err)
+	}
+
+	todo.Title = "pay the rent today"
+	if _, err := s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	claim := func(at time.Time) []pkg.Event {
+		var mine []pkg.Event
+		for {
+			events, err := s.evts.ClaimEvents(ctx, at, time.Minute, 100)
+			if err != nil {
+				t.Fatalf("exected a nil error for claim events got %v", err)
+			}
+			for _, e := range events {
+				if e.UserID == userID {
+					mine = append(mine, e)
+				}
+			}
+			if len(events) < 100 {
+				return mine
+			}
+		}
+	}
+	claimed := claim(now)
+	if len(claimed) != 1 || claimed[0].Type != pkg.EventTodoUpdated {
+		t.Fatalf("expected the update claimed got %+v", claimed)
+	}
+	// leased
+	if again := claim(now); len(again) != 0 {
+		t.Errorf("expected no event claimed during the lease got %+v", again)
+	}
+	if err := s.evts.MarkEventFailed(ctx, claimed[0], "boom", now.Add(time.Minute), false); err != nil {
+		t.Fatalf("exected a nil error for mark failed got %v", err)
+	}
+	retried := claim(now.Add(2 * time.Minute))
+	if len(retried) != 1 || retried[0].Attempt != 2 {
+		t.Fatalf("expected the failed event retried got %+v", retried)
+	}
+	// the mark of the first attempt is stale
+	if err := s.evts.MarkRelayed(ctx, claimed[0]); err != nil {
+		t.Fatalf("exected a nil error for mark relayed got %v", err)
+	}
+	if err := s.evts.MarkEventFailed(ctx, retried[0], "boom", now.Add(3*time.Minute), true); err != nil {
+		t.Fatalf("exected a nil error for mark failed got %v", err)
+	}
+	if dead := claim(now.Add(time.Hour)); len(dead) != 0 {
+		t.Errorf("expected the dead event left alone got %+v", dead)
+	}
+	if n, err := s.evts.PurgeEvents(ctx, time.Now().Add(time.Hour)); err != nil || n < int64(len(want)+1) {
+		t.Errorf("expected the relayed and dead events purged got %d %v", n, err)
+	}
+}
+
+// SetWebhookRepo sets the webhook storage, for the TestWebhooks. The staff
+// organization of the signups needs the SetOrgRepo, its project the SetProjectRepo,
+// and the events the SetEventRepo.
+func (s *TodoSuiteBase) SetWebhookRepo(hooks pkg.WebhookStorage) {
+	s.hooks = hooks
+}
+
+// TestWebhooks verifies the events dispatched once to the subscribed webhooks only,
+// the ones of an organization never to the personal webhooks,
+// a delivery claimed once until its lease is over, the stale marks ignored, and a
+// webhook disabled by its failures in a row.
+func (s *TodoSuiteBase) TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	ownerID, userID := s.storeUser(t), s.storeUser(t)
+	staff := pkg.OrgModel{ID: uuid.New(), Name: "staff"}
+	if err := s.orgs.InsertOne(ctx, staff, ownerID); err != nil {
+		t.Fatalf("exected a nil error for insert org got %v", err)
+	}
+	personal := pkg.WebhookModel{ID: uuid.New(), UserID: userID, URL: "https://example.com/hook",
+		Events: []string{pkg.EventTodoCreated, pkg.EventTodoCompleted}, Secret: "whsec_1", CreatedAt: time.Now()}
+	signups := pkg.WebhookModel{ID: uuid.New(), UserID: ownerID, OrgID: staff.ID, URL: "https://example.com/signups",
+		Events: []string{pkg.EventUserSignedUp}, Secret: "whsec_2", CreatedAt: time.Now()}
+	for _, webhook := range []pkg.WebhookModel{personal, signups} {
+		if err := s.hooks.InsertOne(ctx, webhook); err != nil {
+			t.Fatalf("exected a nil error for insert webhook got %v", err)
+		}
+	}
+	found, err := s.hooks.FindOneWebhook(ctx, signups.ID)
+	if err != nil || found.OrgID != staff.ID || found.URL != signups.URL || len(found.Events) != 1 {
+		t.Errorf("expected the webhook [%+v] got %+v %v", signups, found, err)
+	}
+	if _, err = s.hooks.FindOneWebhook(ctx, uuid.New()); !errors.Is(err, serror.ErrWebhookNotFound) {
+		t.Errorf("expected webhook not found got %v", err)
+	}
+	if list, _ := s.hooks.FindWebhooksOfUser(ctx, ownerID); len(list) != 0 {
+		t.Errorf("expected the org webhook kept apart from the personal ones got %+v", list)
+	}
+	if list, _ := s.hooks.FindWebhooksOfOrg(ctx, staff.ID); len(list) != 1 || list[0].ID != signups.ID {
+		t.Errorf("expected the webhook of the org got %+v", list)
+	}
+
+	s.storeUser(t)
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "pay rent"}
+	if _, err = s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	todo.Finished = true
+	if _, err = s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	// the todos the user creates in an organization aren't told to its personal webhooks
+	project := pkg.ProjectModel{ID: uuid.New(), UserID: userID, OrgID: staff.ID, Name: "roadmap"}
+	if _, err = s.projs.InsertOne(ctx, project); err != nil {
+		t.Fatalf("exected a nil error for insert project got %v", err)
+	}
+	if _, err = s.r.InsertOne(ctx, pkg.TodoModel{ID: uuid.New(), UserID: userID, ProjectID: project.ID, Title: "plan"}); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	s.relay(t, time.Now(), func(e pkg.Event) {
+		// once
+		for i := 0; i < 2; i++ {
+			if err := s.hooks.DispatchEvent(ctx, e, staff.ID); err != nil {
+				t.Fatalf("exected a nil error for dispatch got %v", err)
+			}
+		}
+	})
+	deliveries, err := s.hooks.FindDeliveries(ctx, personal.ID, 10)
+	if err != nil || len(deliveries) != 2 || deliveries[0].EventType != pkg.EventTodoCompleted ||
+		deliveries[1].EventType != pkg.EventTodoCreated || deliveries[0].Status != pkg.DeliveryPending {
+		t.Fatalf("expected only the personal todo events dispatched to the personal webhook got %+v %v", deliveries, err)
+	}
+	if deliveries, _ = s.hooks.FindDeliveries(ctx, signups.ID, 10); len(deliveries) == 0 ||
+		deliveries[0].EventType != pkg.EventUserSignedUp {
+		t.Errorf("expected the signups dispatched to the staff webhook got %+v", deliveries)
+	}
+
+	now := time.Now()
+	claim := func(at time.Time) []pkg.WebhookDelivery {
+		list, err := s.hooks.ClaimDeliveries(ctx, at, time.Minute, 100)
+		if err != nil {
+			t.Fatalf("exected a nil error for claim got %v", err)
+		}
+		var mine []pkg.WebhookDelivery
+		for _, d := range list {
+			if d.WebhookID == personal.ID {
+				mine = append(mine, d)
+			}
+		}
+		return mine
+	}
+	claimed := claim(now)
+	if len(claimed) != 2 || claimed[0].Attempt != 1 || claimed[0].Secret != personal.Secret || len(claimed[0].Data) == 0 {
+		t.Fatalf("expected the deliveries of the personal webhook claimed got %+v", claimed)
+	}
+	// leased
+	if again := claim(now); len(again) != 0 {
+		t.Errorf("expected no delivery claimed during the lease got %+v", again)
+	}
+	created, completed := claimed[0], claimed[1]
+	if created.EventType != pkg.EventTodoCreated {
+		created, completed = completed, created
+	}
+	if err = s.hooks.MarkDelivered(ctx, created, 204); err != nil {
+		t.Fatalf("exected a nil error for mark delivered got %v", err)
+	}
+	if disabled, err := s.hooks.MarkFailed(ctx, completed, 500, "boom", now, false, 2); err != nil || disabled {
+		t.Fatalf("exected the webhook left enabled got %v %v", disabled, err)
+	}
+	claimed = claim(now.Add(2 * time.Minute))
+	if len(claimed) != 1 || claimed[0].ID != completed.ID || claimed[0].Attempt != 2 {
+		t.Fatalf("expected the failed delivery retried got %+v", claimed)
+	}
+	// the mark of the first attempt is stale
+	if err = s.hooks.MarkDelivered(ctx, completed, 204); err != nil {
+		t.Fatalf("exected a nil error for mark delivered got %v", err)
+	}
+	if disabled, err := s.hooks.MarkFailed(ctx, claimed[0], 500, "boom", now, false, 2); err != nil || !disabled {
+		t.Fatalf("exected the webhook disabled got %v %v", disabled, err)
+	}
+	deliveries, _ = s.hooks.FindDeliveries(ctx, personal.ID, 10)
+	if deliveries[0].Status != pkg.DeliveryPending || deliveries[0].Attempts != 2 || deliveries[0].ResponseCode != 500 ||
+		deliveries[1].Status != pkg.DeliveryDelivered || deliveries[1].DeliveredAt.IsZero() {
+		t.Errorf("expected a delivery retried and one delivered got %+v", deliveries)
+	}
+	if found, _ = s.hooks.FindOneWebhook(ctx, personal.ID); !found.Disabled || found.Failures != 2 {
+		t.Errorf("expected the webhook disabled after 2 failures got %+v", found)
+	}
+	if claimed = claim(now.Add(time.Hour)); len(claimed) != 0 {
+		t.Errorf("expected no delivery claimed for a disabled webhook got %+v", claimed)
+	}
+
+	found.Disabled, found.Failures = false, 0
+	if err = s.hooks.UpdateOne(ctx, found); err != nil {
+		t.Fatalf("exected a nil error for update webhook got %v", err)
+	}
+	if claimed = claim(now.Add(time.Hour)); len(claimed) != 1 {
+		t.Errorf("expected the delivery resumed once enabled got %+v", claimed)
+	}
+	if n, err := s.hooks.PurgeDeliveries(ctx, time.Now().Add(time.Hour)); err != nil || n == 0 {
+		t.Errorf("expected the delivered delivery purged got %d %v", n, err)
+	}
+	if err = s.hooks.DeleteOne(ctx, personal.ID); err != nil {
+		t.Fatalf("exected a nil error for delete webhook got %v", err)
+	}
+	if err = s.hooks.DeleteOne(ctx, personal.ID); !errors.Is(err, serror.ErrWebhookNotFound) {
+		t.Errorf("expected webhook not found got %v", err)
+	}
+}
+
+// SetEventLog sets the log of the events, for the TestEventLog.
+func (s *TodoSuiteBase) SetEventLog(elog pkg.EventLog) {
+	s.elog = elog
+}
+
+// TestEventLog verifies the events of the types read in order after the last
+// one, a listener told they're logged, and a missing event reported.
+func (s *TodoSuiteBase) TestEventLog(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	last, err := s.elog.LastEventID(ctx)
+	if err != nil {
+		t.Fatalf("exected a nil error for last event id got %v", err)
+	}
+
+	listenCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	notified := make(chan struct{}, 10)
+	listened := make(chan error, 1)
+	go func() {
+		listened <- s.elog.ListenEvents(listenCtx, func() { notified <- struct{}{} })
+	}()
+	// once listening
+	<-notified
+
+	todo := pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: "water the plants"}
+	if _, err = s.r.InsertOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for insert got %v", err)
+	}
+	todo.Finished = true
+	if _, err = s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	select {
+	case <-notified:
+	case <-listenCtx.Done():
+		t.Errorf("expected the listener notified got %v", listenCtx.Err())
+	}
+	cancel()
+	if err = <-listened; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the listening canceled got %v", err)
+	}
+
+	types := []string{pkg.EventTodoCreated, pkg.EventTodoCompleted}
+	var mine []pkg.Event
+	for after := last; ; {
+		events, err := s.elog.EventsAfter(ctx, after, types, 100)
+		if err != nil {
+			t.Fatalf("exected a nil error for events after got %v", err)
+		}
+		for _, e := range events {
+			if e.SubjectID == todo.ID {
+				mine = append(mine, e)
+			}
+		}
+		if len(events) < 100 {
+			break
+		}
+		after = events[len(events)-1].ID
+	}
+	if len(mine) != 2 || mine[0].Type != pkg.EventTodoCreated || mine[1].Type != pkg.EventTodoCompleted {
+		t.Fatalf("expected the events %v of the todo got %+v", types, mine)
+	}
+	events, err := s.elog.EventsAfter(ctx, mine[0].ID, types[1:], 100)
+	if err != nil || len(events) == 0 {
+		t.Fatalf("exected the events after the created one got %v %v", events, err)
+	}
+	for _, e := range events {
+		if e.Type != pkg.EventTodoCompleted {
+			t.Errorf("expected only the events of the types got %+v", e)
+		}
+	}
+	if _, err = s.elog.EventsAfter(ctx, -1, types, 100); !errors.Is(err, serror.ErrEventNotFound) {
+		t.Errorf("expected event not found got %v", err)
+	}
+}
+
+// SetSyncRepo sets the storage of the todos synced from scratch, for the TestSync.
+// The TestSync needs the SetEventLog and the SetGrantRepo too.
+func (s *TodoSuiteBase) SetSyncRepo(sync pkg.SyncStorage) {
+	s.sync = sync
+}
+
+// TestSync verifies all the todos of the user out of the trash, and the ones shared with it,
+// are read at once, a grant removed is logged, and the events carry every field synced.
+func (s *TodoSuiteBase) TestSync(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	var last pkg.TodoModel
+	// more than the latest todos listed
+	for i := 0; i < 60; i++ {
+		last = pkg.TodoModel{ID: uuid.New(), UserID: userID, Title: fmt.Sprintf("todo %d", i), Version: 1}
+		if _, err := s.r.InsertOne(ctx, last); err != nil {
+			t.Fatalf("exected a nil error for insert got %v", err)
+		}
+	}
+	if err := s.r.DeleteOne(ctx, last.ID, 0); err != nil {
+		t.Fatalf("exected a nil error for delete got %v", err)
+	}
+	todos, err := s.sync.FindSyncedTodos(ctx, userID)
+	if err != nil {
+		t.Fatalf("exected a nil error for synced todos got %v", err)
+	}
+	if len(todos) != 59 || todos[0].Title != "todo 0" {
+		t.Errorf("expected all the todos out of the trash, oldest first got %d", len(todos))
+	}
+
+	ownerID := s.storeUser(t)
+	shared := pkg.TodoModel{ID: uuid.New(), UserID: ownerID, Title: "shared", Version: 1}
+	private := pkg.TodoModel{ID: uuid.New(), UserID: ownerID, Title: "private", Version: 1}
+	for _, todo := range []pkg.TodoModel{shared, private} {
+		if _, err = s.r.InsertOne(ctx, todo); err != nil {
+			t.Fatalf("exected a nil error for insert got %v", err)
+		}
+	}
+	err = s.grnts.UpsertOne(ctx, pkg.GrantModel{Resource: pkg.Resource{Type: pkg.ResourceTodo, ID: shared.ID},
+		UserID: userID, Role: pkg.RoleViewer, GrantedBy: ownerID})
+	if err != nil {
+		t.Fatalf("exected a nil error for upsert grant got %v", err)
+	}
+	if todos, err = s.sync.FindSyncedTodos(ctx, userID); err != nil || len(todos) != 60 || todos[59].ID != shared.ID {
+		t.Errorf("expected the todo shared with the user synced too got %d %v", len(todos), err)
+	}
+	unshared, err := s.elog.LastEventID(ctx)
+	if err != nil {
+		t.Fatalf("exected a nil error for last event id got %v", err)
+	}
+	if err = s.grnts.DeleteOne(ctx, pkg.Resource{Type: pkg.ResourceTodo, ID: shared.ID}, userID); err != nil {
+		t.Fatalf("exected a nil error for delete grant got %v", err)
+	}
+	revoked, err := s.elog.EventsAfter(ctx, unshared, []string{pkg.EventAccessRevoked}, 10)
+	if err != nil || len(revoked) != 1 || revoked[0].UserID != userID || revoked[0].SubjectID != shared.ID {
+		t.Errorf("expected the access revoked from the user got %+v %v", revoked, err)
+	}
+
+	after, err := s.elog.LastEventID(ctx)
+	if err != nil {
+		t.Fatalf("exected a nil error for last event id got %v", err)
+	}
+	todo := todos[0]
+	todo.AutoFinish = true
+	if _, err = s.r.UpdateOne(ctx, todo); err != nil {
+		t.Fatalf("exected a nil error for update got %v", err)
+	}
+	events, err := s.elog.EventsAfter(ctx, after, []string{pkg.EventTodoUpdated}, 100)
+	if err != nil {
+		t.Fatalf("exected a nil error for events after got %v", err)
+	}
+	for _, e := range events {
+		if e.SubjectID != todo.ID {
+			continue
+		}
+		var data struct {
+			AutoFinish bool `json:"auto_finish"`
+		}
+		if err = json.Unmarshal(e.Data, &data); err != nil || !data.AutoFinish {
+			t.Errorf("expected the auto finish in the event got %s %v", e.Data, err)
+		}
+		return
+	}
+	t.Errorf("expected the todo updated by its auto finish got %+v", events)
+}
+
+// SetSearchRepo sets the full text search of the todos, for the TestSearch.
+func (s *TodoSuiteBase) SetSearchRepo(srch pkg.TodoSearcher) {
+	s.srch = srch
+}
+
+// TestSearch verifies the todos are searched by the words starting with all the terms,
+// the ones matching in their title first, combined with the filter.
+func (s *TodoSuiteBase) TestSearch(t *testing.T) {
+	ctx := context.Background()
+	userID := s.storeUser(t)
+	todos := []pkg.TodoModel{
+		{ID: uuid.New(), UserID: userID, Title: "Weekend", Content: "Buy groceries, then water the plants", Version: 1},
+		{ID: uuid.New(), UserID: userID, Title: "Groceries for the weekend", Finished: true, Version: 1},
+		{ID: uuid.New(), UserID: userID, Title: "Groceries, deleted", Version: 1},
+		{ID: uuid.New(), UserID: s.storeUser(t), Title: "Groceries of another user", Version: 1},
+	}
+	for _, todo := range todos {
+		if _, err := s.r.InsertOne(ctx, todo); err != nil {
+			t.Fatalf("exected a nil error for insert got %v", err)
+		}
+	}
+	if err := s.r.DeleteOne(ctx, todos[2].ID, 0); err != nil {
+		t.Fatalf("exected a nil error for delete got %v", err)
+	}
+
+	hits, err := s.srch.SearchTodosOfUser(ctx, userID, []string{"grocer", "week"}, pkg.NilFilter, 10)
+	if err != nil {
+		t.Fatalf("exected a nil error for search got %v", err)
+	}
+	if len(hits) != 2 || hits[0].Todo.ID != todos[1].ID || hits[1].Todo.ID != todos[0].ID {
+		t.Fatalf("expected the todos matching in their title first got %+v", hits)
+	}
+	if !strings.Contains(hits[0].Snippet, pkg.MatchStart+"Groceries"+pkg.MatchStop) {
+		t.Errorf("expected the matches marked in the snippet got %q", hits[0].Snippet)
+	}
+
+	hits, err = s.srch.SearchTodosOfUser(ctx, userID, []string{"grocer"}, pkg.UnFinished, 10)
+	if err != nil || len(hits) != 1 || hits[0].Todo.ID != todos[0].ID {
+		t.Errorf("expected only the unfinished todo got %+v %v", hits, err)
+	}
+	hits, err = s.srch.SearchTodosOfUser(ctx, userID, []string{"grocer", "plant"}, pkg.NilFilter, 1)
+	if err != nil || len(hits) != 1 {
+		t.Errorf("expected only the todo matching all the terms got %+v %v", hits, err)
+	}
+}
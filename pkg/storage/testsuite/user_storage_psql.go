@@ -32,7 +32,7 @@ func (s *UserSuiteBase) TestFindAndStore(t *testing.T) {
 		t.Errorf("expected error for random uuid user find")
 	}
 
-	if err != nil && errors.Is(err, serror.ErrUserNotFound) {
+	if err != nil && !errors.Is(err, serror.ErrUserNotFound) {
 		t.Errorf("expected error type value [`no user found`] got `%s`", err.Error())
 	}
 	id := uuid.New()
@@ -71,7 +71,7 @@ func (s *UserSuiteBase) TestFindByEmailAndStore(t *testing.T) {
 	if err == nil {
 		t.Errorf("expected error for unknown email find")
 	}
-	if err != nil && errors.Is(err, serror.ErrUserNotFound) {
+	if err != nil && !errors.Is(err, serror.ErrUserNotFound) {
 		t.Errorf("expected error type value [`no user found`] got `%s`", err.Error())
 	}
 	id := uuid.New()
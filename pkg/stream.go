@@ -0,0 +1,305 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// ErrEventGone indicates the event to resume after is no longer in the log,
+// the subscriber missed the events logged since.
+var ErrEventGone = errors.New("event no longer in the log")
+
+// todoEventTypes are the types of the events of the todo stream.
+var todoEventTypes = []string{EventTodoCreated, EventTodoUpdated, EventTodoCompleted, EventTodoDeleted, EventTodoRestored}
+
+// Defaults of the todo stream.
+const (
+	streamBatch = 100
+	// streamBuffer is how many of the last events read are kept in memory,
+	// the subscribers further behind read them from the log.
+	streamBuffer = 1024
+)
+
+// EventLog define a contract for storage, to read its outbox as a log of
+// the events in the order they were committed.
+type EventLog interface {
+	// LastEventID returns the id of the last event of the log, zero if it's empty.
+	LastEventID(ctx context.Context) (int64, error)
+	// EventsAfter returns at most n events of the types, logged after the event of the
+	// afterID, from the start of the log if zero. serror.ErrEventNotFound is returned
+	// if the event is no longer in the log.
+	EventsAfter(ctx context.Context, afterID int64, types []string, n int) ([]Event, error)
+	// ListenEvents calls notify once listening and then every time events are logged,
+	// until the ctx is done or the listening fails.
+	ListenEvents(ctx context.Context, notify func()) error
+}
+
+// TodoStream fans out the todo events of the log to the users they're visible to, as the
+// Authorizer decides. Every replica runs its own stream, reading the shared log every time
+// the storage tells events were logged, so each gets all of them whichever wrote them.
+// The last streamBuffer events read are kept to resume the subscribers.
+type TodoStream struct {
+	log  EventLog
+	auth Authorizer
+
+	// reading is held by a read, the events are read by one at a time.
+	reading sync.Mutex
+	mu      sync.Mutex
+	// ready is false until the head is read from the log.
+	ready bool
+	// head is the id of the event logged right before the first of the events.
+	head   int64
+	events []Event
+	// wake is closed, and replaced, every time events are read.
+	wake chan struct{}
+}
+
+// NewTodoStream returns a TodoStream of the events of the log, it needs to Run to read them.
+func NewTodoStream(log EventLog, auth Authorizer) *TodoStream {
+	return &TodoStream{
+		log:  log,
+		auth: auth,
+		wake: make(chan struct{}),
+	}
+}
+
+// Run reads the log every time events are logged until the ctx is done, and listens
+// again after the retry if the listening fails. The log is read every retry too, the
+// events of a missed notification, or not visible yet once notified, aren't held back.
+// The failures are reported to the onErr, if not nil.
+func (ts *TodoStream) Run(ctx context.Context, retry time.Duration, onErr func(err error)) {
+	report := func(err error) {
+		if err != nil && onErr != nil && ctx.Err() == nil {
+			onErr(err)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(retry)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report(ts.read(ctx))
+			}
+		}
+	}()
+	for {
+		// the events logged while it wasn't listening are read once it listens again.
+		report(ts.log.ListenEvents(ctx, func() {
+			report(ts.read(ctx))
+		}))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// read reads the events logged after the last one read, a batch after the other
+// until none is left, and wakes up the subscribers.
+func (ts *TodoStream) read(ctx context.Context) error {
+	ts.reading.Lock()
+	defer ts.reading.Unlock()
+	return ts.readAfterLast(ctx)
+}
+
+// readAfterLast is the read, ts.reading must be held.
+func (ts *TodoStream) readAfterLast(ctx context.Context) error {
+	ts.mu.Lock()
+	ready, after := ts.ready, ts.last()
+	ts.mu.Unlock()
+	if !ready {
+		// the subscribers only get the events logged from now on.
+		var err error
+		if after, err = ts.log.LastEventID(ctx); err != nil {
+			return err
+		}
+		ts.reset(after)
+	}
+
+	for {
+		events, err := ts.log.EventsAfter(ctx, after, todoEventTypes, streamBatch)
+		if errors.Is(err, serror.ErrEventNotFound) {
+			// the last event read was purged without any logged since, start over.
+			ts.mu.Lock()
+			ts.ready = false
+			ts.mu.Unlock()
+			return ts.readAfterLast(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		ts.append(events)
+		if len(events) < streamBatch {
+			return nil
+		}
+		after = events[len(events)-1].ID
+	}
+}
+
+// reset empties the buffer of the events, the next read ones being logged after the head.
+func (ts *TodoStream) reset(head int64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.ready = true
+	ts.head = head
+	ts.events = nil
+}
+
+// append adds the events to the buffer, drops the oldest beyond the streamBuffer
+// and wakes up the subscribers.
+func (ts *TodoStream) append(events []Event) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.events = append(ts.events, events...)
+	if drop := len(ts.events) - streamBuffer; drop > 0 {
+		ts.head = ts.events[drop-1].ID
+		ts.events = ts.events[drop:]
+	}
+	close(ts.wake)
+	ts.wake = make(chan struct{})
+}
+
+// last returns the id of the last event read, ts.mu must be held.
+func (ts *TodoStream) last() int64 {
+	if len(ts.events) == 0 {
+		return ts.head
+	}
+	return ts.events[len(ts.events)-1].ID
+}
+
+// buffered returns the events of the buffer read after the event of the afterID,
+// ok is false if it's not in the buffer. ts.mu must be held.
+func (ts *TodoStream) buffered(afterID int64) (events []Event, ok bool) {
+	if !ts.ready {
+		return nil, false
+	}
+	if afterID == ts.head {
+		return ts.events[:len(ts.events):len(ts.events)], true
+	}
+	// the ids aren't in order of the log, the most recent are looked up first.
+	for i := len(ts.events) - 1; i >= 0; i-- {
+		if ts.events[i].ID == afterID {
+			return ts.events[i+1 : len(ts.events) : len(ts.events)], true
+		}
+	}
+	return nil, false
+}
+
+// since returns the events logged after the event of the afterID, the ones of the buffer
+// if it's there or a batch of the log otherwise, and the channel closed once more are read.
+func (ts *TodoStream) since(ctx context.Context, afterID int64) ([]Event, <-chan struct{}, error) {
+	ts.mu.Lock()
+	wake := ts.wake
+	events, ok := ts.buffered(afterID)
+	ts.mu.Unlock()
+	if ok {
+		return events, wake, nil
+	}
+	events, err := ts.log.EventsAfter(ctx, afterID, todoEventTypes, streamBatch)
+	if errors.Is(err, serror.ErrEventNotFound) {
+		return nil, nil, ErrEventGone
+	}
+	return events, wake, err
+}
+
+// Subscribe returns the subscription of the user to the events logged after the
+// event of the lastEventID, or to the ones logged from now on if it's zero.
+func (ts *TodoStream) Subscribe(ctx context.Context, userID uuid.UUID, lastEventID int64) (*TodoSubscription, error) {
+	if lastEventID == 0 {
+		ts.mu.Lock()
+		ready, last := ts.ready, ts.last()
+		ts.mu.Unlock()
+		if !ready {
+			var err error
+			if last, err = ts.log.LastEventID(ctx); err != nil {
+				return nil, err
+			}
+		}
+		lastEventID = last
+	}
+	return &TodoSubscription{stream: ts, userID: userID, after: lastEventID}, nil
+}
+
+// TodoSubscription is the subscription of a user to a TodoStream,
+// it's not safe for concurrent use.
+type TodoSubscription struct {
+	stream *TodoStream
+	userID uuid.UUID
+	// after is the id of the last event handed to the subscriber, or skipped.
+	after int64
+}
+
+// LastEventID returns the id the subscription resumes after.
+func (sub *TodoSubscription) LastEventID() int64 {
+	return sub.after
+}
+
+// Next returns the next events visible to the user, in the order they were logged,
+// and waits for them until the ctx is done. ErrEventGone is returned if the
+// subscription fell behind the log.
+func (sub *TodoSubscription) Next(ctx context.Context) ([]Event, error) {
+	for {
+		events, wake, err := sub.stream.since(ctx, sub.after)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			visible, err := sub.visible(ctx, events)
+			if err != nil {
+				return nil, err
+			}
+			sub.after = events[len(events)-1].ID
+			if len(visible) > 0 {
+				return visible, nil
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// visible returns the events of the todos the user has a role on.
+func (sub *TodoSubscription) visible(ctx context.Context, events []Event) ([]Event, error) {
+	return visibleEvents(ctx, sub.stream.auth, sub.userID, events)
+}
+
+// visibleEvents returns the todo events of the todos the user has a role on, as the auth decides.
+// Even the owner is asked about, it has no role left on the todos of an organization it left.
+func visibleEvents(ctx context.Context, auth Authorizer, userID uuid.UUID, events []Event) ([]Event, error) {
+	var visible []Event
+	for _, e := range events {
+		var todo struct {
+			ID        uuid.UUID `json:"id"`
+			ProjectID uuid.UUID `json:"project_id"`
+		}
+		if err := json.Unmarshal(e.Data, &todo); err != nil {
+			return nil, err
+		}
+		role, err := auth.RoleOnTodo(ctx, userID, TodoModel{ID: todo.ID, UserID: e.UserID, ProjectID: todo.ProjectID})
+		if err != nil {
+			return nil, err
+		}
+		if role >= RoleViewer {
+			visible = append(visible, e)
+		}
+	}
+	return visible, nil
+}
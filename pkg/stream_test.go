@@ -0,0 +1,195 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyEventLog is a log of the events in memory, the ones before
+// the start are purged.
+type dummyEventLog struct {
+	mu     sync.Mutex
+	start  int
+	events []Event
+	notify chan struct{}
+}
+
+func (d *dummyEventLog) log(e Event) {
+	d.mu.Lock()
+	e.ID = int64(len(d.events) + 1)
+	d.events = append(d.events, e)
+	d.mu.Unlock()
+	d.notify <- struct{}{}
+}
+
+func (d *dummyEventLog) LastEventID(ctx context.Context) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(len(d.events)), nil
+}
+
+func (d *dummyEventLog) EventsAfter(ctx context.Context, afterID int64, types []string, n int) ([]Event, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if afterID < int64(d.start) {
+		return nil, serror.ErrEventNotFound
+	}
+	var events []Event
+	for _, e := range d.events[afterID:] {
+		for _, typ := range types {
+			if e.Type == typ && len(events) < n {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+func (d *dummyEventLog) ListenEvents(ctx context.Context, notify func()) error {
+	notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.notify:
+			notify()
+		}
+	}
+}
+
+func todoEvent(typ string, todo TodoModel) Event {
+	data, _ := json.Marshal(map[string]interface{}{"id": todo.ID, "user_id": todo.UserID, "project_id": todo.ProjectID})
+	return Event{Type: typ, UserID: todo.UserID, SubjectID: todo.ID, Data: data}
+}
+
+func TestTodoStream(t *testing.T) {
+	t.Parallel()
+	grants := &dummyGrantRepo{roles: make(map[uuid.UUID]map[uuid.UUID]Role)}
+	ownerID, viewerID, strangerID := uuid.New(), uuid.New(), uuid.New()
+	shared := TodoModel{ID: uuid.New(), UserID: ownerID}
+	_ = grants.UpsertOne(context.Background(), GrantModel{Resource: Resource{Type: ResourceTodo, ID: shared.ID},
+		UserID: viewerID, Role: RoleViewer, GrantedBy: ownerID})
+	private := TodoModel{ID: uuid.New(), UserID: ownerID}
+
+	elog := &dummyEventLog{notify: make(chan struct{}, 10)}
+	elog.log(todoEvent(EventTodoCreated, private))
+	stream := NewTodoStream(elog, NewAuthorizer(grants))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.Run(ctx, time.Millisecond, func(err error) {
+		t.Errorf("expected a nil error for run got %v", err)
+	})
+
+	owner, err := stream.Subscribe(ctx, ownerID, 0)
+	if err != nil {
+		t.Fatalf("expected a nil error for subscribe got %v", err)
+	}
+	viewer, _ := stream.Subscribe(ctx, viewerID, 0)
+	stranger, _ := stream.Subscribe(ctx, strangerID, 0)
+	elog.log(todoEvent(EventTodoCreated, shared))
+	elog.log(Event{Type: EventUserSignedUp, UserID: viewerID, SubjectID: viewerID})
+	elog.log(todoEvent(EventTodoUpdated, private))
+	elog.log(todoEvent(EventTodoCompleted, shared))
+
+	next := func(sub *TodoSubscription, want int) []Event {
+		t.Helper()
+		var events []Event
+		for len(events) < want {
+			waitCtx, cancelWait := context.WithTimeout(ctx, time.Second)
+			more, err := sub.Next(waitCtx)
+			cancelWait()
+			if err != nil {
+				t.Fatalf("expected %d events got %v %v", want, events, err)
+			}
+			events = append(events, more...)
+		}
+		return events
+	}
+	if events := next(owner, 3); events[0].ID != 2 || events[1].ID != 4 || events[2].ID != 5 {
+		t.Errorf("expected all the todo events of the owner got %+v", events)
+	}
+	if events := next(viewer, 2); events[0].ID != 2 || events[1].ID != 5 {
+		t.Errorf("expected the events of the shared todo got %+v", events)
+	}
+	waitCtx, cancelWait := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelWait()
+	if events, err := stranger.Next(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected no event for the stranger got %+v %v", events, err)
+	}
+	if stranger.LastEventID() != 5 {
+		t.Errorf("expected the stranger to have skipped the events got %d", stranger.LastEventID())
+	}
+
+	// a subscription resumes after the last event it got, even the one of another replica.
+	resumed, _ := stream.Subscribe(ctx, ownerID, 2)
+	if events := next(resumed, 2); events[0].ID != 4 || events[1].ID != 5 {
+		t.Errorf("expected the events after the resumed one got %+v", events)
+	}
+	elog.mu.Lock()
+	elog.start = 3
+	elog.mu.Unlock()
+	gone, _ := NewTodoStream(elog, NewAuthorizer(grants)).Subscribe(ctx, ownerID, 1)
+	if _, err = gone.Next(ctx); !errors.Is(err, ErrEventGone) {
+		t.Errorf("expected event gone got %v", err)
+	}
+}
+
+func TestTodoStream_MissedNotification(t *testing.T) {
+	t.Parallel()
+	ownerID := uuid.New()
+	todo := TodoModel{ID: uuid.New(), UserID: ownerID}
+	// nobody receives the notifications
+	elog := &dummyEventLog{notify: make(chan struct{}, 10)}
+	stream := NewTodoStream(elog, NewAuthorizer(nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.Run(ctx, 10*time.Millisecond, nil)
+
+	sub, err := stream.Subscribe(ctx, ownerID, 0)
+	if err != nil {
+		t.Fatalf("expected a nil error for subscribe got %v", err)
+	}
+	elog.mu.Lock()
+	elog.events = append(elog.events, todoEvent(EventTodoCreated, todo))
+	elog.events[0].ID = 1
+	elog.mu.Unlock()
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, time.Second)
+	defer cancelWait()
+	if events, err := sub.Next(waitCtx); err != nil || len(events) != 1 || events[0].ID != 1 {
+		t.Errorf("expected the event read without notification got %+v %v", events, err)
+	}
+}
+
+func TestVisibleEvents_OrgLeft(t *testing.T) {
+	t.Parallel()
+	orgs := newDummyOrgRepo()
+	orgID, userID := uuid.New(), uuid.New()
+	orgs.members[orgID] = map[uuid.UUID]OrgRole{userID: OrgRoleMember}
+	projectID := uuid.New()
+	orgs.projectOrgs[projectID] = orgID
+	auth := NewOrgAuthorizer(nil, orgs)
+	events := []Event{
+		todoEvent(EventTodoCreated, TodoModel{ID: uuid.New(), UserID: userID}),
+		todoEvent(EventTodoCreated, TodoModel{ID: uuid.New(), UserID: userID, ProjectID: projectID}),
+	}
+
+	if visible, err := visibleEvents(context.Background(), auth, userID, events); err != nil || len(visible) != 2 {
+		t.Errorf("expected the events of the member got %+v %v", visible, err)
+	}
+	delete(orgs.members[orgID], userID)
+	if visible, err := visibleEvents(context.Background(), auth, userID, events); err != nil || len(visible) != 1 ||
+		visible[0].SubjectID != events[0].SubjectID {
+		t.Errorf("expected only the personal event once the org is left got %+v %v", visible, err)
+	}
+}
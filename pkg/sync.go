@@ -0,0 +1,541 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// ErrInvalidSyncToken indicates the sync token is not one returned by a sync.
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// errAccessRevoked indicates the user lost access to todos since the token, the
+// client may hold some it can no longer see, so it syncs from scratch.
+var errAccessRevoked = errors.New("access revoked since the token")
+
+// syncEventTypes are the types of the events a sync reads.
+var syncEventTypes = []string{EventTodoCreated, EventTodoUpdated, EventTodoCompleted, EventTodoDeleted,
+	EventTodoRestored, EventAccessRevoked}
+
+// Defaults of the sync.
+const (
+	// syncBatch is the most events read by a sync, the client syncs again for the rest.
+	syncBatch = 500
+	// syncAttempts is how many times a change is resolved against a todo changed meanwhile.
+	syncAttempts = 3
+)
+
+// the fields of a todo a client syncs, by their json name.
+const (
+	fieldTitle      = "title"
+	fieldContent    = "content"
+	fieldFinished   = "finished"
+	fieldAutoFinish = "auto_finish"
+	fieldProjectID  = "project_id"
+	fieldDueAt      = "due_at"
+)
+
+var syncedFields = []string{fieldTitle, fieldContent, fieldFinished, fieldAutoFinish, fieldProjectID, fieldDueAt}
+
+// TodoChangeOp tells what kind of change a TodoChange is
+type TodoChangeOp int8
+
+const (
+	// TodoChangeUpsert creates the todo if its base version is zero, and updates it otherwise
+	TodoChangeUpsert TodoChangeOp = iota + 1
+	// TodoChangeDelete moves the todo to the trash
+	TodoChangeDelete
+)
+
+// TodoFields are some of the fields of a todo, the nil ones are left out.
+type TodoFields struct {
+	Title      *string
+	Content    *string
+	Finished   *bool
+	AutoFinish *bool
+	ProjectID  *uuid.UUID
+	// DueAt is zero for the todo to not be due.
+	DueAt *time.Time
+}
+
+// values returns the values of the fields set, by name.
+func (f TodoFields) values() map[string]interface{} {
+	values := make(map[string]interface{})
+	if f.Title != nil {
+		values[fieldTitle] = *f.Title
+	}
+	if f.Content != nil {
+		values[fieldContent] = *f.Content
+	}
+	if f.Finished != nil {
+		values[fieldFinished] = *f.Finished
+	}
+	if f.AutoFinish != nil {
+		values[fieldAutoFinish] = *f.AutoFinish
+	}
+	if f.ProjectID != nil {
+		values[fieldProjectID] = *f.ProjectID
+	}
+	if f.DueAt != nil {
+		values[fieldDueAt] = *f.DueAt
+	}
+	return values
+}
+
+// todoValues returns the values of the synced fields of the todo, by name.
+func todoValues(todo TodoModel) map[string]interface{} {
+	return map[string]interface{}{
+		fieldTitle:      todo.Title,
+		fieldContent:    todo.Content,
+		fieldFinished:   todo.Finished,
+		fieldAutoFinish: todo.AutoFinish,
+		fieldProjectID:  todo.ProjectID,
+		fieldDueAt:      todo.DueAt,
+	}
+}
+
+// setTodoValue sets the field of the todo by name to the value.
+func setTodoValue(todo *TodoModel, name string, v interface{}) {
+	switch name {
+	case fieldTitle:
+		todo.Title = v.(string)
+	case fieldContent:
+		todo.Content = v.(string)
+	case fieldFinished:
+		todo.Finished = v.(bool)
+	case fieldAutoFinish:
+		todo.AutoFinish = v.(bool)
+	case fieldProjectID:
+		todo.ProjectID = v.(uuid.UUID)
+	case fieldDueAt:
+		todo.DueAt = v.(time.Time)
+	}
+}
+
+// equalValues tells if the values of a field are the same, the times being the same instant.
+func equalValues(a, b interface{}) bool {
+	if t, ok := a.(time.Time); ok {
+		u, ok := b.(time.Time)
+		return ok && t.Equal(u)
+	}
+	return a == b
+}
+
+// TodoChange is a change a client made to a todo while offline. Changes are only the fields
+// the client changed since the BaseVersion of the todo it synced last, and Base their values
+// at the BaseVersion, for the server to tell the ones it changed too.
+type TodoChange struct {
+	Op TodoChangeOp
+	// ID is chosen by the client for a todo it created, whose BaseVersion is zero.
+	ID          uuid.UUID
+	BaseVersion int64
+	Base        TodoFields
+	Changes     TodoFields
+}
+
+// SyncOutcome tells how a TodoChange was resolved
+type SyncOutcome int8
+
+const (
+	// SyncApplied is a change applied as is, the todo didn't change since its base version.
+	SyncApplied SyncOutcome = iota + 1
+	// SyncMerged is a change applied to a todo changed since its base version,
+	// but none of the fields it changes.
+	SyncMerged
+	// SyncConflict is a change conflicting with a change of the server, which won.
+	SyncConflict
+	// SyncRejected is a change that can't be applied, as it would be rejected on its own.
+	SyncRejected
+)
+
+// TodoChangeResult is the outcome of a TodoChange.
+type TodoChangeResult struct {
+	ID      uuid.UUID
+	Outcome SyncOutcome
+	// Todo is the todo as stored once the change is resolved, zero if it's Deleted.
+	Todo    TodoModel
+	Deleted bool
+	// Conflicts are the fields changed by the client that kept the value of the server.
+	Conflicts []string
+	// Err is why the change was rejected.
+	Err error
+}
+
+// SyncedTodo is a todo changed on the server, a tombstone if it's Deleted.
+type SyncedTodo struct {
+	Todo    TodoModel
+	Deleted bool
+}
+
+// SyncResult is the outcome of a sync.
+type SyncResult struct {
+	// Results are the outcome of the changes of the client, in the same order.
+	Results []TodoChangeResult
+	// Todos are the todos changed since the token as they were last changed, or
+	// all the todos of the user if Reset, the client then forgets the others.
+	Todos []SyncedTodo
+	Reset bool
+	// Token is the token of the next sync. If More, the client syncs again
+	// right away for the rest of the changes.
+	Token string
+	More  bool
+}
+
+// SyncStorage define a contract for storage, to read the todos of a client
+// syncing from scratch.
+type SyncStorage interface {
+	// FindSyncedTodos returns all the todos out of the trash the user may have a role on,
+	// oldest first: its own, the ones shared with it and the ones of the projects of
+	// its organizations. The Authorizer decides which of them are synced.
+	FindSyncedTodos(ctx context.Context, userID uuid.UUID) ([]TodoModel, error)
+}
+
+// SyncService provides the delta sync of the todos of the offline first clients. A client
+// sends the changes it made offline along with the token of its last sync, and gets back
+// the todos changed on the server since, read from the event log, and a new token.
+//
+// The conflicts are detected by the version of the todos, and resolved field by field:
+//
+//   - a change of a todo still at its base version is applied as is.
+//   - a change of a todo updated since is merged: a field the server left at its base value
+//     takes the value of the client, a field the server changed too keeps the value of the
+//     server and is reported as a conflict, unless both changed it to the same value.
+//   - an update of a todo deleted since is a conflict, the deletion wins.
+//   - a delete of a todo updated since is a conflict, the todo is kept with the changes
+//     the client didn't see.
+//   - a create is idempotent, the client chooses the id so a sync retried doesn't
+//     create the todo twice.
+//
+// A change rejected on its own, as invalid or forbidden, is rejected alone. The todos
+// shared with the user are synced as they change. Once a grant or a membership of the
+// user is removed, its next sync is from scratch, leaving out the todos it can no longer
+// see, and a todo moved out of a project the user sees is told deleted.
+type SyncService struct {
+	todos TodoService
+	repo  SyncStorage
+	log   EventLog
+}
+
+// NewSyncService returns a new SyncService of the todos of the todo service, the todos
+// changed are read from the event log of the storage of the repo.
+func NewSyncService(todos TodoService, repo SyncStorage, log EventLog) SyncService {
+	return SyncService{
+		todos: todos,
+		repo:  repo,
+		log:   log,
+	}
+}
+
+// Sync applies the changes of the user in order, and returns the todos changed since
+// the token, the applied changes among them. All the todos are returned if the token
+// is empty, if the events since are no longer in the log, or if the user lost access
+// to some todos since.
+func (ss SyncService) Sync(ctx context.Context, userID uuid.UUID, token string, changes []TodoChange) (SyncResult, error) {
+	var after int64
+	if token != "" {
+		var err error
+		if after, err = strconv.ParseInt(token, 10, 64); err != nil || after < 0 {
+			return SyncResult{}, ErrInvalidSyncToken
+		}
+	}
+
+	result := SyncResult{Results: make([]TodoChangeResult, len(changes))}
+	for i, c := range changes {
+		r, err := ss.apply(ctx, userID, c)
+		if err != nil {
+			return SyncResult{}, err
+		}
+		result.Results[i] = r
+	}
+
+	if token != "" {
+		err := ss.changedAfter(ctx, userID, after, &result)
+		if !errors.Is(err, ErrEventGone) && !errors.Is(err, errAccessRevoked) {
+			return result, err
+		}
+	}
+	// the todos changed from now on are in the log after the head.
+	head, err := ss.log.LastEventID(ctx)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	todos, err := ss.repo.FindSyncedTodos(ctx, userID)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	result.Reset = true
+	result.Todos = make([]SyncedTodo, 0, len(todos))
+	for _, todo := range todos {
+		role, err := ss.todos.auth.RoleOnTodo(ctx, userID, todo)
+		if err != nil {
+			return SyncResult{}, err
+		}
+		if role >= RoleViewer {
+			result.Todos = append(result.Todos, SyncedTodo{Todo: todo})
+		}
+	}
+	result.Token = strconv.FormatInt(head, 10)
+	return result, nil
+}
+
+// changedAfter sets the todos visible to the user changed after the event of the afterID
+// to the result, with the token of the last event read. ErrEventGone is returned if
+// the event is no longer in the log, errAccessRevoked if the user lost access since.
+func (ss SyncService) changedAfter(ctx context.Context, userID uuid.UUID, afterID int64, result *SyncResult) error {
+	events, err := ss.log.EventsAfter(ctx, afterID, syncEventTypes, syncBatch)
+	if errors.Is(err, serror.ErrEventNotFound) {
+		return ErrEventGone
+	}
+	if err != nil {
+		return err
+	}
+	var todoEvents []Event
+	for _, e := range events {
+		switch {
+		case e.Type != EventAccessRevoked:
+			todoEvents = append(todoEvents, e)
+		case e.UserID == userID:
+			return errAccessRevoked
+		}
+	}
+	result.Token = strconv.FormatInt(afterID, 10)
+	if len(events) > 0 {
+		result.Token = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+	result.More = len(events) == syncBatch
+
+	visible, err := visibleEvents(ctx, ss.todos.auth, userID, todoEvents)
+	if err != nil {
+		return err
+	}
+	seen := make(map[int64]bool, len(visible))
+	for _, e := range visible {
+		seen[e.ID] = true
+	}
+	// a todo changed many times is only returned as last changed.
+	index := make(map[uuid.UUID]int)
+	for _, e := range todoEvents {
+		todo, movedFrom, err := todoOfEvent(e)
+		if err != nil {
+			return err
+		}
+		synced := SyncedTodo{Todo: todo, Deleted: e.Type == EventTodoDeleted}
+		if !seen[e.ID] {
+			// moved out of a project the user sees, it's told deleted without its fields.
+			if movedFrom == uuid.Nil {
+				continue
+			}
+			role, err := ss.todos.auth.RoleOnTodo(ctx, userID, TodoModel{ID: todo.ID, UserID: todo.UserID, ProjectID: movedFrom})
+			if err != nil {
+				return err
+			}
+			if role < RoleViewer {
+				continue
+			}
+			synced = SyncedTodo{Todo: TodoModel{ID: todo.ID, Version: todo.Version}, Deleted: true}
+		}
+		if i, ok := index[todo.ID]; ok {
+			result.Todos[i] = synced
+			continue
+		}
+		index[todo.ID] = len(result.Todos)
+		result.Todos = append(result.Todos, synced)
+	}
+	return nil
+}
+
+// todoOfEvent returns the todo of the data of the todo event, once changed, and
+// the project it moved from, nil if it didn't move.
+func todoOfEvent(e Event) (TodoModel, uuid.UUID, error) {
+	var data struct {
+		ID         uuid.UUID  `json:"id"`
+		UserID     uuid.UUID  `json:"user_id"`
+		ProjectID  uuid.UUID  `json:"project_id"`
+		Title      string     `json:"title"`
+		Content    string     `json:"content"`
+		Finished   bool       `json:"finished"`
+		AutoFinish bool       `json:"auto_finish"`
+		Version    int64      `json:"version"`
+		DueAt      *time.Time `json:"due_at"`
+		DeletedAt  *time.Time `json:"deleted_at"`
+		SeriesID   *uuid.UUID `json:"series_id"`
+		MovedFrom  *uuid.UUID `json:"moved_from"`
+	}
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		return NilTodoModel, uuid.Nil, err
+	}
+	todo := TodoModel{
+		ID:         data.ID,
+		UserID:     data.UserID,
+		ProjectID:  data.ProjectID,
+		Title:      data.Title,
+		Content:    data.Content,
+		Finished:   data.Finished,
+		AutoFinish: data.AutoFinish,
+		Version:    data.Version,
+	}
+	if data.DueAt != nil {
+		todo.DueAt = *data.DueAt
+	}
+	if data.DeletedAt != nil {
+		todo.DeletedAt = *data.DeletedAt
+	}
+	if data.SeriesID != nil {
+		todo.SeriesID = *data.SeriesID
+	}
+	var movedFrom uuid.UUID
+	if data.MovedFrom != nil {
+		movedFrom = *data.MovedFrom
+	}
+	return todo, movedFrom, nil
+}
+
+// apply resolves the change of the user, and resolves it again if the todo
+// changed meanwhile. The errors of a change rejected are in its result.
+func (ss SyncService) apply(ctx context.Context, userID uuid.UUID, c TodoChange) (TodoChangeResult, error) {
+	for attempt := 1; ; attempt++ {
+		var r TodoChangeResult
+		var err error
+		switch {
+		case c.Op == TodoChangeDelete:
+			r, err = ss.delete(ctx, userID, c)
+		case c.BaseVersion == 0:
+			r, err = ss.create(ctx, userID, c)
+		default:
+			r, err = ss.update(ctx, userID, c)
+		}
+		if errors.Is(err, serror.ErrVersionConflict) && attempt < syncAttempts {
+			continue
+		}
+		if isRejection(err) {
+			return TodoChangeResult{ID: c.ID, Outcome: SyncRejected, Err: err}, nil
+		}
+		r.ID = c.ID
+		return r, err
+	}
+}
+
+// isRejection tells if the error rejects the change, rather than the sync as a whole.
+func isRejection(err error) bool {
+	return errors.Is(err, ErrInvalidTodo) || errors.Is(err, ErrForbidden) ||
+		errors.Is(err, serror.ErrProjectNotFound) || errors.Is(err, serror.ErrVersionConflict)
+}
+
+// create stores the todo created by the client, unless a sync stored it already.
+func (ss SyncService) create(ctx context.Context, userID uuid.UUID, c TodoChange) (TodoChangeResult, error) {
+	existing, err := ss.todos.Find(ctx, userID, c.ID)
+	if err == nil {
+		// the client missed the result of the sync that created it.
+		return TodoChangeResult{Outcome: SyncApplied, Todo: existing}, nil
+	}
+	if !errors.Is(err, serror.ErrTodoNotFound) {
+		return TodoChangeResult{}, err
+	}
+	_, err = ss.todos.repo.FindOneTodo(ctx, c.ID)
+	switch {
+	case err == nil:
+		// the id of a todo the user can't see.
+		return TodoChangeResult{}, ErrInvalidTodo
+	case !errors.Is(err, serror.ErrTodoNotFound):
+		return TodoChangeResult{}, err
+	}
+	_, err = ss.todos.repo.FindDeletedTodo(ctx, c.ID)
+	switch {
+	case err == nil:
+		// created by a sync the client missed, and deleted since.
+		return TodoChangeResult{Outcome: SyncConflict, Deleted: true}, nil
+	case !errors.Is(err, serror.ErrTodoNotFound):
+		return TodoChangeResult{}, err
+	}
+
+	todo := TodoModel{ID: c.ID}
+	for name, v := range c.Changes.values() {
+		setTodoValue(&todo, name, v)
+	}
+	created, err := ss.todos.create(ctx, userID, todo)
+	if err != nil {
+		return TodoChangeResult{}, err
+	}
+	return TodoChangeResult{Outcome: SyncApplied, Todo: created}, nil
+}
+
+// update merges the change into the todo as it's now, and stores it
+// if any field changes. The update of a todo deleted since is a conflict.
+func (ss SyncService) update(ctx context.Context, userID uuid.UUID, c TodoChange) (TodoChangeResult, error) {
+	current, err := ss.todos.Find(ctx, userID, c.ID)
+	if errors.Is(err, serror.ErrTodoNotFound) {
+		return TodoChangeResult{Outcome: SyncConflict, Deleted: true}, nil
+	}
+	if err != nil {
+		return TodoChangeResult{}, err
+	}
+
+	merged, changed, conflicts := mergeTodo(current, c)
+	outcome := SyncMerged
+	switch {
+	case c.BaseVersion == current.Version:
+		outcome = SyncApplied
+	case len(conflicts) > 0:
+		outcome = SyncConflict
+	}
+	if !changed {
+		return TodoChangeResult{Outcome: outcome, Todo: current, Conflicts: conflicts}, nil
+	}
+	merged.Version = current.Version
+	updated, err := ss.todos.Update(ctx, userID, merged)
+	if err != nil {
+		return TodoChangeResult{}, err
+	}
+	return TodoChangeResult{Outcome: outcome, Todo: updated, Conflicts: conflicts}, nil
+}
+
+// mergeTodo returns the todo with the fields of the change merged into it, if any changed,
+// and the fields of the change in conflict with the ones of the todo, which are kept.
+// A field conflicts if the todo changed since the base version of the change, to another
+// value than the one of the change, and the field isn't at its base value anymore.
+func mergeTodo(current TodoModel, c TodoChange) (merged TodoModel, changed bool, conflicts []string) {
+	merged = current
+	base, server, client := c.Base.values(), todoValues(current), c.Changes.values()
+	for _, name := range syncedFields {
+		v, ok := client[name]
+		if !ok || equalValues(v, server[name]) {
+			continue
+		}
+		if c.BaseVersion != current.Version {
+			// without its base value, the field can't be told unchanged by the server.
+			b, ok := base[name]
+			if !ok || !equalValues(b, server[name]) {
+				conflicts = append(conflicts, name)
+				continue
+			}
+		}
+		setTodoValue(&merged, name, v)
+		changed = true
+	}
+	return merged, changed, conflicts
+}
+
+// delete moves the todo to the trash, unless it changed since the base version
+// of the change. A todo deleted already is a delete applied.
+func (ss SyncService) delete(ctx context.Context, userID uuid.UUID, c TodoChange) (TodoChangeResult, error) {
+	current, err := ss.todos.Find(ctx, userID, c.ID)
+	if errors.Is(err, serror.ErrTodoNotFound) {
+		return TodoChangeResult{Outcome: SyncApplied, Deleted: true}, nil
+	}
+	if err != nil {
+		return TodoChangeResult{}, err
+	}
+	if current.Version != c.BaseVersion {
+		return TodoChangeResult{Outcome: SyncConflict, Todo: current}, nil
+	}
+	if err = ss.todos.Delete(ctx, userID, c.ID, current.Version); err != nil {
+		return TodoChangeResult{}, err
+	}
+	return TodoChangeResult{Outcome: SyncApplied, Deleted: true}, nil
+}
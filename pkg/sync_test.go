@@ -0,0 +1,279 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FindSyncedTodos returns every todo out of the trash, the ones the user has a role on among them.
+func (d *dummyTodoRepo) FindSyncedTodos(ctx context.Context, userID uuid.UUID) ([]TodoModel, error) {
+	var todos []TodoModel
+	for _, todo := range d.todos {
+		if todo.DeletedAt.IsZero() {
+			todos = append(todos, todo)
+		}
+	}
+	return todos, nil
+}
+
+func strField(s string) *string { return &s }
+
+func boolField(b bool) *bool { return &b }
+
+func TestMergeTodo(t *testing.T) {
+	t.Parallel()
+	due := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	current := TodoModel{ID: uuid.New(), Title: "server", Content: "notes", Version: 3, DueAt: due}
+
+	tcs := []struct {
+		name      string
+		change    TodoChange
+		merged    TodoModel
+		changed   bool
+		conflicts []string
+	}{
+		{
+			name:    "same version applies as is",
+			change:  TodoChange{BaseVersion: 3, Changes: TodoFields{Title: strField("client"), Finished: boolField(true)}},
+			merged:  TodoModel{ID: current.ID, Title: "client", Content: "notes", Finished: true, Version: 3, DueAt: due},
+			changed: true,
+		},
+		{
+			name: "field left at its base value by the server takes the client one",
+			change: TodoChange{BaseVersion: 2, Base: TodoFields{Content: strField("notes")},
+				Changes: TodoFields{Content: strField("more notes")}},
+			merged:  TodoModel{ID: current.ID, Title: "server", Content: "more notes", Version: 3, DueAt: due},
+			changed: true,
+		},
+		{
+			name: "field changed by both keeps the server one",
+			change: TodoChange{BaseVersion: 2, Base: TodoFields{Title: strField("base"), Content: strField("notes")},
+				Changes: TodoFields{Title: strField("client"), Content: strField("more notes")}},
+			merged:    TodoModel{ID: current.ID, Title: "server", Content: "more notes", Version: 3, DueAt: due},
+			changed:   true,
+			conflicts: []string{fieldTitle},
+		},
+		{
+			name:   "field changed by both to the same value",
+			change: TodoChange{BaseVersion: 2, Base: TodoFields{Title: strField("base")}, Changes: TodoFields{Title: strField("server")}},
+			merged: current,
+		},
+		{
+			name:      "field without its base value is a conflict",
+			change:    TodoChange{BaseVersion: 2, Changes: TodoFields{Finished: boolField(true)}},
+			merged:    current,
+			conflicts: []string{fieldFinished},
+		},
+		{
+			name: "same instant in another location",
+			change: TodoChange{BaseVersion: 2, Base: TodoFields{Title: strField("server")},
+				Changes: TodoFields{DueAt: func() *time.Time { d := due.In(time.FixedZone("IST", 19800)); return &d }()}},
+			merged: current,
+		},
+	}
+	for _, tc := range tcs {
+		merged, changed, conflicts := mergeTodo(current, tc.change)
+		if !reflect.DeepEqual(merged, tc.merged) || changed != tc.changed || !reflect.DeepEqual(conflicts, tc.conflicts) {
+			t.Errorf("%s: expected %+v %v %v got %+v %v %v", tc.name, tc.merged, tc.changed, tc.conflicts,
+				merged, changed, conflicts)
+		}
+	}
+}
+
+func TestSyncService_Changes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := newDummyTodoRepo()
+	userID := uuid.New()
+	ss := NewSyncService(NewTodoService(repo), repo, &dummyEventLog{notify: make(chan struct{}, 100)})
+	stale := TodoModel{ID: uuid.New(), UserID: userID, Title: "server", Content: "notes", Version: 3}
+	kept := TodoModel{ID: uuid.New(), UserID: userID, Title: "kept", Version: 2}
+	deleted := TodoModel{ID: uuid.New(), UserID: userID, Title: "deleted", Version: 1}
+	repo.todos[stale.ID], repo.todos[kept.ID], repo.todos[deleted.ID] = stale, kept, deleted
+	createdID := uuid.New()
+
+	changes := []TodoChange{
+		{Op: TodoChangeUpsert, ID: createdID, Changes: TodoFields{Title: strField("offline")}},
+		// retried, as the client missed the result
+		{Op: TodoChangeUpsert, ID: createdID, Changes: TodoFields{Title: strField("offline")}},
+		{Op: TodoChangeUpsert, ID: stale.ID, BaseVersion: 2, Base: TodoFields{Title: strField("base"), Content: strField("notes")},
+			Changes: TodoFields{Title: strField("client"), Content: strField("more notes")}},
+		{Op: TodoChangeDelete, ID: kept.ID, BaseVersion: 1},
+		{Op: TodoChangeDelete, ID: deleted.ID, BaseVersion: 1},
+		{Op: TodoChangeUpsert, ID: deleted.ID, BaseVersion: 1, Base: TodoFields{Title: strField("deleted")},
+			Changes: TodoFields{Title: strField("too late")}},
+		{Op: TodoChangeUpsert, ID: uuid.New(), Changes: TodoFields{Title: strField("  ")}},
+	}
+	result, err := ss.Sync(ctx, userID, "", changes)
+	if err != nil {
+		t.Fatalf("expected a nil error for sync got %v", err)
+	}
+
+	outcomes := []SyncOutcome{SyncApplied, SyncApplied, SyncConflict, SyncConflict, SyncApplied, SyncConflict, SyncRejected}
+	for i, r := range result.Results {
+		if r.ID != changes[i].ID || r.Outcome != outcomes[i] {
+			t.Errorf("expected change %d %v got %+v", i, outcomes[i], r)
+		}
+	}
+	if r := result.Results[1]; r.Todo.ID != createdID || r.Todo.Title != "offline" || len(repo.todos) != 3 {
+		t.Errorf("expected the todo created once with the client id got %+v", r)
+	}
+	if r := result.Results[2]; r.Todo.Title != "server" || r.Todo.Content != "more notes" || r.Todo.Version != 4 ||
+		!reflect.DeepEqual(r.Conflicts, []string{fieldTitle}) {
+		t.Errorf("expected the change merged with the title of the server got %+v", r)
+	}
+	if r := result.Results[3]; r.Deleted || r.Todo.Title != "kept" || repo.todos[kept.ID].Title != "kept" {
+		t.Errorf("expected the todo changed since to be kept got %+v", r)
+	}
+	if r := result.Results[5]; !r.Deleted {
+		t.Errorf("expected the deletion to win got %+v", r)
+	}
+	if r := result.Results[6]; !errors.Is(r.Err, ErrInvalidTodo) {
+		t.Errorf("expected the invalid todo rejected alone got %+v", r)
+	}
+	if !result.Reset || len(result.Todos) != 3 || result.Token != "0" {
+		t.Errorf("expected all the todos from scratch got %+v", result)
+	}
+
+	if _, err = ss.Sync(ctx, userID, "latest", nil); !errors.Is(err, ErrInvalidSyncToken) {
+		t.Errorf("expected invalid sync token got %v", err)
+	}
+}
+
+func TestSyncService_ChangedSince(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := newDummyTodoRepo()
+	grants := &dummyGrantRepo{roles: make(map[uuid.UUID]map[uuid.UUID]Role)}
+	elog := &dummyEventLog{notify: make(chan struct{}, 100)}
+	auth := NewAuthorizer(grants)
+	ss := NewSyncService(NewSharedTodoService(repo, &dummyProjectRepo{}, auth), repo, elog)
+	userID, ownerID := uuid.New(), uuid.New()
+	mine := TodoModel{ID: uuid.New(), UserID: userID, Title: "mine", Version: 1}
+	shared := TodoModel{ID: uuid.New(), UserID: ownerID, Title: "shared", Version: 1}
+	private := TodoModel{ID: uuid.New(), UserID: ownerID, Title: "private", Version: 1}
+	_ = grants.UpsertOne(ctx, GrantModel{Resource: Resource{Type: ResourceTodo, ID: shared.ID},
+		UserID: userID, Role: RoleViewer, GrantedBy: ownerID})
+
+	elog.log(todoEvent(EventTodoCreated, mine))
+	first, err := ss.Sync(ctx, userID, "", nil)
+	if err != nil || first.Token != "1" {
+		t.Fatalf("expected the token of the last event got %+v %v", first, err)
+	}
+	for _, todo := range []TodoModel{shared, private} {
+		elog.log(todoEvent(EventTodoCreated, todo))
+	}
+	mine.Title, mine.Version = "renamed", 2
+	elog.log(Event{Type: EventTodoUpdated, UserID: userID, SubjectID: mine.ID,
+		Data: []byte(`{"id":"` + mine.ID.String() + `","user_id":"` + userID.String() + `","title":"renamed","version":2,"due_at":null}`)})
+	elog.log(todoEvent(EventTodoDeleted, mine))
+
+	next, err := ss.Sync(ctx, userID, first.Token, nil)
+	if err != nil {
+		t.Fatalf("expected a nil error for sync got %v", err)
+	}
+	if next.Reset || next.More || next.Token != "5" || len(next.Todos) != 2 {
+		t.Fatalf("expected the todos changed since got %+v", next)
+	}
+	if next.Todos[0].Todo.ID != shared.ID || next.Todos[0].Deleted || next.Todos[0].Todo.UserID != ownerID {
+		t.Errorf("expected the shared todo got %+v", next.Todos[0])
+	}
+	if next.Todos[1].Todo.ID != mine.ID || !next.Todos[1].Deleted {
+		t.Errorf("expected the tombstone of the last change got %+v", next.Todos[1])
+	}
+	if again, _ := ss.Sync(ctx, userID, next.Token, nil); len(again.Todos) != 0 || again.Token != next.Token {
+		t.Errorf("expected nothing changed since got %+v", again)
+	}
+
+	elog.mu.Lock()
+	elog.start = 3
+	elog.mu.Unlock()
+	if gone, err := ss.Sync(ctx, userID, first.Token, nil); err != nil || !gone.Reset || gone.Token != "5" {
+		t.Errorf("expected to sync from scratch once the token is gone got %+v %v", gone, err)
+	}
+}
+
+func TestSyncService_ResetShared(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := newDummyTodoRepo()
+	grants := &dummyGrantRepo{roles: make(map[uuid.UUID]map[uuid.UUID]Role)}
+	ss := NewSyncService(NewSharedTodoService(repo, &dummyProjectRepo{}, NewAuthorizer(grants)), repo,
+		&dummyEventLog{notify: make(chan struct{}, 100)})
+	userID, ownerID := uuid.New(), uuid.New()
+	mine := TodoModel{ID: uuid.New(), UserID: userID, Title: "mine", Version: 1}
+	shared := TodoModel{ID: uuid.New(), UserID: ownerID, Title: "shared", Version: 1}
+	private := TodoModel{ID: uuid.New(), UserID: ownerID, Title: "private", Version: 1}
+	repo.todos[mine.ID], repo.todos[shared.ID], repo.todos[private.ID] = mine, shared, private
+	_ = grants.UpsertOne(ctx, GrantModel{Resource: Resource{Type: ResourceTodo, ID: shared.ID},
+		UserID: userID, Role: RoleViewer, GrantedBy: ownerID})
+
+	result, err := ss.Sync(ctx, userID, "", nil)
+	if err != nil || !result.Reset || len(result.Todos) != 2 {
+		t.Fatalf("expected the own and shared todos from scratch got %+v %v", result, err)
+	}
+	for _, synced := range result.Todos {
+		if synced.Todo.ID == private.ID {
+			t.Errorf("expected the private todo of the owner left out got %+v", synced)
+		}
+	}
+}
+
+func TestSyncService_AccessLost(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := newDummyTodoRepo()
+	grants := &dummyGrantRepo{roles: make(map[uuid.UUID]map[uuid.UUID]Role)}
+	elog := &dummyEventLog{notify: make(chan struct{}, 100)}
+	ss := NewSyncService(NewSharedTodoService(repo, &dummyProjectRepo{}, NewAuthorizer(grants)), repo, elog)
+	userID, ownerID, projectID := uuid.New(), uuid.New(), uuid.New()
+	shared := TodoModel{ID: uuid.New(), UserID: ownerID, Title: "shared", Version: 1}
+	inProject := TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: projectID, Title: "in project", Version: 1}
+	repo.todos[shared.ID], repo.todos[inProject.ID] = shared, inProject
+	_ = grants.UpsertOne(ctx, GrantModel{Resource: Resource{Type: ResourceTodo, ID: shared.ID},
+		UserID: userID, Role: RoleViewer, GrantedBy: ownerID})
+	_ = grants.UpsertOne(ctx, GrantModel{Resource: Resource{Type: ResourceProject, ID: projectID},
+		UserID: userID, Role: RoleViewer, GrantedBy: ownerID})
+	first, err := ss.Sync(ctx, userID, "", nil)
+	if err != nil || len(first.Todos) != 2 {
+		t.Fatalf("expected the todos shared with the user got %+v %v", first, err)
+	}
+
+	// moved by its owner out of the project shared with the user
+	moved := inProject
+	moved.ProjectID, moved.Version = uuid.New(), 2
+	e := todoEvent(EventTodoUpdated, moved)
+	e.Data = []byte(`{"id":"` + moved.ID.String() + `","project_id":"` + moved.ProjectID.String() +
+		`","title":"in project","version":2,"moved_from":"` + projectID.String() + `"}`)
+	elog.log(e)
+	elog.log(todoEvent(EventTodoUpdated, TodoModel{ID: uuid.New(), UserID: ownerID, ProjectID: moved.ProjectID}))
+	next, err := ss.Sync(ctx, userID, first.Token, nil)
+	if err != nil || next.Reset || len(next.Todos) != 1 {
+		t.Fatalf("expected the tombstone of the moved todo alone got %+v %v", next, err)
+	}
+	if synced := next.Todos[0]; synced.Todo.ID != moved.ID || !synced.Deleted || synced.Todo.Title != "" {
+		t.Errorf("expected the moved todo told deleted without its fields got %+v", synced)
+	}
+
+	// unshared
+	delete(grants.roles[shared.ID], userID)
+	elog.log(Event{Type: EventAccessRevoked, UserID: uuid.New(), SubjectID: shared.ID, Data: []byte(`{}`)})
+	if again, err := ss.Sync(ctx, userID, next.Token, nil); err != nil || again.Reset {
+		t.Errorf("expected the access lost by another user ignored got %+v %v", again, err)
+	}
+	elog.log(Event{Type: EventAccessRevoked, UserID: userID, SubjectID: shared.ID, Data: []byte(`{}`)})
+	reset, err := ss.Sync(ctx, userID, next.Token, nil)
+	if err != nil || !reset.Reset || reset.More || len(reset.Todos) != 1 || reset.Todos[0].Todo.ID != inProject.ID {
+		t.Errorf("expected a sync from scratch without the todo unshared got %+v %v", reset, err)
+	}
+	if reset.Token != "4" {
+		t.Errorf("expected the token of the head got %s", reset.Token)
+	}
+}
@@ -2,8 +2,14 @@ package pkg
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
 )
 
 var (
@@ -11,13 +17,35 @@ var (
 	NilTodoModel TodoModel
 )
 
+var (
+	// ErrInvalidTodo indicates the todo fails the validation
+	ErrInvalidTodo = errors.New("invalid todo")
+	// ErrBatchAborted indicates the write has not been applied,
+	// because another write of the same atomic batch failed
+	ErrBatchAborted = errors.New("batch aborted")
+)
+
 // TodoModel is each single individual task
 type TodoModel struct {
-	Title    string
-	Content  string
-	ID       uuid.UUID
-	UserID   uuid.UUID
-	Finished bool
+	Title   string
+	Content string
+	ID      uuid.UUID
+	UserID  uuid.UUID
+	// ProjectID is the project the todo is filed under. A nil one is the inbox
+	// of the user on insert, and keeps the current project on update.
+	ProjectID uuid.UUID
+	Finished  bool
+	// AutoFinish marks the todo as finished once all its checklist items are done.
+	AutoFinish bool
+	// Version is incremented by the storage on every update of the todo.
+	Version int64
+	// DeletedAt is zero while the todo is not in the trash.
+	DeletedAt time.Time
+	// DueAt is zero if the todo isn't due at any time.
+	DueAt time.Time
+	// SeriesID is the series of a recurring todo, which the todo is an occurrence of.
+	// It's nil if the todo doesn't recur, and only written through the SeriesStorage.
+	SeriesID uuid.UUID
 }
 
 // TodoFilter tells what kind of filter to apply on queries
@@ -32,12 +60,384 @@ const (
 	UnFinished
 )
 
+// TodoWriteOp tells what kind of write a TodoWrite is
+type TodoWriteOp int8
+
+const (
+	// TodoInsert inserts the todo
+	TodoInsert TodoWriteOp = iota + 1
+	// TodoUpdate updates the title, content, finished, auto finish and project of the todo
+	TodoUpdate
+	// TodoComplete only marks the todo as finished
+	TodoComplete
+	// TodoDelete deletes the todo
+	TodoDelete
+)
+
+// TodoWrite is a single write of a batch. The Todo.Version is the version the
+// update, complete and delete expects to replace, zero skips the check.
+// Every write other than the insert only applies to a todo of the Todo.UserID.
+type TodoWrite struct {
+	Op   TodoWriteOp
+	Todo TodoModel
+}
+
+// TodoWriteResult is the outcome of a TodoWrite, Todo is the stored
+// todo after an insert, update or complete.
+type TodoWriteResult struct {
+	Todo TodoModel
+	Err  error
+}
+
 // TodoStorage define a contract for storage, to interact
 // with the Todo Model.
 type TodoStorage interface {
 	FindOneTodo(ctx context.Context, id uuid.UUID) (TodoModel, error)
-	FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter TodoFilter) ([]TodoModel, error)
-	UpdateOne(ctx context.Context, todo TodoModel) error
+	// FindAllTodoOfUser returns the latest todos of the user matching the filter,
+	// only the ones of the project if the projectID is not nil, the ones out of
+	// the projects of an organization otherwise.
+	FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter TodoFilter, projectID uuid.UUID) ([]TodoModel, error)
+	// UpdateOne updates the todo and returns its new version. If the todo.Version is
+	// not zero the update only succeeds if it's still the stored version, the check
+	// being atomic with the update, serror.ErrVersionConflict is returned otherwise.
+	UpdateOne(ctx context.Context, todo TodoModel) (int64, error)
+	// InsertOne stores the todo, serror.ErrProjectNotFound is returned if its
+	// project is not one of the user. Same for the UpdateOne.
 	InsertOne(ctx context.Context, todo TodoModel) (uuid.UUID, error)
-	DeleteOne(ctx context.Context, id uuid.UUID) error
+	// DeleteOne moves the todo to the trash. If the version is not zero the delete only
+	// succeeds if it's still the stored version, like the UpdateOne.
+	// Every other method but the trash ones ignores the todos in the trash.
+	DeleteOne(ctx context.Context, id uuid.UUID, version int64) error
+	// FindTrashOfUser returns the latest deleted todos of the user out of the projects
+	// of an organization, latest deleted first.
+	FindTrashOfUser(ctx context.Context, userID uuid.UUID) ([]TodoModel, error)
+	// FindDeletedTodo returns the todo with the id if it's in the trash.
+	FindDeletedTodo(ctx context.Context, id uuid.UUID) (TodoModel, error)
+	// RestoreOne moves the todo of the user out of the trash and returns it.
+	RestoreOne(ctx context.Context, id, userID uuid.UUID) (TodoModel, error)
+	// PurgeDeleted deletes for good the todos deleted before the time,
+	// and returns how many were deleted.
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	// ApplyBatch applies the writes in order and returns the result of each, a todo
+	// missing or of another user is reported as serror.ErrTodoNotFound. If atomic,
+	// either all the writes are applied or none, and the ones not applied because of
+	// another failing write report ErrBatchAborted. Otherwise each write succeeds
+	// or fails on its own. The error is only about the batch as a whole.
+	ApplyBatch(ctx context.Context, writes []TodoWrite, atomic bool) ([]TodoWriteResult, error)
+}
+
+// TodoService provides the use cases implementation to work
+// with the todos of a user, and the ones shared with the user.
+type TodoService struct {
+	repo TodoStorage
+	// projects is nil if the todos can't be shared through their project
+	projects ProjectStorage
+	auth     Authorizer
+	// series is nil if finishing a recurring todo doesn't store its next occurrence
+	series SeriesStorage
+}
+
+// NewTodoService returns a new TodoService initialized with
+// a concrete repo implementation, the todos are only the ones of their owner.
+func NewTodoService(repo TodoStorage) TodoService {
+	return TodoService{
+		repo: repo,
+	}
+}
+
+// NewSharedTodoService returns a new TodoService whose todos are shared as the auth
+// decides, either on their own or through the project of the projects repo they
+// are filed under.
+func NewSharedTodoService(repo TodoStorage, projects ProjectStorage, auth Authorizer) TodoService {
+	return TodoService{
+		repo:     repo,
+		projects: projects,
+		auth:     auth,
+	}
+}
+
+// NewRecurringTodoService returns a new TodoService like the NewSharedTodoService,
+// that stores the next occurrence of a recurring todo of the series repo once it's finished.
+func NewRecurringTodoService(repo TodoStorage, projects ProjectStorage, auth Authorizer, series SeriesStorage) TodoService {
+	ts := NewSharedTodoService(repo, projects, auth)
+	ts.series = series
+	return ts
+}
+
+// IsValidTodo validate if the todo is valid or not
+func (ts TodoService) IsValidTodo(todo TodoModel) bool {
+	title := strings.TrimSpace(todo.Title)
+	return title != "" && len(title) <= 255
+}
+
+// Find returns the todo with the id, if the user can read it.
+// A todo the user has no role on is reported as not found, so its existence isn't leaked.
+func (ts TodoService) Find(ctx context.Context, userID, id uuid.UUID) (TodoModel, error) {
+	return ts.authorized(ctx, userID, id, RoleViewer)
+}
+
+// authorized returns the todo with the id, if the user has at least the needed role on it.
+func (ts TodoService) authorized(ctx context.Context, userID, id uuid.UUID, need Role) (TodoModel, error) {
+	todo, err := ts.repo.FindOneTodo(ctx, id)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	if err = ts.auth.AuthorizeTodo(ctx, userID, todo, need); err != nil {
+		return NilTodoModel, err
+	}
+	return todo, nil
+}
+
+// ownerOfProject returns the owner of the project the user wants to write a todo into,
+// which owns the todo too. Without the projects repo, or for the inbox, it's the user.
+func (ts TodoService) ownerOfProject(ctx context.Context, userID, projectID uuid.UUID) (uuid.UUID, error) {
+	if ts.projects == nil || projectID == uuid.Nil {
+		return userID, nil
+	}
+	project, err := ts.projects.FindOneProject(ctx, projectID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err = ts.auth.AuthorizeProject(ctx, userID, project, RoleEditor); err != nil {
+		return uuid.Nil, err
+	}
+	return project.UserID, nil
+}
+
+// List returns the todos of the user matching the filter, only the ones
+// of the project if the projectID is not nil. The todos of a project shared
+// with the user are the ones of its owner.
+func (ts TodoService) List(ctx context.Context, userID uuid.UUID, filter TodoFilter, projectID uuid.UUID) ([]TodoModel, error) {
+	ownerID := userID
+	if ts.projects != nil && projectID != uuid.Nil {
+		project, err := ts.projects.FindOneProject(ctx, projectID)
+		if err != nil {
+			return nil, err
+		}
+		if err = ts.auth.AuthorizeProject(ctx, userID, project, RoleViewer); err != nil {
+			return nil, err
+		}
+		ownerID = project.UserID
+	}
+	return ts.repo.FindAllTodoOfUser(ctx, ownerID, filter, projectID)
+}
+
+// Create stores a new todo and returns it. It's owned by the user,
+// unless it's filed under a project shared with the user.
+func (ts TodoService) Create(ctx context.Context, userID uuid.UUID, todo TodoModel) (TodoModel, error) {
+	todo.ID = uuid.New()
+	return ts.create(ctx, userID, todo)
+}
+
+// create stores the new todo with its own ID, as the Create.
+func (ts TodoService) create(ctx context.Context, userID uuid.UUID, todo TodoModel) (TodoModel, error) {
+	if !ts.IsValidTodo(todo) {
+		return NilTodoModel, ErrInvalidTodo
+	}
+	ownerID, err := ts.ownerOfProject(ctx, userID, todo.ProjectID)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	todo.UserID = ownerID
+	todo.Title = strings.TrimSpace(todo.Title)
+	todo.Version = 1
+	todo.SeriesID = uuid.Nil
+	id, err := ts.repo.InsertOne(ctx, todo)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	if todo.ProjectID == uuid.Nil {
+		// the storage filed it under the inbox
+		return ts.repo.FindOneTodo(ctx, id)
+	}
+	todo.ID = id
+	return todo, nil
+}
+
+// Update updates the todo the user can edit and returns it with its new version.
+// If todo.Version is not zero, it's the version the update expects to replace.
+// Moving the todo to another project needs the user to edit that project too.
+// Of a recurring todo, only that occurrence is updated, and finishing it stores the next one.
+func (ts TodoService) Update(ctx context.Context, userID uuid.UUID, todo TodoModel) (TodoModel, error) {
+	if !ts.IsValidTodo(todo) {
+		return NilTodoModel, ErrInvalidTodo
+	}
+	current, err := ts.authorized(ctx, userID, todo.ID, RoleEditor)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	if todo.ProjectID == uuid.Nil {
+		todo.ProjectID = current.ProjectID
+	} else if todo.ProjectID != current.ProjectID {
+		if _, err = ts.ownerOfProject(ctx, userID, todo.ProjectID); err != nil {
+			return NilTodoModel, err
+		}
+	}
+	todo.UserID = current.UserID
+	todo.SeriesID = current.SeriesID
+	todo.Title = strings.TrimSpace(todo.Title)
+	version, err := ts.repo.UpdateOne(ctx, todo)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	todo.Version = version
+	if !current.Finished && todo.Finished {
+		// the update is saved already, a failed advance is caught up by the SeriesAdvancer.
+		_ = ts.advance(ctx, todo)
+	}
+	return todo, nil
+}
+
+// advance stores the occurrence that follows the finished todo, if it recurs and
+// its series isn't over. The next occurrence follows the due time of the todo,
+// or now if it isn't due, and it's filed under the same project.
+// It's idempotent, an occurrence already stored is kept as is, and the series
+// without an occurrence to come is ended.
+func (ts TodoService) advance(ctx context.Context, done TodoModel) error {
+	if ts.series == nil || done.SeriesID == uuid.Nil || !done.Finished {
+		return nil
+	}
+	series, err := ts.series.FindOneSeries(ctx, done.SeriesID)
+	switch {
+	case errors.Is(err, serror.ErrSeriesNotFound):
+		// stopped in between
+		return nil
+	case err != nil:
+		return err
+	}
+	after := done.DueAt
+	if after.IsZero() {
+		after = time.Now()
+	}
+	next, err := series.Occurrences(after, 1)
+	if err != nil {
+		return err
+	}
+	if len(next) == 0 {
+		return ts.series.EndOne(ctx, series.ID)
+	}
+	return ts.series.InsertOccurrence(ctx, TodoModel{
+		ID:         occurrenceID(series.ID, next[0]),
+		UserID:     done.UserID,
+		ProjectID:  done.ProjectID,
+		Title:      series.Title,
+		Content:    series.Content,
+		AutoFinish: done.AutoFinish,
+		Version:    1,
+		DueAt:      next[0],
+		SeriesID:   series.ID,
+	})
+}
+
+// Delete moves the todo the user can edit to the trash. If version is not zero,
+// it's the version the delete expects to remove.
+func (ts TodoService) Delete(ctx context.Context, userID, id uuid.UUID, version int64) error {
+	if _, err := ts.authorized(ctx, userID, id, RoleEditor); err != nil {
+		return err
+	}
+	return ts.repo.DeleteOne(ctx, id, version)
+}
+
+// Trash returns the todos of the user in the trash.
+func (ts TodoService) Trash(ctx context.Context, userID uuid.UUID) ([]TodoModel, error) {
+	return ts.repo.FindTrashOfUser(ctx, userID)
+}
+
+// Restore moves the todo the user can edit out of the trash and returns it.
+func (ts TodoService) Restore(ctx context.Context, userID, id uuid.UUID) (TodoModel, error) {
+	todo, err := ts.repo.FindDeletedTodo(ctx, id)
+	if err != nil {
+		return NilTodoModel, err
+	}
+	if err = ts.auth.AuthorizeTodo(ctx, userID, todo, RoleEditor); err != nil {
+		return NilTodoModel, err
+	}
+	return ts.repo.RestoreOne(ctx, id, todo.UserID)
+}
+
+// Batch applies the writes of the user in order and returns the result of each,
+// with the same atomic semantic as the TodoStorage.ApplyBatch.
+// Inserted todos get a new ID, and every write is restricted to the todos the user
+// can edit, a write the user isn't authorized to fails as a whole.
+func (ts TodoService) Batch(ctx context.Context, userID uuid.UUID, writes []TodoWrite, atomic bool) ([]TodoWriteResult, error) {
+	results := make([]TodoWriteResult, len(writes))
+	valid := make([]TodoWrite, 0, len(writes))
+	index := make([]int, 0, len(writes))
+	for i, w := range writes {
+		switch w.Op {
+		case TodoInsert, TodoUpdate:
+			if !ts.IsValidTodo(w.Todo) {
+				results[i].Err = ErrInvalidTodo
+				continue
+			}
+			w.Todo.Title = strings.TrimSpace(w.Todo.Title)
+		case TodoComplete, TodoDelete:
+		default:
+			results[i].Err = fmt.Errorf("unsupported todo write op %d", w.Op)
+			continue
+		}
+		ownerID, err := ts.authorizeWrite(ctx, userID, w)
+		switch {
+		case err == nil:
+			w.Todo.UserID = ownerID
+		case w.Op != TodoInsert && errors.Is(err, serror.ErrTodoNotFound):
+			// scoped to the user it misses, so the storage reports it
+			// in order with the other failures of the batch.
+			w.Todo.UserID = userID
+		default:
+			results[i].Err = err
+			continue
+		}
+		if w.Op == TodoInsert {
+			w.Todo.ID = uuid.New()
+			w.Todo.Version = 1
+			w.Todo.SeriesID = uuid.Nil
+		}
+		valid = append(valid, w)
+		index = append(index, i)
+	}
+
+	if atomic && len(valid) != len(writes) {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBatchAborted
+			}
+		}
+		return results, nil
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	applied, err := ts.repo.ApplyBatch(ctx, valid, atomic)
+	if err != nil {
+		return nil, err
+	}
+	for j, r := range applied {
+		results[index[j]] = r
+		// the todo may have been finished already, its next occurrence is then stored already too.
+		// The writes are applied already, a failed advance is caught up by the SeriesAdvancer.
+		if r.Err == nil && valid[j].Op != TodoInsert {
+			_ = ts.advance(ctx, r.Todo)
+		}
+	}
+	return results, nil
+}
+
+// authorizeWrite returns the owner of the todo of the write, if the user can edit it
+// and the project it files the todo under.
+func (ts TodoService) authorizeWrite(ctx context.Context, userID uuid.UUID, w TodoWrite) (uuid.UUID, error) {
+	if w.Op == TodoInsert {
+		return ts.ownerOfProject(ctx, userID, w.Todo.ProjectID)
+	}
+	current, err := ts.authorized(ctx, userID, w.Todo.ID, RoleEditor)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if w.Op == TodoUpdate && w.Todo.ProjectID != uuid.Nil && w.Todo.ProjectID != current.ProjectID {
+		if _, err = ts.ownerOfProject(ctx, userID, w.Todo.ProjectID); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	return current.UserID, nil
 }
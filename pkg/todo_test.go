@@ -1 +1,199 @@
+// +build unit_tests all_tests
+
 package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+type dummyTodoRepo struct {
+	todos map[uuid.UUID]TodoModel
+}
+
+func newDummyTodoRepo() *dummyTodoRepo {
+	return &dummyTodoRepo{todos: make(map[uuid.UUID]TodoModel)}
+}
+
+func (d *dummyTodoRepo) FindOneTodo(ctx context.Context, id uuid.UUID) (TodoModel, error) {
+	todo, ok := d.todos[id]
+	if !ok {
+		return NilTodoModel, serror.ErrTodoNotFound
+	}
+	return todo, nil
+}
+
+func (d *dummyTodoRepo) FindAllTodoOfUser(ctx context.Context, userID uuid.UUID, filter TodoFilter, projectID uuid.UUID) ([]TodoModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyTodoRepo) UpdateOne(ctx context.Context, todo TodoModel) (int64, error) {
+	stored := d.todos[todo.ID]
+	if todo.Version != 0 && todo.Version != stored.Version {
+		return 0, serror.ErrVersionConflict
+	}
+	todo.Version = stored.Version + 1
+	d.todos[todo.ID] = todo
+	return todo.Version, nil
+}
+
+func (d *dummyTodoRepo) InsertOne(ctx context.Context, todo TodoModel) (uuid.UUID, error) {
+	d.todos[todo.ID] = todo
+	return todo.ID, nil
+}
+
+func (d *dummyTodoRepo) DeleteOne(ctx context.Context, id uuid.UUID, version int64) error {
+	delete(d.todos, id)
+	return nil
+}
+
+func (d *dummyTodoRepo) FindTrashOfUser(ctx context.Context, userID uuid.UUID) ([]TodoModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyTodoRepo) FindDeletedTodo(ctx context.Context, id uuid.UUID) (TodoModel, error) {
+	// deleted for good
+	return NilTodoModel, serror.ErrTodoNotFound
+}
+
+func (d *dummyTodoRepo) RestoreOne(ctx context.Context, id, userID uuid.UUID) (TodoModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyTodoRepo) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	for id, todo := range d.todos {
+		if !todo.DeletedAt.IsZero() && todo.DeletedAt.Before(before) {
+			delete(d.todos, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (d *dummyTodoRepo) ApplyBatch(ctx context.Context, writes []TodoWrite, atomic bool) ([]TodoWriteResult, error) {
+	results := make([]TodoWriteResult, len(writes))
+	for i, w := range writes {
+		if w.Op == TodoInsert {
+			d.todos[w.Todo.ID] = w.Todo
+		}
+		results[i] = TodoWriteResult{Todo: w.Todo}
+	}
+	return results, nil
+}
+
+func TestTodoService_Create(t *testing.T) {
+	t.Parallel()
+	ts := NewTodoService(newDummyTodoRepo())
+	userID := uuid.New()
+
+	_, err := ts.Create(context.Background(), userID, TodoModel{Title: "   "})
+	if !errors.Is(err, ErrInvalidTodo) {
+		t.Errorf("expected invalid todo error for blank title got %v", err)
+	}
+
+	todo, err := ts.Create(context.Background(), userID, TodoModel{Title: " write tests ", UserID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.UserID != userID || todo.Title != "write tests" || todo.Version != 1 || todo.ID == uuid.Nil {
+		t.Errorf("unexpected created todo %+v", todo)
+	}
+}
+
+func TestTodoService_Ownership(t *testing.T) {
+	t.Parallel()
+	repo := newDummyTodoRepo()
+	ts := NewTodoService(repo)
+	owner, other := uuid.New(), uuid.New()
+
+	todo, err := ts.Create(context.Background(), owner, TodoModel{Title: "mine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ts.Find(context.Background(), other, todo.ID); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected todo of another user to be not found got %v", err)
+	}
+	todo.Title = "stolen"
+	if _, err = ts.Update(context.Background(), other, todo); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected update of another user todo to be not found got %v", err)
+	}
+	if err = ts.Delete(context.Background(), other, todo.ID, 0); !errors.Is(err, serror.ErrTodoNotFound) {
+		t.Errorf("expected delete of another user todo to be not found got %v", err)
+	}
+	if repo.todos[todo.ID].Title != "mine" {
+		t.Errorf("expected todo to be left untouched")
+	}
+}
+
+func TestTodoService_UpdateVersion(t *testing.T) {
+	t.Parallel()
+	ts := NewTodoService(newDummyTodoRepo())
+	userID := uuid.New()
+	todo, err := ts.Create(context.Background(), userID, TodoModel{Title: "version me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	todo.Finished = true
+	updated, err := ts.Update(context.Background(), userID, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("expected version 2 got %d", updated.Version)
+	}
+
+	// todo still carries the version 1
+	if _, err = ts.Update(context.Background(), userID, todo); !errors.Is(err, serror.ErrVersionConflict) {
+		t.Errorf("expected version conflict for stale update got %v", err)
+	}
+}
+
+func TestTodoService_Batch(t *testing.T) {
+	t.Parallel()
+	repo := newDummyTodoRepo()
+	ts := NewTodoService(repo)
+	userID := uuid.New()
+	writes := []TodoWrite{
+		{Op: TodoInsert, Todo: TodoModel{Title: " first "}},
+		{Op: TodoInsert, Todo: TodoModel{Title: ""}},
+		{Op: TodoComplete, Todo: TodoModel{ID: uuid.New(), UserID: uuid.New()}},
+	}
+
+	results, err := ts.Batch(context.Background(), userID, writes, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[1].Err, ErrInvalidTodo) {
+		t.Errorf("expected invalid todo error got %v", results[1].Err)
+	}
+	if !errors.Is(results[0].Err, ErrBatchAborted) || !errors.Is(results[2].Err, ErrBatchAborted) {
+		t.Errorf("expected the other writes of an atomic batch to be aborted got %v", results)
+	}
+	if len(repo.todos) != 0 {
+		t.Errorf("expected nothing to be stored by the aborted batch")
+	}
+
+	results, err = ts.Batch(context.Background(), userID, writes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || results[0].Todo.Title != "first" || results[0].Todo.UserID != userID || results[0].Todo.Version != 1 {
+		t.Errorf("unexpected insert result %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, ErrInvalidTodo) {
+		t.Errorf("expected invalid todo error got %v", results[1].Err)
+	}
+	// every write is restricted to the todos of the user
+	if results[2].Err != nil || results[2].Todo.UserID != userID {
+		t.Errorf("expected the write to be scoped to the user got %+v", results[2])
+	}
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"context"
+	"errors"
 	"regexp"
 	"strings"
 
@@ -14,6 +15,13 @@ var (
 	NilUserModel UserModel
 )
 
+var (
+	// ErrDuplicateRegistration indicates the email is already registered
+	ErrDuplicateRegistration = errors.New("email already registered")
+	// ErrInvalidCredentials indicates the email and password doesn't match
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
 // UserModel represents individual user registered in the system
 type UserModel struct {
 	ID        uuid.UUID
@@ -30,6 +38,7 @@ type UserStorage interface {
 	Find(ctx context.Context, id uuid.UUID) (UserModel, error)
 	FindByEmail(ctx context.Context, email string) (UserModel, error)
 	Update(ctx context.Context, user UserModel) error
+	// Store stores the user along with its inbox project.
 	Store(ctx context.Context, user UserModel) (uuid.UUID, error)
 }
 
@@ -0,0 +1,420 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+var (
+	// NilWebhookModel is empty WebhookModel, all zeros
+	NilWebhookModel WebhookModel
+)
+
+var (
+	// ErrInvalidWebhook indicates the webhook fails the validation
+	ErrInvalidWebhook = errors.New("invalid webhook")
+)
+
+// Defaults of the webhook deliveries.
+const (
+	webhookBatch       = 100
+	webhookLease       = time.Minute
+	webhookMaxAttempts = 8
+	// webhookDisableAfter is how many failed attempts in a row disable the webhook.
+	webhookDisableAfter = 20
+	// maxDeliveries is how many deliveries of the delivery log are listed.
+	maxDeliveries = 50
+)
+
+// WebhookModel is an endpoint the events it subscribes to are posted to, either
+// a personal one told about the todos of its user, or the one of an organization
+// told about the todos of its projects.
+type WebhookModel struct {
+	ID uuid.UUID
+	// UserID is the user who registered the webhook.
+	UserID uuid.UUID
+	// OrgID is the organization of the webhook, nil for a personal one.
+	OrgID  uuid.UUID
+	URL    string
+	Events []string
+	// Secret signs the payloads, it's only told once the webhook is created.
+	Secret string
+	// Disabled is set once the deliveries failed webhookDisableAfter times in a row.
+	Disabled bool
+	// Failures counts the failed attempts in a row.
+	Failures  int
+	CreatedAt time.Time
+}
+
+// DeliveryStatus tells where the delivery of an event to a webhook stands.
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is a delivery still to be done, or to be retried.
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliveryDelivered is a delivery the webhook responded to with a 2xx.
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryDead is a delivery given up on after webhookMaxAttempts failed attempts.
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// DeliveryModel is an entry of the delivery log of a webhook.
+type DeliveryModel struct {
+	ID        int64
+	WebhookID uuid.UUID
+	EventID   int64
+	EventType string
+	Status    DeliveryStatus
+	Attempts  int
+	// ResponseCode is the status code of the last attempt, zero without response.
+	ResponseCode int
+	LastError    string
+	CreatedAt    time.Time
+	DeliveredAt  time.Time
+}
+
+// WebhookDelivery is a delivery due, with the webhook and the event to post.
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID uuid.UUID
+	URL       string
+	Secret    string
+	EventID   int64
+	EventType string
+	// Data is the json of the subject of the event, as it was once changed.
+	Data       json.RawMessage
+	OccurredAt time.Time
+	// Attempt counts the attempts of the delivery, from 1.
+	Attempt int
+}
+
+// WebhookSender posts a delivery to its webhook, and returns the status
+// code of the response, zero if there's none.
+type WebhookSender interface {
+	Send(ctx context.Context, d WebhookDelivery) (int, error)
+}
+
+// WebhookStorage define a contract for storage, to interact with the
+// WebhookModel and to deliver them the events of the outbox.
+type WebhookStorage interface {
+	FindOneWebhook(ctx context.Context, id uuid.UUID) (WebhookModel, error)
+	// FindWebhooksOfUser returns the personal webhooks of the user.
+	FindWebhooksOfUser(ctx context.Context, userID uuid.UUID) ([]WebhookModel, error)
+	FindWebhooksOfOrg(ctx context.Context, orgID uuid.UUID) ([]WebhookModel, error)
+	InsertOne(ctx context.Context, webhook WebhookModel) error
+	// UpdateOne updates the url, events and disabled of the webhook, enabling it
+	// again starts the failures over.
+	UpdateOne(ctx context.Context, webhook WebhookModel) error
+	DeleteOne(ctx context.Context, id uuid.UUID) error
+	// FindDeliveries returns at most n latest deliveries of the webhook, latest first.
+	FindDeliveries(ctx context.Context, webhookID uuid.UUID, n int) ([]DeliveryModel, error)
+	// DispatchEvent turns the event into a delivery to each enabled webhook subscribed
+	// to it, once. The signups are dispatched to the webhooks of the staffOrgID, if not nil.
+	DispatchEvent(ctx context.Context, e Event, staffOrgID uuid.UUID) error
+	// ClaimDeliveries returns at most n deliveries due at the now time to enabled webhooks,
+	// and leases them until now+lease so no other claim returns them before.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, n int) ([]WebhookDelivery, error)
+	// MarkDelivered marks the delivery delivered, and starts the failures of its webhook over.
+	MarkDelivered(ctx context.Context, d WebhookDelivery, code int) error
+	// MarkFailed records the failed attempt of the delivery, retried at the retryAt time unless
+	// it's dead, and counts the failure of its webhook. The webhook is disabled once it failed
+	// disableAfter times in a row, in which case disabled is true.
+	MarkFailed(ctx context.Context, d WebhookDelivery, code int, reason string, retryAt time.Time, dead bool,
+		disableAfter int) (disabled bool, err error)
+	// PurgeDeliveries deletes the delivered and dead deliveries older than
+	// the time, and returns how many were deleted.
+	PurgeDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// WebhookService provides the use cases implementation to work
+// with the webhooks of a user or of the organizations the user manages.
+type WebhookService struct {
+	auth Authorizer
+	repo WebhookStorage
+	// staffOrgID is the organization whose webhooks can subscribe to
+	// the signups, nil if none can.
+	staffOrgID uuid.UUID
+}
+
+// NewWebhookService returns a new WebhookService initialized with a concrete repo
+// implementation, the organization webhooks are authorized by the auth. The webhooks
+// of the staffOrgID can subscribe to the signups, none can if it's nil.
+func NewWebhookService(repo WebhookStorage, auth Authorizer, staffOrgID uuid.UUID) WebhookService {
+	return WebhookService{
+		auth:       auth,
+		repo:       repo,
+		staffOrgID: staffOrgID,
+	}
+}
+
+// nonPublicNets are the networks not reachable on the internet, the webhooks
+// aren't posted to, they would reach into the network of the server.
+var nonPublicNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4",
+		"240.0.0.0/4", "::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// IsPublicIP reports whether the ip is reachable on the internet, neither
+// a loopback, link local, private nor multicast one.
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidWebhook validate if the webhook is valid or not, its url is an https one
+// to a public host. The name of the host is only resolved by the sender.
+func (ws WebhookService) IsValidWebhook(webhook WebhookModel) bool {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || len(webhook.URL) > 2048 {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return false
+	}
+	if len(webhook.Events) == 0 {
+		return false
+	}
+	seen := make(map[string]bool, len(webhook.Events))
+	for _, event := range webhook.Events {
+		if !eventTypes[event] || seen[event] {
+			return false
+		}
+		if event == EventUserSignedUp && (ws.staffOrgID == uuid.Nil || webhook.OrgID != ws.staffOrgID) {
+			return false
+		}
+		seen[event] = true
+	}
+	return true
+}
+
+// List returns the personal webhooks of the user, or the ones of the
+// organization if not nil and the user can manage them.
+func (ws WebhookService) List(ctx context.Context, userID, orgID uuid.UUID) ([]WebhookModel, error) {
+	if orgID == uuid.Nil {
+		return ws.repo.FindWebhooksOfUser(ctx, userID)
+	}
+	if err := ws.auth.AuthorizeOrg(ctx, userID, orgID, PermWebhooksManage); err != nil {
+		return nil, err
+	}
+	return ws.repo.FindWebhooksOfOrg(ctx, orgID)
+}
+
+// Find returns the webhook with the id, if the user can manage it.
+func (ws WebhookService) Find(ctx context.Context, userID, id uuid.UUID) (WebhookModel, error) {
+	webhook, err := ws.repo.FindOneWebhook(ctx, id)
+	if err != nil {
+		return NilWebhookModel, err
+	}
+	if webhook.OrgID == uuid.Nil {
+		if webhook.UserID != userID {
+			return NilWebhookModel, serror.ErrWebhookNotFound
+		}
+		return webhook, nil
+	}
+	err = ws.auth.AuthorizeOrg(ctx, userID, webhook.OrgID, PermWebhooksManage)
+	if errors.Is(err, serror.ErrOrgNotFound) {
+		// the webhooks of an organization don't leak to non members
+		return NilWebhookModel, serror.ErrWebhookNotFound
+	}
+	if err != nil {
+		return NilWebhookModel, err
+	}
+	return webhook, nil
+}
+
+// Create registers a new webhook of the user, or of the organization the user
+// manages if its OrgID is not nil, and returns it along with its secret.
+func (ws WebhookService) Create(ctx context.Context, userID uuid.UUID, webhook WebhookModel) (WebhookModel, error) {
+	if !ws.IsValidWebhook(webhook) {
+		return NilWebhookModel, ErrInvalidWebhook
+	}
+	if webhook.OrgID != uuid.Nil {
+		if err := ws.auth.AuthorizeOrg(ctx, userID, webhook.OrgID, PermWebhooksManage); err != nil {
+			return NilWebhookModel, err
+		}
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return NilWebhookModel, err
+	}
+	webhook.ID = uuid.New()
+	webhook.UserID = userID
+	webhook.Secret = secret
+	webhook.Disabled = false
+	webhook.Failures = 0
+	webhook.CreatedAt = time.Now().UTC()
+	if err = ws.repo.InsertOne(ctx, webhook); err != nil {
+		return NilWebhookModel, err
+	}
+	return webhook, nil
+}
+
+// Update updates the url, events and disabled of the webhook the user can manage
+// and returns it, enabling it again is how a webhook disabled by its failures is resumed.
+func (ws WebhookService) Update(ctx context.Context, userID uuid.UUID, webhook WebhookModel) (WebhookModel, error) {
+	current, err := ws.Find(ctx, userID, webhook.ID)
+	if err != nil {
+		return NilWebhookModel, err
+	}
+	current.URL, current.Events = webhook.URL, webhook.Events
+	if !ws.IsValidWebhook(current) {
+		return NilWebhookModel, ErrInvalidWebhook
+	}
+	if current.Disabled && !webhook.Disabled {
+		current.Failures = 0
+	}
+	current.Disabled = webhook.Disabled
+	if err = ws.repo.UpdateOne(ctx, current); err != nil {
+		return NilWebhookModel, err
+	}
+	return current, nil
+}
+
+// Delete deletes the webhook the user can manage, along with its deliveries.
+func (ws WebhookService) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	if _, err := ws.Find(ctx, userID, id); err != nil {
+		return err
+	}
+	return ws.repo.DeleteOne(ctx, id)
+}
+
+// Deliveries returns the latest deliveries of the webhook the user can manage.
+func (ws WebhookService) Deliveries(ctx context.Context, userID, id uuid.UUID) ([]DeliveryModel, error) {
+	if _, err := ws.Find(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	return ws.repo.FindDeliveries(ctx, id, maxDeliveries)
+}
+
+// newWebhookSecret returns a random secret, to sign the payloads of a webhook.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Compile-time check for ensuring WebhookDispatcher implements EventHandler.
+var _ EventHandler = WebhookDispatcher{}
+
+// WebhookDispatcher is the EventHandler dispatching the events to the webhooks subscribed
+// to them, and posts the deliveries due through the sender. Like the reminders, the
+// deliveries are the ones of the storage, shared by all the dispatchers.
+//
+// A failed attempt is retried with an exponential backoff, and the delivery is dead once
+// it failed webhookMaxAttempts times. A webhook is disabled once it failed
+// webhookDisableAfter times in a row, until it's enabled again.
+type WebhookDispatcher struct {
+	repo       WebhookStorage
+	sender     WebhookSender
+	staffOrgID uuid.UUID
+	interval   time.Duration
+	now        func() time.Time
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher that delivers the deliveries of the
+// repo through the sender every interval. The signups are dispatched to the webhooks
+// of the staffOrgID, if not nil.
+func NewWebhookDispatcher(repo WebhookStorage, sender WebhookSender, staffOrgID uuid.UUID, interval time.Duration) WebhookDispatcher {
+	return WebhookDispatcher{
+		repo:       repo,
+		sender:     sender,
+		staffOrgID: staffOrgID,
+		interval:   interval,
+		now:        time.Now,
+	}
+}
+
+// Handle dispatches the event to the webhooks subscribed to it, they're
+// delivered on the next Deliver.
+func (wd WebhookDispatcher) Handle(ctx context.Context, e Event) error {
+	return wd.repo.DispatchEvent(ctx, e, wd.staffOrgID)
+}
+
+// Deliver posts the deliveries due now, and returns how many were delivered and
+// how many failed. The webhooks disabled by the failures are reported to the
+// onDisable, if not nil.
+func (wd WebhookDispatcher) Deliver(ctx context.Context, onDisable func(webhookID uuid.UUID)) (delivered, failed int, err error) {
+	list, err := wd.repo.ClaimDeliveries(ctx, wd.now(), webhookLease, webhookBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, d := range list {
+		code, err := wd.sender.Send(ctx, d)
+		if err == nil {
+			delivered++
+			err = wd.repo.MarkDelivered(ctx, d, code)
+		} else {
+			failed++
+			var disabled bool
+			dead := d.Attempt >= webhookMaxAttempts
+			disabled, err = wd.repo.MarkFailed(ctx, d, code, err.Error(), wd.now().Add(backoff(d.Attempt)), dead,
+				webhookDisableAfter)
+			if disabled && onDisable != nil {
+				onDisable(d.WebhookID)
+			}
+		}
+		// the lease expires if the mark failed, so it's delivered again.
+		if err != nil {
+			return delivered, failed, err
+		}
+	}
+	return delivered, failed, nil
+}
+
+// Run delivers right away and then every interval, until the ctx is done. The outcome
+// of every delivery is reported to the onDeliver, and the disabled webhooks to the
+// onDisable, if not nil.
+func (wd WebhookDispatcher) Run(ctx context.Context, onDeliver func(delivered, failed int, err error),
+	onDisable func(webhookID uuid.UUID)) {
+	ticker := time.NewTicker(wd.interval)
+	defer ticker.Stop()
+	for {
+		delivered, failed, err := wd.Deliver(ctx, onDisable)
+		if onDeliver != nil && ctx.Err() == nil {
+			onDeliver(delivered, failed, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+// Compile-time check for ensuring HTTPSender implements pkg.WebhookSender.
+var _ pkg.WebhookSender = (*HTTPSender)(nil)
+
+// payload is the json body posted for an event.
+type payload struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+var (
+	// errUnreachable is the failure of a post without response. The cause isn't told,
+	// it's in the delivery log of the webhook, and would tell about the network of the server.
+	errUnreachable = errors.New("webhook unreachable")
+	// errNonPublicAddress is the failure to connect to an address not public.
+	errNonPublicAddress = errors.New("non public address")
+)
+
+// HTTPSender posts the deliveries as signed json, any response
+// but a 2xx one is a failed delivery.
+type HTTPSender struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewHTTPSender returns a HTTPSender whose every post gives up after the timeout.
+// The webhooks are only posted to public addresses, checked once their host is
+// resolved, and without proxy, so a webhook can't reach into the network of the server.
+func NewHTTPSender(timeout time.Duration) HTTPSender {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnly,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return NewHTTPSenderWithClient(&http.Client{Transport: transport, Timeout: timeout})
+}
+
+// NewHTTPSenderWithClient returns a HTTPSender posting with the client, which
+// decides the addresses the webhooks can be posted to. The webhooks aren't
+// followed on a redirect, the signature is for their url.
+func NewHTTPSenderWithClient(client *http.Client) HTTPSender {
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return HTTPSender{client: &c, now: time.Now}
+}
+
+// publicOnly refuses to connect to the address, resolved already, unless it's a public one.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !pkg.IsPublicIP(ip) {
+		return errNonPublicAddress
+	}
+	return nil
+}
+
+// Send posts the event of the delivery to its webhook, and returns the status code of the response.
+func (s HTTPSender) Send(ctx context.Context, d pkg.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(payload{
+		ID:        d.EventID,
+		Type:      d.EventType,
+		CreatedAt: d.OccurredAt.UTC(),
+		Data:      d.Data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	ts := s.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "prod-todo-webhook/1")
+	req.Header.Set("Webhook-Id", strconv.FormatInt(d.EventID, 10))
+	req.Header.Set("Webhook-Event", d.EventType)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("Webhook-Signature", Sign(d.Secret, ts, body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, errUnreachable
+	}
+	defer resp.Body.Close()
+	// drained for the connection to be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,58 @@
+// Package webhook posts the events to the webhooks, signed with their secret.
+//
+// Every post carries the headers:
+//
+//	Webhook-Id: the id of the event, the same for all the attempts
+//	Webhook-Event: the type of the event, like todo.created
+//	Webhook-Timestamp: the unix time of the attempt, in seconds
+//	Webhook-Signature: v1=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// A receiver recomputes the signature over the raw body, and rejects the
+// timestamps too far from its clock so a captured post can't be replayed.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureVersion prefixes the signatures, for the scheme to change without breaking the receivers.
+const signatureVersion = "v1="
+
+var (
+	// ErrInvalidSignature indicates the signature doesn't match the body and timestamp
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrTimestampExpired indicates the timestamp is outside of the tolerance
+	ErrTimestampExpired = errors.New("webhook timestamp outside of the tolerance")
+)
+
+// Sign returns the Webhook-Signature of the body posted at the unix timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signatureVersion + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and the timestamp headers of the body received at the now time,
+// the timestamp can't be more than tolerance away from it. It's what a receiver does.
+func Verify(secret, signature, timestamp string, body []byte, now time.Time, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrTimestampExpired
+	}
+	if !strings.HasPrefix(signature, signatureVersion) ||
+		!hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// +build unit_tests all_tests
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":1}`)
+	sig := Sign("whsec_1", now.Unix(), body)
+	ts := "1614589200"
+
+	tt := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		now       time.Time
+		want      error
+	}{
+		{name: "valid", secret: "whsec_1", signature: sig, timestamp: ts, body: body, now: now},
+		{name: "within the tolerance", secret: "whsec_1", signature: sig, timestamp: ts, body: body, now: now.Add(4 * time.Minute)},
+		{name: "other secret", secret: "whsec_2", signature: sig, timestamp: ts, body: body, now: now, want: ErrInvalidSignature},
+		{name: "tampered body", secret: "whsec_1", signature: sig, timestamp: ts, body: []byte(`{"id":2}`), now: now, want: ErrInvalidSignature},
+		{name: "other timestamp", secret: "whsec_1", signature: sig, timestamp: "1614589201", body: body, now: now, want: ErrInvalidSignature},
+		{name: "replayed", secret: "whsec_1", signature: sig, timestamp: ts, body: body, now: now.Add(time.Hour), want: ErrTimestampExpired},
+		{name: "unknown version", secret: "whsec_1", signature: "v0=" + sig[3:], timestamp: ts, body: body, now: now, want: ErrInvalidSignature},
+		{name: "invalid timestamp", secret: "whsec_1", signature: sig, timestamp: "now", body: body, now: now, want: ErrInvalidSignature},
+	}
+	for _, tc := range tt {
+		if err := Verify(tc.secret, tc.signature, tc.timestamp, tc.body, tc.now, 5*time.Minute); err != tc.want {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestHTTPSender_Send(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	todoID := uuid.New()
+	status := http.StatusNoContent
+	var got payload
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		err = Verify("whsec_1", r.Header.Get("Webhook-Signature"), r.Header.Get("Webhook-Timestamp"), body, now, time.Minute)
+		if err != nil {
+			t.Errorf("expected a signed post got %v", err)
+		}
+		if r.Header.Get("Webhook-Id") != "42" || r.Header.Get("Webhook-Event") != pkg.EventTodoCreated {
+			t.Errorf("expected the event headers got %v", r.Header)
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := NewHTTPSenderWithClient(srv.Client())
+	s.now = func() time.Time { return now }
+	d := pkg.WebhookDelivery{ID: 1, URL: srv.URL, Secret: "whsec_1", EventID: 42, EventType: pkg.EventTodoCreated,
+		Data: json.RawMessage(`{"id":"` + todoID.String() + `"}`), OccurredAt: now, Attempt: 1}
+	code, err := s.Send(context.Background(), d)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("expected the delivery sent got %d %v", code, err)
+	}
+	var data struct {
+		ID uuid.UUID `json:"id"`
+	}
+	if err := json.Unmarshal(got.Data, &data); err != nil || got.ID != 42 || data.ID != todoID || !got.CreatedAt.Equal(now) {
+		t.Errorf("expected the payload of the event got %+v", got)
+	}
+
+	status = http.StatusGone
+	if code, err = s.Send(context.Background(), d); err == nil || code != http.StatusGone {
+		t.Errorf("expected a failed delivery on a %d got %d %v", status, code, err)
+	}
+	status = http.StatusFound
+	if _, err = s.Send(context.Background(), d); err == nil {
+		t.Errorf("expected a redirect not followed")
+	}
+}
+
+func TestHTTPSender_NonPublic(t *testing.T) {
+	t.Parallel()
+	var posted bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewHTTPSender(time.Second)
+	// the names are checked once resolved
+	for _, url := range []string{srv.URL, "https://localhost:" + port} {
+		code, err := s.Send(context.Background(), pkg.WebhookDelivery{ID: 1, URL: url, EventID: 42, Attempt: 1})
+		if err != errUnreachable || code != 0 {
+			t.Errorf("expected %s unreachable got %d %v", url, code, err)
+		}
+	}
+	if posted {
+		t.Errorf("expected nothing posted to a loopback address")
+	}
+}
@@ -0,0 +1,268 @@
+// +build unit_tests all_tests
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ankur-anand/prod-todo/pkg/storage/serror"
+)
+
+// dummyWebhookRepo keeps the webhooks, claims the due deliveries once, and records their marks.
+type dummyWebhookRepo struct {
+	webhooks   map[uuid.UUID]WebhookModel
+	dispatched []Event
+	due        []WebhookDelivery
+	delivered  []WebhookDelivery
+	failed     map[int64]failedDelivery
+}
+
+type failedDelivery struct {
+	code    int
+	retryAt time.Time
+	dead    bool
+}
+
+func newDummyWebhookRepo() *dummyWebhookRepo {
+	return &dummyWebhookRepo{webhooks: make(map[uuid.UUID]WebhookModel), failed: make(map[int64]failedDelivery)}
+}
+
+func (d *dummyWebhookRepo) FindOneWebhook(ctx context.Context, id uuid.UUID) (WebhookModel, error) {
+	webhook, ok := d.webhooks[id]
+	if !ok {
+		return NilWebhookModel, serror.ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+func (d *dummyWebhookRepo) FindWebhooksOfUser(ctx context.Context, userID uuid.UUID) ([]WebhookModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyWebhookRepo) FindWebhooksOfOrg(ctx context.Context, orgID uuid.UUID) ([]WebhookModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyWebhookRepo) InsertOne(ctx context.Context, webhook WebhookModel) error {
+	d.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+func (d *dummyWebhookRepo) UpdateOne(ctx context.Context, webhook WebhookModel) error {
+	d.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+func (d *dummyWebhookRepo) DeleteOne(ctx context.Context, id uuid.UUID) error {
+	delete(d.webhooks, id)
+	return nil
+}
+
+func (d *dummyWebhookRepo) FindDeliveries(ctx context.Context, webhookID uuid.UUID, n int) ([]DeliveryModel, error) {
+	panic("implement me")
+}
+
+func (d *dummyWebhookRepo) DispatchEvent(ctx context.Context, e Event, staffOrgID uuid.UUID) error {
+	d.dispatched = append(d.dispatched, e)
+	return nil
+}
+
+func (d *dummyWebhookRepo) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, n int) ([]WebhookDelivery, error) {
+	due := d.due
+	d.due = nil
+	return due, nil
+}
+
+func (d *dummyWebhookRepo) MarkDelivered(ctx context.Context, delivery WebhookDelivery, code int) error {
+	d.delivered = append(d.delivered, delivery)
+	return nil
+}
+
+func (d *dummyWebhookRepo) MarkFailed(ctx context.Context, delivery WebhookDelivery, code int, reason string, retryAt time.Time,
+	dead bool, disableAfter int) (bool, error) {
+	d.failed[delivery.ID] = failedDelivery{code: code, retryAt: retryAt, dead: dead}
+	webhook := d.webhooks[delivery.WebhookID]
+	webhook.Failures++
+	disabled := !webhook.Disabled && webhook.Failures >= disableAfter
+	webhook.Disabled = webhook.Disabled || disabled
+	d.webhooks[delivery.WebhookID] = webhook
+	return disabled, nil
+}
+
+func (d *dummyWebhookRepo) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	panic("implement me")
+}
+
+// senderFunc is a WebhookSender function.
+type senderFunc func(ctx context.Context, d WebhookDelivery) (int, error)
+
+func (f senderFunc) Send(ctx context.Context, d WebhookDelivery) (int, error) {
+	return f(ctx, d)
+}
+
+func TestWebhookService_Create(t *testing.T) {
+	t.Parallel()
+	orgID, staffOrgID, userID, adminID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	orgs := newDummyOrgRepo()
+	orgs.members[orgID] = map[uuid.UUID]OrgRole{userID: OrgRoleMember, adminID: OrgRoleAdmin}
+	orgs.members[staffOrgID] = map[uuid.UUID]OrgRole{adminID: OrgRoleAdmin}
+	repo := newDummyWebhookRepo()
+	ws := NewWebhookService(repo, NewOrgAuthorizer(nil, orgs), staffOrgID)
+
+	invalid := []WebhookModel{
+		{URL: "ftp://example.com/hook", Events: []string{EventTodoCreated}},
+		{URL: "/hook", Events: []string{EventTodoCreated}},
+		{URL: "http://example.com/hook", Events: []string{EventTodoCreated}},
+		{URL: "https://localhost:8080/hook", Events: []string{EventTodoCreated}},
+		{URL: "https://127.0.0.1/hook", Events: []string{EventTodoCreated}},
+		{URL: "https://169.254.169.254/latest/meta-data", Events: []string{EventTodoCreated}},
+		{URL: "https://10.0.0.7/hook", Events: []string{EventTodoCreated}},
+		{URL: "https://[::ffff:192.168.1.1]/hook", Events: []string{EventTodoCreated}},
+		{URL: "https://[fd00::1]/hook", Events: []string{EventTodoCreated}},
+		{URL: "https://example.com/hook"},
+		{URL: "https://example.com/hook", Events: []string{"todo.archived"}},
+		{URL: "https://example.com/hook", Events: []string{EventTodoCreated, EventTodoCreated}},
+		{URL: "https://example.com/hook", Events: []string{EventUserSignedUp}},
+		{URL: "https://example.com/hook", OrgID: orgID, Events: []string{EventUserSignedUp}},
+	}
+	for _, webhook := range invalid {
+		if _, err := ws.Create(context.Background(), adminID, webhook); !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("expected invalid webhook error for %+v got %v", webhook, err)
+		}
+	}
+
+	tt := []struct {
+		name    string
+		userID  uuid.UUID
+		webhook WebhookModel
+		want    error
+	}{
+		{name: "personal", userID: userID, webhook: WebhookModel{URL: "https://example.com/hook", Events: []string{EventTodoCreated}}},
+		{name: "org member", userID: userID, webhook: WebhookModel{URL: "https://example.com/hook", OrgID: orgID,
+			Events: []string{EventTodoCompleted}}, want: ErrForbidden},
+		{name: "non member", userID: uuid.New(), webhook: WebhookModel{URL: "https://example.com/hook", OrgID: orgID,
+			Events: []string{EventTodoCompleted}}, want: serror.ErrOrgNotFound},
+		{name: "org admin", userID: adminID, webhook: WebhookModel{URL: "https://example.com/hook", OrgID: orgID,
+			Events: []string{EventTodoCompleted}}},
+		{name: "staff", userID: adminID, webhook: WebhookModel{URL: "https://tools.example.com/signups", OrgID: staffOrgID,
+			Events: []string{EventUserSignedUp}}},
+	}
+	for _, tc := range tt {
+		webhook, err := ws.Create(context.Background(), tc.userID, tc.webhook)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, err)
+			continue
+		}
+		if err == nil && (webhook.ID == uuid.Nil || webhook.UserID != tc.userID || len(webhook.Secret) != 70) {
+			t.Errorf("%s: unexpected created webhook %+v", tc.name, webhook)
+		}
+	}
+	if len(repo.webhooks) != 3 {
+		t.Errorf("expected 3 webhooks stored got %d", len(repo.webhooks))
+	}
+}
+
+func TestWebhookService_Find(t *testing.T) {
+	t.Parallel()
+	orgID, ownerID, adminID, memberID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	orgs := newDummyOrgRepo()
+	orgs.members[orgID] = map[uuid.UUID]OrgRole{adminID: OrgRoleAdmin, memberID: OrgRoleMember}
+	repo := newDummyWebhookRepo()
+	personal := WebhookModel{ID: uuid.New(), UserID: ownerID, URL: "https://example.com/hook", Events: []string{EventTodoCreated}}
+	org := WebhookModel{ID: uuid.New(), UserID: adminID, OrgID: orgID, URL: "https://example.com/hook", Events: []string{EventTodoCreated}}
+	repo.webhooks[personal.ID], repo.webhooks[org.ID] = personal, org
+	ws := NewWebhookService(repo, NewOrgAuthorizer(nil, orgs), uuid.Nil)
+
+	tt := []struct {
+		name   string
+		userID uuid.UUID
+		id     uuid.UUID
+		want   error
+	}{
+		{name: "owner", userID: ownerID, id: personal.ID},
+		{name: "other user", userID: adminID, id: personal.ID, want: serror.ErrWebhookNotFound},
+		{name: "org admin", userID: adminID, id: org.ID},
+		{name: "org member", userID: memberID, id: org.ID, want: ErrForbidden},
+		{name: "non member", userID: ownerID, id: org.ID, want: serror.ErrWebhookNotFound},
+		{name: "unknown", userID: ownerID, id: uuid.New(), want: serror.ErrWebhookNotFound},
+	}
+	for _, tc := range tt {
+		if _, err := ws.Find(context.Background(), tc.userID, tc.id); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.want, err)
+		}
+	}
+
+	disabled := personal
+	disabled.Disabled, disabled.Failures = true, webhookDisableAfter
+	repo.webhooks[personal.ID] = disabled
+	updated, err := ws.Update(context.Background(), ownerID, WebhookModel{ID: personal.ID, URL: "https://example.com/v2",
+		Events: []string{EventTodoCompleted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Disabled || updated.Failures != 0 || updated.URL != "https://example.com/v2" || updated.UserID != ownerID {
+		t.Errorf("expected the webhook enabled again got %+v", updated)
+	}
+}
+
+func TestWebhookDispatcher_Deliver(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	repo := newDummyWebhookRepo()
+	up := WebhookModel{ID: uuid.New(), URL: "https://example.com/up"}
+	down := WebhookModel{ID: uuid.New(), URL: "https://example.com/down", Failures: webhookDisableAfter - 2}
+	repo.webhooks[up.ID], repo.webhooks[down.ID] = up, down
+	ok := WebhookDelivery{ID: 1, WebhookID: up.ID, URL: up.URL, Attempt: 1}
+	retried := WebhookDelivery{ID: 2, WebhookID: down.ID, URL: down.URL, Attempt: 3}
+	last := WebhookDelivery{ID: 3, WebhookID: down.ID, URL: down.URL, Attempt: webhookMaxAttempts}
+	repo.due = []WebhookDelivery{ok, retried, last}
+
+	wd := NewWebhookDispatcher(repo, senderFunc(func(ctx context.Context, d WebhookDelivery) (int, error) {
+		if d.URL == down.URL {
+			return 503, errors.New("webhook responded 503 Service Unavailable")
+		}
+		return 200, nil
+	}), uuid.Nil, time.Minute)
+	wd.now = func() time.Time { return now }
+
+	var disabled []uuid.UUID
+	delivered, failed, err := wd.Deliver(context.Background(), func(webhookID uuid.UUID) {
+		disabled = append(disabled, webhookID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 1 || failed != 2 || len(repo.delivered) != 1 || repo.delivered[0].ID != ok.ID {
+		t.Errorf("expected 1 delivered and 2 failed got %d %d", delivered, failed)
+	}
+	if mark := repo.failed[retried.ID]; mark.dead || mark.code != 503 || !mark.retryAt.Equal(now.Add(4*time.Minute)) {
+		t.Errorf("expected the third attempt retried in 4m got %+v", mark)
+	}
+	if mark := repo.failed[last.ID]; !mark.dead {
+		t.Errorf("expected the last attempt dead got %+v", mark)
+	}
+	if len(disabled) != 1 || disabled[0] != down.ID || !repo.webhooks[down.ID].Disabled {
+		t.Errorf("expected the failing webhook disabled got %v", disabled)
+	}
+}
+
+func TestWebhookDispatcher_Handle(t *testing.T) {
+	t.Parallel()
+	repo := newDummyWebhookRepo()
+	staffOrgID := uuid.New()
+	bus := NewEventBus()
+	bus.Subscribe("webhooks", NewWebhookDispatcher(repo, nil, staffOrgID, time.Minute))
+
+	e := Event{ID: 7, Type: EventTodoDeleted, UserID: uuid.New(), SubjectID: uuid.New()}
+	if err := bus.Publish(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.dispatched) != 1 || repo.dispatched[0].ID != e.ID {
+		t.Errorf("expected the event dispatched to the webhooks got %+v", repo.dispatched)
+	}
+}